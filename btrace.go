@@ -15,37 +15,209 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
+	"path"
+	"runtime"
 	"runtime/pprof"
 	"sort"
 	"strings"
 	"time"
 
 	"github.com/google/traceout/ftrace"
+	"github.com/google/traceout/ftrace/remote"
+	"github.com/google/traceout/ftrace/report"
 )
 
 import _ "net/http/pprof"
 import "net/http"
 
 var (
-	cpuProfile  string
-	memProfile  string
-	debugServer bool
-	recordReads string
-	timeout     time.Duration
-	test        bool
+	cpuProfile    string
+	memProfile    string
+	debugServer   bool
+	recordReads   string
+	timeout       time.Duration
+	test          bool
+	testSlack     time.Duration
+	testIgnore    stringList
+	remoteAddr    string
+	adbSerial     string
+	useAdb        bool
+	serialDev     string
+	serialBaud    int
+	tracefsRoot   string
+	procRoot      string
+	followPid     int
+	recordTgid    bool
+	jsonOutput    bool
+	pinCPUs       bool
+	filterExpr    string
+	schedPolicy   string
+	niceLevel     int
+	rtPriority    int
+	latency       bool
+	templ         string
+	eventTempls   stringList
+	allEvents     bool
+	bootTrace     bool
+	instance      string
+	previousBoot  bool
+	pstore        bool
+	flight        bool
+	stopOn        string
+	eventPatterns stringList
+	categories    stringList
+	outFile       string
+	rotateSize    int64
+	rotateEvery   time.Duration
+	keepFiles     int
+	compress      string
+	streamAddr    string
+	summary       bool
+	listOnly      bool
+	listFields    bool
+	tui           bool
+	reportFile    string
+	worstWakeups  int
+	irqSummary    bool
+	syscallArch   string
+	straceOutput  bool
+	stacks        bool
+	stackOn       stringList
+	kernelDefs    stringList
+	evalMaps      stringList
+	arch          string
 )
 
+// stringList is a flag that can be repeated
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
 func init() {
 	flag.StringVar(&cpuProfile, "cpuprofile", "", "write cpu profile to file")
 	flag.StringVar(&memProfile, "memprofile", "", "write memory profile to file")
-	flag.BoolVar(&debugServer, "debugserver", false, "enable debug server on localhost:6060")
+	flag.BoolVar(&debugServer, "debugserver", false, "enable debug server and metrics on localhost:6060")
 	flag.StringVar(&recordReads, "record", "", "record files read from kernel for replay testing")
 	flag.DurationVar(&timeout, "t", 0, "end trace after timeout")
 	flag.BoolVar(&test, "test", false, "compare kernel formatted trace to btrace output")
+	flag.DurationVar(&testSlack, "test-slack", time.Microsecond, "largest timestamp difference of matching lines with -test")
+	flag.Var(&testIgnore, "test-ignore", "leave fields with names matching a pattern out of -test comparisons (repeatable)")
+	flag.StringVar(&remoteAddr, "remote", "", "trace a remote device running the traceout agent at host:port")
+	flag.BoolVar(&useAdb, "adb", false, "trace an Android device over adb")
+	flag.StringVar(&adbSerial, "s", "", "serial number of the adb device to trace (implies -adb)")
+	flag.StringVar(&serialDev, "serial", "", "trace a device running the traceout agent on a serial line")
+	flag.IntVar(&serialBaud, "baud", 115200, "baud rate for -serial")
+	flag.StringVar(&tracefsRoot, "tracefs", "", "path to the local tracefs mount (default autodetect)")
+	flag.StringVar(&procRoot, "proc", "", "path to the local proc mount (default /proc)")
+	flag.IntVar(&followPid, "p", 0, "only trace the given pid and its children")
+	flag.BoolVar(&recordTgid, "tgid", false, "record and print the thread group id of each event")
+	flag.BoolVar(&jsonOutput, "json", false, "print events as JSON, one object per line")
+	flag.BoolVar(&pinCPUs, "pin", false, "pin each cpu's reader to that cpu")
+	flag.StringVar(&filterExpr, "filter", "", "only print events matching a C expression over their fields")
+	flag.StringVar(&schedPolicy, "sched", "other", "scheduling policy of the capture threads: other, idle or fifo")
+	flag.IntVar(&niceLevel, "nice", 0, "nice level of the capture threads with -sched=other")
+	flag.IntVar(&rtPriority, "rtprio", 1, "realtime priority of the capture threads with -sched=fifo")
+	flag.BoolVar(&latency, "latency", false, "print events like the kernel's latency-format trace option")
+	flag.StringVar(&templ, "template", "", "print events with a text/template, see ftrace.TemplateData")
+	flag.BoolVar(&allEvents, "all", false, "trace every available event")
+	flag.BoolVar(&bootTrace, "boot", false, "pick up the trace started with the trace_event= boot parameter, reading what is already buffered")
+	flag.StringVar(&instance, "instance", "", "trace into the tracing instance with this name instead of the top level buffer")
+	flag.BoolVar(&previousBoot, "previous-boot", false, "print the events the persistent buffer of -instance kept through the last reboot and exit")
+	flag.BoolVar(&pstore, "pstore", false, "print the function trace pstore kept through the last crash and exit")
+	flag.Var(&eventPatterns, "e", "trace events matching <system>/<event>, which may contain wildcards (repeatable)")
+	flag.Var(&categories, "category", "trace a group of events: sched, irq, wq, power, memory, disk, signal or syscall (repeatable)")
+	flag.StringVar(&stopOn, "stop-on", "", "stop when an event matching <event>[:<filter>] is traced, with -flight the kernel stops tracing")
+	flag.BoolVar(&flight, "flight", false, "trace into an overwriting buffer and print what it holds when stopped")
+	flag.StringVar(&outFile, "o", "", "write events to a file instead of stdout")
+	flag.Int64Var(&rotateSize, "rotate-size", 0, "with -o, start a new file when the output reaches this many bytes")
+	flag.DurationVar(&rotateEvery, "rotate-interval", 0, "with -o, start a new file after this long")
+	flag.StringVar(&compress, "compress", "none", "compress the output as it is written: "+strings.Join(ftrace.CodecNames(), ", "))
+	flag.StringVar(&streamAddr, "stream", "", "serve events to clients connecting to host:port or unix:<path> instead of printing them")
+	flag.IntVar(&keepFiles, "keep", 0, "with -o, keep this many old files when rotating (default all)")
+	flag.BoolVar(&summary, "summary", true, "print event counts and kernel drops to stderr when done")
+	flag.BoolVar(&listOnly, "list-events", false, "list the available events, or those matching -e, and exit")
+	flag.BoolVar(&listFields, "list-fields", false, "like -list-events, with each event's fields and print format")
+	flag.BoolVar(&tui, "tui", false, "show events in an interactive terminal view")
+	flag.StringVar(&reportFile, "report", "", "write an HTML summary of the capture to a file when done")
+	flag.StringVar(&syscallArch, "syscall-arch", "", "name syscalls with the numbers of this architecture: "+strings.Join(ftrace.SyscallArchs(), ", ")+" (default the local one when tracing locally)")
+	flag.BoolVar(&straceOutput, "strace", false, "trace syscalls and print them like strace, with decoded arguments")
+	flag.BoolVar(&irqSummary, "irq-summary", false, "print the time spent in IRQ and softirq handlers to stderr when done")
+	flag.Var(&kernelDefs, "kernel-defs", "read the traced kernel's constants from C #defines and enums in a file, may be repeated")
+	flag.StringVar(&arch, "arch", "", "machine the traced kernel runs on as printed by uname -m, for kernels without the kernel.arch sysctl")
+	flag.Var(&evalMaps, "eval-map", "read the traced kernel's enum values from a copy of its eval_map file, may be repeated")
+	flag.BoolVar(&stacks, "stack", false, "record the kernel stack of every event")
+	flag.Var(&stackOn, "stack-on", "record the kernel stack of events matching <event>[:<filter>], may be repeated")
+	flag.IntVar(&worstWakeups, "wakeup-latency", 0, "print wakeup to schedule latencies and the n longest to stderr when done")
+	flag.Var(&eventTempls, "event-template", "print an event type with a text/template, as <event>=<template> (repeatable)")
+}
+
+// defaultEvents are traced when no -e or -category flags are given
+var defaultEvents = []string{
+	"sched/sched_switch",
+	"sched/sched_wakeup",
+	"task/task_newtask",
+	"irq/irq_handler_entry",
+	"irq/irq_handler_exit",
+	"irq/softirq_entry",
+	"irq/softirq_exit",
+	"irq/softirq_raise",
+	"workqueue/workqueue_activate_work",
+	"workqueue/workqueue_execute_start",
+	"workqueue/workqueue_execute_end",
+	"workqueue/workqueue_queue_work",
+	"power/cpu_frequency",
+	"power/cpu_idle",
+	"vmscan/mm_vmscan_direct_reclaim_begin",
+	"vmscan/mm_vmscan_direct_reclaim_end",
+	"vmscan/mm_vmscan_kswapd_sleep",
+	"vmscan/mm_vmscan_kswapd_wake",
+	"ext4/ext4_da_write_begin",
+	"ext4/ext4_da_write_end",
+	"ext4/ext4_sync_file_enter",
+	"ext4/ext4_sync_file_exit",
+	"block/block_rq_issue",
+	"block/block_rq_complete",
+	//"tlb/tlb_flush",
+	"signal/signal_generate",
+	"signal/signal_deliver",
+}
+
+// eventCategories are shorthands for groups of events, for -category
+var eventCategories = map[string][]string{
+	"sched":   {"sched/sched_switch", "sched/sched_wakeup", "sched/sched_wakeup_new", "sched/sched_process_exit", "task/task_newtask"},
+	"irq":     {"irq/*"},
+	"wq":      {"workqueue/*"},
+	"power":   {"power/cpu_frequency", "power/cpu_idle"},
+	"memory":  {"vmscan/mm_vmscan_direct_reclaim_begin", "vmscan/mm_vmscan_direct_reclaim_end", "vmscan/mm_vmscan_kswapd_sleep", "vmscan/mm_vmscan_kswapd_wake"},
+	"disk":    {"block/block_rq_issue", "block/block_rq_complete", "ext4/ext4_sync_file_enter", "ext4/ext4_sync_file_exit"},
+	"signal":  {"signal/*"},
+	"syscall": {"raw_syscalls/sys_enter", "raw_syscalls/sys_exit"},
+}
+
+// writeReport writes the HTML summary of events to a file
+func writeReport(name string, events ftrace.Events) error {
+	out, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err := report.New(events).WriteHTML(out); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
 }
 
 func do_main() error {
@@ -76,7 +248,30 @@ func do_main() error {
 		defer f.Close()
 	}
 
-	fp := ftrace.NewLocalFileProvider()
+	fp := ftrace.NewLocalFileProviderAt(tracefsRoot, procRoot)
+	if remoteAddr != "" {
+		client, err := remote.Dial("tcp", remoteAddr)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+		fp = client
+	} else if serialDev != "" {
+		conn, err := remote.OpenSerial(serialDev, serialBaud)
+		if err != nil {
+			return err
+		}
+		client := remote.NewClient(conn)
+		defer client.Close()
+		fp = client
+	} else if useAdb || adbSerial != "" {
+		fp = ftrace.NewAdbFileProvider(adbSerial)
+	}
+	if instance != "" {
+		fp = ftrace.NewInstanceFileProvider(fp, instance)
+	} else if previousBoot {
+		return fmt.Errorf("-previous-boot needs the -instance of a persistent buffer")
+	}
 	if recordReads != "" {
 		rfp := ftrace.NewRecordingFileProvider(fp)
 		fp = rfp
@@ -98,51 +293,277 @@ func do_main() error {
 		return err
 	}
 
-	f.Disable()
-	f.Clear()
-
-	eventNames := []string{
-		"sched/sched_switch",
-		"sched/sched_wakeup",
-		"task/task_newtask",
-		"irq/irq_handler_entry",
-		"irq/irq_handler_exit",
-		"irq/softirq_entry",
-		"irq/softirq_exit",
-		"irq/softirq_raise",
-		"workqueue/workqueue_activate_work",
-		"workqueue/workqueue_execute_start",
-		"workqueue/workqueue_execute_end",
-		"workqueue/workqueue_queue_work",
-		"power/cpu_frequency",
-		"power/cpu_idle",
-		"vmscan/mm_vmscan_direct_reclaim_begin",
-		"vmscan/mm_vmscan_direct_reclaim_end",
-		"vmscan/mm_vmscan_kswapd_sleep",
-		"vmscan/mm_vmscan_kswapd_wake",
-		"ext4/ext4_da_write_begin",
-		"ext4/ext4_da_write_end",
-		"ext4/ext4_sync_file_enter",
-		"ext4/ext4_sync_file_exit",
-		"block/block_rq_issue",
-		"block/block_rq_complete",
-		//"tlb/tlb_flush",
-		"signal/signal_generate",
-		"signal/signal_deliver",
+	if arch != "" {
+		a, ok := ftrace.ArchByName(arch)
+		if !ok {
+			return fmt.Errorf("unknown arch %s", arch)
+		}
+		f.KernelDefs().SetArch(a)
+	}
+
+	for _, name := range kernelDefs {
+		defs, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		_, err = f.KernelDefs().LoadConstants(defs)
+		defs.Close()
+		if err != nil {
+			return err
+		}
+	}
+	for _, name := range evalMaps {
+		evalMap, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		_, err = f.KernelDefs().LoadEvalMap(evalMap)
+		evalMap.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	if debugServer {
+		// Capture health on /debug/vars, and on /metrics for Prometheus
+		f.PublishExpvar("traceout")
+		http.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+			f.Metrics().WritePrometheus(w, "")
+		})
+	}
+
+	if flag.Arg(0) == "doctor" {
+		// Report what the kernel's event formats need that traceout lacks
+		report, err := f.Coverage()
+		if err != nil {
+			return err
+		}
+		fmt.Print(report.String())
+		return nil
+	}
+
+	if listOnly || listFields {
+		return listEvents(os.Stdout, fp, f, eventPatterns, listFields)
+	}
+
+	if previousBoot {
+		// Leave the buffer's configuration alone, changing it would
+		// reset the buffer
+		events, err := f.ReadPreviousBoot(f.NumCPUs(), ftrace.CaptureOptions{})
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(os.Stdout)
+		for _, e := range events {
+			if jsonOutput {
+				enc.Encode(e)
+			} else {
+				fmt.Println(e.String())
+			}
+		}
+		return nil
+	}
+
+	if pstore {
+		data, err := ftrace.ReadPstoreFtrace(fp)
+		if err != nil {
+			return err
+		}
+		events, err := f.DecodePstoreFtrace(data)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(os.Stdout)
+		for _, e := range events {
+			if jsonOutput {
+				enc.Encode(e)
+			} else {
+				fmt.Println(e.String())
+			}
+		}
+		return nil
+	}
+
+	// Put the machine's tracing configuration back when done
+	session, err := f.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	defer f.Close()
+
+	if !bootTrace {
+		f.Disable()
+		f.Clear()
+	}
+
+	eventNames := eventPatterns
+	for _, c := range categories {
+		names, ok := eventCategories[c]
+		if !ok {
+			return fmt.Errorf("unknown category %s", c)
+		}
+		eventNames = append(eventNames, names...)
+	}
+	if straceOutput {
+		eventNames = append(eventNames, eventCategories["syscall"]...)
+	}
+	if len(eventNames) == 0 && !bootTrace {
+		eventNames = defaultEvents
 	}
 
 	eventTypes := []*ftrace.EventType{}
 
+	// -e, -category and -strace may name the same events
+	listed := make(map[*ftrace.EventType]bool)
 	for _, e := range eventNames {
-		eType, err := f.NewEventType(e)
+		eTypes, err := f.NewEventTypes(e)
+		if err != nil {
+			return err
+		}
+		for _, etype := range eTypes {
+			if !listed[etype] {
+				listed[etype] = true
+				eventTypes = append(eventTypes, etype)
+			}
+		}
+	}
+
+	if stopOn != "" {
+		v := strings.SplitN(stopOn, ":", 2)
+		var stopType *ftrace.EventType
+		for _, e := range eventTypes {
+			if e.Name() == path.Base(v[0]) {
+				stopType = e
+			}
+		}
+		if stopType == nil {
+			stopType, err = f.NewEventType(v[0])
+			if err != nil {
+				return err
+			}
+			eventTypes = append(eventTypes, stopType)
+		}
+
+		filter := ""
+		if len(v) == 2 {
+			filter = v[1]
+		}
+		if flight {
+			err = session.StopOnKernel(stopType, filter)
+		} else if filter != "" {
+			var stopFilter *ftrace.Filter
+			stopFilter, err = ftrace.NewFilter(filter)
+			if stopFilter != nil {
+				session.StopOn(stopType, stopFilter.Match)
+			}
+		} else {
+			session.StopOn(stopType, nil)
+		}
 		if err != nil {
 			return err
 		}
-		eventTypes = append(eventTypes, eType)
+	}
+
+	for _, on := range stackOn {
+		v := strings.SplitN(on, ":", 2)
+		var stackType *ftrace.EventType
+		for _, e := range eventTypes {
+			if e.Name() == path.Base(v[0]) {
+				stackType = e
+			}
+		}
+		if stackType == nil {
+			stackType, err = f.NewEventType(v[0])
+			if err != nil {
+				return err
+			}
+			eventTypes = append(eventTypes, stackType)
+		}
+		filter := ""
+		if len(v) == 2 {
+			filter = v[1]
+		}
+		if err := session.StacktraceOn(stackType, filter); err != nil {
+			return err
+		}
+	}
+
+	// The events enabled at boot are left as they are, even when also
+	// named with -e or -category, so they stay enabled when done
+	bootEnabled := make(map[*ftrace.EventType]bool)
+	if bootTrace {
+		attached, err := f.AttachEnabledEvents()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		for _, e := range eventTypes {
+			listed[e] = true
+		}
+		for _, e := range attached {
+			bootEnabled[e] = true
+			if !listed[e] {
+				eventTypes = append(eventTypes, e)
+			}
+		}
 	}
 
 	for _, e := range eventTypes {
-		e.Enable()
+		if !bootEnabled[e] {
+			e.Enable()
+		}
+	}
+
+	if stacks {
+		err = f.EnableStacktrace()
+		if err != nil {
+			return err
+		}
+	}
+
+	if allEvents {
+		err = f.EnableAllEvents()
+		if err != nil {
+			return err
+		}
+	}
+
+	if recordTgid {
+		err = f.EnableRecordTgid()
+		if err != nil {
+			return err
+		}
+	}
+
+	local := remoteAddr == "" && serialDev == "" && !useAdb && adbSerial == ""
+	arch := syscallArch
+	if syscallArch != "" {
+		if err := f.ResolveSyscalls(syscallArch); err != nil {
+			return fmt.Errorf("%v %s", err, syscallArch)
+		}
+	} else if local && !test {
+		// -test compares with the kernel's output, which shows syscall
+		// numbers.  Not every architecture has a table, so errors are
+		// ignored.
+		arch = runtime.GOARCH
+		f.ResolveSyscalls(arch)
+	}
+	var strace *ftrace.StraceFormatter
+	if straceOutput {
+		// File names can only be read from local processes
+		var reader ftrace.StringReader
+		if local {
+			reader = ftrace.ProcMemReader{Root: procRoot}
+		}
+		strace = ftrace.NewStraceFormatter(arch, reader)
+	}
+
+	if followPid != 0 {
+		err = f.FollowPid(followPid)
+		if err != nil {
+			return err
+		}
 	}
 
 	go func() {
@@ -175,16 +596,178 @@ func do_main() error {
 		}()
 	}
 
-	f.PrepareCapture(32, doneCh)
+	options := ftrace.CaptureOptions{PinCPUs: pinCPUs}
+	switch schedPolicy {
+	case "other":
+		options.Priority = ftrace.ThreadPriority{Policy: ftrace.SchedOther, Nice: niceLevel}
+	case "idle":
+		options.Priority = ftrace.ThreadPriority{Policy: ftrace.SchedIdle}
+	case "fifo":
+		options.Priority = ftrace.ThreadPriority{Policy: ftrace.SchedFIFO, RTPriority: rtPriority}
+	default:
+		return fmt.Errorf("unknown scheduling policy %s", schedPolicy)
+	}
+	if filterExpr != "" {
+		options.Filter, err = ftrace.NewFilter(filterExpr)
+		if err != nil {
+			return err
+		}
+	}
+	if !flight {
+		f.PrepareCaptureWithOptions(32, doneCh, options)
+	}
+
+	var formatter *ftrace.TemplateFormatter
+	if templ != "" || len(eventTempls) > 0 {
+		byEvent := make(map[string]string)
+		for _, t := range eventTempls {
+			v := strings.SplitN(t, "=", 2)
+			if len(v) != 2 {
+				return fmt.Errorf("expected <event>=<template>, got %s", t)
+			}
+			byEvent[v[0]] = v[1]
+		}
+		formatter, err = ftrace.NewTemplateFormatter(templ, byEvent)
+		if err != nil {
+			return err
+		}
+	}
+
+	codec, err := ftrace.GetCodec(compress)
+	if err != nil {
+		return err
+	}
+	var out io.Writer
+	if streamAddr != "" {
+		// Clients join mid-stream, so it is never compressed
+		stream, err := listenStream(streamAddr)
+		if err != nil {
+			return err
+		}
+		defer stream.Close()
+		out = stream
+	}
+	if outFile != "" {
+		rf, err := newRotatingFile(outFile, rotateSize, rotateEvery, keepFiles, codec)
+		if err != nil {
+			return err
+		}
+		defer rf.Close()
+		if out != nil {
+			out = io.MultiWriter(out, rf)
+		} else {
+			out = rf
+		}
+	} else if out == nil {
+		w, err := codec.NewWriter(os.Stdout)
+		if err != nil {
+			return err
+		}
+		defer w.Close()
+		out = w
+	}
+
+	stats := newCaptureSummary()
+	// Events kept for the analyses done at the end
+	var captured ftrace.Events
+	collect := func(e ftrace.Events) {
+		stats.add(e)
+		if reportFile != "" || worstWakeups > 0 || irqSummary {
+			captured = append(captured, e...)
+		}
+	}
 
-	if !test {
+	if !test && tui {
+		f.Enable()
+		err = runTUI(f, func() {
+			select {
+			case sigCh <- os.Interrupt:
+			default:
+			}
+		})
+		f.Disable()
+		if err != nil {
+			return err
+		}
+	} else if !test && latency {
+		fmt.Fprint(out, ftrace.LatencyHeader)
+		lf := ftrace.NewLatencyFormatter()
 		f.Enable()
 		f.Capture(func(e ftrace.Events) {
-			for _, e := range e {
-				fmt.Println(e.String())
+			collect(e)
+			sort.Stable(ftrace.EventsByTime{Events: e})
+			for _, line := range lf.FormatEvents(e) {
+				fmt.Fprintln(out, line)
 			}
 		})
 		f.Disable()
+	} else if !test {
+		enc := json.NewEncoder(out)
+		printEvents := func(e ftrace.Events) {
+			collect(e)
+			if strace != nil {
+				// Syscalls are paired in time order
+				sort.Stable(ftrace.EventsByTime{Events: e})
+			}
+			for _, e := range e {
+				if strace != nil {
+					// sys_enter is printed with its sys_exit
+					line, ok := strace.Format(e)
+					if ok {
+						fmt.Fprintln(out, line)
+					}
+					if ok || e.EventType().Name() == "sys_enter" {
+						continue
+					}
+				}
+				if jsonOutput {
+					if err := enc.Encode(e); err != nil {
+						fmt.Println(err.Error())
+					}
+				} else if formatter != nil {
+					line, err := formatter.Format(e)
+					if err != nil {
+						line = err.Error()
+					}
+					fmt.Fprintln(out, line)
+				} else {
+					fmt.Fprintln(out, e.String())
+					// Stacks without their event print themselves
+					if e.EventType().Name() != "kernel_stack" {
+						for _, sym := range e.KernelStackSymbols() {
+							fmt.Fprintln(out, " => "+sym)
+						}
+					}
+				}
+			}
+		}
+
+		if flight {
+			// Only read the buffer once stopped
+			err = f.SetOverwrite(true)
+			if err != nil {
+				return err
+			}
+			f.Enable()
+			// A -stop-on trigger turns tracing off in the kernel
+			stopped := make(<-chan struct{})
+			if stopOn != "" {
+				stopped = session.KernelStopped(doneCh)
+			}
+			select {
+			case <-doneCh:
+			case <-stopped:
+			}
+			events, err := f.Snapshot(f.NumCPUs(), options)
+			if err != nil {
+				return err
+			}
+			printEvents(events)
+		} else {
+			f.Enable()
+			f.Capture(printEvents)
+			f.Disable()
+		}
 	} else {
 		var events ftrace.Events
 
@@ -199,27 +782,24 @@ func do_main() error {
 			eventStrings = append(eventStrings, e.String())
 		}
 
-		kernelStrings := strings.Split(string(kernelTrace), "\n")
-		for kernelStrings[0][0] == '#' {
-			kernelStrings = kernelStrings[1:]
-		}
-
-		i := 0
-		for i = 0; i < len(eventStrings); i++ {
-			if i >= len(kernelStrings) {
-				err = fmt.Errorf("kernelStrings shorter than eventStrings (%d < %d)",
-					len(kernelStrings), len(eventStrings))
-				break
-			}
-			if eventStrings[i] != kernelStrings[i] {
-				err = fmt.Errorf("mismatch line %d, expected\n   %s\ngot\n   %s",
-					i+1, kernelStrings[i], eventStrings[i])
-				break
+		var kernelStrings []string
+		for _, line := range strings.Split(string(kernelTrace), "\n") {
+			if line != "" && line[0] != '#' {
+				kernelStrings = append(kernelStrings, line)
 			}
 		}
 
-		if i == len(eventStrings) {
-			fmt.Printf("%d lines match\n", len(eventStrings))
+		// Events with the same timestamp may be printed in another order
+		c := ftrace.CompareTraces(kernelStrings, eventStrings, ftrace.CompareOptions{
+			TimestampSlack: testSlack,
+			IgnoreFields:   testIgnore,
+			Lookahead:      16,
+		})
+		c.WriteReport(os.Stdout, 20)
+		if !c.Equal() {
+			err = fmt.Errorf("%d of %d lines don't match", len(c.Mismatches), c.Matched+len(c.Mismatches))
+		} else {
+			fmt.Printf("%d lines match\n", c.Matched)
 			for _, t := range eventTypes {
 				if !events.HasEventType(t) {
 					fmt.Printf("no events of type %s\n", t.Name())
@@ -228,8 +808,31 @@ func do_main() error {
 		}
 	}
 
+	if summary && !test && !tui {
+		stats.write(os.Stderr, f, f.NumCPUs(), time.Since(stats.start))
+	}
+	if !test && !tui {
+		sort.Stable(ftrace.EventsByTime{Events: captured})
+	}
+	if irqSummary && !test && !tui {
+		writeIRQSummary(os.Stderr, captured)
+	}
+	if worstWakeups > 0 && !test && !tui {
+		writeWakeupLatency(os.Stderr, captured, worstWakeups)
+	}
+	if reportFile != "" && !test && !tui {
+		if err := writeReport(reportFile, captured); err != nil {
+			return err
+		}
+	}
+
 	for _, e := range eventTypes {
-		e.Disable()
+		if !bootEnabled[e] {
+			e.Disable()
+		}
+	}
+	if allEvents {
+		f.DisableAllEvents()
 	}
 
 	return err
@@ -0,0 +1,80 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// eventgen generates event format files from kernel trace headers, laid out
+// like tracefs under the output directory: <out>/events/<system>/<event>/format.
+// The tree can be passed to btrace -tracefs to decode events without access
+// to the traced device.
+//
+// Usage: eventgen -o <out> [-id <first id>] include/trace/events/*.h
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/google/traceout/ftrace/eventsrc"
+)
+
+var (
+	outDir  string
+	firstID int
+)
+
+func init() {
+	flag.StringVar(&outDir, "o", "", "output directory")
+	flag.IntVar(&firstID, "id", 1, "event ID assigned to the first event, incremented for each event")
+}
+
+func main() {
+	flag.Parse()
+	if outDir == "" || flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: eventgen -o <out> [-id <first id>] <header>...")
+		os.Exit(2)
+	}
+
+	id := firstID
+	for _, header := range flag.Args() {
+		src, err := ioutil.ReadFile(header)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+
+		defs, errs := eventsrc.Parse(string(src), nil)
+		for _, err := range errs {
+			fmt.Printf("%s: %s\n", header, err.Error())
+		}
+
+		for _, def := range defs {
+			if def.System == "" {
+				fmt.Printf("%s: %s: no TRACE_SYSTEM\n", header, def.Name)
+				continue
+			}
+			dir := filepath.Join(outDir, "events", def.System, def.Name)
+			err := os.MkdirAll(dir, 0755)
+			if err == nil {
+				err = ioutil.WriteFile(filepath.Join(dir, "format"), []byte(def.Format(id)), 0644)
+			}
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+			id++
+		}
+	}
+}
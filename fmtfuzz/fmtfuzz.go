@@ -0,0 +1,254 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// fmtfuzz replays a session recorded with btrace -record and looks for
+// differences between traceout's printf implementation and the C library's.
+//
+// If the recording contains the kernel's trace file (btrace -test -record),
+// the decoded events are first compared against it.  Then the field values
+// of the recorded events are randomly mutated, and each event's print fmt is
+// formatted with both cprintf and snprintf (through package cref, which
+// needs cgo) with the same argument values.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/traceout/ftrace"
+	"github.com/google/traceout/ftrace/cparse"
+	"github.com/google/traceout/ftrace/cprintf"
+	"github.com/google/traceout/ftrace/cprintf/cref"
+)
+
+const (
+	tracingPrefix = "/sys/kernel/debug/tracing/"
+	// size of the common fields at the start of every event, not mutated
+	commonFieldsSize = 8
+)
+
+var (
+	iterations int
+	samples    int
+	maxReports int
+	seed       int64
+)
+
+var (
+	formatRe = regexp.MustCompile(`^` + tracingPrefix + `events/([^/]+/[^/]+)/format$`)
+	pipeRe   = regexp.MustCompile(`^per_cpu/cpu(\d+)/trace_pipe_raw$`)
+)
+
+func init() {
+	flag.IntVar(&iterations, "n", 100, "mutations per sampled event")
+	flag.IntVar(&samples, "samples", 10, "recorded events sampled per event type")
+	flag.IntVar(&maxReports, "reports", 5, "maximum divergences reported per event type")
+	flag.Int64Var(&seed, "seed", 1, "random seed")
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: fmtfuzz [flags] <recording>")
+		os.Exit(2)
+	}
+
+	err := fuzz(flag.Arg(0))
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+}
+
+func fuzz(recording string) error {
+	files, err := ftrace.LoadRecording(recording)
+	if err != nil {
+		return err
+	}
+
+	f, err := ftrace.New(ftrace.NewTestFileProvider(files))
+	if err != nil {
+		return err
+	}
+
+	cpus := 0
+	for name := range files {
+		if m := formatRe.FindStringSubmatch(name); m != nil {
+			if _, err := f.NewEventType(m[1]); err != nil {
+				fmt.Printf("%s: %s\n", m[1], err.Error())
+			}
+		}
+		if m := pipeRe.FindStringSubmatch(name); m != nil {
+			cpu, _ := strconv.Atoi(m[1])
+			if cpu+1 > cpus {
+				cpus = cpu + 1
+			}
+		}
+	}
+
+	err = f.PrepareCapture(cpus, make(chan bool))
+	if err != nil {
+		return err
+	}
+	var events ftrace.Events
+	f.Capture(func(e ftrace.Events) {
+		events = append(events, e...)
+	})
+	sort.Stable(ftrace.EventsByTime{Events: events})
+	fmt.Printf("%d recorded events\n", len(events))
+
+	if trace, ok := files[tracingPrefix+"trace"]; ok {
+		compareKernelTrace(events, trace)
+	}
+
+	byType := make(map[*ftrace.EventType]ftrace.Events)
+	var etypes []*ftrace.EventType
+	for _, e := range events {
+		t := e.EventType()
+		if byType[t] == nil {
+			etypes = append(etypes, t)
+		}
+		byType[t] = append(byType[t], e)
+	}
+
+	rnd := rand.New(rand.NewSource(seed))
+	for _, t := range etypes {
+		fuzzEventType(t, byType[t], rnd)
+	}
+
+	return nil
+}
+
+func compareKernelTrace(events ftrace.Events, trace string) {
+	var kernel []string
+	for _, l := range strings.Split(trace, "\n") {
+		if l != "" && l[0] != '#' {
+			kernel = append(kernel, l)
+		}
+	}
+
+	mismatches := 0
+	for i, e := range events {
+		if i >= len(kernel) {
+			break
+		}
+		if got := e.String(); got != kernel[i] {
+			if mismatches < maxReports {
+				fmt.Printf("kernel trace mismatch line %d:\n  kernel:   %s\n  traceout: %s\n", i+1, kernel[i], got)
+			}
+			mismatches++
+		}
+	}
+	fmt.Printf("%d of %d lines differ from the kernel trace\n", mismatches, len(events))
+}
+
+func fuzzEventType(t *ftrace.EventType, events ftrace.Events, rnd *rand.Rand) {
+	args, err := cparse.Parse(t.PrintFmt(), t)
+	if err != nil || len(args) == 0 || !args[0].IsConstant() {
+		fmt.Printf("%s: can't parse print fmt\n", t.Name())
+		return
+	}
+	format := args[0].Value(nil).AsString()
+	args = args[1:]
+
+	if len(events) > samples {
+		events = events[:samples]
+	}
+
+	tried, skipped, divergences := 0, 0, 0
+	for _, e := range events {
+		for i := 0; i < iterations; i++ {
+			raw := mutate(e.Raw(), rnd)
+			fe, err := t.DecodeEvent(raw, e.Cpu, e.When)
+			if err != nil {
+				skipped++
+				continue
+			}
+
+			values, ok := evaluate(args, *fe)
+			if !ok {
+				skipped++
+				continue
+			}
+
+			want, err := cref.Sprintf(format, values)
+			if err == cref.ErrUnsupported || err == cref.ErrNoCgo {
+				fmt.Printf("%s: %s\n", t.Name(), err.Error())
+				return
+			} else if err != nil {
+				skipped++
+				continue
+			}
+			got, err := cprintf.Sprintf(format, values)
+			if err != nil {
+				got = err.Error()
+			}
+
+			tried++
+			if got != want {
+				if divergences < maxReports {
+					report(t, format, values, want, got)
+				}
+				divergences++
+			}
+		}
+	}
+
+	fmt.Printf("%s: %d formatted, %d skipped, %d differ\n", t.Name(), tried, skipped, divergences)
+}
+
+// mutate returns a copy of an event with some of its non-common bytes
+// replaced with random or boundary values
+func mutate(raw []byte, rnd *rand.Rand) []byte {
+	raw = append([]byte(nil), raw...)
+	if len(raw) <= commonFieldsSize {
+		return raw
+	}
+	boundary := []byte{0x00, 0x01, 0x7f, 0x80, 0xff}
+	for n := rnd.Intn(4) + 1; n > 0; n-- {
+		i := commonFieldsSize + rnd.Intn(len(raw)-commonFieldsSize)
+		if rnd.Intn(2) == 0 {
+			raw[i] = boundary[rnd.Intn(len(boundary))]
+		} else {
+			raw[i] = byte(rnd.Intn(256))
+		}
+	}
+	return raw
+}
+
+func evaluate(args []cparse.Expression, e ftrace.Event) ([]cparse.Value, bool) {
+	values := make([]cparse.Value, len(args))
+	for i, a := range args {
+		values[i] = a.Value(e)
+		if values[i].IsError() {
+			return nil, false
+		}
+	}
+	return values, true
+}
+
+func report(t *ftrace.EventType, format string, values []cparse.Value, want, got string) {
+	var dumps []string
+	for _, v := range values {
+		dumps = append(dumps, v.Dump())
+	}
+	fmt.Printf("%s: divergence\n  format: %q\n  values: %s\n  C:      %q\n  Go:     %q\n",
+		t.Name(), format, strings.Join(dumps, ", "), want, got)
+}
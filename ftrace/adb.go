@@ -0,0 +1,173 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+)
+
+// adbFileProvider accesses the tracing files of an Android device through
+// the adb command on the host.  Small files are read with "adb exec-out cat",
+// which passes binary data through unmodified, and the trace pipes are
+// streamed from a long running "adb exec-out cat".  The device must allow
+// adb access to tracefs, which usually requires "adb root".
+type adbFileProvider struct {
+	serial string
+	// tracefs is the device's tracing directory, found on first use
+	tracefs     string
+	tracefsOnce sync.Once
+}
+
+// NewAdbFileProvider returns a FileProvider for the device with the given
+// serial number, or the only connected device if serial is empty.
+func NewAdbFileProvider(serial string) FileProvider {
+	return &adbFileProvider{
+		serial: serial,
+	}
+}
+
+func (fp *adbFileProvider) command(args ...string) *exec.Cmd {
+	if fp.serial != "" {
+		args = append([]string{"-s", fp.serial}, args...)
+	}
+	return exec.Command("adb", args...)
+}
+
+// tracefsPath returns the first of the standard tracefs locations the
+// device has tracing_on in.  Devices since Android 11 don't mount debugfs,
+// so only have /sys/kernel/tracing.
+func (fp *adbFileProvider) tracefsPath() string {
+	fp.tracefsOnce.Do(func() {
+		fp.tracefs = ftracePath
+		for _, dir := range tracefsPaths {
+			if _, err := fp.cat(path.Join(dir, "tracing_on")); err == nil {
+				fp.tracefs = dir
+				break
+			}
+		}
+	})
+	return fp.tracefs
+}
+
+func (fp *adbFileProvider) cat(filename string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := fp.command("exec-out", "cat", shellQuote(filename))
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		return nil, adbError(filename, err, stderr.Bytes())
+	}
+	// Older versions of adb do not return the exit status of the remote
+	// command, so treat anything on stderr as a failure
+	if stderr.Len() > 0 {
+		return nil, adbError(filename, nil, stderr.Bytes())
+	}
+	return stdout.Bytes(), nil
+}
+
+func (fp *adbFileProvider) ReadFtraceFile(filename string) ([]byte, error) {
+	if !SafeFtracePath(filename) {
+		return nil, BadFtraceFileName
+	}
+	return fp.cat(path.Join(fp.tracefsPath(), filename))
+}
+
+func (fp *adbFileProvider) ReadProcFile(filename string) ([]byte, error) {
+	if !SafeProcPath(filename) {
+		return nil, BadProcFileName
+	}
+	return fp.cat(path.Join(procPath, filename))
+}
+
+func (fp *adbFileProvider) ReadPstoreFile(filename string) ([]byte, error) {
+	if !SafePstorePath(filename) {
+		return nil, BadPstoreFileName
+	}
+	return fp.cat(path.Join(pstorePath, filename))
+}
+
+func (fp *adbFileProvider) WriteFtraceFile(filename string, data []byte) error {
+	if !SafeFtracePath(filename) {
+		return BadFtraceFileName
+	}
+	filename = path.Join(fp.tracefsPath(), filename)
+
+	cmd := fp.command("shell", "printf %s "+shellQuote(string(data))+" > "+shellQuote(filename))
+	out, err := cmd.CombinedOutput()
+	if err != nil || len(out) > 0 {
+		return adbError(filename, err, out)
+	}
+	return nil
+}
+
+func (fp *adbFileProvider) OpenFtrace(filename string) (io.ReadCloser, error) {
+	if !SafeFtracePath(filename) {
+		return nil, BadFtraceFileName
+	}
+
+	cmd := fp.command("exec-out", "cat", shellQuote(path.Join(fp.tracefsPath(), filename)))
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+
+	return &adbReader{
+		ReadCloser: stdout,
+		cmd:        cmd,
+	}, nil
+}
+
+// adbReader streams the output of an adb command, and kills the command
+// when closed
+type adbReader struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+}
+
+func (r *adbReader) Close() error {
+	r.cmd.Process.Kill()
+	r.ReadCloser.Close()
+	r.cmd.Wait()
+	return nil
+}
+
+func adbError(filename string, err error, output []byte) error {
+	msg := strings.TrimSpace(string(output))
+	switch {
+	case err != nil && msg != "":
+		return fmt.Errorf("adb %s: %s: %s", filename, err.Error(), msg)
+	case err != nil:
+		return fmt.Errorf("adb %s: %s", filename, err.Error())
+	default:
+		return fmt.Errorf("adb %s: %s", filename, msg)
+	}
+}
+
+// shellQuote quotes a string so it is passed unmodified through the device
+// shell
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,88 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		s, want string
+	}{
+		{"", "''"},
+		{"/sys/kernel/tracing/trace", "'/sys/kernel/tracing/trace'"},
+		{"a b; rm -rf /", "'a b; rm -rf /'"},
+		{"it's", `'it'\''s'`},
+	}
+	for _, test := range tests {
+		if got := shellQuote(test.s); got != test.want {
+			t.Errorf("shellQuote(%q): want %s got %s", test.s, test.want, got)
+		}
+	}
+}
+
+func TestAdbError(t *testing.T) {
+	exit := errors.New("exit status 1")
+	tests := []struct {
+		err    error
+		output string
+		want   string
+	}{
+		{exit, "cat: No such file\n", "adb trace: exit status 1: cat: No such file"},
+		{exit, "", "adb trace: exit status 1"},
+		{nil, "Permission denied\n", "adb trace: Permission denied"},
+	}
+	for _, test := range tests {
+		if got := adbError("trace", test.err, []byte(test.output)).Error(); got != test.want {
+			t.Errorf("want %q got %q", test.want, got)
+		}
+	}
+}
+
+// fakeAdb is an adb for a device with only /sys/kernel/tracing
+const fakeAdb = `#!/bin/sh
+if [ "$1" = "-s" ]; then shift 2; fi
+case "$3" in
+"'/sys/kernel/tracing/tracing_on'") echo 1 ;;
+"'/sys/kernel/tracing/trace_clock'") echo "[local] global" ;;
+*) echo "cat: $3: No such file or directory" >&2; exit 1 ;;
+esac
+`
+
+func TestAdbTracefs(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell script as adb")
+	}
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "adb"), []byte(fakeAdb), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	fp := NewAdbFileProvider("emulator-5554")
+	data, err := fp.ReadFtraceFile("trace_clock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "[local] global\n" {
+		t.Errorf("want trace_clock from /sys/kernel/tracing got %q", data)
+	}
+}
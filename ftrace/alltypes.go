@@ -0,0 +1,127 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// eventIDsRefreshInterval limits how often the event IDs are reread for an
+// ID with no known event
+const eventIDsRefreshInterval = time.Second
+
+// lazyEventTypes registers event types the first time their ID is seen in
+// the ring buffer, for captures with every event enabled or with
+// CaptureOptions.DiscoverEventTypes.  Parsing all of the format files up
+// front is slow, and most events never fire.
+type lazyEventTypes struct {
+	sync.Mutex
+	// paths maps event IDs to "<system>/<event>" paths, read on first use
+	paths map[int]string
+	// pathsRead is when paths was last read
+	pathsRead time.Time
+	// types holds the parsed types, or nil for IDs that failed to parse
+	types map[int]*EventType
+	// allEnabled is set by EnableAllEvents, for Close to undo
+	allEnabled bool
+}
+
+// EnableAllEvents turns on every event the kernel can trace.  Events that
+// were not registered with NewEventType are registered as they are
+// decoded, so everything in the ring buffer is captured, for exploratory
+// debugging where the interesting event isn't known in advance.
+func (f *Ftrace) EnableAllEvents() error {
+	f.lazyTypes = &lazyEventTypes{allEnabled: true}
+	return f.fp.WriteFtraceFile("events/enable", []byte("1"))
+}
+
+// DisableAllEvents turns off every event
+func (f *Ftrace) DisableAllEvents() error {
+	return f.fp.WriteFtraceFile("events/enable", []byte("0"))
+}
+
+// eventType returns the EventType registered for id, falling back to
+// parsing its format file if EnableAllEvents was called or the capture
+// discovers event types
+func (f *Ftrace) eventType(id int) *EventType {
+	if etype := f.eventTypes[id]; etype != nil || f.lazyTypes == nil {
+		return etype
+	}
+
+	l := f.lazyTypes
+	l.Lock()
+	defer l.Unlock()
+
+	if etype, ok := l.types[id]; ok {
+		return etype
+	}
+
+	p, ok := l.paths[id]
+	if now := time.Now(); !ok && now.Sub(l.pathsRead) >= eventIDsRefreshInterval {
+		// Read the IDs on first use, and again for the IDs of events
+		// created since, like kprobes added during the capture.  The
+		// other cpus' events are decoded meanwhile, and unknown IDs
+		// wait for the next read.
+		l.pathsRead = now
+		l.Unlock()
+		paths := f.readEventIDs()
+		l.Lock()
+		l.paths = paths
+		if etype, ok := l.types[id]; ok {
+			return etype
+		}
+		p, ok = l.paths[id]
+	}
+	if !ok {
+		return nil
+	}
+
+	etype, _ := newEventType(f.fp, p, f.defs, f.formatCache)
+	if etype != nil && etype.id != id {
+		etype = nil
+	}
+	if l.types == nil {
+		l.types = make(map[int]*EventType)
+	}
+	l.types[id] = etype
+	return etype
+}
+
+// readEventIDs maps the ID of every available event to its path
+func (f *Ftrace) readEventIDs() map[int]string {
+	paths := make(map[int]string)
+
+	available, err := f.AvailableEvents()
+	if err != nil {
+		return paths
+	}
+
+	for _, p := range available {
+		data, err := f.fp.ReadFtraceFile(path.Join("events", p, "id"))
+		if err != nil {
+			continue
+		}
+		id, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err != nil {
+			continue
+		}
+		paths[id] = p
+	}
+	return paths
+}
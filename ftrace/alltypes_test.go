@@ -0,0 +1,94 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEnableAllEvents(t *testing.T) {
+	f := newTestFtraceWith(t, map[string]string{
+		"/sys/kernel/debug/tracing/available_events":             "sched:sched_wakeup\ntask:task_newtask\n",
+		"/sys/kernel/debug/tracing/events/sched/sched_wakeup/id": "62\n",
+		"/sys/kernel/debug/tracing/events/task/task_newtask/id":  "110\n",
+	})
+
+	page := &testPage{timestamp: 1000000000}
+	page.addEvent(500, schedWakeup(1234, "bash", 120, 1))
+
+	if _, err := f.decodePage(2, page.bytes()); err == nil {
+		t.Fatal("expected an unknown type error before EnableAllEvents")
+	}
+
+	if err := f.EnableAllEvents(); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := f.decodePage(2, page.bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].EventType().Name() != "sched_wakeup" {
+		t.Fatalf("want a sched_wakeup event got %v", events)
+	}
+
+	if etype := f.eventType(999); etype != nil {
+		t.Errorf("want no type for an unknown ID got %s", etype.Name())
+	}
+}
+
+func TestDiscoverEventTypes(t *testing.T) {
+	files := testFilesWith(map[string]string{
+		"/sys/kernel/debug/tracing/available_events":             "sched:sched_wakeup\n",
+		"/sys/kernel/debug/tracing/events/sched/sched_wakeup/id": "62\n",
+	})
+	f := newTestFtrace(t, files)
+
+	if err := f.PrepareCaptureWithOptions(1, make(chan bool), CaptureOptions{DiscoverEventTypes: true}); err != nil {
+		t.Fatal(err)
+	}
+	if f.lazyTypes == nil || f.lazyTypes.allEnabled {
+		t.Fatal("want event types discovered without enabling all events")
+	}
+
+	page := &testPage{timestamp: 1000000000}
+	page.addEvent(500, schedWakeup(1234, "bash", 120, 1))
+	events, err := f.decodePage(0, page.bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].EventType().Name() != "sched_wakeup" {
+		t.Fatalf("want a sched_wakeup event got %v", events)
+	}
+
+	// An event created after the IDs were first read is found by reading
+	// them again, once the refresh interval has passed
+	files["/sys/kernel/debug/tracing/available_events"] += "task:task_newtask\n"
+	files["/sys/kernel/debug/tracing/events/task/task_newtask/id"] = "110\n"
+	page = &testPage{timestamp: 1000000000}
+	page.addEvent(500, taskNewtask(1234, 1300, "bash"))
+	if _, err := f.decodePage(0, page.bytes()); err == nil {
+		t.Fatal("want an unknown type error before the refresh interval")
+	}
+	f.lazyTypes.pathsRead = time.Time{}
+	events, err = f.decodePage(0, page.bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].EventType().Name() != "task_newtask" {
+		t.Fatalf("want a task_newtask event got %v", events)
+	}
+}
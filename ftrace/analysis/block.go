@@ -0,0 +1,133 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package analysis
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/traceout/ftrace"
+)
+
+// BlockRequest is a block I/O request from its issue to the device until it
+// completed
+type BlockRequest struct {
+	// Dev is the kernel dev_t of the device
+	Dev     uint64
+	Sector  uint64
+	Sectors uint64
+	// RWBS is the kernel's description of the request, like R or WS, if the
+	// events have it
+	RWBS            string
+	Issue, Complete uint64
+	Latency         time.Duration
+}
+
+// Device returns the device of the request as major,minor
+func (r BlockRequest) Device() string {
+	return DevName(r.Dev)
+}
+
+func (r BlockRequest) String() string {
+	return fmt.Sprintf("%s %s sector %d + %d took %v, issued at %d",
+		r.Device(), r.RWBS, r.Sector, r.Sectors, r.Latency, r.Issue)
+}
+
+// DevName formats a kernel dev_t as major,minor
+func DevName(dev uint64) string {
+	return fmt.Sprintf("%d,%d", dev>>20, dev&(1<<20-1))
+}
+
+// BlockLatencies pairs block_rq_issue events with the block_rq_complete of
+// the same device and sector to measure how long requests took.  Requests
+// issued before the capture started, or not completed by its end, aren't
+// counted.
+type BlockLatencies struct {
+	Requests []BlockRequest
+
+	issued map[blockKey]BlockRequest
+}
+
+type blockKey struct {
+	dev, sector uint64
+}
+
+func NewBlockLatencies() *BlockLatencies {
+	return &BlockLatencies{issued: make(map[blockKey]BlockRequest)}
+}
+
+// Add updates the latencies with an event.  Events must be added in time
+// order.
+func (b *BlockLatencies) Add(e *ftrace.Event) {
+	name := e.EventType().Name()
+	if name != "block_rq_issue" && name != "block_rq_complete" {
+		return
+	}
+	dev, err := e.Uint("dev")
+	if err != nil {
+		return
+	}
+	sector, err := e.Uint("sector")
+	if err != nil {
+		return
+	}
+	key := blockKey{dev, sector}
+
+	if name == "block_rq_issue" {
+		r := BlockRequest{Dev: dev, Sector: sector, Issue: e.When}
+		r.Sectors, _ = e.Uint("nr_sector")
+		r.RWBS, _ = e.Str("rwbs")
+		b.issued[key] = r
+		return
+	}
+
+	r, ok := b.issued[key]
+	if !ok {
+		return
+	}
+	delete(b.issued, key)
+	r.Complete = e.When
+	r.Latency = time.Duration(r.Complete - r.Issue)
+	b.Requests = append(b.Requests, r)
+}
+
+// ByDevice returns the distribution of the latencies of each device, by
+// dev_t
+func (b *BlockLatencies) ByDevice() map[uint64]Distribution {
+	durations := make(map[uint64][]time.Duration)
+	for _, r := range b.Requests {
+		durations[r.Dev] = append(durations[r.Dev], r.Latency)
+	}
+	distributions := make(map[uint64]Distribution)
+	for dev, d := range durations {
+		distributions[dev] = NewDistribution(d)
+	}
+	return distributions
+}
+
+// Outliers returns the requests that took longer than factor times the
+// median latency of their device, slowest first
+func (b *BlockLatencies) Outliers(factor float64) []BlockRequest {
+	byDevice := b.ByDevice()
+	var outliers []BlockRequest
+	for _, r := range b.Requests {
+		if float64(r.Latency) > factor*float64(byDevice[r.Dev].P50) {
+			outliers = append(outliers, r)
+		}
+	}
+	sort.SliceStable(outliers, func(i, j int) bool { return outliers[i].Latency > outliers[j].Latency })
+	return outliers
+}
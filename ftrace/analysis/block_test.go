@@ -0,0 +1,85 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package analysis
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+const blockRqFormat = `name: %s
+ID: %d
+format:
+` + commonFields + `
+	field:dev_t dev;	offset:8;	size:4;	signed:0;
+	field:sector_t sector;	offset:16;	size:8;	signed:0;
+	field:unsigned int nr_sector;	offset:24;	size:4;	signed:0;
+	field:char rwbs[8];	offset:28;	size:8;	signed:1;
+
+print fmt: "%%d,%%d %%s %%llu + %%u", ((unsigned int) ((REC->dev) >> 20)), ((unsigned int) ((REC->dev) & ((1U << 20) - 1))), REC->rwbs, (unsigned long long)REC->sector, REC->nr_sector
+`
+
+func TestBlockLatencies(t *testing.T) {
+	issue := eventType(t, fmt.Sprintf(blockRqFormat, "block_rq_issue", 1001))
+	complete := eventType(t, fmt.Sprintf(blockRqFormat, "block_rq_complete", 1002))
+
+	sda := uint64(8 << 20)
+	sdb := uint64(8<<20 | 16)
+	ms := uint64(time.Millisecond)
+	te := &testEvents{t: t}
+	// Completion of a request issued before the capture
+	te.add(complete, 0, 0, map[string]interface{}{"dev": sda, "sector": 50})
+	te.add(issue, 0, 0, map[string]interface{}{"dev": sda, "sector": 100, "nr_sector": 8, "rwbs": "R"})
+	te.add(issue, 0, 1*ms, map[string]interface{}{"dev": sda, "sector": 200, "nr_sector": 8, "rwbs": "R"})
+	te.add(issue, 0, 1*ms, map[string]interface{}{"dev": sda, "sector": 300, "nr_sector": 8, "rwbs": "WS"})
+	te.add(issue, 1, 1*ms, map[string]interface{}{"dev": sdb, "sector": 100, "nr_sector": 16, "rwbs": "R"})
+	te.add(complete, 0, 2*ms, map[string]interface{}{"dev": sda, "sector": 100})
+	te.add(complete, 1, 3*ms, map[string]interface{}{"dev": sdb, "sector": 100})
+	te.add(complete, 0, 3*ms, map[string]interface{}{"dev": sda, "sector": 200})
+	te.add(complete, 0, 21*ms, map[string]interface{}{"dev": sda, "sector": 300})
+	// Never completed
+	te.add(issue, 0, 22*ms, map[string]interface{}{"dev": sda, "sector": 400})
+
+	b := NewBlockLatencies()
+	for _, e := range te.events {
+		b.Add(e)
+	}
+
+	if len(b.Requests) != 4 {
+		t.Fatalf("want 4 requests got %v", b.Requests)
+	}
+	want := BlockRequest{Dev: sda, Sector: 100, Sectors: 8, RWBS: "R", Issue: 0, Complete: 2 * ms, Latency: 2 * time.Millisecond}
+	if b.Requests[0] != want {
+		t.Errorf("want %+v got %+v", want, b.Requests[0])
+	}
+
+	byDevice := b.ByDevice()
+	wantSda := Distribution{Count: 3, Min: 2 * time.Millisecond, Mean: 8 * time.Millisecond, P50: 2 * time.Millisecond, P90: 20 * time.Millisecond, P99: 20 * time.Millisecond, Max: 20 * time.Millisecond}
+	if len(byDevice) != 2 || byDevice[sda] != wantSda || byDevice[sdb].Count != 1 {
+		t.Errorf("unexpected distributions %v", byDevice)
+	}
+
+	outliers := b.Outliers(5)
+	if len(outliers) != 1 || outliers[0].Sector != 300 {
+		t.Fatalf("want the sector 300 request as outlier got %v", outliers)
+	}
+	if got, want := outliers[0].String(), "8,0 WS sector 300 + 8 took 20ms, issued at 1000000"; got != want {
+		t.Errorf("want %q got %q", want, got)
+	}
+	if got := DevName(sdb); got != "8,16" {
+		t.Errorf("want 8,16 got %s", got)
+	}
+}
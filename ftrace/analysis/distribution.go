@@ -0,0 +1,93 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package analysis
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Distribution summarizes a set of durations
+type Distribution struct {
+	Count              int
+	Min, Mean          time.Duration
+	P50, P90, P99, Max time.Duration
+}
+
+// NewDistribution computes the summary of durations, which it sorts
+func NewDistribution(durations []time.Duration) Distribution {
+	if len(durations) == 0 {
+		return Distribution{}
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	var total time.Duration
+	for _, d := range durations {
+		total += d
+	}
+	return Distribution{
+		Count: len(durations),
+		Min:   durations[0],
+		Mean:  total / time.Duration(len(durations)),
+		P50:   Percentile(durations, 50),
+		P90:   Percentile(durations, 90),
+		P99:   Percentile(durations, 99),
+		Max:   durations[len(durations)-1],
+	}
+}
+
+func (d Distribution) String() string {
+	return fmt.Sprintf("count=%d min=%v mean=%v p50=%v p90=%v p99=%v max=%v",
+		d.Count, d.Min, d.Mean, d.P50, d.P90, d.P99, d.Max)
+}
+
+// Percentile returns the p'th percentile of sorted durations, using the
+// nearest rank
+func Percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := (len(sorted)*p + 99) / 100
+	if i > 0 {
+		i--
+	}
+	return sorted[i]
+}
+
+// Histogram counts durations in power of two buckets of microseconds.
+// Bucket 0 counts durations under 1µs, and bucket i those from 2^(i-1)µs up
+// to 2^i µs.
+type Histogram []int
+
+// NewHistogram counts durations into a Histogram
+func NewHistogram(durations []time.Duration) Histogram {
+	var h Histogram
+	for _, d := range durations {
+		i := 0
+		for us := d / time.Microsecond; us > 0; us >>= 1 {
+			i++
+		}
+		for len(h) <= i {
+			h = append(h, 0)
+		}
+		h[i]++
+	}
+	return h
+}
+
+// Bound returns the upper bound of bucket i
+func (h Histogram) Bound(i int) time.Duration {
+	return time.Microsecond << uint(i)
+}
@@ -0,0 +1,61 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package analysis
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	l := []time.Duration{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	for _, c := range []struct {
+		p    int
+		want time.Duration
+	}{{50, 5}, {90, 9}, {99, 10}, {100, 10}, {1, 1}} {
+		if got := Percentile(l, c.p); got != c.want {
+			t.Errorf("percentile %d: want %d got %d", c.p, c.want, got)
+		}
+	}
+	if got := Percentile(nil, 50); got != 0 {
+		t.Errorf("percentile of nothing: want 0 got %d", got)
+	}
+}
+
+func TestDistribution(t *testing.T) {
+	d := NewDistribution([]time.Duration{40, 10, 30, 20})
+	want := Distribution{Count: 4, Min: 10, Mean: 25, P50: 20, P90: 40, P99: 40, Max: 40}
+	if d != want {
+		t.Errorf("want %v got %v", want, d)
+	}
+	if d := NewDistribution(nil); d != (Distribution{}) {
+		t.Errorf("want empty distribution got %v", d)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram([]time.Duration{500, time.Microsecond, 1500, 3 * time.Microsecond, 4 * time.Microsecond, 100 * time.Microsecond})
+	want := Histogram{1, 2, 1, 1, 0, 0, 0, 1}
+	if !reflect.DeepEqual(h, want) {
+		t.Errorf("want %v got %v", want, h)
+	}
+	if got := h.Bound(2); got != 4*time.Microsecond {
+		t.Errorf("want bound 4µs got %v", got)
+	}
+	if h := NewHistogram(nil); len(h) != 0 {
+		t.Errorf("want empty histogram got %v", h)
+	}
+}
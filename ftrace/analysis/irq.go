@@ -0,0 +1,172 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package analysis
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/traceout/ftrace"
+)
+
+// softirqNames are the names of the softirq vectors, as printed by the
+// kernel's softirq events
+var softirqNames = []string{"HI", "TIMER", "NET_TX", "NET_RX", "BLOCK", "IRQ_POLL", "TASKLET", "SCHED", "HRTIMER", "RCU"}
+
+// SoftirqName returns the name of a softirq vector
+func SoftirqName(vec int) string {
+	if vec >= 0 && vec < len(softirqNames) {
+		return softirqNames[vec]
+	}
+	return fmt.Sprintf("softirq %d", vec)
+}
+
+// IRQTime is the time spent handling one IRQ or softirq vector
+type IRQTime struct {
+	// IRQ is the IRQ number, or the softirq vector
+	IRQ   int
+	Name  string
+	Count int
+	// Total is the time spent in all the handler runs
+	Total     time.Duration
+	Durations Distribution
+	Histogram Histogram
+}
+
+// irqKey identifies a handler, hard IRQs and softirqs being numbered apart
+type irqKey struct {
+	softirq bool
+	irq     int
+}
+
+type irqRun struct {
+	irq   int
+	entry uint64
+}
+
+// IRQTimes measures the time spent in IRQ handlers from irq_handler_entry
+// to irq_handler_exit, and in softirqs from softirq_entry to softirq_exit.
+// Handlers interrupted by the end of the capture aren't counted.
+type IRQTimes struct {
+	durations map[irqKey][]time.Duration
+	names     map[int]string
+	cpuTime   map[int]time.Duration
+
+	// The handlers running on each cpu
+	irqRunning     map[int]irqRun
+	softirqRunning map[int]irqRun
+}
+
+func NewIRQTimes() *IRQTimes {
+	return &IRQTimes{
+		durations:      make(map[irqKey][]time.Duration),
+		names:          make(map[int]string),
+		cpuTime:        make(map[int]time.Duration),
+		irqRunning:     make(map[int]irqRun),
+		softirqRunning: make(map[int]irqRun),
+	}
+}
+
+// Add updates the times with an event.  Events must be added in time order.
+func (t *IRQTimes) Add(e *ftrace.Event) {
+	switch e.EventType().Name() {
+	case "irq_handler_entry":
+		irq, err := e.Int("irq")
+		if err != nil {
+			return
+		}
+		if name, err := e.Str("name"); err == nil {
+			t.names[int(irq)] = name
+		}
+		t.irqRunning[e.Cpu] = irqRun{int(irq), e.When}
+	case "irq_handler_exit":
+		t.exit(t.irqRunning, false, e, "irq")
+	case "softirq_entry":
+		vec, err := e.Int("vec")
+		if err != nil {
+			return
+		}
+		t.softirqRunning[e.Cpu] = irqRun{int(vec), e.When}
+	case "softirq_exit":
+		t.exit(t.softirqRunning, true, e, "vec")
+	}
+}
+
+func (t *IRQTimes) exit(running map[int]irqRun, softirq bool, e *ftrace.Event, field string) {
+	irq, err := e.Int(field)
+	if err != nil {
+		return
+	}
+	run, ok := running[e.Cpu]
+	if !ok || run.irq != int(irq) {
+		return
+	}
+	delete(running, e.Cpu)
+	d := time.Duration(e.When - run.entry)
+	key := irqKey{softirq, int(irq)}
+	t.durations[key] = append(t.durations[key], d)
+	t.cpuTime[e.Cpu] += d
+}
+
+func (t *IRQTimes) times(softirq bool) []IRQTime {
+	var times []IRQTime
+	for key, d := range t.durations {
+		if key.softirq != softirq {
+			continue
+		}
+		it := IRQTime{
+			IRQ:       key.irq,
+			Count:     len(d),
+			Durations: NewDistribution(d),
+			Histogram: NewHistogram(d),
+		}
+		for _, d := range d {
+			it.Total += d
+		}
+		if softirq {
+			it.Name = SoftirqName(key.irq)
+		} else {
+			it.Name = t.names[key.irq]
+		}
+		times = append(times, it)
+	}
+	sort.Slice(times, func(i, j int) bool {
+		if times[i].Total != times[j].Total {
+			return times[i].Total > times[j].Total
+		}
+		return times[i].IRQ < times[j].IRQ
+	})
+	return times
+}
+
+// IRQs returns the time spent in each hard IRQ's handlers, longest first
+func (t *IRQTimes) IRQs() []IRQTime {
+	return t.times(false)
+}
+
+// Softirqs returns the time spent in each softirq vector, longest first
+func (t *IRQTimes) Softirqs() []IRQTime {
+	return t.times(true)
+}
+
+// CPUTime returns the total time each cpu spent in IRQs and softirqs
+func (t *IRQTimes) CPUTime() map[int]time.Duration {
+	cpuTime := make(map[int]time.Duration)
+	for cpu, d := range t.cpuTime {
+		cpuTime[cpu] = d
+	}
+	return cpuTime
+}
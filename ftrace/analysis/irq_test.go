@@ -0,0 +1,105 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package analysis
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const irqHandlerEntryFormat = `name: irq_handler_entry
+ID: 95
+format:
+` + commonFields + `
+	field:int irq;	offset:8;	size:4;	signed:1;
+	field:__data_loc char[] name;	offset:12;	size:4;	signed:1;
+
+print fmt: "irq=%d name=%s", REC->irq, __get_str(name)
+`
+
+const irqHandlerExitFormat = `name: irq_handler_exit
+ID: 94
+format:
+` + commonFields + `
+	field:int irq;	offset:8;	size:4;	signed:1;
+	field:int ret;	offset:12;	size:4;	signed:1;
+
+print fmt: "irq=%d ret=%s", REC->irq, REC->ret ? "handled" : "unhandled"
+`
+
+const softirqFormat = `name: %s
+ID: %d
+format:
+` + commonFields + `
+	field:unsigned int vec;	offset:8;	size:4;	signed:0;
+
+print fmt: "vec=%%u", REC->vec
+`
+
+func TestIRQTimes(t *testing.T) {
+	irqEntry := eventType(t, irqHandlerEntryFormat)
+	irqExit := eventType(t, irqHandlerExitFormat)
+	softirqEntry := eventType(t, fmt.Sprintf(softirqFormat, "softirq_entry", 93))
+	softirqExit := eventType(t, fmt.Sprintf(softirqFormat, "softirq_exit", 92))
+
+	us := uint64(time.Microsecond)
+	te := &testEvents{t: t}
+	// Exit of a handler entered before the capture
+	te.add(irqExit, 0, 0, map[string]interface{}{"irq": 42})
+	te.add(irqEntry, 0, 10*us, map[string]interface{}{"irq": 42, "name": "eth0"})
+	te.add(irqEntry, 1, 11*us, map[string]interface{}{"irq": 16, "name": "ahci"})
+	te.add(irqExit, 0, 13*us, map[string]interface{}{"irq": 42})
+	te.add(irqExit, 1, 12*us, map[string]interface{}{"irq": 16})
+	te.add(softirqEntry, 0, 14*us, map[string]interface{}{"vec": 3})
+	te.add(softirqExit, 0, 34*us, map[string]interface{}{"vec": 3})
+	te.add(irqEntry, 0, 40*us, map[string]interface{}{"irq": 42, "name": "eth0"})
+	te.add(irqExit, 0, 45*us, map[string]interface{}{"irq": 42})
+	// Still running when the capture ended
+	te.add(softirqEntry, 1, 50*us, map[string]interface{}{"vec": 1})
+
+	it := NewIRQTimes()
+	for _, e := range te.events {
+		it.Add(e)
+	}
+
+	irqs := it.IRQs()
+	if len(irqs) != 2 {
+		t.Fatalf("want 2 irqs got %v", irqs)
+	}
+	eth0 := irqs[0]
+	if eth0.IRQ != 42 || eth0.Name != "eth0" || eth0.Count != 2 || eth0.Total != 8*time.Microsecond ||
+		eth0.Durations.Max != 5*time.Microsecond || !reflect.DeepEqual(eth0.Histogram, Histogram{0, 0, 1, 1}) {
+		t.Errorf("unexpected eth0 times %+v", eth0)
+	}
+	if irqs[1].IRQ != 16 || irqs[1].Name != "ahci" || irqs[1].Total != time.Microsecond {
+		t.Errorf("unexpected ahci times %+v", irqs[1])
+	}
+
+	softirqs := it.Softirqs()
+	if len(softirqs) != 1 || softirqs[0].Name != "NET_RX" || softirqs[0].Total != 20*time.Microsecond {
+		t.Errorf("unexpected softirq times %+v", softirqs)
+	}
+
+	want := map[int]time.Duration{0: 28 * time.Microsecond, 1: time.Microsecond}
+	if got := it.CPUTime(); !reflect.DeepEqual(got, want) {
+		t.Errorf("want cpu times %v got %v", want, got)
+	}
+
+	if got := SoftirqName(12); got != "softirq 12" {
+		t.Errorf("unexpected name %s", got)
+	}
+}
@@ -0,0 +1,273 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package analysis turns captured events into higher level facts, like how
+// long each thread ran or waited to run.  Analyses are fed events in time
+// order with Add, and ignore events they don't use, so one pass over a
+// capture can feed several of them.
+package analysis
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/traceout/ftrace"
+)
+
+// ThreadState is what a thread was doing during an Interval
+type ThreadState int
+
+const (
+	// Unknown is the state before a thread's first scheduler event
+	Unknown ThreadState = iota
+	// Running on a cpu
+	Running
+	// Runnable is waiting for a cpu, after a wakeup or preemption
+	Runnable
+	// Sleeping in an interruptible wait
+	Sleeping
+	// Blocked in an uninterruptible wait, usually for I/O
+	Blocked
+	// Dead after exiting
+	Dead
+)
+
+func (s ThreadState) String() string {
+	switch s {
+	case Unknown:
+		return "unknown"
+	case Running:
+		return "running"
+	case Runnable:
+		return "runnable"
+	case Sleeping:
+		return "sleeping"
+	case Blocked:
+		return "blocked"
+	case Dead:
+		return "dead"
+	}
+	return fmt.Sprintf("ThreadState(%d)", int(s))
+}
+
+// Task state bits in sched_switch's prev_state
+const (
+	taskInterruptible   = 0x01
+	taskUninterruptible = 0x02
+	taskDead            = 0x10 | 0x20 | 0x40 | 0x80
+)
+
+// Interval is a time a thread spent in one state, from Start up to End in
+// nanoseconds.  CPU is the cpu it ran on while Running, and -1 otherwise.
+type Interval struct {
+	Start, End uint64
+	State      ThreadState
+	CPU        int
+}
+
+func (i Interval) Duration() time.Duration {
+	return time.Duration(i.End - i.Start)
+}
+
+// Thread is the scheduling history of one thread
+type Thread struct {
+	Pid       int
+	Comm      string
+	Intervals []Interval
+
+	// The current state, not yet in Intervals
+	state ThreadState
+	since uint64
+	cpu   int
+}
+
+// CPUTime returns the total time the thread was Running
+func (t *Thread) CPUTime() time.Duration {
+	var d time.Duration
+	for _, i := range t.Intervals {
+		if i.State == Running {
+			d += i.Duration()
+		}
+	}
+	return d
+}
+
+// TimeIn returns the total time the thread spent in a state
+func (t *Thread) TimeIn(state ThreadState) time.Duration {
+	var d time.Duration
+	for _, i := range t.Intervals {
+		if i.State == state {
+			d += i.Duration()
+		}
+	}
+	return d
+}
+
+// StateAt returns the state of the thread at a time
+func (t *Thread) StateAt(when uint64) ThreadState {
+	n := sort.Search(len(t.Intervals), func(i int) bool {
+		return t.Intervals[i].End > when
+	})
+	if n < len(t.Intervals) && t.Intervals[n].Start <= when {
+		return t.Intervals[n].State
+	}
+	return Unknown
+}
+
+// Between returns the thread's intervals that overlap start to end, clipped
+// to it
+func (t *Thread) Between(start, end uint64) []Interval {
+	var intervals []Interval
+	n := sort.Search(len(t.Intervals), func(i int) bool {
+		return t.Intervals[i].End > start
+	})
+	for ; n < len(t.Intervals) && t.Intervals[n].Start < end; n++ {
+		i := t.Intervals[n]
+		if i.Start < start {
+			i.Start = start
+		}
+		if i.End > end {
+			i.End = end
+		}
+		intervals = append(intervals, i)
+	}
+	return intervals
+}
+
+// SchedTimeline builds the state intervals of every thread from the
+// sched_switch, sched_wakeup, sched_wakeup_new and task_newtask events.  A
+// thread's history starts at its first scheduler event, since its state
+// before that isn't known.  The idle threads, pid 0, are left out.
+type SchedTimeline struct {
+	threads map[int]*Thread
+}
+
+func NewSchedTimeline() *SchedTimeline {
+	return &SchedTimeline{threads: make(map[int]*Thread)}
+}
+
+func (s *SchedTimeline) thread(pid int) *Thread {
+	t := s.threads[pid]
+	if t == nil {
+		t = &Thread{Pid: pid, cpu: -1}
+		s.threads[pid] = t
+	}
+	return t
+}
+
+// transition moves a thread to a new state at when
+func (s *SchedTimeline) transition(pid int, state ThreadState, cpu int, when uint64) {
+	if pid == 0 {
+		return
+	}
+	t := s.thread(pid)
+	if t.state != Unknown && when > t.since {
+		t.Intervals = append(t.Intervals, Interval{t.since, when, t.state, t.cpu})
+	}
+	t.state = state
+	t.since = when
+	t.cpu = -1
+	if state == Running {
+		t.cpu = cpu
+	}
+}
+
+func (s *SchedTimeline) setComm(pid int, e *ftrace.Event, field string) {
+	if pid == 0 {
+		return
+	}
+	if comm, err := e.Str(field); err == nil && comm != "" {
+		s.thread(pid).Comm = comm
+	}
+}
+
+// Add updates the timeline with an event.  Events must be added in time
+// order.
+func (s *SchedTimeline) Add(e *ftrace.Event) {
+	switch e.EventType().Name() {
+	case "sched_switch":
+		prev, err := e.Int("prev_pid")
+		if err != nil {
+			return
+		}
+		next, err := e.Int("next_pid")
+		if err != nil {
+			return
+		}
+		prevState, _ := e.Int("prev_state")
+
+		state := Runnable
+		switch {
+		case prevState&taskDead != 0:
+			state = Dead
+		case prevState&taskUninterruptible != 0:
+			state = Blocked
+		case prevState&taskInterruptible != 0:
+			state = Sleeping
+		}
+
+		s.setComm(int(prev), e, "prev_comm")
+		s.setComm(int(next), e, "next_comm")
+		s.transition(int(prev), state, e.Cpu, e.When)
+		s.transition(int(next), Running, e.Cpu, e.When)
+
+	case "sched_wakeup", "sched_wakeup_new":
+		pid, err := e.Int("pid")
+		if err != nil {
+			return
+		}
+		s.setComm(int(pid), e, "comm")
+		// A wakeup of a running thread, or one already woken, changes
+		// nothing
+		if t := s.thread(int(pid)); t.state != Running && t.state != Runnable {
+			s.transition(int(pid), Runnable, -1, e.When)
+		}
+
+	case "task_newtask":
+		pid, err := e.Int("pid")
+		if err != nil {
+			return
+		}
+		s.setComm(int(pid), e, "comm")
+	}
+}
+
+// End closes every thread's current interval at when, usually the time of
+// the last event of the capture.  Threads keep their state, so more events
+// can be added afterwards.
+func (s *SchedTimeline) End(when uint64) {
+	for _, t := range s.threads {
+		if t.state != Unknown && t.state != Dead && when > t.since {
+			t.Intervals = append(t.Intervals, Interval{t.since, when, t.state, t.cpu})
+			t.since = when
+		}
+	}
+}
+
+// Thread returns the history of a thread, or nil if it had no scheduler
+// events
+func (s *SchedTimeline) Thread(pid int) *Thread {
+	return s.threads[pid]
+}
+
+// Threads returns every thread, sorted by pid
+func (s *SchedTimeline) Threads() []*Thread {
+	threads := make([]*Thread, 0, len(s.threads))
+	for _, t := range s.threads {
+		threads = append(threads, t)
+	}
+	sort.Slice(threads, func(i, j int) bool { return threads[i].Pid < threads[j].Pid })
+	return threads
+}
@@ -0,0 +1,191 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package analysis
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/traceout/ftrace"
+)
+
+const commonFields = `	field:unsigned short common_type;	offset:0;	size:2;	signed:0;
+	field:unsigned char common_flags;	offset:2;	size:1;	signed:0;
+	field:unsigned char common_preempt_count;	offset:3;	size:1;	signed:0;
+	field:int common_pid;	offset:4;	size:4;	signed:1;
+`
+
+const schedSwitchFormat = `name: sched_switch
+ID: 61
+format:
+` + commonFields + `
+	field:char prev_comm[16];	offset:8;	size:16;	signed:0;
+	field:pid_t prev_pid;	offset:24;	size:4;	signed:1;
+	field:int prev_prio;	offset:28;	size:4;	signed:1;
+	field:long prev_state;	offset:32;	size:8;	signed:1;
+	field:char next_comm[16];	offset:40;	size:16;	signed:0;
+	field:pid_t next_pid;	offset:56;	size:4;	signed:1;
+	field:int next_prio;	offset:60;	size:4;	signed:1;
+
+print fmt: "prev_comm=%s prev_pid=%d next_comm=%s next_pid=%d", REC->prev_comm, REC->prev_pid, REC->next_comm, REC->next_pid
+`
+
+const schedWakeupFormat = `name: sched_wakeup
+ID: 63
+format:
+` + commonFields + `
+	field:char comm[16];	offset:8;	size:16;	signed:0;
+	field:pid_t pid;	offset:24;	size:4;	signed:1;
+	field:int prio;	offset:28;	size:4;	signed:1;
+	field:int target_cpu;	offset:32;	size:4;	signed:1;
+
+print fmt: "comm=%s pid=%d prio=%d target_cpu=%03d", REC->comm, REC->pid, REC->prio, REC->target_cpu
+`
+
+const taskNewtaskFormat = `name: task_newtask
+ID: 120
+format:
+` + commonFields + `
+	field:pid_t pid;	offset:8;	size:4;	signed:1;
+	field:char comm[16];	offset:12;	size:16;	signed:0;
+	field:unsigned long clone_flags;	offset:32;	size:8;	signed:0;
+	field:short oom_score_adj;	offset:40;	size:2;	signed:1;
+
+print fmt: "pid=%d comm=%s clone_flags=%lx oom_score_adj=%hd", REC->pid, REC->comm, REC->clone_flags, REC->oom_score_adj
+`
+
+func eventType(t *testing.T, format string) *ftrace.EventType {
+	etype, err := ftrace.ParseEventFormat([]byte(format))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return etype
+}
+
+type testEvents struct {
+	t      *testing.T
+	events ftrace.Events
+}
+
+func (te *testEvents) add(etype *ftrace.EventType, cpu int, when uint64, fields map[string]interface{}) {
+	e, err := etype.NewEvent(fields, cpu, when)
+	if err != nil {
+		te.t.Fatal(err)
+	}
+	te.events = append(te.events, e)
+}
+
+func TestSchedTimeline(t *testing.T) {
+	schedSwitch := eventType(t, schedSwitchFormat)
+	wakeup := eventType(t, schedWakeupFormat)
+	newtask := eventType(t, taskNewtaskFormat)
+
+	te := &testEvents{t: t}
+	ms := uint64(time.Millisecond)
+	te.add(newtask, 0, 0, map[string]interface{}{"pid": 20, "comm": "cc1"})
+	te.add(schedSwitch, 0, 1*ms, map[string]interface{}{"prev_pid": 0, "next_pid": 10, "next_comm": "make"})
+	te.add(wakeup, 0, 2*ms, map[string]interface{}{"pid": 20, "comm": "cc1"})
+	// make is preempted by cc1
+	te.add(schedSwitch, 0, 3*ms, map[string]interface{}{"prev_pid": 10, "prev_comm": "make", "prev_state": 0, "next_pid": 20, "next_comm": "cc1"})
+	// cc1 blocks on I/O
+	te.add(schedSwitch, 0, 5*ms, map[string]interface{}{"prev_pid": 20, "prev_comm": "cc1", "prev_state": 2, "next_pid": 10, "next_comm": "make"})
+	// make sleeps
+	te.add(schedSwitch, 0, 6*ms, map[string]interface{}{"prev_pid": 10, "prev_comm": "make", "prev_state": 1, "next_pid": 0})
+	te.add(wakeup, 1, 8*ms, map[string]interface{}{"pid": 20, "comm": "cc1"})
+	te.add(schedSwitch, 1, 9*ms, map[string]interface{}{"prev_pid": 0, "next_pid": 20, "next_comm": "cc1"})
+
+	s := NewSchedTimeline()
+	for _, e := range te.events {
+		s.Add(e)
+	}
+	s.End(10 * ms)
+
+	threads := s.Threads()
+	if len(threads) != 2 || threads[0].Pid != 10 || threads[1].Pid != 20 {
+		t.Fatalf("want threads 10 and 20 got %v", threads)
+	}
+
+	makeThread := s.Thread(10)
+	wantMake := []Interval{
+		{1 * ms, 3 * ms, Running, 0},
+		{3 * ms, 5 * ms, Runnable, -1},
+		{5 * ms, 6 * ms, Running, 0},
+		{6 * ms, 10 * ms, Sleeping, -1},
+	}
+	if makeThread.Comm != "make" || !reflect.DeepEqual(makeThread.Intervals, wantMake) {
+		t.Errorf("want make intervals %v got %s %v", wantMake, makeThread.Comm, makeThread.Intervals)
+	}
+	if got := makeThread.CPUTime(); got != 3*time.Millisecond {
+		t.Errorf("want make cpu time 3ms got %v", got)
+	}
+
+	cc1 := s.Thread(20)
+	wantCC1 := []Interval{
+		{2 * ms, 3 * ms, Runnable, -1},
+		{3 * ms, 5 * ms, Running, 0},
+		{5 * ms, 8 * ms, Blocked, -1},
+		{8 * ms, 9 * ms, Runnable, -1},
+		{9 * ms, 10 * ms, Running, 1},
+	}
+	if cc1.Comm != "cc1" || !reflect.DeepEqual(cc1.Intervals, wantCC1) {
+		t.Errorf("want cc1 intervals %v got %s %v", wantCC1, cc1.Comm, cc1.Intervals)
+	}
+	if got := cc1.CPUTime(); got != 3*time.Millisecond {
+		t.Errorf("want cc1 cpu time 3ms got %v", got)
+	}
+	if got := cc1.TimeIn(Runnable); got != 2*time.Millisecond {
+		t.Errorf("want cc1 runnable time 2ms got %v", got)
+	}
+
+	for _, c := range []struct {
+		when uint64
+		want ThreadState
+	}{{0, Unknown}, {2 * ms, Runnable}, {4 * ms, Running}, {5 * ms, Blocked}, {9*ms + 1, Running}, {10 * ms, Unknown}} {
+		if got := cc1.StateAt(c.when); got != c.want {
+			t.Errorf("cc1 state at %d: want %v got %v", c.when, c.want, got)
+		}
+	}
+
+	wantBetween := []Interval{
+		{4 * ms, 5 * ms, Running, 0},
+		{5 * ms, 7 * ms, Blocked, -1},
+	}
+	if got := cc1.Between(4*ms, 7*ms); !reflect.DeepEqual(got, wantBetween) {
+		t.Errorf("want cc1 intervals between 4ms and 7ms %v got %v", wantBetween, got)
+	}
+}
+
+func TestSchedTimelineExit(t *testing.T) {
+	schedSwitch := eventType(t, schedSwitchFormat)
+
+	te := &testEvents{t: t}
+	te.add(schedSwitch, 0, 100, map[string]interface{}{"prev_pid": 0, "next_pid": 30, "next_comm": "true"})
+	te.add(schedSwitch, 0, 200, map[string]interface{}{"prev_pid": 30, "prev_comm": "true", "prev_state": 0x40, "next_pid": 0})
+
+	s := NewSchedTimeline()
+	for _, e := range te.events {
+		s.Add(e)
+	}
+	s.End(1000)
+
+	want := []Interval{{100, 200, Running, 0}}
+	if got := s.Thread(30).Intervals; !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v got %v", want, got)
+	}
+	if s.Thread(0) != nil {
+		t.Errorf("idle thread in timeline")
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package analysis
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/traceout/ftrace"
+)
+
+// SyscallTime is the time spent in one syscall
+type SyscallTime struct {
+	Nr int
+	// Name is the name of the syscall, like openat, or NR and the number if
+	// it isn't known
+	Name  string
+	Count int
+	// Errors is the number of calls that returned an error
+	Errors    int
+	Total     time.Duration
+	Durations Distribution
+}
+
+type syscallEntry struct {
+	nr    int64
+	entry uint64
+}
+
+// SyscallTimes measures syscall durations by pairing the raw_syscalls
+// sys_enter and sys_exit events of each thread.  Syscalls in progress when
+// the capture started or ended aren't counted.
+type SyscallTimes struct {
+	arch      string
+	durations map[int][]time.Duration
+	errors    map[int]int
+	inSyscall map[int]syscallEntry
+}
+
+// NewSyscallTimes returns SyscallTimes naming syscalls with the numbers of
+// arch, as for ftrace.SyscallName
+func NewSyscallTimes(arch string) *SyscallTimes {
+	return &SyscallTimes{
+		arch:      arch,
+		durations: make(map[int][]time.Duration),
+		errors:    make(map[int]int),
+		inSyscall: make(map[int]syscallEntry),
+	}
+}
+
+// Add updates the times with an event.  Events must be added in time order.
+func (s *SyscallTimes) Add(e *ftrace.Event) {
+	switch e.EventType().Name() {
+	case "sys_enter":
+		nr, err := e.Int("id")
+		if err != nil {
+			return
+		}
+		s.inSyscall[e.Pid] = syscallEntry{nr, e.When}
+	case "sys_exit":
+		nr, err := e.Int("id")
+		if err != nil {
+			return
+		}
+		entry, ok := s.inSyscall[e.Pid]
+		if !ok || entry.nr != nr {
+			return
+		}
+		delete(s.inSyscall, e.Pid)
+		s.durations[int(nr)] = append(s.durations[int(nr)], time.Duration(e.When-entry.entry))
+		if ret, err := e.Int("ret"); err == nil && ret < 0 {
+			s.errors[int(nr)]++
+		}
+	}
+}
+
+// Syscalls returns the time spent in each syscall, longest first
+func (s *SyscallTimes) Syscalls() []SyscallTime {
+	var times []SyscallTime
+	for nr, d := range s.durations {
+		st := SyscallTime{
+			Nr:        nr,
+			Name:      ftrace.SyscallName(s.arch, nr),
+			Count:     len(d),
+			Errors:    s.errors[nr],
+			Durations: NewDistribution(d),
+		}
+		if st.Name == "" {
+			st.Name = fmt.Sprintf("NR %d", nr)
+		}
+		for _, d := range d {
+			st.Total += d
+		}
+		times = append(times, st)
+	}
+	sort.Slice(times, func(i, j int) bool {
+		if times[i].Total != times[j].Total {
+			return times[i].Total > times[j].Total
+		}
+		return times[i].Nr < times[j].Nr
+	})
+	return times
+}
@@ -0,0 +1,96 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package analysis
+
+import (
+	"testing"
+	"time"
+)
+
+const sysEnterFormat = `name: sys_enter
+ID: 22
+format:
+` + commonFields + `
+	field:long id;	offset:8;	size:8;	signed:1;
+	field:unsigned long args[6];	offset:16;	size:48;	signed:0;
+
+print fmt: "NR %ld (%lx, %lx, %lx, %lx, %lx, %lx)", REC->id, REC->args[0], REC->args[1], REC->args[2], REC->args[3], REC->args[4], REC->args[5]
+`
+
+const sysExitFormat = `name: sys_exit
+ID: 21
+format:
+` + commonFields + `
+	field:long id;	offset:8;	size:8;	signed:1;
+	field:long ret;	offset:16;	size:8;	signed:1;
+
+print fmt: "NR %ld = %ld", REC->id, REC->ret
+`
+
+func TestSyscallTimes(t *testing.T) {
+	enter := eventType(t, sysEnterFormat)
+	exit := eventType(t, sysExitFormat)
+
+	te := &testEvents{t: t}
+	us := uint64(time.Microsecond)
+	for _, c := range []struct {
+		enter bool
+		pid   int
+		when  uint64
+		nr    int
+		ret   int
+	}{
+		// Exit of a syscall entered before the capture
+		{false, 10, 0, 0, 0},
+		{true, 10, 1 * us, 257, 0},
+		{true, 20, 2 * us, 0, 0},
+		{false, 10, 5 * us, 257, 3},
+		{true, 10, 6 * us, 257, 0},
+		{false, 10, 8 * us, 257, -2},
+		{false, 20, 12 * us, 0, 100},
+		// Still in the syscall when the capture ended
+		{true, 10, 20 * us, 1, 0},
+	} {
+		if c.enter {
+			te.add(enter, 0, c.when, map[string]interface{}{"common_pid": c.pid, "id": c.nr})
+		} else {
+			te.add(exit, 0, c.when, map[string]interface{}{"common_pid": c.pid, "id": c.nr, "ret": c.ret})
+		}
+	}
+
+	s := NewSyscallTimes("amd64")
+	for _, e := range te.events {
+		s.Add(e)
+	}
+
+	got := s.Syscalls()
+	if len(got) != 2 {
+		t.Fatalf("want 2 syscalls got %v", got)
+	}
+	if got[0].Name != "read" || got[0].Count != 1 || got[0].Total != 10*time.Microsecond || got[0].Errors != 0 {
+		t.Errorf("unexpected read times %+v", got[0])
+	}
+	if got[1].Name != "openat" || got[1].Count != 2 || got[1].Total != 6*time.Microsecond || got[1].Errors != 1 || got[1].Durations.Max != 4*time.Microsecond {
+		t.Errorf("unexpected openat times %+v", got[1])
+	}
+
+	unknown := NewSyscallTimes("vax")
+	for _, e := range te.events {
+		unknown.Add(e)
+	}
+	if n := unknown.Syscalls()[0].Name; n != "NR 0" {
+		t.Errorf("want NR 0 got %s", n)
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package analysis
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/traceout/ftrace"
+)
+
+// WakeupLatency is the time a thread waited for a cpu after being woken
+type WakeupLatency struct {
+	Pid  int
+	Comm string
+	// CPU is the cpu the thread was scheduled on
+	CPU int
+	// Wakeup and Scheduled are the times of the sched_wakeup and of the
+	// sched_switch to the thread
+	Wakeup, Scheduled uint64
+	Latency           time.Duration
+	// Event is the sched_switch that scheduled the thread
+	Event *ftrace.Event
+}
+
+func (l WakeupLatency) String() string {
+	return fmt.Sprintf("%s-%d waited %v for cpu %d, woken at %d scheduled at %d",
+		l.Comm, l.Pid, l.Latency, l.CPU, l.Wakeup, l.Scheduled)
+}
+
+// WakeupLatencies measures the scheduling latency of threads, from their
+// sched_wakeup or sched_wakeup_new until the sched_switch that runs them.
+// Threads that become runnable by being preempted aren't counted.
+type WakeupLatencies struct {
+	Latencies []WakeupLatency
+
+	woken   map[int]uint64
+	running map[int]bool
+}
+
+func NewWakeupLatencies() *WakeupLatencies {
+	return &WakeupLatencies{
+		woken:   make(map[int]uint64),
+		running: make(map[int]bool),
+	}
+}
+
+// Add updates the latencies with an event.  Events must be added in time
+// order.
+func (w *WakeupLatencies) Add(e *ftrace.Event) {
+	switch e.EventType().Name() {
+	case "sched_wakeup", "sched_wakeup_new":
+		pid, err := e.Int("pid")
+		if err != nil || pid == 0 || w.running[int(pid)] {
+			return
+		}
+		// Later wakeups of a thread already waiting don't restart its wait
+		if _, ok := w.woken[int(pid)]; !ok {
+			w.woken[int(pid)] = e.When
+		}
+
+	case "sched_switch":
+		if prev, err := e.Int("prev_pid"); err == nil {
+			delete(w.running, int(prev))
+		}
+		next, err := e.Int("next_pid")
+		if err != nil || next == 0 {
+			return
+		}
+		w.running[int(next)] = true
+		woken, ok := w.woken[int(next)]
+		if !ok {
+			return
+		}
+		delete(w.woken, int(next))
+		comm, _ := e.Str("next_comm")
+		w.Latencies = append(w.Latencies, WakeupLatency{
+			Pid:       int(next),
+			Comm:      comm,
+			CPU:       e.Cpu,
+			Wakeup:    woken,
+			Scheduled: e.When,
+			Latency:   time.Duration(e.When - woken),
+			Event:     e,
+		})
+	}
+}
+
+func (w *WakeupLatencies) group(key func(WakeupLatency) int) map[int]Distribution {
+	durations := make(map[int][]time.Duration)
+	for _, l := range w.Latencies {
+		k := key(l)
+		durations[k] = append(durations[k], l.Latency)
+	}
+	distributions := make(map[int]Distribution)
+	for k, d := range durations {
+		distributions[k] = NewDistribution(d)
+	}
+	return distributions
+}
+
+// All returns the distribution of every latency
+func (w *WakeupLatencies) All() Distribution {
+	durations := make([]time.Duration, len(w.Latencies))
+	for i, l := range w.Latencies {
+		durations[i] = l.Latency
+	}
+	return NewDistribution(durations)
+}
+
+// ByThread returns the distribution of the latencies of each thread, by pid
+func (w *WakeupLatencies) ByThread() map[int]Distribution {
+	return w.group(func(l WakeupLatency) int { return l.Pid })
+}
+
+// ByCPU returns the distribution of the latencies on each cpu the threads
+// were scheduled on
+func (w *WakeupLatencies) ByCPU() map[int]Distribution {
+	return w.group(func(l WakeupLatency) int { return l.CPU })
+}
+
+// Worst returns the n longest latencies, longest first
+func (w *WakeupLatencies) Worst(n int) []WakeupLatency {
+	worst := append([]WakeupLatency(nil), w.Latencies...)
+	sort.SliceStable(worst, func(i, j int) bool { return worst[i].Latency > worst[j].Latency })
+	if len(worst) > n {
+		worst = worst[:n]
+	}
+	return worst
+}
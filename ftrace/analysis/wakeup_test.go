@@ -0,0 +1,76 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package analysis
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWakeupLatencies(t *testing.T) {
+	schedSwitch := eventType(t, schedSwitchFormat)
+	wakeup := eventType(t, schedWakeupFormat)
+
+	te := &testEvents{t: t}
+	us := uint64(time.Microsecond)
+	te.add(schedSwitch, 0, 0, map[string]interface{}{"prev_pid": 0, "next_pid": 10, "next_comm": "make"})
+	// A wakeup of a running thread is ignored
+	te.add(wakeup, 1, 1*us, map[string]interface{}{"pid": 10, "comm": "make"})
+	te.add(wakeup, 0, 2*us, map[string]interface{}{"pid": 20, "comm": "cc1"})
+	// A second wakeup doesn't restart the wait
+	te.add(wakeup, 0, 3*us, map[string]interface{}{"pid": 20, "comm": "cc1"})
+	// make is preempted, which isn't a wakeup
+	te.add(schedSwitch, 0, 12*us, map[string]interface{}{"prev_pid": 10, "prev_comm": "make", "next_pid": 20, "next_comm": "cc1"})
+	te.add(schedSwitch, 1, 14*us, map[string]interface{}{"prev_pid": 0, "next_pid": 10, "next_comm": "make"})
+	te.add(schedSwitch, 1, 15*us, map[string]interface{}{"prev_pid": 10, "prev_comm": "make", "prev_state": 1, "next_pid": 0})
+	te.add(wakeup, 0, 20*us, map[string]interface{}{"pid": 10, "comm": "make"})
+	te.add(schedSwitch, 1, 24*us, map[string]interface{}{"prev_pid": 0, "next_pid": 10, "next_comm": "make"})
+
+	w := NewWakeupLatencies()
+	for _, e := range te.events {
+		w.Add(e)
+	}
+
+	if len(w.Latencies) != 2 {
+		t.Fatalf("want 2 latencies got %v", w.Latencies)
+	}
+	l := w.Latencies[0]
+	if l.Pid != 20 || l.Comm != "cc1" || l.CPU != 0 || l.Wakeup != 2*us || l.Scheduled != 12*us || l.Latency != 10*time.Microsecond || l.Event != te.events[4] {
+		t.Errorf("unexpected first latency %+v", l)
+	}
+
+	if got := w.All(); got.Count != 2 || got.Min != 4*time.Microsecond || got.Max != 10*time.Microsecond {
+		t.Errorf("unexpected distribution %v", got)
+	}
+	byThread := w.ByThread()
+	if len(byThread) != 2 || byThread[10].Max != 4*time.Microsecond || byThread[20].Max != 10*time.Microsecond {
+		t.Errorf("unexpected distributions by thread %v", byThread)
+	}
+	byCPU := w.ByCPU()
+	if len(byCPU) != 2 || byCPU[0].Count != 1 || byCPU[1].P50 != 4*time.Microsecond {
+		t.Errorf("unexpected distributions by cpu %v", byCPU)
+	}
+
+	worst := w.Worst(1)
+	if len(worst) != 1 || worst[0].Pid != 20 {
+		t.Errorf("want cc1 as the worst got %v", worst)
+	}
+	if got, want := worst[0].String(), "cc1-20 waited 10µs for cpu 0, woken at 2000 scheduled at 12000"; got != want {
+		t.Errorf("want %q got %q", want, got)
+	}
+	if got := w.Worst(5); len(got) != 2 {
+		t.Errorf("want all latencies got %v", got)
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"encoding/binary"
+	"strings"
+)
+
+// Arch describes the machine the traced kernel runs on, as far as decoding
+// its events depends on it
+type Arch struct {
+	// Name is the machine name, as printed by uname -m
+	Name string
+	// ByteOrder is the order of the bytes of integers in the ring buffer
+	ByteOrder binary.ByteOrder
+	// LongSize is the size of a long and of a pointer in bytes
+	LongSize int
+}
+
+// defaultArch is assumed for kernels that don't say what they run on, and
+// for event types parsed without an Ftrace
+var defaultArch = Arch{"x86_64", binary.LittleEndian, 8}
+
+var knownArchs = []Arch{
+	{"x86_64", binary.LittleEndian, 8},
+	{"i386", binary.LittleEndian, 4},
+	{"i486", binary.LittleEndian, 4},
+	{"i586", binary.LittleEndian, 4},
+	{"i686", binary.LittleEndian, 4},
+	{"aarch64", binary.LittleEndian, 8},
+	{"aarch64_be", binary.BigEndian, 8},
+	{"armv5tel", binary.LittleEndian, 4},
+	{"armv6l", binary.LittleEndian, 4},
+	{"armv7l", binary.LittleEndian, 4},
+	{"armv8l", binary.LittleEndian, 4},
+	{"armv7b", binary.BigEndian, 4},
+	{"riscv32", binary.LittleEndian, 4},
+	{"riscv64", binary.LittleEndian, 8},
+	{"loongarch64", binary.LittleEndian, 8},
+	{"ppc", binary.BigEndian, 4},
+	{"ppc64", binary.BigEndian, 8},
+	{"ppc64le", binary.LittleEndian, 8},
+	{"s390", binary.BigEndian, 4},
+	{"s390x", binary.BigEndian, 8},
+	{"sparc64", binary.BigEndian, 8},
+}
+
+// ArchByName returns the profile of a machine by the name uname -m prints,
+// like "x86_64" or "s390x".  Machines like mips that can run either byte
+// order under the same name aren't known, and can be described with an Arch
+// instead.
+func ArchByName(name string) (Arch, bool) {
+	name = strings.TrimSpace(name)
+	for _, a := range knownArchs {
+		if a.Name == name {
+			return a, true
+		}
+	}
+	return Arch{}, false
+}
+
+// detectArch sets the Ftrace's arch from the kernel.arch sysctl of kernels
+// that have it, and otherwise takes the long size from the page header,
+// whose commit field is a long
+func (f *Ftrace) detectArch() {
+	if data, err := f.fp.ReadProcFile("sys/kernel/arch"); err == nil {
+		if a, ok := ArchByName(string(data)); ok {
+			f.defs.SetArch(a)
+			return
+		}
+	}
+	if f.pageHeaderFieldCommit >= 0 && f.pageHeader.fields[f.pageHeaderFieldCommit].size == 4 {
+		f.defs.SetLongSize(4)
+	}
+}
+
+// byteOrder returns the byte order of the traced kernel
+func (f *Ftrace) byteOrder() binary.ByteOrder {
+	return f.defs.arch.ByteOrder
+}
+
+// byteOrder returns the byte order the event type's events are decoded with
+func (etype *EventType) byteOrder() binary.ByteOrder {
+	return etype.kernelDefs().arch.ByteOrder
+}
+
+// byteOrder returns the byte order of the kernel that recorded the event
+func (e Event) byteOrder() binary.ByteOrder {
+	if e.ftrace != nil {
+		return e.ftrace.byteOrder()
+	}
+	return e.etype.byteOrder()
+}
@@ -0,0 +1,118 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestArchByName(t *testing.T) {
+	if a, ok := ArchByName("s390x\n"); !ok || a.ByteOrder != binary.BigEndian || a.LongSize != 8 {
+		t.Errorf("want big endian s390x with 8 byte longs got %+v %v", a, ok)
+	}
+	if a, ok := ArchByName("armv7l"); !ok || a.ByteOrder != binary.LittleEndian || a.LongSize != 4 {
+		t.Errorf("want little endian armv7l with 4 byte longs got %+v %v", a, ok)
+	}
+	if _, ok := ArchByName("mips"); ok {
+		t.Error("want mips unknown")
+	}
+}
+
+func TestDetectArch(t *testing.T) {
+	files := testFilesWith(nil)
+	if a := newTestFtrace(t, files).KernelDefs().Arch(); a != defaultArch {
+		t.Errorf("want the default arch got %+v", a)
+	}
+	files["/proc/sys/kernel/arch"] = "ppc64\n"
+	if a := newTestFtrace(t, files).KernelDefs().Arch(); a.Name != "ppc64" || a.ByteOrder != binary.BigEndian {
+		t.Errorf("want ppc64 got %+v", a)
+	}
+}
+
+func TestBigEndianDecode(t *testing.T) {
+	f := newTestFtraceWith(t, map[string]string{
+		"/proc/sys/kernel/arch": "s390x",
+	})
+	etype, err := f.NewEventType("sched/sched_wakeup")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := etype.NewEvent(map[string]interface{}{"common_type": 62, "common_pid": 1234, "comm": "bash", "pid": 1234, "prio": 120, "success": 1, "target_cpu": 2}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := e.contents
+	if binary.BigEndian.Uint32(payload[24:]) != 1234 {
+		t.Fatalf("want the pid encoded big endian got %x", payload[24:28])
+	}
+
+	// The type_len of the event header is its top 5 bits
+	page := binary.BigEndian.AppendUint64(nil, 1000000000)
+	page = binary.BigEndian.AppendUint64(page, uint64(4+len(payload)))
+	page = binary.BigEndian.AppendUint32(page, uint32(len(payload)/4)<<entryTimeDeltaBits|500)
+	page = append(page, payload...)
+	page = append(page, make([]byte, 4096-len(page))...)
+
+	events, err := f.decodePage(0, page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("want 1 event got %d", len(events))
+	}
+	got := events[0]
+	if got.When != 1000000500 || got.Pid != 1234 {
+		t.Errorf("want pid 1234 at 1000000500 got %d at %d", got.Pid, got.When)
+	}
+	if want, s := "comm=bash pid=1234 prio=120 success=1 target_cpu=002", etype.Format(*got); s != want {
+		t.Errorf("want %q got %q", want, s)
+	}
+}
+
+const kfree32Format = `name: kfree
+ID: 481
+format:
+	field:unsigned short common_type;	offset:0;	size:2;	signed:0;
+	field:unsigned char common_flags;	offset:2;	size:1;	signed:0;
+	field:unsigned char common_preempt_count;	offset:3;	size:1;	signed:0;
+	field:int common_pid;	offset:4;	size:4;	signed:1;
+
+	field:unsigned long call_site;	offset:8;	size:4;	signed:0;
+	field:const void * ptr;	offset:12;	size:4;	signed:0;
+
+print fmt: "call_site=%lx (%ld) ptr=%p mask=%lx", REC->call_site, REC->call_site, REC->ptr, ~0UL
+`
+
+func TestLongSizeFormat(t *testing.T) {
+	f := newTestFtraceWith(t, map[string]string{
+		"/proc/sys/kernel/arch":                              "armv7l",
+		"/sys/kernel/debug/tracing/events/kmem/kfree/format": kfree32Format,
+	})
+	etype, err := f.NewEventType("kmem/kfree")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := etype.NewEvent(map[string]interface{}{"common_type": 481, "call_site": uint64(0xfffffff0), "ptr": uint64(0xc0001234)}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "call_site=fffffff0 (-16) ptr=c0001234 mask=ffffffff"
+	if got := etype.Format(*e); got != want {
+		t.Errorf("want %q got %q", want, got)
+	}
+}
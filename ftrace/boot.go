@@ -0,0 +1,63 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"fmt"
+	"strings"
+)
+
+// EnabledEvents returns the events that are already enabled, from
+// set_event, as "<system>/<event>" paths
+func (f *Ftrace) EnabledEvents() ([]string, error) {
+	enabled, err := f.fp.ReadFtraceFile("set_event")
+	if err != nil {
+		return nil, err
+	}
+	return eventPaths(enabled), nil
+}
+
+// AttachEnabledEvents registers the events that are already enabled, to
+// pick up a trace started by someone else, like one started at boot with
+// the trace_event= and trace_buf_size= kernel parameters.  The events stay
+// enabled after Close.  Without a call to Clear, a capture reads what is
+// already in the ring buffer before the events traced live.  Events whose
+// formats can't be parsed are left out, and reported in the error.
+func (f *Ftrace) AttachEnabledEvents() ([]*EventType, error) {
+	enabled, err := f.EnabledEvents()
+	if err != nil {
+		return nil, err
+	}
+
+	var etypes []*EventType
+	var failed []string
+	for _, name := range enabled {
+		if etype := f.eventTypeByPath(name); etype != nil {
+			etypes = append(etypes, etype)
+			continue
+		}
+		etype, err := f.NewEventType(name)
+		if err != nil {
+			failed = append(failed, name+": "+err.Error())
+			continue
+		}
+		etypes = append(etypes, etype)
+	}
+
+	if len(failed) > 0 {
+		return etypes, fmt.Errorf("can't decode enabled events: %s", strings.Join(failed, "; "))
+	}
+	return etypes, nil
+}
@@ -0,0 +1,67 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"strings"
+	"testing"
+)
+
+const badPrintFmtFormat = `name: bad_event
+ID: 300
+format:
+	field:unsigned short common_type;	offset:0;	size:2;	signed:0;
+	field:int x;	offset:4;	size:4;	signed:1;
+
+print fmt: "%d", (long short)REC->x
+`
+
+func TestAttachEnabledEvents(t *testing.T) {
+	f := newTestFtraceWith(t, map[string]string{
+		"/sys/kernel/debug/tracing/set_event":                    "sched:sched_wakeup\ntest:bad_event\n",
+		"/sys/kernel/debug/tracing/events/test/bad_event/format": badPrintFmtFormat,
+		"per_cpu/cpu0/trace_pipe_raw":                            string(wakeupPage(1, 2)),
+	})
+
+	enabled, err := f.EnabledEvents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(enabled) != 2 || enabled[0] != "sched/sched_wakeup" || enabled[1] != "test/bad_event" {
+		t.Errorf("want sched/sched_wakeup and test/bad_event got %v", enabled)
+	}
+
+	etypes, err := f.AttachEnabledEvents()
+	if err == nil || !strings.Contains(err.Error(), "test/bad_event") {
+		t.Errorf("want an error for test/bad_event got %v", err)
+	}
+	if len(etypes) != 1 || etypes[0].Name() != "sched_wakeup" {
+		t.Fatalf("want sched_wakeup attached got %v", etypes)
+	}
+
+	// The events already in the buffer are read
+	if err := f.PrepareCapture(1, make(chan bool)); err != nil {
+		t.Fatal(err)
+	}
+	var pids []int
+	f.Capture(func(events Events) {
+		for _, e := range events {
+			pids = append(pids, e.Pid)
+		}
+	})
+	if len(pids) != 2 {
+		t.Errorf("want the 2 buffered events got %v", pids)
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"sync/atomic"
+)
+
+// DropPolicy selects which events a capture with
+// CaptureOptions.MaxBufferedEvents drops when its consumer falls behind.
+// The events of a page are kept or dropped together.
+type DropPolicy int
+
+const (
+	// DropOldest drops the oldest buffered pages to make room for new ones
+	DropOldest DropPolicy = iota
+	// DropNewest drops new pages that don't fit in the buffer
+	DropNewest
+)
+
+// eventRing is the buffer of decoded pages waiting for the consumer
+type eventRing struct {
+	batches []Events
+	// size is the number of events in batches, counting an empty batch
+	// as one so that pages of filtered events are bounded too
+	size int
+}
+
+func batchSize(events Events) int {
+	if len(events) == 0 {
+		return 1
+	}
+	return len(events)
+}
+
+// push adds a batch, returning the batches dropped to stay within max.  A
+// batch is always added to an empty ring, however big, so that the
+// capture makes progress.
+func (r *eventRing) push(events Events, max int, policy DropPolicy) (dropped []Events) {
+	n := batchSize(events)
+	if policy == DropNewest && len(r.batches) > 0 && r.size+n > max {
+		return []Events{events}
+	}
+	for len(r.batches) > 0 && r.size+n > max {
+		dropped = append(dropped, r.pop())
+	}
+	r.batches = append(r.batches, events)
+	r.size += n
+	return dropped
+}
+
+func (r *eventRing) pop() Events {
+	events := r.batches[0]
+	r.batches[0] = nil
+	r.batches = r.batches[1:]
+	r.size -= batchSize(events)
+	return events
+}
+
+// bufferEvents passes pages of events from in to out, holding up to
+// CaptureOptions.MaxBufferedEvents of them while the consumer is busy and
+// dropping pages by the DropPolicy beyond that, so that a slow consumer
+// neither stalls the trace pipe nor grows memory without bound.  out is
+// closed once in is closed and drained.
+func (f *Ftrace) bufferEvents(in <-chan Events, out chan<- Events, doneCh <-chan bool) {
+	defer close(out)
+
+	var ring eventRing
+	for in != nil || len(ring.batches) > 0 {
+		// Sending is only enabled when there is something to send
+		var send chan<- Events
+		var next Events
+		if len(ring.batches) > 0 {
+			send, next = out, ring.batches[0]
+		}
+
+		select {
+		case events, ok := <-in:
+			if !ok {
+				in = nil
+				continue
+			}
+			for _, d := range ring.push(events, f.options.MaxBufferedEvents, f.options.DropPolicy) {
+				atomic.AddInt64(&f.metrics.pagesDropped, 1)
+				atomic.AddInt64(&f.metrics.eventsDropped, int64(len(d)))
+			}
+		case send <- next:
+			ring.pop()
+		case <-doneCh:
+			return
+		case <-f.closeCh:
+			return
+		}
+	}
+}
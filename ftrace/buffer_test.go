@@ -0,0 +1,104 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"testing"
+)
+
+// testBatch returns a batch of n events with Pid set to id
+func testBatch(id, n int) Events {
+	events := make(Events, n)
+	for i := range events {
+		events[i] = &Event{Pid: id}
+	}
+	return events
+}
+
+func ringIds(r *eventRing) []int {
+	var ids []int
+	for _, b := range r.batches {
+		if len(b) > 0 {
+			ids = append(ids, b[0].Pid)
+		}
+	}
+	return ids
+}
+
+func TestEventRing(t *testing.T) {
+	tests := []struct {
+		policy  DropPolicy
+		kept    []int
+		dropped []int
+	}{
+		{DropOldest, []int{3, 4}, []int{1, 2}},
+		{DropNewest, []int{1, 2}, []int{3, 4}},
+	}
+	for _, test := range tests {
+		var r eventRing
+		var dropped []int
+		for id := 1; id <= 4; id++ {
+			for _, d := range r.push(testBatch(id, 3), 6, test.policy) {
+				dropped = append(dropped, d[0].Pid)
+			}
+		}
+		if got := ringIds(&r); len(got) != 2 || got[0] != test.kept[0] || got[1] != test.kept[1] {
+			t.Errorf("policy %d: want %v kept got %v", test.policy, test.kept, got)
+		}
+		if len(dropped) != 2 || dropped[0] != test.dropped[0] || dropped[1] != test.dropped[1] {
+			t.Errorf("policy %d: want %v dropped got %v", test.policy, test.dropped, dropped)
+		}
+		if r.size != 6 {
+			t.Errorf("policy %d: want size 6 got %d", test.policy, r.size)
+		}
+	}
+
+	// An oversized batch is taken by an empty ring
+	var r eventRing
+	if dropped := r.push(testBatch(1, 10), 6, DropNewest); len(dropped) != 0 {
+		t.Errorf("want an oversized batch buffered, dropped %d", len(dropped))
+	}
+	if events := r.pop(); len(events) != 10 || r.size != 0 {
+		t.Errorf("want the oversized batch popped, got %d events size %d", len(events), r.size)
+	}
+}
+
+func TestBufferEvents(t *testing.T) {
+	f := newTestFtrace(t, testFiles)
+	f.options.MaxBufferedEvents = 4
+	f.options.DropPolicy = DropOldest
+
+	in := make(chan Events)
+	out := make(chan Events)
+	go f.bufferEvents(in, out, make(chan bool))
+
+	// Nothing is read from out until the input ends, so the consumer has
+	// fallen behind
+	for id := 1; id <= 4; id++ {
+		in <- testBatch(id, 2)
+	}
+	close(in)
+
+	var ids []int
+	for events := range out {
+		ids = append(ids, events[0].Pid)
+	}
+	if len(ids) != 2 || ids[0] != 3 || ids[1] != 4 {
+		t.Errorf("want batches 3 and 4 got %v", ids)
+	}
+	if m := f.Metrics(); m.EventsDropped != 4 {
+		t.Errorf("want 4 events dropped got %d", m.EventsDropped)
+	}
+}
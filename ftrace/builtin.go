@@ -0,0 +1,360 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/traceout/ftrace/cparse"
+	"github.com/google/traceout/ftrace/cprintf"
+)
+
+// builtinEventPaths are the events the kernel records itself rather than
+// through tracepoints.  They can't be enabled, and end up in the ring
+// buffer from trace_printk, writes to trace_marker, the stacktrace options
+// and the function and sched tracers.
+var builtinEventPaths = []string{
+	"ftrace/function",
+	"ftrace/print",
+	"ftrace/bprint",
+	"ftrace/bputs",
+	"ftrace/kernel_stack",
+	"ftrace/user_stack",
+	"ftrace/context_switch",
+	"ftrace/wakeup",
+}
+
+// printkFormatsRefreshInterval limits how often printk_formats is reread
+// when a format can't be found, for example because a module was loaded
+// mid-trace
+const printkFormatsRefreshInterval = time.Second
+
+func isBuiltinPath(path string) bool {
+	for _, p := range builtinEventPaths {
+		if p == path {
+			return true
+		}
+	}
+	return false
+}
+
+// registerBuiltinTypes registers the builtin events, so that pages holding
+// them decode.  Kernels built without the features that record some of
+// them have no format files for them, so errors are ignored.
+func (f *Ftrace) registerBuiltinTypes() {
+	for _, p := range builtinEventPaths {
+		etype, err := newEventType(f.fp, p, f.defs, f.formatCache)
+		if err != nil || f.eventTypes[etype.id] != nil {
+			continue
+		}
+		f.eventTypes[etype.id] = etype
+	}
+}
+
+func (etype *EventType) hasFields(names ...string) bool {
+	for _, name := range names {
+		if etype.getFieldNum(name) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (etype *EventType) isPrint() bool {
+	return etype.name == "print" && etype.hasFields("ip", "buf")
+}
+
+func (etype *EventType) isBprint() bool {
+	return etype.name == "bprint" && etype.hasFields("ip", "fmt", "buf")
+}
+
+func (etype *EventType) isBputs() bool {
+	return etype.name == "bputs" && etype.hasFields("ip", "str")
+}
+
+func (etype *EventType) isFunction() bool {
+	return etype.name == "function" && etype.hasFields("ip", "parent_ip")
+}
+
+func (etype *EventType) isUserStack() bool {
+	return etype.name == "user_stack" && etype.hasFields("tgid", "caller")
+}
+
+// tail returns the contents of the event from a field to the end, for the
+// variable length arrays at the end of the builtin events
+func (e Event) tail(name string) []byte {
+	i := e.etype.getFieldNum(name)
+	if i < 0 || e.etype.fields[i].offset > len(e.contents) {
+		return nil
+	}
+	return e.contents[e.etype.fields[i].offset:]
+}
+
+// callerSymbol formats the ip field of a print, bprint or bputs event,
+// which is the function that called trace_printk
+func (e Event) callerSymbol() string {
+	ip, err := e.Uint("ip")
+	if err != nil {
+		return ""
+	}
+	return e.ftrace.kernelSymbol(ip, false)
+}
+
+// formatFunction formats a function event from the function tracer, whose
+// print fmt casts to pointers, which cparse doesn't support
+func formatFunction(e Event) string {
+	ip, _ := e.Uint("ip")
+	parent, _ := e.Uint("parent_ip")
+	return " " + e.ftrace.kernelSymbol(ip, false) + " <-- " + e.ftrace.kernelSymbol(parent, false)
+}
+
+// formatPrint formats a print event, from trace_printk with a constant
+// string or a write to trace_marker.  The trailing newline is dropped, as
+// it is from the rest of the events.
+func formatPrint(e Event) string {
+	return e.callerSymbol() + ": " + strings.TrimSuffix(cString(e.tail("buf")), "\n")
+}
+
+// formatBputs formats a bputs event, from trace_printk or trace_puts with
+// a constant string and no arguments
+func formatBputs(e Event) string {
+	addr, _ := e.Uint("str")
+	s, ok := e.ftrace.printkFormat(addr)
+	if !ok {
+		return fmt.Sprintf("%s: str=0x%x", e.callerSymbol(), addr)
+	}
+	return e.callerSymbol() + ": " + strings.TrimSuffix(s, "\n")
+}
+
+// formatBprint formats a bprint event, from trace_printk with arguments.
+// The format string is only recorded as its address, which is looked up in
+// printk_formats.
+func formatBprint(e Event) string {
+	addr, _ := e.Uint("fmt")
+	format, ok := e.ftrace.printkFormat(addr)
+	if !ok {
+		return fmt.Sprintf("%s: fmt=0x%x buf=%x", e.callerSymbol(), addr, e.tail("buf"))
+	}
+	longSize := e.etype.fields[e.etype.getFieldNum("ip")].size
+	return e.callerSymbol() + ": " + strings.TrimSuffix(e.binaryPrintf(format, e.tail("buf"), longSize), "\n")
+}
+
+// formatUserStack formats a user_stack event like the kernel, with the
+// addresses resolved if the Ftrace has a UserSymbolizer
+func formatUserStack(e Event) string {
+	lines := []string{"<user stack trace>"}
+	for _, addr := range e.stackFrames() {
+		lines = append(lines, " => "+e.UserSymbol(addr))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// binaryPrintf formats the arguments trace_printk saved with vbin_printf.
+// Integers are stored at their size and aligned to it, except that 8 byte
+// ones are only aligned to 4.  Strings are copied in with their NUL, and
+// so are the results of %p extensions other than symbols and raw
+// addresses, which are formatted when the event is recorded.
+func (e Event) binaryPrintf(format string, args []byte, longSize int) string {
+	var out strings.Builder
+	var values []cparse.Value
+	pos := 0
+	order := e.byteOrder()
+
+	align := func(size int) {
+		if size > 4 {
+			size = 4
+		}
+		pos = (pos + size - 1) &^ (size - 1)
+	}
+	readInt := func(size int) uint64 {
+		align(size)
+		if pos+size > len(args) {
+			pos = len(args)
+			return 0
+		}
+		var v uint64
+		switch size {
+		case 1:
+			v = uint64(args[pos])
+		case 2:
+			v = uint64(order.Uint16(args[pos:]))
+		case 4:
+			v = uint64(order.Uint32(args[pos:]))
+		case 8:
+			v = order.Uint64(args[pos:])
+		}
+		pos += size
+		return v
+	}
+	readString := func() string {
+		if pos >= len(args) {
+			return ""
+		}
+		s := cString(args[pos:])
+		pos += len(s) + 1
+		return s
+	}
+
+	for format != "" {
+		i := strings.IndexByte(format, '%')
+		if i == -1 {
+			out.WriteString(strings.Replace(format, "%", "%%", -1))
+			break
+		}
+		out.WriteString(format[:i])
+		format = format[i+1:]
+
+		end := strings.IndexAny(format, "cdiopsuxX%")
+		if end == -1 {
+			out.WriteString("%%" + strings.Replace(format, "%", "%%", -1))
+			break
+		}
+		spec, conversion := format[:end], format[end]
+		format = format[end+1:]
+		if conversion == '%' {
+			out.WriteString("%%")
+			continue
+		}
+
+		// A '*' width or precision is saved as an int before the value
+		for strings.Contains(spec, "*") {
+			star := strconv.Itoa(int(int32(readInt(4))))
+			spec = strings.Replace(spec, "*", star, 1)
+		}
+
+		length := strings.TrimLeft(spec, "-+ #0123456789.")
+		modifiers := spec[:len(spec)-len(length)]
+		size := 4
+		switch length {
+		case "hh":
+			size = 1
+		case "h":
+			size = 2
+		case "l", "z", "Z", "t":
+			size = longSize
+		case "ll", "L", "j":
+			size = 8
+		}
+
+		switch conversion {
+		case 's':
+			out.WriteString("%" + modifiers + "s")
+			values = append(values, cparse.NewValueString(readString()))
+		case 'c':
+			out.WriteString("%" + modifiers + "c")
+			values = append(values, cparse.NewValueInt(readInt(1), 1, false))
+		case 'p':
+			ext := ""
+			for format != "" && isAlnum(format[0]) {
+				ext += format[:1]
+				format = format[1:]
+			}
+			var s string
+			switch {
+			case ext == "":
+				out.WriteString("%" + modifiers + "p")
+				values = append(values, cparse.NewValueInt(readInt(longSize), longSize, false))
+				continue
+			case strings.IndexByte("SsFfBxKe", ext[0]) != -1:
+				addr := readInt(longSize)
+				switch ext[0] {
+				case 'S', 'F':
+					s = e.ftrace.kernelSymbol(addr, true)
+				case 's', 'f':
+					s = e.ftrace.kernelSymbol(addr, false)
+				case 'B':
+					s = e.ftrace.kernelBacktraceSymbol(addr)
+				default:
+					s = fmt.Sprintf("%0*x", 2*longSize, addr)
+				}
+			default:
+				s = readString()
+			}
+			out.WriteString("%" + modifiers + "s")
+			values = append(values, cparse.NewValueString(s))
+		default:
+			if length == "Z" || length == "t" || length == "j" {
+				length = "l"
+				if size == 8 {
+					length = "ll"
+				}
+			}
+			signed := conversion == 'd' || conversion == 'i'
+			out.WriteString("%" + modifiers + length + string(conversion))
+			values = append(values, cparse.NewValueInt(readInt(size), size, signed))
+		}
+	}
+
+	s, err := cprintf.Sprintf(out.String(), values)
+	if err != nil {
+		return err.Error()
+	}
+	return s
+}
+
+func isAlnum(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// printkFormat returns the trace_printk format string at addr in kernel
+// memory, from printk_formats
+func (f *Ftrace) printkFormat(addr uint64) (string, bool) {
+	if f == nil {
+		return "", false
+	}
+	f.caches.Lock()
+	defer f.caches.Unlock()
+
+	now := time.Now()
+	if _, ok := f.printkFormats[addr]; !ok &&
+		(f.printkFormats == nil || now.Sub(f.printkFormatsRead) >= printkFormatsRefreshInterval) {
+
+		f.printkFormatsRead = now
+		if data, err := f.fp.ReadFtraceFile("printk_formats"); err == nil {
+			f.printkFormats = parsePrintkFormats(string(data))
+		}
+	}
+	s, ok := f.printkFormats[addr]
+	return s, ok
+}
+
+// printkFormatUnescaper undoes the escaping of printk_formats, which only
+// escapes newlines, tabs, backslashes and quotes
+var printkFormatUnescaper = strings.NewReplacer(`\n`, "\n", `\t`, "\t", `\\`, `\`, `\"`, `"`)
+
+// parsePrintkFormats parses printk_formats, which lists the format
+// strings of trace_printk calls as `0xffffffff81e0a4b8 : "woke %d\n"`
+func parsePrintkFormats(data string) map[uint64]string {
+	formats := make(map[uint64]string)
+	for _, line := range strings.Split(data, "\n") {
+		v := strings.SplitN(line, " : ", 2)
+		if len(v) != 2 {
+			continue
+		}
+		addr, err := strconv.ParseUint(strings.TrimPrefix(v[0], "0x"), 16, 64)
+		if err != nil {
+			continue
+		}
+		s := strings.TrimSpace(v[1])
+		if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+			continue
+		}
+		formats[addr] = printkFormatUnescaper.Replace(s[1 : len(s)-1])
+	}
+	return formats
+}
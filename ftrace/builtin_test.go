@@ -0,0 +1,228 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+const printFormat = `name: print
+ID: 5
+format:
+	field:unsigned short common_type;	offset:0;	size:2;	signed:0;
+	field:unsigned char common_flags;	offset:2;	size:1;	signed:0;
+	field:unsigned char common_preempt_count;	offset:3;	size:1;	signed:0;
+	field:int common_pid;	offset:4;	size:4;	signed:1;
+
+	field:unsigned long ip;	offset:8;	size:8;	signed:0;
+	field:char buf[];	offset:16;	size:0;	signed:1;
+
+print fmt: "%ps: %s", (void *)REC->ip, REC->buf
+`
+
+const bprintFormat = `name: bprint
+ID: 6
+format:
+	field:unsigned short common_type;	offset:0;	size:2;	signed:0;
+	field:unsigned char common_flags;	offset:2;	size:1;	signed:0;
+	field:unsigned char common_preempt_count;	offset:3;	size:1;	signed:0;
+	field:int common_pid;	offset:4;	size:4;	signed:1;
+
+	field:unsigned long ip;	offset:8;	size:8;	signed:0;
+	field:const char * fmt;	offset:16;	size:8;	signed:0;
+	field:u32 buf[];	offset:24;	size:0;	signed:0;
+
+print fmt: "%ps: %s", (void *)REC->ip, REC->fmt
+`
+
+const userStackFormat = `name: user_stack
+ID: 12
+format:
+	field:unsigned short common_type;	offset:0;	size:2;	signed:0;
+	field:unsigned char common_flags;	offset:2;	size:1;	signed:0;
+	field:unsigned char common_preempt_count;	offset:3;	size:1;	signed:0;
+	field:int common_pid;	offset:4;	size:4;	signed:1;
+
+	field:unsigned int tgid;	offset:8;	size:4;	signed:0;
+	field:unsigned long caller[8];	offset:16;	size:64;	signed:0;
+
+print fmt: "\t=> %ps\n\t=> %ps\n\t=> %ps\n\t=> %ps\n\t=> %ps\n\t=> %ps\n\t=> %ps\n\t=> %ps\n", (void *)REC->caller[0], (void *)REC->caller[1], (void *)REC->caller[2], (void *)REC->caller[3], (void *)REC->caller[4], (void *)REC->caller[5], (void *)REC->caller[6], (void *)REC->caller[7]
+`
+
+const contextSwitchFormat = `name: context_switch
+ID: 2
+format:
+	field:unsigned short common_type;	offset:0;	size:2;	signed:0;
+	field:unsigned char common_flags;	offset:2;	size:1;	signed:0;
+	field:unsigned char common_preempt_count;	offset:3;	size:1;	signed:0;
+	field:int common_pid;	offset:4;	size:4;	signed:1;
+
+	field:unsigned int prev_pid;	offset:8;	size:4;	signed:0;
+	field:unsigned char prev_prio;	offset:12;	size:1;	signed:0;
+	field:unsigned char prev_state;	offset:13;	size:1;	signed:0;
+	field:unsigned int next_pid;	offset:16;	size:4;	signed:0;
+	field:unsigned char next_prio;	offset:20;	size:1;	signed:0;
+	field:unsigned char next_state;	offset:21;	size:1;	signed:0;
+	field:unsigned int next_cpu;	offset:24;	size:4;	signed:0;
+
+print fmt: "%u:%u:%u  ==> %u:%u:%u [%03u]", REC->prev_pid, REC->prev_prio, REC->prev_state, REC->next_pid, REC->next_prio, REC->next_state, REC->next_cpu
+`
+
+const testPrintkFormats = `0xffffffff810a3200 : "woke %s pid=%d prio=%hhu\n"
+0xffffffff810a3240 : "tab\there \"quoted\" \\ %lld %lx\n"
+0xffffffff810a3280 : "constant string\n"
+`
+
+func newBuiltinTestFtrace(t *testing.T) *Ftrace {
+	return newTestFtraceWith(t, map[string]string{
+		"/sys/kernel/debug/tracing/events/ftrace/print/format":          printFormat,
+		"/sys/kernel/debug/tracing/events/ftrace/bprint/format":         bprintFormat,
+		"/sys/kernel/debug/tracing/events/ftrace/user_stack/format":     userStackFormat,
+		"/sys/kernel/debug/tracing/events/ftrace/context_switch/format": contextSwitchFormat,
+		"/sys/kernel/debug/tracing/printk_formats":                      testPrintkFormats,
+		"/proc/kallsyms": testKallsyms,
+	})
+}
+
+func builtinHeader(id, pid int, size int) []byte {
+	b := make([]byte, size)
+	binary.LittleEndian.PutUint16(b[0:], uint16(id))
+	binary.LittleEndian.PutUint32(b[4:], uint32(pid))
+	return b
+}
+
+func TestBuiltinEvents(t *testing.T) {
+	f := newBuiltinTestFtrace(t)
+
+	print := builtinHeader(5, 1234, 16)
+	binary.LittleEndian.PutUint64(print[8:], 0xffffffff810a3110)
+	print = append(print, "marker\n\x00"...)
+
+	bprint := builtinHeader(6, 1234, 24)
+	binary.LittleEndian.PutUint64(bprint[8:], 0xffffffff810a2c40)
+	binary.LittleEndian.PutUint64(bprint[16:], 0xffffffff810a3200)
+	bprint = append(bprint, "bash\x00\x00\x00\x00"...)
+	bprint = binary.LittleEndian.AppendUint32(bprint, 42)
+	bprint = append(bprint, 120)
+
+	userStack := builtinHeader(12, 1234, 80)
+	binary.LittleEndian.PutUint32(userStack[8:], 1234)
+	binary.LittleEndian.PutUint64(userStack[16:], 0x7f0000001000)
+	binary.LittleEndian.PutUint64(userStack[24:], 0x400123)
+
+	contextSwitch := builtinHeader(2, 1234, 28)
+	binary.LittleEndian.PutUint32(contextSwitch[8:], 1234)
+	contextSwitch[12] = 120
+	contextSwitch[13] = 1
+	binary.LittleEndian.PutUint32(contextSwitch[16:], 1)
+	contextSwitch[20] = 120
+	binary.LittleEndian.PutUint32(contextSwitch[24:], 3)
+
+	page := &testPage{timestamp: 1000000000}
+	page.addEvent(0, print)
+	page.addEvent(0, bprint)
+	page.addEvent(0, userStack)
+	page.addEvent(0, contextSwitch)
+
+	events, err := f.decodePage(0, page.bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"wake_up_process: marker",
+		"try_to_wake_up: woke bash pid=42 prio=120",
+		"<user stack trace>\n => 0x7f0000001000\n => 0x400123",
+		"1234:120:1  ==> 1:120:0 [003]",
+	}
+	var got []string
+	for _, e := range events {
+		got = append(got, e.EventType().Format(*e))
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want %q\ngot %q", want, got)
+	}
+
+	// The builtin types are already registered
+	etype, err := f.NewEventType("ftrace/print")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if etype != f.eventType(5) {
+		t.Error("ftrace/print registered twice")
+	}
+}
+
+func TestBinaryPrintf(t *testing.T) {
+	f := newBuiltinTestFtrace(t)
+	etype := f.eventType(6)
+	e := Event{etype: etype, ftrace: f}
+
+	args := func(words ...interface{}) []byte {
+		var b []byte
+		for _, w := range words {
+			switch w := w.(type) {
+			case uint32:
+				b = binary.LittleEndian.AppendUint32(b, w)
+			case uint64:
+				b = binary.LittleEndian.AppendUint64(b, w)
+			case string:
+				b = append(b, w...)
+			case byte:
+				b = append(b, w)
+			}
+		}
+		return b
+	}
+
+	tests := []struct {
+		format string
+		args   []byte
+		want   string
+	}{
+		{"%d %u", args(uint32(0xffffffff), uint32(0xffffffff)), "-1 4294967295"},
+		{"%s|%-5s|%d", args("ab\x00", "c\x00", "\x00\x00\x00", uint32(7)), "ab|c    |7"},
+		{"%hhx %x", args(byte(0xab), "\x00\x00\x00", uint32(0x1234)), "ab 1234"},
+		{"%hd %d", args(byte(0xfe), byte(0xff), "\x00\x00", uint32(1)), "-2 1"},
+		{"%d %lld %lx", args(uint32(7), uint64(1), uint64(0xffffffff81000000)), "7 1 ffffffff81000000"},
+		{"%c%c", args(byte('o'), byte('k')), "ok"},
+		{"%*d|%-*d|", args(uint32(4), uint32(1), uint32(3), uint32(2)), "   1|2  |"},
+		{"%ps %pS", args(uint64(0xffffffff810a2c7b), uint64(0xffffffff810a2c7b)), "try_to_wake_up try_to_wake_up+0x3b/0x4d0"},
+		{"%pB", args(uint64(0xffffffff810a3110)), "try_to_wake_up+0x4d0/0x4d0"},
+		{"%pI4 %d", args("10.0.0.1\x00", "\x00\x00\x00", uint32(5)), "10.0.0.1 5"},
+		{"100%% %s", args("done\x00"), "100% done"},
+		{"%d %d", args(uint32(1)), "1 0"},
+	}
+
+	for _, test := range tests {
+		if got := e.binaryPrintf(test.format, test.args, 8); got != test.want {
+			t.Errorf("%q: want %q got %q", test.format, test.want, got)
+		}
+	}
+}
+
+func TestParsePrintkFormats(t *testing.T) {
+	formats := parsePrintkFormats(testPrintkFormats + "garbage\n0xzz : \"bad\"\n")
+	want := map[uint64]string{
+		0xffffffff810a3200: "woke %s pid=%d prio=%hhu\n",
+		0xffffffff810a3240: "tab\there \"quoted\" \\ %lld %lx\n",
+		0xffffffff810a3280: "constant string\n",
+	}
+	if !reflect.DeepEqual(formats, want) {
+		t.Errorf("want %q got %q", want, formats)
+	}
+}
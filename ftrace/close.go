@@ -0,0 +1,128 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// FtraceClosed is returned by calls on an Ftrace, or on its EventTypes,
+// after Close
+var FtraceClosed error = errors.New("Ftrace is closed")
+
+// closableFileProvider fails every call once closed, so nothing reads or
+// changes the tracing files after Ftrace.Close
+type closableFileProvider struct {
+	fp     FileProvider
+	closed int32
+}
+
+func (fp *closableFileProvider) isClosed() bool {
+	return atomic.LoadInt32(&fp.closed) != 0
+}
+
+func (fp *closableFileProvider) close() {
+	atomic.StoreInt32(&fp.closed, 1)
+}
+
+func (fp *closableFileProvider) ReadFtraceFile(filename string) ([]byte, error) {
+	if fp.isClosed() {
+		return nil, FtraceClosed
+	}
+	return fp.fp.ReadFtraceFile(filename)
+}
+
+func (fp *closableFileProvider) WriteFtraceFile(filename string, data []byte) error {
+	if fp.isClosed() {
+		return FtraceClosed
+	}
+	return fp.fp.WriteFtraceFile(filename, data)
+}
+
+func (fp *closableFileProvider) ReadProcFile(filename string) ([]byte, error) {
+	if fp.isClosed() {
+		return nil, FtraceClosed
+	}
+	return fp.fp.ReadProcFile(filename)
+}
+
+func (fp *closableFileProvider) ReadPstoreFile(filename string) ([]byte, error) {
+	if fp.isClosed() {
+		return nil, FtraceClosed
+	}
+	return fp.fp.ReadPstoreFile(filename)
+}
+
+func (fp *closableFileProvider) OpenFtrace(filename string) (io.ReadCloser, error) {
+	if fp.isClosed() {
+		return nil, FtraceClosed
+	}
+	return fp.fp.OpenFtrace(filename)
+}
+
+// openPipes holds the trace pipes being read, to close them on
+// Ftrace.Close
+type openPipes struct {
+	sync.Mutex
+	pipes []io.Closer
+}
+
+func (p *openPipes) add(c io.Closer) {
+	p.Lock()
+	defer p.Unlock()
+	p.pipes = append(p.pipes, c)
+}
+
+func (p *openPipes) closeAll() {
+	p.Lock()
+	defer p.Unlock()
+	for _, c := range p.pipes {
+		c.Close()
+	}
+	p.pipes = nil
+}
+
+// Close ends any capture, closes the trace pipes and disables the events
+// that were enabled through this Ftrace.  Readers blocked waiting for the
+// kernel return once their pipe is closed or the next page arrives.  After
+// Close, calls on the Ftrace and its EventTypes return FtraceClosed.
+func (f *Ftrace) Close() error {
+	if f.fp.isClosed() {
+		return FtraceClosed
+	}
+
+	var firstErr error
+	for _, etype := range f.eventTypes {
+		if etype.enabled {
+			if err := etype.Disable(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	if f.lazyTypes != nil && f.lazyTypes.allEnabled {
+		if err := f.DisableAllEvents(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	f.fp.close()
+	close(f.closeCh)
+	f.pipes.closeAll()
+
+	return firstErr
+}
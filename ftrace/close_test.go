@@ -0,0 +1,88 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+// pipeFileProvider opens trace pipes that block until closed
+type pipeFileProvider struct {
+	*writeLogFileProvider
+}
+
+func (fp pipeFileProvider) OpenFtrace(filename string) (io.ReadCloser, error) {
+	r, _ := io.Pipe()
+	return r, nil
+}
+
+func TestClose(t *testing.T) {
+	fp := pipeFileProvider{&writeLogFileProvider{FileProvider: NewTestFileProvider(testFiles)}}
+	f, err := New(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enabled, err := f.NewEventType("sched/sched_wakeup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.NewEventType("task/task_newtask"); err != nil {
+		t.Fatal(err)
+	}
+	if err := enabled.Enable(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.PrepareCapture(2, make(chan bool)); err != nil {
+		t.Fatal(err)
+	}
+	captureDone := make(chan bool)
+	go func() {
+		f.Capture(func(Events) {})
+		close(captureDone)
+	}()
+
+	fp.writes = nil
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-captureDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Capture didn't return after Close")
+	}
+
+	want := [2]string{"events/sched/sched_wakeup/enable", "0"}
+	if len(fp.writes) != 1 || fp.writes[0] != want {
+		t.Errorf("want only %v written got %v", want, fp.writes)
+	}
+
+	if err := f.Close(); err != FtraceClosed {
+		t.Errorf("want FtraceClosed from a second Close got %v", err)
+	}
+	if _, err := f.NewEventType("sched/sched_switch"); err != FtraceClosed {
+		t.Errorf("want FtraceClosed from NewEventType got %v", err)
+	}
+	if err := f.Enable(); err != FtraceClosed {
+		t.Errorf("want FtraceClosed from Enable got %v", err)
+	}
+	if err := enabled.Enable(); err != FtraceClosed {
+		t.Errorf("want FtraceClosed from EventType.Enable got %v", err)
+	}
+}
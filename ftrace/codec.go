@@ -0,0 +1,149 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"sync"
+)
+
+// Codec is a compression format that can be selected by name when writing
+// and is detected from its magic number when reading.  The "none" and "gzip"
+// codecs are built in, others like zstd or lz4 can be added with
+// RegisterCodec.
+type Codec struct {
+	Name string
+	// Magic is the prefix of all compressed streams, used to detect the codec
+	// when reading.  It is empty for the "none" codec.
+	Magic     []byte
+	NewWriter func(io.Writer) (io.WriteCloser, error)
+	NewReader func(io.Reader) (io.ReadCloser, error)
+}
+
+var codecs = struct {
+	sync.Mutex
+	byName map[string]Codec
+}{
+	byName: make(map[string]Codec),
+}
+
+func init() {
+	RegisterCodec(Codec{
+		Name: "none",
+		NewWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return nopWriteCloser{w}, nil
+		},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			return ioutil.NopCloser(r), nil
+		},
+	})
+	RegisterCodec(Codec{
+		Name:  "gzip",
+		Magic: []byte{0x1f, 0x8b, 0x08},
+		NewWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return gzip.NewWriter(w), nil
+		},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+	})
+}
+
+// RegisterCodec adds a codec, replacing any existing codec with the same name
+func RegisterCodec(c Codec) {
+	codecs.Lock()
+	defer codecs.Unlock()
+	codecs.byName[c.Name] = c
+}
+
+// GetCodec returns the codec with the given name
+func GetCodec(name string) (Codec, error) {
+	codecs.Lock()
+	defer codecs.Unlock()
+	c, ok := codecs.byName[name]
+	if !ok {
+		return Codec{}, fmt.Errorf("unknown compression codec %s", name)
+	}
+	return c, nil
+}
+
+// CodecNames returns the names of all registered codecs
+func CodecNames() []string {
+	codecs.Lock()
+	defer codecs.Unlock()
+	var names []string
+	for name := range codecs.byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DetectCodec returns the codec whose magic number starts data, or the
+// "none" codec
+func DetectCodec(data []byte) Codec {
+	codecs.Lock()
+	defer codecs.Unlock()
+	for _, c := range codecs.byName {
+		if len(c.Magic) > 0 && bytes.HasPrefix(data, c.Magic) {
+			return c
+		}
+	}
+	return codecs.byName["none"]
+}
+
+// NewDecompressingReader returns a reader that decompresses r with the
+// codec detected from its first bytes
+func NewDecompressingReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(16)
+	return DetectCodec(magic).NewReader(br)
+}
+
+// Compress compresses data with the named codec
+func Compress(name string, data []byte) ([]byte, error) {
+	c, err := GetCodec(name)
+	if err != nil {
+		return nil, err
+	}
+	buf := new(bytes.Buffer)
+	w, err := c.NewWriter(buf)
+	if err != nil {
+		return nil, err
+	}
+	_, err = w.Write(data)
+	if err != nil {
+		return nil, err
+	}
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,51 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	data := bytes.Repeat([]byte("sched_switch: prev_comm=swapper/0 "), 100)
+
+	for _, name := range []string{"none", "gzip"} {
+		compressed, err := Compress(name, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if DetectCodec(compressed).Name != name {
+			t.Errorf("%s: detected codec %s", name, DetectCodec(compressed).Name)
+		}
+
+		r, err := NewDecompressingReader(bytes.NewReader(compressed))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("%s: round trip mismatch", name)
+		}
+	}
+
+	if _, err := GetCodec("bogus"); err == nil {
+		t.Error("GetCodec of unregistered codec succeeded")
+	}
+}
@@ -0,0 +1,302 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CompareOptions sets the tolerances of CompareTraces
+type CompareOptions struct {
+	// TimestampSlack is the largest difference between the timestamps of
+	// lines that match, for rounding differences
+	TimestampSlack time.Duration
+	// IgnoreFields are path.Match patterns of the names of "name=value"
+	// fields left out of the comparison, like "*_comm"
+	IgnoreFields []string
+	// Lookahead is how many lines past the next one are searched for a
+	// match, for events with the same timestamp printed in another order.
+	// Zero compares the lines strictly in order.
+	Lookahead int
+}
+
+// Comparison is the result of CompareTraces
+type Comparison struct {
+	// Matched is the number of lines that match
+	Matched int
+	// Mismatches are the kernel lines that don't match in order, then
+	// the extra lines
+	Mismatches []Mismatch
+	// ByEvent holds the counts for each event name
+	ByEvent map[string]*EventComparison
+}
+
+// EventComparison counts the lines of one event name
+type EventComparison struct {
+	Matched int
+	// Different counts lines of the same event at the same time and on
+	// the same task that differ, usually in how the event is formatted
+	Different int
+	// Missing counts kernel lines with nothing like them in the other
+	// trace, and Extra lines of the other trace with no kernel line
+	Missing int
+	Extra   int
+}
+
+// Mismatch is a line of either trace with no match in the other.  When
+// both lines are set they are the same event formatted differently.
+type Mismatch struct {
+	Event string
+	// KernelLine and Line are the 1-based numbers of the lines, or 0
+	KernelLine int
+	Kernel     string
+	Line       int
+	Got        string
+}
+
+// traceLine is a line in the kernel's trace format, split into the parts
+// that are compared separately
+type traceLine struct {
+	text string
+	// prefix is the task, pid, cpu and flags, which must be equal
+	prefix string
+	when   time.Duration
+	event  string
+	fields []string
+}
+
+var traceLineRegexp = regexp.MustCompile(`^(.*?-\d+\s+(?:\(\s*[\d-]+\)\s+)?\[\d+\]\s+\S+)\s+(\d+)\.(\d{6}): ([^:]+): ?(.*)$`)
+
+func parseTraceLine(text string, ignore []string) traceLine {
+	m := traceLineRegexp.FindStringSubmatch(text)
+	if m == nil {
+		return traceLine{text: text}
+	}
+	seconds, _ := strconv.ParseInt(m[2], 10, 64)
+	micros, _ := strconv.ParseInt(m[3], 10, 64)
+	line := traceLine{
+		text:   text,
+		prefix: m[1],
+		when:   time.Duration(seconds)*time.Second + time.Duration(micros)*time.Microsecond,
+		event:  m[4],
+	}
+	for _, field := range strings.Fields(m[5]) {
+		if !ignoredField(field, ignore) {
+			line.fields = append(line.fields, field)
+		}
+	}
+	return line
+}
+
+func ignoredField(field string, ignore []string) bool {
+	eq := strings.IndexByte(field, '=')
+	if eq <= 0 {
+		return false
+	}
+	for _, pattern := range ignore {
+		if ok, _ := path.Match(pattern, field[:eq]); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// sameEvent reports whether two lines are of the same event, on the same
+// task and at the same time within slack
+func (l traceLine) sameEvent(o traceLine, slack time.Duration) bool {
+	if l.event == "" || l.prefix != o.prefix || l.event != o.event {
+		return false
+	}
+	d := l.when - o.when
+	return -slack <= d && d <= slack
+}
+
+// before reports whether l is earlier than o by more than slack
+func (l traceLine) before(o traceLine, slack time.Duration) bool {
+	return l.event != "" && o.event != "" && l.when < o.when-slack
+}
+
+func (l traceLine) matches(o traceLine, slack time.Duration) bool {
+	if l.event == "" {
+		return l.text == o.text
+	}
+	if !l.sameEvent(o, slack) || len(l.fields) != len(o.fields) {
+		return false
+	}
+	for i := range l.fields {
+		if l.fields[i] != o.fields[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// CompareTraces compares the kernel's formatted trace, with the comments
+// removed, to the same events formatted by Event.String.  Each kernel line
+// is matched to the first line among the next Lookahead+1 unmatched ones
+// that is the same within the tolerances of options.
+func CompareTraces(kernel, got []string, options CompareOptions) *Comparison {
+	c := &Comparison{ByEvent: make(map[string]*EventComparison)}
+	counts := func(event string) *EventComparison {
+		ec := c.ByEvent[event]
+		if ec == nil {
+			ec = &EventComparison{}
+			c.ByEvent[event] = ec
+		}
+		return ec
+	}
+
+	gotLines := make([]traceLine, len(got))
+	for i, text := range got {
+		gotLines[i] = parseTraceLine(text, options.IgnoreFields)
+	}
+	used := make([]bool, len(got))
+	// next is the first line of got that may still match
+	next := 0
+
+	for i, text := range kernel {
+		k := parseTraceLine(text, options.IgnoreFields)
+
+		// Lines before the kernel line's time can't match any later
+		// kernel line, they are left as extra
+		for next < len(got) && (used[next] || gotLines[next].before(k, options.TimestampSlack)) {
+			next++
+		}
+
+		// The candidates are the first Lookahead+1 unused lines
+		var candidates []int
+		for j := next; j < len(got) && len(candidates) <= options.Lookahead; j++ {
+			if !used[j] {
+				candidates = append(candidates, j)
+			}
+		}
+
+		match, similar := -1, -1
+		for _, j := range candidates {
+			if k.matches(gotLines[j], options.TimestampSlack) {
+				match = j
+				break
+			}
+			if similar < 0 && k.sameEvent(gotLines[j], options.TimestampSlack) {
+				similar = j
+			}
+		}
+
+		switch {
+		case match >= 0:
+			used[match] = true
+			c.Matched++
+			counts(k.event).Matched++
+		case similar >= 0:
+			used[similar] = true
+			counts(k.event).Different++
+			c.Mismatches = append(c.Mismatches, Mismatch{
+				Event:      k.event,
+				KernelLine: i + 1,
+				Kernel:     text,
+				Line:       similar + 1,
+				Got:        got[similar],
+			})
+		default:
+			counts(k.event).Missing++
+			c.Mismatches = append(c.Mismatches, Mismatch{
+				Event:      k.event,
+				KernelLine: i + 1,
+				Kernel:     text,
+			})
+		}
+	}
+
+	for j := range got {
+		if !used[j] {
+			counts(gotLines[j].event).Extra++
+			c.Mismatches = append(c.Mismatches, Mismatch{
+				Event: gotLines[j].event,
+				Line:  j + 1,
+				Got:   got[j],
+			})
+		}
+	}
+	return c
+}
+
+// Equal reports whether every line matched
+func (c *Comparison) Equal() bool {
+	return len(c.Mismatches) == 0
+}
+
+// WriteReport writes up to maxMismatches of the mismatched lines as a
+// diff, followed by the counts for each event
+func (c *Comparison) WriteReport(w io.Writer, maxMismatches int) error {
+	for i, m := range c.Mismatches {
+		if i == maxMismatches {
+			if _, err := fmt.Fprintf(w, "... %d more mismatches\n", len(c.Mismatches)-i); err != nil {
+				return err
+			}
+			break
+		}
+		if _, err := fmt.Fprintf(w, "%s:\n", m.location()); err != nil {
+			return err
+		}
+		if m.Kernel != "" {
+			if _, err := fmt.Fprintf(w, "- %s\n", m.Kernel); err != nil {
+				return err
+			}
+		}
+		if m.Got != "" {
+			if _, err := fmt.Fprintf(w, "+ %s\n", m.Got); err != nil {
+				return err
+			}
+		}
+	}
+
+	events := make([]string, 0, len(c.ByEvent))
+	for event := range c.ByEvent {
+		events = append(events, event)
+	}
+	sort.Strings(events)
+	if _, err := fmt.Fprintf(w, "%-32s %8s %8s %8s %8s\n", "event", "matched", "differ", "missing", "extra"); err != nil {
+		return err
+	}
+	for _, event := range events {
+		ec := c.ByEvent[event]
+		name := event
+		if name == "" {
+			name = "(unparsed)"
+		}
+		if _, err := fmt.Fprintf(w, "%-32s %8d %8d %8d %8d\n", name, ec.Matched, ec.Different, ec.Missing, ec.Extra); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m Mismatch) location() string {
+	switch {
+	case m.Kernel != "" && m.Got != "":
+		return fmt.Sprintf("kernel line %d differs from line %d", m.KernelLine, m.Line)
+	case m.Kernel != "":
+		return fmt.Sprintf("kernel line %d is missing", m.KernelLine)
+	default:
+		return fmt.Sprintf("line %d is not in the kernel's trace", m.Line)
+	}
+}
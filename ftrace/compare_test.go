@@ -0,0 +1,112 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+var compareKernel = []string{
+	"            bash-1234  [001] d...  1000.000500: sched_wakeup: comm=sshd pid=99 prio=120 target_cpu=001",
+	"            bash-1234  [001] d...  1000.000500: sched_wakeup: comm=init pid=1 prio=120 target_cpu=000",
+	"            bash-1234  [001] d...  1000.000700: task_newtask: pid=1300 comm=bash clone_flags=0 oom_score_adj=0",
+	"          <idle>-0     [000] d...  1000.000900: sched_wakeup: comm=bash pid=1234 prio=120 target_cpu=001",
+}
+
+func TestCompareTraces(t *testing.T) {
+	got := []string{
+		// Same time, other order
+		"            bash-1234  [001] d...  1000.000500: sched_wakeup: comm=init pid=1 prio=120 target_cpu=000",
+		"            bash-1234  [001] d...  1000.000500: sched_wakeup: comm=sshd pid=99 prio=120 target_cpu=001",
+		// Rounded the other way
+		"            bash-1234  [001] d...  1000.000701: task_newtask: pid=1300 comm=bash clone_flags=0 oom_score_adj=0",
+		// Different comm
+		"          <idle>-0     [000] d...  1000.000900: sched_wakeup: comm=sh pid=1234 prio=120 target_cpu=001",
+	}
+
+	c := CompareTraces(compareKernel, got, CompareOptions{})
+	if c.Equal() || c.Matched != 0 {
+		t.Errorf("want no strict matches got %d", c.Matched)
+	}
+
+	c = CompareTraces(compareKernel, got, CompareOptions{
+		TimestampSlack: time.Microsecond,
+		Lookahead:      1,
+	})
+	if c.Matched != 3 || len(c.Mismatches) != 1 {
+		t.Fatalf("want 3 matches and 1 mismatch got %d and %+v", c.Matched, c.Mismatches)
+	}
+	want := Mismatch{
+		Event:      "sched_wakeup",
+		KernelLine: 4,
+		Kernel:     compareKernel[3],
+		Line:       4,
+		Got:        got[3],
+	}
+	if c.Mismatches[0] != want {
+		t.Errorf("want %+v got %+v", want, c.Mismatches[0])
+	}
+	if ec := c.ByEvent["sched_wakeup"]; ec.Matched != 2 || ec.Different != 1 {
+		t.Errorf("want 2 sched_wakeup matched and 1 different got %+v", ec)
+	}
+
+	c = CompareTraces(compareKernel, got, CompareOptions{
+		TimestampSlack: time.Microsecond,
+		IgnoreFields:   []string{"*comm"},
+		Lookahead:      1,
+	})
+	if !c.Equal() {
+		t.Errorf("want every line to match ignoring comm got %+v", c.Mismatches)
+	}
+}
+
+func TestCompareMissingAndExtra(t *testing.T) {
+	got := []string{
+		"            bash-1234  [001] d...  1000.000400: sched_wakeup: comm=early pid=5 prio=120 target_cpu=001",
+		compareKernel[0],
+		compareKernel[1],
+		compareKernel[3],
+	}
+
+	c := CompareTraces(compareKernel, got, CompareOptions{})
+	if c.Matched != 3 {
+		t.Errorf("want 3 matches got %d", c.Matched)
+	}
+	if ec := c.ByEvent["task_newtask"]; ec == nil || ec.Missing != 1 {
+		t.Errorf("want 1 missing task_newtask got %+v", ec)
+	}
+	if ec := c.ByEvent["sched_wakeup"]; ec.Extra != 1 {
+		t.Errorf("want 1 extra sched_wakeup got %+v", ec)
+	}
+
+	var buf bytes.Buffer
+	if err := c.WriteReport(&buf, 10); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"kernel line 3 is missing:",
+		"- " + compareKernel[2],
+		"line 1 is not in the kernel's trace:",
+		"+ " + got[0],
+		"sched_wakeup                            3        0        0        1",
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("missing %q in\n%s", line, buf.String())
+		}
+	}
+}
@@ -0,0 +1,219 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// CoverageReport compares the print helpers, constants and typedefs used by
+// the kernel's event formats with the ones traceout implements.  Symbols are
+// named "name()" for helper functions, "(name)" for typedefs used in casts
+// and "name" for constants.
+type CoverageReport struct {
+	Systems []SystemCoverage
+	// Number of events referencing each symbol
+	Implemented map[string]int
+	Missing     map[string]int
+}
+
+// SystemCoverage is the coverage of the events of one trace system
+type SystemCoverage struct {
+	System string
+	Events int
+	// Events whose print fmt can't be parsed, so NewEventType fails
+	Unparsable []string
+	// Symbols referenced by the system's events that traceout doesn't
+	// implement
+	Missing []string
+}
+
+var (
+	stringLiteralRe = regexp.MustCompile(`"(\\.|[^"\\])*"|'(\\.|[^'\\])*'`)
+	castRe          = regexp.MustCompile(`\(\s*((?:[A-Za-z_]\w*\s+)*[A-Za-z_]\w*)\s*\**\s*\)`)
+	identifierRe    = regexp.MustCompile(`(REC->|\.|->)?\b([A-Za-z_]\w*)\b(\s*\()?`)
+)
+
+var cKeywords = map[string]bool{
+	"char": true, "short": true, "int": true, "long": true, "signed": true,
+	"unsigned": true, "void": true, "const": true, "struct": true, "sizeof": true,
+	"REC": true,
+}
+
+// Coverage reads the format file of every available event and reports what
+// traceout can't format
+func (f *Ftrace) Coverage() (*CoverageReport, error) {
+	available, err := f.AvailableEvents()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &CoverageReport{
+		Implemented: make(map[string]int),
+		Missing:     make(map[string]int),
+	}
+	systems := make(map[string]*SystemCoverage)
+	missing := make(map[string]map[string]bool)
+
+	for _, name := range available {
+		system, event := path.Split(name)
+		system = path.Clean(system)
+
+		s := systems[system]
+		if s == nil {
+			s = &SystemCoverage{System: system}
+			systems[system] = s
+			missing[system] = make(map[string]bool)
+		}
+		s.Events++
+
+		format, err := f.fp.ReadFtraceFile(path.Join("events", name, "format"))
+		if err != nil {
+			s.Unparsable = append(s.Unparsable, event)
+			continue
+		}
+		etype := &EventType{defs: f.defs}
+		err = etype.parseFormatData(format)
+		if err != nil || etype.printFmt == "" {
+			s.Unparsable = append(s.Unparsable, event)
+			// parseFormatData stops at the error, so the print fmt may
+			// not have been recorded
+			etype.printFmt = printFmtLine(string(format))
+		}
+
+		for _, sym := range etype.referencedSymbols() {
+			if etype.implements(sym) {
+				report.Implemented[sym]++
+			} else {
+				report.Missing[sym]++
+				missing[system][sym] = true
+			}
+		}
+	}
+
+	for _, s := range systems {
+		for sym := range missing[s.System] {
+			s.Missing = append(s.Missing, sym)
+		}
+		sort.Strings(s.Missing)
+		report.Systems = append(report.Systems, *s)
+	}
+	sort.Slice(report.Systems, func(i, j int) bool {
+		return report.Systems[i].System < report.Systems[j].System
+	})
+
+	return report, nil
+}
+
+func printFmtLine(format string) string {
+	for _, line := range strings.Split(format, "\n") {
+		if strings.HasPrefix(line, "print fmt:") {
+			return strings.TrimSpace(strings.TrimPrefix(line, "print fmt:"))
+		}
+	}
+	return ""
+}
+
+// referencedSymbols returns the helpers, typedefs and constants used by the
+// arguments of the event's print fmt
+func (etype *EventType) referencedSymbols() []string {
+	src := stringLiteralRe.ReplaceAllString(etype.printFmt, `""`)
+	seen := make(map[string]bool)
+	var syms []string
+	add := func(sym string) {
+		if !seen[sym] {
+			seen[sym] = true
+			syms = append(syms, sym)
+		}
+	}
+
+	for _, m := range castRe.FindAllStringSubmatch(src, -1) {
+		words := strings.Fields(m[1])
+		if !cKeywords[words[0]] && len(words) == 1 {
+			add("(" + words[0] + ")")
+		}
+	}
+	src = castRe.ReplaceAllString(src, " ")
+
+	for _, m := range identifierRe.FindAllStringSubmatch(src, -1) {
+		name := m[2]
+		switch {
+		case m[1] != "" || cKeywords[name]:
+			// field or struct member
+		case m[3] != "":
+			add(name + "()")
+		case etype.getFieldNum(name) >= 0 || isNumber(name):
+		default:
+			add(name)
+		}
+	}
+	return syms
+}
+
+func isNumber(s string) bool {
+	return s[0] >= '0' && s[0] <= '9'
+}
+
+func (etype *EventType) implements(sym string) bool {
+	switch {
+	case strings.HasSuffix(sym, "()"):
+		_, ok := kernelFunctions[strings.TrimSuffix(sym, "()")]
+		return ok
+	case strings.HasPrefix(sym, "("):
+		return etype.kernelDefs().GetType(strings.Trim(sym, "()")) != ""
+	default:
+		_, ok := etype.kernelDefs().Constant(sym)
+		return ok
+	}
+}
+
+func (r *CoverageReport) String() string {
+	out := ""
+	events, unparsable := 0, 0
+	for _, s := range r.Systems {
+		events += s.Events
+		unparsable += len(s.Unparsable)
+		out += fmt.Sprintf("%s: %d events, %d unparsable", s.System, s.Events, len(s.Unparsable))
+		if len(s.Unparsable) > 0 {
+			out += " (" + strings.Join(s.Unparsable, ", ") + ")"
+		}
+		out += "\n"
+		if len(s.Missing) > 0 {
+			out += "  missing: " + strings.Join(s.Missing, ", ") + "\n"
+		}
+	}
+
+	out += fmt.Sprintf("\n%d of %d events parse, %d symbols implemented, %d missing\n",
+		events-unparsable, events, len(r.Implemented), len(r.Missing))
+
+	var missing []string
+	for sym := range r.Missing {
+		missing = append(missing, sym)
+	}
+	sort.Slice(missing, func(i, j int) bool {
+		if r.Missing[missing[i]] != r.Missing[missing[j]] {
+			return r.Missing[missing[i]] > r.Missing[missing[j]]
+		}
+		return missing[i] < missing[j]
+	})
+	for _, sym := range missing {
+		out += fmt.Sprintf("  %-40s used by %d events\n", sym, r.Missing[sym])
+	}
+	return out
+}
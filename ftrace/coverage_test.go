@@ -0,0 +1,78 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"reflect"
+	"testing"
+)
+
+const softirqEntryFormat = `name: softirq_entry
+ID: 120
+format:
+	field:unsigned short common_type;	offset:0;	size:2;	signed:0;
+	field:unsigned char common_flags;	offset:2;	size:1;	signed:0;
+	field:unsigned char common_preempt_count;	offset:3;	size:1;	signed:0;
+	field:int common_pid;	offset:4;	size:4;	signed:1;
+
+	field:unsigned int vec;	offset:8;	size:4;	signed:0;
+
+print fmt: "vec=%u [action=%s]", REC->vec, __print_symbolic(REC->vec, { HI_SOFTIRQ, "HI" }, { IRQ_POLL_SOFTIRQ, "IRQ_POLL" })
+`
+
+const kmallocFormat = `name: kmalloc
+ID: 121
+format:
+	field:unsigned short common_type;	offset:0;	size:2;	signed:0;
+	field:unsigned char common_flags;	offset:2;	size:1;	signed:0;
+	field:unsigned char common_preempt_count;	offset:3;	size:1;	signed:0;
+	field:int common_pid;	offset:4;	size:4;	signed:1;
+
+	field:unsigned long call_site;	offset:8;	size:8;	signed:0;
+	field:size_t bytes_req;	offset:16;	size:8;	signed:0;
+	field:gfp_t gfp_flags;	offset:24;	size:4;	signed:0;
+	field:u8 data[8];	offset:28;	size:8;	signed:0;
+
+print fmt: "call_site=%lx bytes_req=%zu gfp_flags=%s node=%d data=%s", REC->call_site, REC->bytes_req, (REC->gfp_flags) ? __print_flags(REC->gfp_flags, "|", {(unsigned long)((( gfp_t)0x400u)), "GFP_NOWAIT"}, {(unsigned long)(( gfp_t)0x800u), "__GFP_KSWAPD_RECLAIM"}) : "none", (int)NUMA_NO_NODE, __print_hex(REC->data, 8)
+`
+
+func TestCoverage(t *testing.T) {
+	f := newTestFtraceWith(t, map[string]string{
+		"/sys/kernel/debug/tracing/available_events":                 "sched:sched_wakeup\nirq:softirq_entry\nkmem:kmalloc\nkmem:kfree\n",
+		"/sys/kernel/debug/tracing/events/sched/sched_wakeup/format": schedWakeupFormat,
+		"/sys/kernel/debug/tracing/events/irq/softirq_entry/format":  softirqEntryFormat,
+		"/sys/kernel/debug/tracing/events/kmem/kmalloc/format":       kmallocFormat,
+	})
+
+	report, err := f.Coverage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []SystemCoverage{
+		{System: "irq", Events: 1, Missing: []string{"IRQ_POLL_SOFTIRQ"}},
+		{System: "kmem", Events: 2, Unparsable: []string{"kfree"},
+			Missing: []string{"NUMA_NO_NODE"}},
+		{System: "sched", Events: 1},
+	}
+	if !reflect.DeepEqual(report.Systems, want) {
+		t.Errorf("want %+v\ngot  %+v", want, report.Systems)
+	}
+
+	wantImplemented := map[string]int{"__print_symbolic()": 1, "HI_SOFTIRQ": 1, "__print_flags()": 1, "__print_hex()": 1, "(gfp_t)": 1}
+	if !reflect.DeepEqual(report.Implemented, wantImplemented) {
+		t.Errorf("want implemented %v got %v", wantImplemented, report.Implemented)
+	}
+}
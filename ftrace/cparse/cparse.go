@@ -22,7 +22,10 @@ package cparse
 // { 128, "K" }, { 256, "W" }, { 512, "P" }) : "R", REC->prev_state & 1024 ? "+" : "",
 // REC->next_comm, REC->next_pid, REC->next_prio
 
-import ()
+import (
+	"fmt"
+	"strings"
+)
 
 // Expression is a parsed expression.  Use Value(ctx) to evaluate it in a given
 // context.
@@ -55,6 +58,13 @@ type Scope interface {
 	GetType(name string) string
 }
 
+// A LongSizer is a Scope for a machine whose long isn't 8 bytes, like a 32
+// bit kernel.  Casts to long and unsigned long are to LongSize bytes in
+// Scopes that implement it.
+type LongSizer interface {
+	LongSize() int
+}
+
 // A Function object is a handle to call a function when an Expression is being
 // evaluated
 type Function interface {
@@ -71,6 +81,54 @@ type Variable interface {
 	Get(ctx EvalContext) Value
 }
 
+// An Indexer is a Variable for an array whose elements aren't bytes, like an
+// event's u32 array field.  Subscripts of the Variable call Index with the
+// subscript instead of indexing the Variable's value.
+type Indexer interface {
+	Index(ctx EvalContext, i int64) Value
+}
+
+// A Sizer is a Variable whose size isn't the size of its value, like an
+// event's array field.  sizeof of the Variable is Size bytes.
+type Sizer interface {
+	Size() int
+}
+
+// A ParseError is an error parsing an expression, at the byte offset Pos in
+// its Input
+type ParseError struct {
+	Input string
+	Pos   int
+	Msg   string
+}
+
+// Line returns the line of the error in the input, starting at 1
+func (e *ParseError) Line() int {
+	return strings.Count(e.Input[:e.Pos], "\n") + 1
+}
+
+// Column returns the column of the error in its line, starting at 1
+func (e *ParseError) Column() int {
+	return e.Pos - (strings.LastIndexByte(e.Input[:e.Pos], '\n') + 1) + 1
+}
+
+// Error returns the message with the line and column of the error, and the
+// input starting there
+func (e *ParseError) Error() string {
+	near := "at end of input"
+	if e.Pos < len(e.Input) {
+		snippet := e.Input[e.Pos:]
+		if nl := strings.IndexByte(snippet, '\n'); nl != -1 {
+			snippet = snippet[:nl]
+		}
+		if len(snippet) > 24 {
+			snippet = snippet[:24] + "..."
+		}
+		near = fmt.Sprintf("near %q", snippet)
+	}
+	return fmt.Sprintf("%d:%d: %s %s", e.Line(), e.Column(), e.Msg, near)
+}
+
 // Parse takes a string representing comma separated C expressions and a Scope
 // object, and returns a slice of Expression objects.
 func Parse(input string, scope Scope) ([]Expression, error) {
@@ -118,6 +176,12 @@ func CallFunction(function Function, name string, args []Expression) Expression
 	return newFunctionExpression(function, name, args)
 }
 
+// ConstantExpression returns an Expression that always evaluates to the given
+// value.
+func ConstantExpression(val Value) Expression {
+	return newConstantExpression(nil, val)
+}
+
 // CastExpression returns an Expression that evaluates to the the value of the
 // given expression cast to the given integer type.
 func CastExpression(val Expression, size int, signed bool) Expression {
@@ -28,9 +28,13 @@ A cparse.Value can be converted to a go type with AsInt(), AsString(), etc.,
 or to an interface{} suitable to pass to printf with AsInterface().  An
 Expression can be evaluated multiple times with different contexts.
 
+Array subscripts index into string and list Values, or into Variables that
+implement cparse.Indexer, like fixed size arrays of event fields.  sizeof
+takes a type or an expression, and is the size of Variables that implement
+cparse.Sizer.
+
 Not supported (yet?):
 Pointers
-Arrays
 */
 
 package cparse
@@ -15,6 +15,7 @@
 package cparse
 
 import (
+	"fmt"
 	"strconv"
 	"strings"
 )
@@ -74,6 +75,13 @@ func (e operatorExpression) Value(ctx EvalContext) Value {
 		}
 	}
 
+	// Strings can be compared for equality, for filter expressions
+	if (e.operator.typ == tokenEqual || e.operator.typ == tokenNotEqual) &&
+		len(e.args) == 2 && v1.IsString() && v2.IsString() {
+
+		return NewValueBool((v1.AsString() == v2.AsString()) == (e.operator.typ == tokenEqual))
+	}
+
 	// Operand checking
 	switch e.operator.typ {
 	case tokenNot, tokenBoolNot:
@@ -343,11 +351,14 @@ type constantExpression struct {
 	val Value
 }
 
-// TODO: get int/long size from scope
-func newConstantExpressionFromString(s string) Expression {
+// newConstantExpressionFromString parses a string, character or integer
+// constant, with an l suffix making it a long of longSize bytes
+func newConstantExpressionFromString(s string, longSize int) Expression {
 	var val Value
 	if s[0] == '"' {
-		val = NewValueString(s[1 : len(s)-1])
+		val = stringConstant(s[1 : len(s)-1])
+	} else if s[0] == '\'' {
+		val = charConstant(s[1 : len(s)-1])
 	} else {
 		s := strings.ToLower(s)
 		n := strings.TrimRight(s, "ul")
@@ -359,9 +370,9 @@ func newConstantExpressionFromString(s string) Expression {
 		case "u":
 			signed = false
 		case "l":
-			size = 8
+			size = longSize
 		case "lu", "ul":
-			size = 8
+			size = longSize
 			signed = false
 		case "ll":
 			size = 8
@@ -382,6 +393,96 @@ func newConstantExpressionFromString(s string) Expression {
 	return newConstantExpression(nil, val)
 }
 
+// stringConstant returns the value of the contents of a string literal,
+// with its escape sequences processed
+func stringConstant(s string) Value {
+	if strings.IndexByte(s, '\\') == -1 {
+		return NewValueString(s)
+	}
+
+	b := make([]byte, 0, len(s))
+	for rest := s; rest != ""; {
+		var c byte
+		var err error
+		c, rest, err = unescapeChar(rest)
+		if err != nil {
+			return NewValueError("invalid string constant \"%s\": %s", s, err.Error())
+		}
+		b = append(b, c)
+	}
+	return NewValueString(string(b))
+}
+
+// charConstant returns the value of the contents of a character constant,
+// an int holding a signed char as on x86
+func charConstant(s string) Value {
+	c, rest, err := unescapeChar(s)
+	if err != nil {
+		return NewValueError("invalid character constant '%s': %s", s, err.Error())
+	}
+	if rest != "" {
+		return NewValueError("multi-character constant '%s'", s)
+	}
+	return NewValueInt(uint64(int8(c)), 4, true)
+}
+
+// unescapeChar returns the first character of s, processing a backslash
+// escape sequence, and the rest of s
+func unescapeChar(s string) (byte, string, error) {
+	if s == "" {
+		return 0, "", fmt.Errorf("empty character")
+	}
+	if s[0] != '\\' {
+		return s[0], s[1:], nil
+	}
+	if len(s) < 2 {
+		return 0, "", fmt.Errorf("backslash at end")
+	}
+
+	switch c := s[1]; c {
+	case 'x':
+		n := 2
+		for n < len(s) && isHexDigit(s[n]) {
+			n++
+		}
+		if n == 2 {
+			return 0, "", fmt.Errorf("\\x without hex digits")
+		}
+		v, _ := strconv.ParseUint(s[2:n], 16, 64)
+		return byte(v), s[n:], nil
+	case '0', '1', '2', '3', '4', '5', '6', '7':
+		n := 1
+		for n < len(s) && n < 4 && s[n] >= '0' && s[n] <= '7' {
+			n++
+		}
+		v, _ := strconv.ParseUint(s[1:n], 8, 64)
+		return byte(v), s[n:], nil
+	default:
+		if e, ok := simpleEscapes[c]; ok {
+			return e, s[2:], nil
+		}
+		return 0, "", fmt.Errorf("unknown escape sequence \\%c", c)
+	}
+}
+
+var simpleEscapes = map[byte]byte{
+	'a':  '\a',
+	'b':  '\b',
+	'f':  '\f',
+	'n':  '\n',
+	'r':  '\r',
+	't':  '\t',
+	'v':  '\v',
+	'\\': '\\',
+	'\'': '\'',
+	'"':  '"',
+	'?':  '?',
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
 func newConstantExpression(exp Expression, val Value) Expression {
 	return constantExpression{
 		val: val,
@@ -487,6 +588,125 @@ func (e functionExpression) Dump() string {
 	return e.name + "(" + strings.Join(args, ", ") + ")"
 }
 
+//
+// Array subscripts
+//
+
+type subscriptExpression struct {
+	expressionBase
+	array Expression
+	index Expression
+}
+
+func newSubscriptExpression(array, index Expression) (e Expression) {
+	e = subscriptExpression{
+		array: array,
+		index: index,
+	}
+
+	if array.IsConstant() && index.IsConstant() {
+		e = toConstant(e)
+	}
+
+	return e
+}
+
+func (e subscriptExpression) Value(ctx EvalContext) Value {
+	index := e.index.Value(ctx)
+	if index.IsError() {
+		return index
+	}
+	if !index.IsInt() {
+		return NewValueError("array subscript is not an integer: " + index.Dump())
+	}
+	i := index.AsInt()
+	if !index.intType.signed {
+		i = int64(index.AsUint64())
+	}
+
+	if v, ok := e.array.(variableExpression); ok {
+		if indexer, ok := v.variable.(Indexer); ok {
+			return indexer.Index(ctx, i)
+		}
+	}
+
+	array := e.array.Value(ctx)
+	switch {
+	case array.IsError():
+		return array
+	case array.IsString():
+		s := array.AsString()
+		if i < 0 || i >= int64(len(s)) {
+			return NewValueError("array subscript %d out of range", i)
+		}
+		return NewValueInt(uint64(int8(s[i])), 1, true)
+	case array.IsList():
+		l := array.AsList()
+		if i < 0 || i >= int64(len(l)) {
+			return NewValueError("array subscript %d out of range", i)
+		}
+		return l[i]
+	default:
+		return NewValueError("subscript of non-array " + array.Dump())
+	}
+}
+
+func (e subscriptExpression) Dump() string {
+	return e.array.Dump() + "[" + e.index.Dump() + "]"
+}
+
+//
+// sizeof, evaluated at parse time for types and constants
+//
+
+type sizeofExpression struct {
+	expressionBase
+	exp      Expression
+	longSize int
+}
+
+func newSizeofExpression(exp Expression, longSize int) (e Expression) {
+	e = sizeofExpression{
+		exp:      exp,
+		longSize: longSize,
+	}
+
+	if _, ok := exp.(typeExpression); ok || exp.IsConstant() {
+		e = toConstant(e)
+	}
+
+	return e
+}
+
+func (e sizeofExpression) Value(ctx EvalContext) Value {
+	if t, ok := e.exp.(typeExpression); ok {
+		return NewValueInt(uint64(t.intType.size), e.longSize, false)
+	}
+	if v, ok := e.exp.(variableExpression); ok {
+		if s, ok := v.variable.(Sizer); ok {
+			return NewValueInt(uint64(s.Size()), e.longSize, false)
+		}
+	}
+
+	var size int
+	switch val := e.exp.Value(ctx); {
+	case val.IsError():
+		return val
+	case val.IsInt():
+		size = val.intType.size
+	case val.IsString():
+		// include the terminating NUL, as for a string literal
+		size = len(val.AsString()) + 1
+	default:
+		return NewValueError("sizeof applied to " + val.Dump())
+	}
+	return NewValueInt(uint64(size), e.longSize, false)
+}
+
+func (e sizeofExpression) Dump() string {
+	return "sizeof(" + e.exp.Dump() + ")"
+}
+
 //
 // Types
 //
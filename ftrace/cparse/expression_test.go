@@ -36,6 +36,13 @@ var expressionFalseTests = []string{
 	"0<-1",
 	"-1>=0",
 	"0<=-1",
+
+	`"a"=="b"`,
+	`"a"!="a"`,
+
+	`"a\tb"=="a\\tb"`,
+	"'a'=='b'",
+	"'\\0'",
 }
 
 var expressionTrueTests = []string{
@@ -50,6 +57,27 @@ var expressionTrueTests = []string{
 	"0>=0",
 	"1>=0",
 
+	`"a"=="a"`,
+	`"a"!="b"`,
+	`""==""`,
+
+	"'A'==65",
+	"'a'+1=='b'",
+	`'\n'==10`,
+	`'\t'==9`,
+	`'\0'==0`,
+	`'\\'==92`,
+	`'\''==39`,
+	`'"'==34`,
+	`'\x41'==65`,
+	`'\101'==65`,
+	`'\xff'==-1`,
+	`'\377'==-1`,
+	`(unsigned char)'\xff'==255`,
+
+	`"a\tb"=="a	b"`,
+	`"\x41\102\n"=="AB\12"`,
+
 	"0u<1u",
 	"0u<=0u",
 	"0u<=1u",
@@ -147,3 +175,48 @@ func testExpressions(t *testing.T, tests []string, expect bool) {
 func TestEqualityOperators(t *testing.T) {
 
 }
+
+func TestInvalidCharConstants(t *testing.T) {
+	for _, test := range []string{`''`, `'ab'`, `'\q'`, `'\x'`} {
+		e, err := Parse(test, testScope{})
+		if err != nil {
+			t.Errorf("%s: unexpected parse error %s", test, err.Error())
+			continue
+		}
+		if v := e[0].Value(nil); !v.IsError() {
+			t.Errorf("%s: want error got %s", test, v.Dump())
+		}
+	}
+}
+
+func TestStringEscapes(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{`"plain"`, "plain"},
+		{`"a\tb\n"`, "a\tb\n"},
+		{`"say \"hi\""`, `say "hi"`},
+		{`"back\\slash"`, `back\slash`},
+		{`"\x41\x62c"`, "A,"},
+		{`"\101\0end"`, "A\x00end"},
+		{`"\1234"`, "S4"},
+		{`"\e"`, "value error: invalid string constant \"\\e\": unknown escape sequence \\e"},
+	}
+
+	for _, test := range tests {
+		e, err := Parse(test.in, testScope{})
+		if err != nil {
+			t.Errorf("%s: %s", test.in, err.Error())
+			continue
+		}
+		v := e[0].Value(nil)
+		got := v.AsString()
+		if v.IsError() {
+			got = v.AsError().Error()
+		}
+		if got != test.want {
+			t.Errorf("%s: want %q got %q", test.in, test.want, got)
+		}
+	}
+}
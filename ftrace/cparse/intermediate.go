@@ -38,6 +38,7 @@ type placeholderType int
 const (
 	placeholderParen placeholderType = iota
 	placeholderCast
+	placeholderSubscript
 )
 
 type intermediate struct {
@@ -45,6 +46,9 @@ type intermediate struct {
 	token           token
 	Expression      Expression
 	placeholderType placeholderType
+	// pos is the position in the input of the first token the intermediate
+	// was parsed from
+	pos int
 }
 
 // Slices of an intermediate list that apply operations to the backing it if it exists,
@@ -64,6 +68,7 @@ func (l *intermediateList) replaceIntermediate(begin, size int, intermediate int
 		panic("invalid arguments to replace")
 	}
 
+	intermediate.pos = l.get(begin).pos
 	if l.backing != nil {
 		l.backing[begin] = intermediate
 		l.backing = append(l.backing[:begin+1], l.backing[begin+size:]...)
@@ -99,6 +104,15 @@ func (l *intermediateList) get(i int) *intermediate {
 	}
 }
 
+// pos returns the position in the input of the intermediate at index, or -1
+// if index is out of range
+func (l *intermediateList) pos(index int) int {
+	if index < 0 || index >= l.size {
+		return -1
+	}
+	return l.get(index).pos
+}
+
 func (l *intermediateList) len() int {
 	return l.size
 }
@@ -273,6 +287,7 @@ func newIntermediateList(tokens []token) *intermediateList {
 		l = append(l, intermediate{
 			typ:   intermediateToken,
 			token: t,
+			pos:   t.pos,
 		})
 	}
 
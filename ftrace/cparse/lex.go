@@ -15,7 +15,6 @@
 package cparse
 
 import (
-	"fmt"
 	"unicode"
 )
 
@@ -41,6 +40,7 @@ const (
 
 	tokenString
 	tokenNumber
+	tokenChar
 
 	tokenSymbol
 
@@ -82,6 +82,8 @@ const (
 	tokenComma
 	tokenLeftBracket
 	tokenRightBracket
+	tokenLeftSquare
+	tokenRightSquare
 )
 
 var stringToToken = map[string]tokenType{
@@ -120,6 +122,8 @@ var stringToToken = map[string]tokenType{
 	",": tokenComma,
 	"{": tokenLeftBracket,
 	"}": tokenRightBracket,
+	"[": tokenLeftSquare,
+	"]": tokenRightSquare,
 }
 
 type lexer struct {
@@ -208,6 +212,8 @@ func lexNone(l *lexer) stateFn {
 		return nil
 	case c == '"':
 		return lexString
+	case c == '\'':
+		return lexChar
 	case isNumber(c):
 		return lexNumber
 	case isSymbolStartValid(c):
@@ -219,6 +225,16 @@ func lexNone(l *lexer) stateFn {
 
 // parse a string starting with a quote at the current position
 func lexString(l *lexer) stateFn {
+	return lexQuoted(l, '"', tokenString, "string")
+}
+
+// parse a character constant starting with a single quote at the current
+// position
+func lexChar(l *lexer) stateFn {
+	return lexQuoted(l, '\'', tokenChar, "character constant")
+}
+
+func lexQuoted(l *lexer, quote ascii, t tokenType, name string) stateFn {
 	l.next()
 	for {
 		switch l.next() {
@@ -229,15 +245,14 @@ func lexString(l *lexer) stateFn {
 			}
 			fallthrough
 		case eof:
-			return l.error("unterminated string")
-		case '"':
-			l.emit(tokenString)
+			return l.error("unterminated " + name)
+		case quote:
+			l.emit(t)
 			return lexNone
 		}
 	}
 }
 
-// TODO: array subscripts
 func lexSymbol(l *lexer) stateFn {
 	for {
 		switch c := l.next(); {
@@ -292,11 +307,13 @@ func lexPunctuation(l *lexer) stateFn {
 	return lexNone
 }
 
+// error emits an error token at the start of the current token, with the
+// message as its value
 func (l *lexer) error(e string) stateFn {
 	l.tokens <- token{
 		typ: tokenError,
-		pos: l.pos,
-		val: fmt.Sprintf("error %s at %d\n", e, l.pos),
+		pos: l.start,
+		val: e,
 	}
 	return nil
 }
@@ -63,6 +63,7 @@ var operatorTests = []tokenTest{
 	{"( 1 )", []tokenType{tokenLeftParen, tokenNumber, tokenRightParen}},
 	{"1 , 1", []tokenType{tokenNumber, tokenComma, tokenNumber}},
 	{"{ 1 }", []tokenType{tokenLeftBracket, tokenNumber, tokenRightBracket}},
+	{"a [ 1 ]", []tokenType{tokenSymbol, tokenLeftSquare, tokenNumber, tokenRightSquare}},
 	{"+1", []tokenType{tokenPlus, tokenNumber}},
 	{"-1", []tokenType{tokenMinus, tokenNumber}},
 	{"!1", []tokenType{tokenBoolNot, tokenNumber}},
@@ -89,6 +90,7 @@ var operatorTests = []tokenTest{
 	{"(1)", []tokenType{tokenLeftParen, tokenNumber, tokenRightParen}},
 	{"1,1", []tokenType{tokenNumber, tokenComma, tokenNumber}},
 	{"{1}", []tokenType{tokenLeftBracket, tokenNumber, tokenRightBracket}},
+	{"REC->a[1]", []tokenType{tokenSymbol, tokenLeftSquare, tokenNumber, tokenRightSquare}},
 	{"0x1", []tokenType{tokenNumber}},
 	{"01", []tokenType{tokenNumber}},
 	{"0X1", []tokenType{tokenNumber}},
@@ -96,6 +98,11 @@ var operatorTests = []tokenTest{
 	{"0x1U", []tokenType{tokenNumber}},
 	{"0x1UL", []tokenType{tokenNumber}},
 	{"0x1LL", []tokenType{tokenNumber}},
+	{"'a'", []tokenType{tokenChar}},
+	{`'\''`, []tokenType{tokenChar}},
+	{`'"'+'\\'`, []tokenType{tokenChar, tokenPlus, tokenChar}},
+	{`"'"`, []tokenType{tokenString}},
+	{"'a", []tokenType{tokenError}},
 }
 
 func TestLexOperators(t *testing.T) {
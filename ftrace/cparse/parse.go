@@ -20,23 +20,47 @@ import (
 )
 
 type parser struct {
-	lex    *lexer
-	tokens []token
-	scope  Scope
+	lex      *lexer
+	tokens   []token
+	scope    Scope
+	longSize int
 }
 
 func NewParser(lex *lexer, scope Scope) *parser {
-	return &parser{
-		lex:   lex,
-		scope: scope,
+	p := &parser{
+		lex:      lex,
+		scope:    scope,
+		longSize: 8,
 	}
+	if s, ok := scope.(LongSizer); ok && s.LongSize() > 0 {
+		p.longSize = s.LongSize()
+	}
+	return p
 }
 
 func (p *parser) parse() (Expression, error) {
 	tokens := p.lex.allTokens()
+	for _, t := range tokens {
+		if t.typ == tokenError {
+			return nil, p.errorAt(t.pos, "%s", t.val)
+		}
+	}
 	return p.parseExpression(tokens)
 }
 
+// errorAt returns a ParseError at pos in the input, or at the end of the
+// input if pos is -1
+func (p *parser) errorAt(pos int, format string, args ...interface{}) error {
+	if pos < 0 {
+		pos = len(p.lex.input)
+	}
+	return &ParseError{
+		Input: p.lex.input,
+		Pos:   pos,
+		Msg:   fmt.Sprintf(format, args...),
+	}
+}
+
 func (p *parser) parseExpression(tokens []token) (e Expression, err error) {
 	// create intermediate list
 	l := newIntermediateList(tokens)
@@ -58,7 +82,7 @@ func (p *parser) parseExpression(tokens []token) (e Expression, err error) {
 func (p *parser) parseSubExpression(l *intermediateList, endToken tokenType) (int, error) {
 	// find first ( or { or endToken, call parseSubExpression if necessary, repeat
 	for {
-		i, t := l.findToken(0, []tokenType{tokenLeftBracket, tokenLeftParen, endToken})
+		i, t := l.findToken(0, []tokenType{tokenLeftBracket, tokenLeftParen, tokenLeftSquare, endToken})
 		if t.typ == tokenNone {
 			break
 		}
@@ -75,6 +99,8 @@ func (p *parser) parseSubExpression(l *intermediateList, endToken tokenType) (in
 			subEndToken = tokenRightParen
 		case tokenLeftBracket:
 			subEndToken = tokenRightBracket
+		case tokenLeftSquare:
+			subEndToken = tokenRightSquare
 		default:
 			panic("bad start token " + t.val)
 		}
@@ -86,12 +112,24 @@ func (p *parser) parseSubExpression(l *intermediateList, endToken tokenType) (in
 		// subsize should be 0 or 1
 		// i points to the start token, i+subSize+1 points to the end token
 		if l.token(i+subSize+1).typ != subEndToken {
-			return -1, fmt.Errorf("missing closing token for " + t.val)
+			return -1, p.errorAt(t.pos, "missing closing token for %s", t.val)
 		}
 
 		e := l.expression(i + 1)
-		if t.typ == tokenLeftParen {
-			if _, ok := e.(typeExpression); ok {
+		if t.typ == tokenLeftSquare {
+			// the array being subscripted may still be a symbol token, so
+			// keep the index as a placeholder until symbols are replaced
+			if subSize != 1 {
+				return -1, p.errorAt(t.pos, "expected expression inside []")
+			}
+			l.replaceWithPlaceholder(i, 3, e, placeholderSubscript)
+		} else if t.typ == tokenLeftParen {
+			if ft := l.token(i - 1); ft.typ == tokenSymbol && ft.val == "sizeof" {
+				if subSize != 1 {
+					return -1, p.errorAt(ft.pos, "expected type or expression inside sizeof()")
+				}
+				l.replace(i-1, 4, newSizeofExpression(e, p.longSize))
+			} else if _, ok := e.(typeExpression); ok {
 				// a type expression inside parenthesis must be a cast, but there is no way to know
 				// what the cast applies to until later, so keep it as a placeholder for now
 				l.replaceWithPlaceholder(i, 3, e, placeholderCast)
@@ -109,7 +147,7 @@ func (p *parser) parseSubExpression(l *intermediateList, endToken tokenType) (in
 			} else if subSize == 1 {
 				l.replace(i, subSize+2, e)
 			} else {
-				return -1, fmt.Errorf("empty parens without function call?")
+				return -1, p.errorAt(t.pos, "empty parens without function call?")
 			}
 		} else {
 			l.replace(i, subSize+2, newStructExpression(e))
@@ -118,15 +156,15 @@ func (p *parser) parseSubExpression(l *intermediateList, endToken tokenType) (in
 
 	// replace all literal tokens with constantExpressions
 	for {
-		i, t := l.findToken(0, []tokenType{tokenNumber, tokenString})
+		i, t := l.findToken(0, []tokenType{tokenNumber, tokenString, tokenChar})
 		if i < 0 {
 			break
 		}
-		l.replace(i, 1, newConstantExpressionFromString(t.val))
+		l.replace(i, 1, newConstantExpressionFromString(t.val, p.longSize))
 	}
 
 	// replace all symbol tokens variableExpression or typeExpression
-	// TODO: array subscripts and TODO: postfix increments
+	// TODO: postfix increments
 	for {
 		i, t := l.findToken(0, []tokenType{tokenSymbol})
 		if i < 0 {
@@ -154,11 +192,11 @@ func (p *parser) parseSubExpression(l *intermediateList, endToken tokenType) (in
 		}
 
 		if len(typeKeywords) > 0 {
-			t, err := keywordsToIntType(typeKeywords)
+			typ, err := keywordsToIntType(typeKeywords, p.longSize)
 			if err != nil {
-				return -1, err
+				return -1, p.errorAt(t.pos, "%s", err.Error())
 			}
-			l.replace(i, tokensUsed, newTypeExpression(t))
+			l.replace(i, tokensUsed, newTypeExpression(typ))
 		} else {
 			v := p.scope.GetVariable(t.val)
 			ve := newVariableExpression(v, t.val)
@@ -170,6 +208,19 @@ func (p *parser) parseSubExpression(l *intermediateList, endToken tokenType) (in
 		}
 	}
 
+	// handle array subscripts, which bind tighter than any prefix operator
+	for {
+		i, e := l.findPlaceholderDir(0, leftToRight, placeholderSubscript)
+		if i < 0 {
+			break
+		}
+		before := l.expression(i - 1)
+		if before == nil {
+			return -1, p.errorAt(l.pos(i), "expected expression to the left of [%s]", e.Dump())
+		}
+		l.replace(i-1, 2, newSubscriptExpression(before, e))
+	}
+
 	// handle unary operators, casts, and TODO: prefix increments
 	// also flattens any paren expressions it finds that are not casts
 	i := -1
@@ -186,7 +237,7 @@ func (p *parser) parseSubExpression(l *intermediateList, endToken tokenType) (in
 			i = j
 			after := l.expression(i + 1)
 			if after == nil {
-				return -1, fmt.Errorf("expected expression to the right of cast (%s)", e.Dump())
+				return -1, p.errorAt(l.pos(i), "expected expression to the right of cast (%s)", e.Dump())
 			}
 			l.replace(i, 2, newCastExpression(e.(typeExpression), after))
 			continue
@@ -194,7 +245,7 @@ func (p *parser) parseSubExpression(l *intermediateList, endToken tokenType) (in
 
 		after := l.expression(i + 1)
 		if after == nil {
-			return -1, fmt.Errorf("expected expression to the right of %s", t.val)
+			return -1, p.errorAt(t.pos, "expected expression to the right of %s", t.val)
 		}
 
 		// special case for unary operators + and -
@@ -225,10 +276,10 @@ func (p *parser) parseSubExpression(l *intermediateList, endToken tokenType) (in
 			before := l.expression(i - 1)
 			after := l.expression(i + 1)
 			if before == nil {
-				return -1, fmt.Errorf("expected expression to the left of %s", t.val)
+				return -1, p.errorAt(t.pos, "expected expression to the left of %s", t.val)
 			}
 			if after == nil {
-				return -1, fmt.Errorf("expected expression to the right of %s", t.val)
+				return -1, p.errorAt(t.pos, "expected expression to the right of %s", t.val)
 			}
 
 			e := newOperatorExpression(t, []Expression{before, after})
@@ -246,21 +297,21 @@ func (p *parser) parseSubExpression(l *intermediateList, endToken tokenType) (in
 		left := l.expression(i - 1)
 		middle := l.expression(i + 1)
 		if left == nil {
-			return -1, fmt.Errorf("expected expression before '?'")
+			return -1, p.errorAt(t.pos, "expected expression before '?'")
 		}
 		if middle == nil {
-			return -1, fmt.Errorf("expected expression after '?'")
+			return -1, p.errorAt(t.pos, "expected expression after '?'")
 		}
 
 		// We can cheat here, as ?: is the lowest priority operator the only valid
 		// intermediate list here is {expression, '?', expression, ':', expression}
 		// and the ':' operator can only be at i+2
 		if l.token(i+2).typ != tokenColon {
-			return -1, fmt.Errorf("expected ':' after '?'")
+			return -1, p.errorAt(t.pos, "expected ':' after '?'")
 		}
 		right := l.expression(i + 3)
 		if right == nil {
-			return -1, fmt.Errorf("expected expression after ':'")
+			return -1, p.errorAt(l.pos(i+2), "expected expression after ':'")
 		}
 
 		e := newOperatorExpression(t, []Expression{left, middle, right})
@@ -276,10 +327,10 @@ func (p *parser) parseSubExpression(l *intermediateList, endToken tokenType) (in
 		before := l.expression(i - 1)
 		after := l.expression(i + 1)
 		if before == nil {
-			return -1, fmt.Errorf("expected expression to the left of %s", t.val)
+			return -1, p.errorAt(t.pos, "expected expression to the left of %s", t.val)
 		}
 		if after == nil {
-			return -1, fmt.Errorf("expected expression to the right of %s", t.val)
+			return -1, p.errorAt(t.pos, "expected expression to the right of %s", t.val)
 		}
 
 		e := newListExpression(before, after)
@@ -288,7 +339,7 @@ func (p *parser) parseSubExpression(l *intermediateList, endToken tokenType) (in
 
 	// sanity check for single expression
 	if l.len() > 1 {
-		return -1, fmt.Errorf("failed to parse expression %s", l.dump())
+		return -1, p.errorAt(l.pos(1), "failed to parse expression %s", l.dump())
 	}
 
 	return l.len(), nil
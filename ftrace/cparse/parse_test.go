@@ -70,6 +70,17 @@ var operatorParseTests = []parseTest{
 	{"f (a)", "f(a)"},
 	{"f(a,b)", "f(a, b)"},
 	{"f ()", "f()"},
+	{"a[1]", "a[(int32)1]"},
+	{"REC->a [b + 1]", "REC->a[(b + (int32)1)]"},
+	{"a[1][b]", "a[(int32)1][b]"},
+	{"-a[b]", "(-a[b])"},
+	{"(t)a[b]", "(int32)a[b]"},
+	{"f(a)[b]", "f(a)[b]"},
+	{"(a)[b]", "a[b]"},
+	{"f(a[b], c)", "f(a[b], c)"},
+	{"sizeof(int)", "sizeof(int32)"},
+	{"a / sizeof(t)", "(a / sizeof(int32))"},
+	{"sizeof(a)", "sizeof(a)"},
 }
 
 func TestParseOperators(t *testing.T) {
@@ -117,6 +128,30 @@ func TestParseInts(t *testing.T) {
 	testParseArray(t, parseIntTests)
 }
 
+// ilp32Scope is a Scope for a 32 bit machine
+type ilp32Scope struct {
+	testScope
+}
+
+func (ilp32Scope) LongSize() int {
+	return 4
+}
+
+var ilp32ParseTests = []parseTest{
+	{"1L", "(int32)1"},
+	{"1UL", "(uint32)1"},
+	{"1LL", "(int64)1"},
+	{"(long)-1", "(int32)(-(int32)1)"},
+	{"(unsigned long)1", "(uint32)(int32)1"},
+	{"(unsigned long int)1", "(uint32)(int32)1"},
+	{"(long long)1", "(int64)(int32)1"},
+	{"(unsigned long long)1", "(uint64)(int32)1"},
+}
+
+func TestParseILP32(t *testing.T) {
+	testParseArrayInScope(t, ilp32ParseTests, ilp32Scope{})
+}
+
 var operatorPrecedenceTests = []parseTest{
 	{"a + + b", "(a + (+b))"},
 	{"a + b * c", "(a + (b * c))"},
@@ -136,8 +171,12 @@ func TestParseOperatorPrecedence(t *testing.T) {
 }
 
 func testParseArray(t *testing.T, tests []parseTest) {
+	testParseArrayInScope(t, tests, testScope{})
+}
+
+func testParseArrayInScope(t *testing.T, tests []parseTest, scope Scope) {
 	for _, test := range tests {
-		expressions, err := Parse(test.in, testScope{})
+		expressions, err := Parse(test.in, scope)
 		if err != nil {
 			t.Error(err.Error())
 			return
@@ -154,3 +193,136 @@ func testParseArray(t *testing.T, tests []parseTest) {
 		}
 	}
 }
+
+// arrayScope has a variable "a" that is an Indexer of {10, 20, 30}
+type arrayScope struct {
+	testScope
+}
+
+type arrayVariable struct{}
+
+func (arrayVariable) Get(ctx EvalContext) Value {
+	return NewValueError("array evaluated without a subscript")
+}
+
+func (arrayVariable) Size() int {
+	return 6
+}
+
+func (arrayVariable) Index(ctx EvalContext, i int64) Value {
+	if i < 0 || i >= 3 {
+		return NewValueError("index %d out of range", i)
+	}
+	return NewValueInt(uint64(10*(i+1)), 2, false)
+}
+
+func (arrayScope) GetVariable(name string) Variable {
+	if name == "REC->a" {
+		return arrayVariable{}
+	}
+	return nil
+}
+
+func TestSubscripts(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"REC->a[0]", "(uint16)10"},
+		{"REC->a[1 + 1]", "(uint16)30"},
+		{"REC->a[1u]", "(uint16)20"},
+		{"-REC->a[2]", "(int32)-30"},
+		{"\"abc\"[1]", "(int8)98"},
+		{"\"abc\"[-1]", "value error: array subscript -1 out of range"},
+		{"REC->a[3]", "value error: index 3 out of range"},
+		{"REC->a[\"x\"]", "value error: array subscript is not an integer: \"x\""},
+		{"1[0]", "value error: subscript of non-array (int32)1"},
+	}
+
+	for _, test := range tests {
+		e, err := Parse(test.in, arrayScope{})
+		if err != nil {
+			t.Errorf("%q: %s", test.in, err.Error())
+			continue
+		}
+		if got := e[0].Value(nil).Dump(); got != test.want {
+			t.Errorf("%q: want %s got %s", test.in, test.want, got)
+		}
+	}
+
+	for _, in := range []string{"a[]", "[1]", "a[1"} {
+		if _, err := Parse(in, arrayScope{}); err == nil {
+			t.Errorf("%q: want error", in)
+		}
+	}
+}
+
+func TestSizeof(t *testing.T) {
+	tests := []struct {
+		in    string
+		scope Scope
+		want  string
+	}{
+		{"sizeof(char)", testScope{}, "(uint64)1"},
+		{"sizeof(unsigned short)", testScope{}, "(uint64)2"},
+		{"sizeof(t)", testScope{}, "(uint64)4"},
+		{"sizeof(long)", testScope{}, "(uint64)8"},
+		{"sizeof(long long)", testScope{}, "(uint64)8"},
+		{"sizeof(long)", ilp32Scope{}, "(uint32)4"},
+		{"sizeof(1ll)", testScope{}, "(uint64)8"},
+		{"sizeof(\"abc\")", testScope{}, "(uint64)4"},
+		{"sizeof((short)1 + 1)", testScope{}, "(uint64)4"},
+		{"16 / sizeof(int)", testScope{}, "(uint64)4"},
+		{"sizeof(REC->a)", arrayScope{}, "(uint64)6"},
+		{"sizeof(REC->a[0])", arrayScope{}, "(uint64)2"},
+	}
+
+	for _, test := range tests {
+		e, err := Parse(test.in, test.scope)
+		if err != nil {
+			t.Errorf("%q: %s", test.in, err.Error())
+			continue
+		}
+		if got := e[0].Value(nil).Dump(); got != test.want {
+			t.Errorf("%q: want %s got %s", test.in, test.want, got)
+		}
+	}
+
+	if _, err := Parse("sizeof()", testScope{}); err == nil {
+		t.Error("sizeof(): want error")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		in        string
+		line, col int
+		want      string
+	}{
+		{"a +", 1, 3, `1:3: expected expression to the right of + near "+"`},
+		{"f(a,\n  b ? c)", 2, 5, `2:5: expected ':' after '?' near "? c)"`},
+		{"a $ b", 1, 3, `1:3: unknown token '$' near "$ b"`},
+		{`a, "abc`, 1, 4, `1:4: unterminated string near "\"abc"`},
+		{"a b", 1, 3, "1:3: failed to parse expression a b near \"b\""},
+		{"(a", 1, 1, `1:1: missing closing token for ( near "(a"`},
+		{"(long short)a", 1, 2, `1:2: invalid type: long short near "long short)a"`},
+		{"a ? b :", 1, 7, `1:7: expected expression after ':' near ":"`},
+		{"REC->very_long_field_name_here + ", 1, 32, `1:32: expected expression to the right of + near "+ "`},
+		{"a REC->very_long_field_name_here", 1, 3, `1:3: failed to parse expression a REC->very_long_field_name_here near "REC->very_long_field_nam..."`},
+	}
+
+	for _, test := range tests {
+		_, err := Parse(test.in, testScope{})
+		pe, ok := err.(*ParseError)
+		if !ok {
+			t.Errorf("%q: want a ParseError got %v", test.in, err)
+			continue
+		}
+		if pe.Line() != test.line || pe.Column() != test.col {
+			t.Errorf("%q: want %d:%d got %d:%d", test.in, test.line, test.col, pe.Line(), pe.Column())
+		}
+		if !strings.HasPrefix(pe.Error(), test.want) {
+			t.Errorf("%q: want %s got %s", test.in, test.want, pe.Error())
+		}
+	}
+}
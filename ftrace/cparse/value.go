@@ -312,6 +312,7 @@ var (
 	intULongType     = intType{8, false}
 	intLongLongType  = intType{8, true}
 	intULongLongType = intType{8, false}
+	intBoolType      = intType{1, false}
 )
 
 var intTypes = map[string]intType{
@@ -341,6 +342,7 @@ var intTypes = map[string]intType{
 	"signed long long int":   intLongLongType,
 	"unsigned long long":     intULongLongType,
 	"unsigned long long int": intULongLongType,
+	"_Bool":                  intBoolType,
 }
 
 var intTypeSpecifiers = map[string]int{
@@ -354,7 +356,7 @@ var intTypeSpecifiers = map[string]int{
 	"_Bool":    3,
 }
 
-func keywordsToIntType(keywords []string) (intType, error) {
+func keywordsToIntType(keywords []string, longSize int) (intType, error) {
 	for _, k := range keywords {
 		if _, ok := intTypeSpecifiers[k]; !ok {
 			return intType{}, fmt.Errorf("invalid type keyword: %s", k)
@@ -366,6 +368,9 @@ func keywordsToIntType(keywords []string) (intType, error) {
 	typeString := strings.Join(keywords, " ")
 
 	if typ, ok := intTypes[typeString]; ok {
+		if strings.Count(typeString, "long") == 1 {
+			typ.size = longSize
+		}
 		return typ, nil
 	}
 
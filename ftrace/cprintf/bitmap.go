@@ -0,0 +1,93 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cprintf
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/traceout/ftrace/cparse"
+)
+
+// bitmapFunction implements the kernel's %*pb and %*pbl conversions, which
+// take the size of a bitmap in bits and the bitmap, here as a string of its
+// raw bytes
+type bitmapFunction struct {
+	list bool
+}
+
+func (f bitmapFunction) Get(ctx cparse.EvalContext, args []cparse.Value) cparse.Value {
+	if len(args) != 2 {
+		return cparse.NewValueError("expected 2 arguments to a bitmap conversion")
+	}
+	if !args[0].IsInt() {
+		return cparse.NewValueError("expected integer bitmap size, got " + args[0].Dump())
+	}
+	if !args[1].IsString() {
+		return cparse.NewValueError("expected bitmap, got " + args[1].Dump())
+	}
+	return cparse.NewValueString(FormatBitmap([]byte(args[1].AsString()), int(args[0].AsInt()), f.list))
+}
+
+// FormatBitmap formats the first nbits bits of a bitmap of little endian
+// longs the way the kernel's %*pb does, as comma separated 32 bit chunks in
+// hex, most significant first, or if list is set the way %*pbl does, as
+// ranges of set bits like "0-3,6".  Bits past the end of the bitmap are
+// taken as clear.
+func FormatBitmap(bitmap []byte, nbits int, list bool) string {
+	bit := func(i int) bool {
+		return i/8 < len(bitmap) && bitmap[i/8]&(1<<uint(i%8)) != 0
+	}
+
+	if list {
+		var ranges []string
+		for i := 0; i < nbits; i++ {
+			if !bit(i) {
+				continue
+			}
+			start := i
+			for i+1 < nbits && bit(i+1) {
+				i++
+			}
+			if start == i {
+				ranges = append(ranges, strconv.Itoa(i))
+			} else {
+				ranges = append(ranges, fmt.Sprintf("%d-%d", start, i))
+			}
+		}
+		return strings.Join(ranges, ",")
+	}
+
+	if nbits <= 0 {
+		return ""
+	}
+	var chunks []string
+	chunkBits := nbits % 32
+	if chunkBits == 0 {
+		chunkBits = 32
+	}
+	for start := (nbits+31)/32*32 - 32; start >= 0; start -= 32 {
+		var chunk uint32
+		for i := 0; i < chunkBits; i++ {
+			if bit(start + i) {
+				chunk |= 1 << uint(i)
+			}
+		}
+		chunks = append(chunks, fmt.Sprintf("%0*x", (chunkBits+3)/4, chunk))
+		chunkBits = 32
+	}
+	return strings.Join(chunks, ",")
+}
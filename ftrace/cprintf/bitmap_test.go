@@ -0,0 +1,57 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cprintf
+
+import (
+	"testing"
+
+	"github.com/google/traceout/ftrace/cparse"
+)
+
+func TestFormatBitmap(t *testing.T) {
+	tests := []struct {
+		bitmap []byte
+		nbits  int
+		list   bool
+		want   string
+	}{
+		{[]byte{0x4f}, 8, false, "4f"},
+		{[]byte{0x4f}, 8, true, "0-3,6"},
+		{[]byte{0x0f, 0, 0, 0, 1, 0, 0, 0}, 64, false, "00000001,0000000f"},
+		{[]byte{0x0f, 0, 0, 0, 1, 0, 0, 0}, 64, true, "0-3,32"},
+		{[]byte{0xff, 0xff}, 12, false, "fff"},
+		{[]byte{0xff, 0xff}, 12, true, "0-11"},
+		{[]byte{0x01}, 40, false, "00,00000001"},
+		{[]byte{0}, 8, true, ""},
+		{nil, 0, false, ""},
+	}
+
+	for _, test := range tests {
+		if got := FormatBitmap(test.bitmap, test.nbits, test.list); got != test.want {
+			t.Errorf("%x/%d list=%v: want %q got %q", test.bitmap, test.nbits, test.list, test.want, got)
+		}
+	}
+}
+
+func TestBitmapConversions(t *testing.T) {
+	mask := cparse.NewValueString("\x4f\x00")
+	got, err := Sprintf("cpus=%*pbl mask=%*pb n=%d", []cparse.Value{s32(16), mask, s32(16), mask, s32(2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "cpus=0-3,6 mask=004f n=2"; got != want {
+		t.Errorf("want %q got %q", want, got)
+	}
+}
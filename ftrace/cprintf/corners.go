@@ -0,0 +1,235 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cprintf
+
+// Go's fmt.Sprintf agrees with C's printf for most conversions once the
+// arguments have been cast, but not all.  The corner cases where they differ
+// are listed in cornerCases, and each one rewrites the conversion so that the
+// output matches glibc.
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/google/traceout/ftrace/cparse"
+)
+
+type cornerCase struct {
+	conversions string
+	// flag that must be present for the corner case to apply, or 0
+	flag        byte
+	description string
+	apply       func(c Conversion, s spec) Conversion
+}
+
+var cornerCases = []cornerCase{
+	{"xXo", '#', "'#' prints no 0x for zero, counts 0x in zero padded widths, and %#.0o prints 0 for zero",
+		alternateForm},
+	{"sc", '0', "'0' is ignored for strings and characters",
+		dropZeroFlag},
+	{"c", 0, "%c prints a single byte rather than a UTF-8 encoded rune",
+		rawByte},
+	{"s", 0, "%s prints (null) for a null pointer",
+		nullString},
+}
+
+// spec is a parsed conversion specification, without the conversion
+// character
+type spec struct {
+	flags        string
+	width        int
+	precision    int
+	hasPrecision bool
+	// everything after the flags
+	rest string
+}
+
+const flagModifiers = "-+ #0"
+
+// parseSpec parses the modifiers of a conversion.  It returns false for
+// modifiers it can't handle, like '*'.
+func parseSpec(modifiers string) (spec, bool) {
+	if strings.IndexByte(modifiers, '*') != -1 {
+		return spec{}, false
+	}
+
+	s := spec{}
+	s.rest = strings.TrimLeft(modifiers, flagModifiers)
+	s.flags = modifiers[:len(modifiers)-len(s.rest)]
+
+	m := strings.TrimRight(s.rest, trimmedConversionModfiers)
+	dot := strings.IndexByte(m, '.')
+	width := m
+	if dot != -1 {
+		width = m[:dot]
+		s.hasPrecision = true
+		if m[dot+1:] != "" {
+			p, err := strconv.Atoi(m[dot+1:])
+			if err != nil {
+				return spec{}, false
+			}
+			s.precision = p
+		}
+	}
+	if width != "" {
+		w, err := strconv.Atoi(width)
+		if err != nil {
+			return spec{}, false
+		}
+		s.width = w
+	}
+	return s, true
+}
+
+func (s spec) hasFlag(flag byte) bool {
+	return strings.IndexByte(s.flags, flag) != -1
+}
+
+// applyCornerCases rewrites a conversion for every corner case that matches it
+func applyCornerCases(c Conversion) Conversion {
+	for _, cc := range cornerCases {
+		if strings.IndexByte(cc.conversions, c.Conversion) == -1 {
+			continue
+		}
+		s, ok := parseSpec(c.Modifiers)
+		if !ok {
+			continue
+		}
+		if cc.flag != 0 && !s.hasFlag(cc.flag) {
+			continue
+		}
+		c = cc.apply(c, s)
+	}
+	return c
+}
+
+func dropZeroFlag(c Conversion, s spec) Conversion {
+	c.Modifiers = strings.Replace(s.flags, "0", "", -1) + s.rest
+	return c
+}
+
+func alternateForm(c Conversion, s spec) Conversion {
+	c.Arg = cparse.CallFunction(alternateFormFunction{c.Conversion, s}, "__cprintf_alternate",
+		[]cparse.Expression{c.Arg})
+	c.Conversion = 's'
+	c.Modifiers = ""
+	return c
+}
+
+type alternateFormFunction struct {
+	conversion byte
+	spec       spec
+}
+
+// Get formats an integer with the '#' flag the way C does
+func (f alternateFormFunction) Get(ctx cparse.EvalContext, args []cparse.Value) cparse.Value {
+	if len(args) != 1 {
+		return cparse.NewValueError("expected 1 argument to __cprintf_alternate")
+	}
+	if args[0].IsError() {
+		return args[0]
+	}
+	if !args[0].IsInt() {
+		return cparse.NewValueError("expected integer argument to %%#%c", f.conversion)
+	}
+	v := args[0].AsUint64()
+	s := f.spec
+
+	prefix := ""
+	digits := ""
+	switch f.conversion {
+	case 'o':
+		digits = strconv.FormatUint(v, 8)
+	case 'x':
+		digits = strconv.FormatUint(v, 16)
+		if v != 0 {
+			prefix = "0x"
+		}
+	case 'X':
+		digits = strings.ToUpper(strconv.FormatUint(v, 16))
+		if v != 0 {
+			prefix = "0X"
+		}
+	}
+
+	if s.hasPrecision && s.precision == 0 && v == 0 {
+		digits = ""
+	}
+	if len(digits) < s.precision {
+		digits = strings.Repeat("0", s.precision-len(digits)) + digits
+	}
+	if f.conversion == 'o' && !strings.HasPrefix(digits, "0") {
+		digits = "0" + digits
+	}
+
+	pad := s.width - len(prefix) - len(digits)
+	if pad < 0 {
+		pad = 0
+	}
+	var ret string
+	switch {
+	case s.hasFlag('-'):
+		ret = prefix + digits + strings.Repeat(" ", pad)
+	case s.hasFlag('0') && !s.hasPrecision:
+		ret = prefix + strings.Repeat("0", pad) + digits
+	default:
+		ret = strings.Repeat(" ", pad) + prefix + digits
+	}
+	return cparse.NewValueString(ret)
+}
+
+func rawByte(c Conversion, s spec) Conversion {
+	c.Arg = cparse.CallFunction(rawByteFunction{}, "__cprintf_char", []cparse.Expression{c.Arg})
+	c.Conversion = 's'
+	c.Modifiers = s.flags + strings.TrimRight(s.rest, trimmedConversionModfiers)
+	return c
+}
+
+type rawByteFunction struct{}
+
+func (rawByteFunction) Get(ctx cparse.EvalContext, args []cparse.Value) cparse.Value {
+	if len(args) != 1 {
+		return cparse.NewValueError("expected 1 argument to __cprintf_char")
+	}
+	if args[0].IsError() {
+		return args[0]
+	}
+	if !args[0].IsInt() {
+		return cparse.NewValueError("expected integer argument to %s", "%c")
+	}
+	return cparse.NewValueString(string([]byte{byte(args[0].AsInt())}))
+}
+
+func nullString(c Conversion, s spec) Conversion {
+	c.Arg = cparse.CallFunction(nullStringFunction{}, "__cprintf_string", []cparse.Expression{c.Arg})
+	return c
+}
+
+type nullStringFunction struct{}
+
+// Get passes strings through, and formats pointers that weren't copied into
+// the event the way the kernel formats pointers it can't read
+func (nullStringFunction) Get(ctx cparse.EvalContext, args []cparse.Value) cparse.Value {
+	if len(args) != 1 {
+		return cparse.NewValueError("expected 1 argument to __cprintf_string")
+	}
+	switch {
+	case args[0].IsInt() && args[0].AsUint64() == 0:
+		return cparse.NewValueString("(null)")
+	case args[0].IsInt():
+		return cparse.NewValueString("(efault)")
+	}
+	return args[0]
+}
@@ -21,6 +21,7 @@ package cprintf
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/google/traceout/ftrace/cparse"
@@ -41,6 +42,14 @@ type Conversion struct {
 type conversionCallback func(c Conversion) Conversion
 
 func NewPrintfFunction(args []cparse.Expression, callback conversionCallback) (cparse.Expression, error) {
+	return NewPrintfFunctionWithLongSize(args, callback, 8)
+}
+
+// NewPrintfFunctionWithLongSize is like NewPrintfFunction, for a machine
+// whose long and pointers are longSize bytes rather than 8
+func NewPrintfFunctionWithLongSize(args []cparse.Expression, callback conversionCallback,
+	longSize int) (cparse.Expression, error) {
+
 	if len(args) < 1 {
 		return nil, fmt.Errorf("expected at least one argument to printf")
 	}
@@ -55,7 +64,7 @@ func NewPrintfFunction(args []cparse.Expression, callback conversionCallback) (c
 	format := v.AsString()
 	args = args[1:]
 
-	format, args = mungeConversions(format, args, callback)
+	format, args = mungeConversions(format, args, callback, longSize)
 
 	function := &printfFunction{
 		format: format,
@@ -63,6 +72,29 @@ func NewPrintfFunction(args []cparse.Expression, callback conversionCallback) (c
 	return cparse.CallFunction(function, "printf", args), nil
 }
 
+// Sprintf formats already evaluated values with a C format string, using the
+// same conversion munging as NewPrintfFunction without any callback.
+func Sprintf(format string, args []cparse.Value) (string, error) {
+	return sprintf(format, args, 8)
+}
+
+func sprintf(format string, args []cparse.Value, longSize int) (string, error) {
+	exprs := []cparse.Expression{cparse.ConstantExpression(cparse.NewValueString(format))}
+	for _, a := range args {
+		exprs = append(exprs, cparse.ConstantExpression(a))
+	}
+
+	f, err := NewPrintfFunctionWithLongSize(exprs, nil, longSize)
+	if err != nil {
+		return "", err
+	}
+	v := f.Value(nil)
+	if v.IsError() {
+		return "", v.AsError()
+	}
+	return v.AsString(), nil
+}
+
 func (pf *printfFunction) Get(ctx cparse.EvalContext, args []cparse.Value) cparse.Value {
 	sprintfArgs := make([]interface{}, len(args))
 	for i, v := range args {
@@ -76,14 +108,14 @@ func (pf *printfFunction) Get(ctx cparse.EvalContext, args []cparse.Value) cpars
 }
 
 const (
-	conversionSpecifiers      = "cdiopsux%"
-	formatModifiers           = "0123456789-#.*"
+	conversionSpecifiers      = "cdiopsuxXeEfFgG%"
+	formatModifiers           = "0123456789-+ #.*'"
 	trimmedConversionModfiers = "hlLz"
 	validModifiers            = formatModifiers + trimmedConversionModfiers
 )
 
 func mungeConversions(format string, args []cparse.Expression,
-	callback conversionCallback) (string, []cparse.Expression) {
+	callback conversionCallback, longSize int) (string, []cparse.Expression) {
 
 	out := ""
 	arg := 0
@@ -114,11 +146,65 @@ func mungeConversions(format string, args []cparse.Expression,
 			continue
 		}
 
+		if c == 'p' && mod == "*" && strings.HasPrefix(format, "b") && arg+1 < len(args) {
+			// %*pb and %*pbl take the bitmap's size and the bitmap
+			list := strings.HasPrefix(format, "bl")
+			format = strings.TrimPrefix(format[1:], "l")
+			bitmap := cparse.CallFunction(bitmapFunction{list}, "bitmap", args[arg:arg+2])
+			args = append(append(args[:arg:arg], bitmap), args[arg+2:]...)
+			out += "s"
+			arg++
+			continue
+		}
+
+		if c == 'p' && strings.HasPrefix(format, "h") && (mod == "*" && arg+1 < len(args) || isDigits(mod)) {
+			// %*ph takes the number of bytes to dump and the buffer, and
+			// %<n>ph has it as the width
+			end := 1
+			for end < len(format) && isAlnum(format[end]) {
+				end++
+			}
+			hex := hexDumpFunction{format[1:end]}
+			format = format[end:]
+			if mod == "*" {
+				dump := cparse.CallFunction(hex, "hexdump", args[arg:arg+2])
+				args = append(append(args[:arg:arg], dump), args[arg+2:]...)
+			} else {
+				width, _ := strconv.Atoi(mod)
+				if mod == "" {
+					width = -1
+				}
+				size := cparse.ConstantExpression(cparse.NewValueInt(uint64(width), 4, true))
+				args[arg] = cparse.CallFunction(hex, "hexdump", []cparse.Expression{size, args[arg]})
+			}
+			out += "s"
+			arg++
+			continue
+		}
+
 		trimmed := strings.TrimLeft(mod, validModifiers)
 		if trimmed != "" {
 			out += "UNEXPECTED MODIFIER(" + string(trimmed[0]) + ")"
 		}
 
+		stars := strings.Count(mod, "*")
+		if arg+stars >= len(args) {
+			out = strings.TrimSuffix(out, "%") + "MISSING ARGUMENT"
+			args = args[:arg]
+			break
+		}
+
+		if stars > 0 {
+			// A '*' width or precision takes an int argument before the
+			// value, and the conversion is formatted once it is known
+			star := starFunction{"%" + mod + string(c), longSize}
+			call := cparse.CallFunction(star, "printf", args[arg:arg+stars+1])
+			args = append(append(args[:arg:arg], call), args[arg+stars+1:]...)
+			out += "s"
+			arg++
+			continue
+		}
+
 		conversion := Conversion{
 			Conversion: c,
 			Modifiers:  mod,
@@ -129,7 +215,7 @@ func mungeConversions(format string, args []cparse.Expression,
 		if callback != nil {
 			conversion = callback(conversion)
 		}
-		conversion = munge(conversion)
+		conversion = munge(conversion, longSize)
 
 		out += conversion.Modifiers + string(conversion.Conversion)
 		format = conversion.Suffix
@@ -141,28 +227,35 @@ func mungeConversions(format string, args []cparse.Expression,
 	return out, args
 }
 
-func munge(c Conversion) Conversion {
+func munge(c Conversion, longSize int) Conversion {
 	if c.Conversion == 'i' {
 		c.Conversion = 'd'
 	}
 
+	// The ' flag groups thousands by the locale, and the C locale has no
+	// grouping.  The '+' and ' ' flags only apply to signed conversions,
+	// but Go adds the sign to every integer.
+	c.Modifiers = strings.Replace(c.Modifiers, "'", "", -1)
+	if strings.IndexByte("uxXo", c.Conversion) != -1 {
+		c.Modifiers = strings.NewReplacer("+", "", " ", "").Replace(c.Modifiers)
+	}
+
 	if c.Conversion == 'd' || c.Conversion == 'u' || c.Conversion == 'x' || c.Conversion == 'X' ||
 		c.Conversion == 'o' {
 
-		// TODO: get int size
 		size := 4
 		signed := true
 		switch {
 		case strings.Contains(c.Modifiers, "ll"):
 			size = 8
 		case strings.Contains(c.Modifiers, "l"):
-			size = 8
+			size = longSize
 		case strings.Contains(c.Modifiers, "hh"):
 			size = 1
 		case strings.Contains(c.Modifiers, "h"):
 			size = 2
 		case strings.Contains(c.Modifiers, "z"):
-			size = 8
+			size = longSize
 		}
 
 		if c.Conversion != 'd' {
@@ -176,12 +269,22 @@ func munge(c Conversion) Conversion {
 		c.Arg = cparse.CastExpression(c.Arg, size, signed)
 	}
 
+	if strings.IndexByte("eEfFgG", c.Conversion) != -1 {
+		c = floatConversion(c)
+	}
+
+	if c.Conversion == 'p' {
+		c = applyPointerExtension(c)
+	}
+
 	if c.Conversion == 'p' && c.Modifiers == "" {
 		c.Conversion = 'x'
-		c.Modifiers = "016"
-		c.Arg = cparse.CastExpression(c.Arg, 8, false)
+		c.Modifiers = "0" + strconv.Itoa(2*longSize)
+		c.Arg = cparse.CastExpression(c.Arg, longSize, false)
 	}
 
+	c = applyCornerCases(c)
+
 	modifiers := []byte(c.Modifiers)
 	c.Modifiers = ""
 	for _, m := range modifiers {
@@ -192,3 +295,76 @@ func munge(c Conversion) Conversion {
 
 	return c
 }
+
+// starFunction formats a conversion with a '*' width or precision, taking
+// the width and precision arguments before the value.  A negative width
+// left justifies, and a negative precision is ignored, as in C.
+type starFunction struct {
+	format   string
+	longSize int
+}
+
+func (f starFunction) Get(ctx cparse.EvalContext, args []cparse.Value) cparse.Value {
+	format := f.format
+	precision := strings.IndexByte(format, '.')
+	for _, a := range args[:len(args)-1] {
+		if !a.IsInt() {
+			return cparse.NewValueError("expected integer width or precision, got " + a.Dump())
+		}
+		star := strings.IndexByte(format, '*')
+		n := int(int32(a.AsInt()))
+		switch {
+		case precision != -1 && star > precision && n < 0:
+			format = format[:precision] + format[star+1:]
+		case (precision == -1 || star < precision) && n < 0:
+			format = "%-" + format[1:star] + strconv.Itoa(-n) + format[star+1:]
+		default:
+			format = format[:star] + strconv.Itoa(n) + format[star+1:]
+		}
+		precision = strings.IndexByte(format, '.')
+	}
+	s, err := sprintf(format, args[len(args)-1:], f.longSize)
+	if err != nil {
+		return cparse.NewValueError("%s", err.Error())
+	}
+	return cparse.NewValueString(s)
+}
+
+// floatConversion formats a floating point conversion of an integer, as
+// cparse has no floating point values, with the modifiers applied by Go,
+// whose output matches C's once %g has C's default precision
+func floatConversion(c Conversion) Conversion {
+	modifiers := strings.TrimRight(c.Modifiers, trimmedConversionModfiers)
+	if (c.Conversion == 'g' || c.Conversion == 'G') && strings.IndexByte(modifiers, '.') == -1 {
+		modifiers += ".6"
+	}
+	f := floatFunction{"%" + modifiers + string(c.Conversion)}
+	c.Arg = cparse.CallFunction(f, "__cprintf_float", []cparse.Expression{c.Arg})
+	c.Conversion = 's'
+	c.Modifiers = ""
+	return c
+}
+
+type floatFunction struct {
+	format string
+}
+
+func (f floatFunction) Get(ctx cparse.EvalContext, args []cparse.Value) cparse.Value {
+	if len(args) != 1 {
+		return cparse.NewValueError("expected 1 argument to __cprintf_float")
+	}
+	if args[0].IsError() {
+		return args[0]
+	}
+	if !args[0].IsInt() {
+		return cparse.NewValueError("expected number for %q, got %s", f.format, args[0].Dump())
+	}
+	var v float64
+	switch i := args[0].AsInterface().(type) {
+	case int64:
+		v = float64(i)
+	case uint64:
+		v = float64(i)
+	}
+	return cparse.NewValueString(fmt.Sprintf(f.format, v))
+}
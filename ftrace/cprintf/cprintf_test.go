@@ -0,0 +1,192 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cprintf
+
+import (
+	"testing"
+
+	"github.com/google/traceout/ftrace/cparse"
+)
+
+func s32(i int64) cparse.Value {
+	return cparse.NewValueInt(uint64(i), 4, true)
+}
+
+func str(s string) cparse.Value {
+	return cparse.NewValueString(s)
+}
+
+// Expected output is from glibc's snprintf
+var sprintfTests = []struct {
+	format string
+	arg    cparse.Value
+	want   string
+}{
+	{"%d", s32(-8), "-8"},
+	{"%u", s32(-8), "4294967288"},
+	{"%x", s32(-8), "fffffff8"},
+	{"%05d", s32(42), "00042"},
+	{"%-05d", s32(42), "42   "},
+	{"%0-5d", s32(42), "42   "},
+	{"%05.3d", s32(42), "  042"},
+	{"%08.3x", s32(42), "     02a"},
+	{"%.0d", s32(0), ""},
+	{"%5.0d", s32(0), "     "},
+	{"%.3d", s32(-8), "-008"},
+	{"%+d", s32(42), "+42"},
+	{"%+d", s32(-42), "-42"},
+	{"% d", s32(42), " 42"},
+	{"% 5d", s32(42), "   42"},
+	{"%+ d", s32(42), "+42"},
+	{"%+05d", s32(42), "+0042"},
+	{"%-+5d|", s32(42), "+42  |"},
+	{"%+u", s32(42), "42"},
+	{"% x", s32(42), "2a"},
+	{"%+#x", s32(42), "0x2a"},
+	{"%'d", s32(1234567), "1234567"},
+	{"%'8d", s32(1234567), " 1234567"},
+	{"%+s", str("abc"), "abc"},
+	{"% s", str("abc"), "abc"},
+	{"%+.1f", s32(3), "+3.0"},
+
+	{"%#x", s32(0), "0"},
+	{"%#x", s32(42), "0x2a"},
+	{"%#5x", s32(0), "    0"},
+	{"%#5x", s32(42), " 0x2a"},
+	{"%#05x", s32(0), "00000"},
+	{"%#05x", s32(1), "0x001"},
+	{"%#05x", s32(255), "0x0ff"},
+	{"%0#8x", s32(42), "0x00002a"},
+	{"%#.3x", s32(0), "000"},
+	{"%#.3x", s32(1), "0x001"},
+	{"%#8.3x", s32(0), "     000"},
+	{"%#08.3x", s32(42), "   0x02a"},
+	{"%#-8x", s32(0), "0       "},
+	{"%#-8x", s32(42), "0x2a    "},
+	{"%#lx", cparse.NewValueInt(0xffffffff81000000, 8, false), "0xffffffff81000000"},
+	{"%X", s32(255), "FF"},
+	{"%08X", s32(0xbeef), "0000BEEF"},
+	{"%#X", s32(0), "0"},
+	{"%#X", s32(42), "0X2A"},
+	{"%#06X", s32(42), "0X002A"},
+	{"%lX", cparse.NewValueInt(0xffffffff81000000, 8, false), "FFFFFFFF81000000"},
+
+	{"%#o", s32(0), "0"},
+	{"%#o", s32(8), "010"},
+	{"%#.0o", s32(0), "0"},
+	{"%.0o", s32(0), ""},
+	{"%#.3o", s32(8), "010"},
+	{"%#.3o", s32(1), "001"},
+	{"%#5o", s32(8), "  010"},
+	{"%-#5o", s32(8), "010  "},
+	{"%#08o", s32(8), "00000010"},
+
+	{"%f", s32(3), "3.000000"},
+	{"%.2f", s32(-3), "-3.00"},
+	{"%8.1F", s32(42), "    42.0"},
+	{"%e", s32(1234), "1.234000e+03"},
+	{"%E", s32(1234), "1.234000E+03"},
+	{"%g", s32(1234567), "1.23457e+06"},
+	{"%G", s32(1234567), "1.23457E+06"},
+	{"%g", s32(100000), "100000"},
+
+	{"%c", s32('a'), "a"},
+	{"%c", s32(255), "\xff"},
+	{"%c", s32(-8), "\xf8"},
+	{"%5c", s32(255), "    \xff"},
+	{"%-5c", s32(-8), "\xf8    "},
+	{"%05c", s32('a'), "    a"},
+
+	{"%05s", str("abc"), "  abc"},
+	{"%-05s", str("abc"), "abc  "},
+	{"%08.2s", str("abc"), "      ab"},
+	{"%.0s", str("abc"), ""},
+	{"%5.1s", str("abc"), "    a"},
+	{"%s", cparse.NewValueInt(0, 8, false), "(null)"},
+	{"%8s", cparse.NewValueInt(0, 8, false), "  (null)"},
+}
+
+func TestSprintf(t *testing.T) {
+	for _, test := range sprintfTests {
+		got, err := Sprintf(test.format, []cparse.Value{test.arg})
+		if err != nil {
+			t.Errorf("%q: unexpected error %s", test.format, err.Error())
+		} else if got != test.want {
+			t.Errorf("%q %s: want %q, got %q", test.format, test.arg.Dump(), test.want, got)
+		}
+	}
+}
+
+func TestStarArguments(t *testing.T) {
+	tests := []struct {
+		format string
+		args   []cparse.Value
+		want   string
+	}{
+		{"%*d|%d", []cparse.Value{s32(4), s32(7), s32(8)}, "   7|8"},
+		{"%-*d|", []cparse.Value{s32(4), s32(7)}, "7   |"},
+		{"%*d|", []cparse.Value{s32(-4), s32(7)}, "7   |"},
+		{"%.*s|%s", []cparse.Value{s32(2), str("abc"), str("def")}, "ab|def"},
+		{"%*.*s|", []cparse.Value{s32(5), s32(2), str("abc")}, "   ab|"},
+		{"%.*s|", []cparse.Value{s32(-1), str("abc")}, "abc|"},
+		{"%*lx %u", []cparse.Value{cparse.NewValueInt(6, 8, true), cparse.NewValueInt(0xab, 8, false), s32(1)}, "    ab 1"},
+		{"%*c|", []cparse.Value{s32(3), s32(255)}, "  \xff|"},
+		{"%0*x|", []cparse.Value{s32(4), s32(42)}, "002a|"},
+		{"%d %*d", []cparse.Value{s32(1), s32(4)}, "1 MISSING ARGUMENT"},
+		{"%d %d", []cparse.Value{s32(1)}, "1 MISSING ARGUMENT"},
+	}
+
+	for _, test := range tests {
+		got, err := Sprintf(test.format, test.args)
+		if err != nil {
+			t.Errorf("%q: unexpected error %s", test.format, err.Error())
+		} else if got != test.want {
+			t.Errorf("%q: want %q, got %q", test.format, test.want, got)
+		}
+	}
+}
+
+func TestLongSize(t *testing.T) {
+	tests := []struct {
+		format string
+		args   []cparse.Value
+		want   string
+	}{
+		{"%ld", []cparse.Value{cparse.NewValueInt(^uint64(0), 8, true)}, "-1"},
+		{"%lu", []cparse.Value{cparse.NewValueInt(^uint64(0), 8, true)}, "4294967295"},
+		{"%lx", []cparse.Value{cparse.NewValueInt(0x123456789, 8, false)}, "23456789"},
+		{"%zu", []cparse.Value{cparse.NewValueInt(^uint64(1), 4, true)}, "4294967294"},
+		{"%llu", []cparse.Value{cparse.NewValueInt(^uint64(0), 8, true)}, "18446744073709551615"},
+		{"%p", []cparse.Value{cparse.NewValueInt(0xc0001234, 4, false)}, "c0001234"},
+		{"%p", []cparse.Value{cparse.NewValueInt(0x1234, 8, false)}, "00001234"},
+		{"%*lx|", []cparse.Value{s32(4), cparse.NewValueInt(^uint64(0), 8, true)}, "ffffffff|"},
+	}
+
+	for _, test := range tests {
+		got, err := sprintf(test.format, test.args, 4)
+		if err != nil {
+			t.Errorf("%q: unexpected error %s", test.format, err.Error())
+		} else if got != test.want {
+			t.Errorf("%q: want %q, got %q", test.format, test.want, got)
+		}
+	}
+}
+
+func TestFloatNotNumber(t *testing.T) {
+	v := floatFunction{format: "%5%f"}.Get(nil, []cparse.Value{str("x")})
+	if want := `value error: expected number for "%5%f", got "x"`; !v.IsError() || v.AsError().Error() != want {
+		t.Errorf("want error %q got %s", want, v.Dump())
+	}
+}
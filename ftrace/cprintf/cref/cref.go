@@ -0,0 +1,153 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cref formats values with the C library's snprintf, as a reference
+// to compare package cprintf against.  It needs cgo; without it Sprintf
+// always returns ErrNoCgo.
+package cref
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/google/traceout/ftrace/cparse"
+)
+
+var ErrNoCgo = errors.New("cref: built without cgo")
+
+// ErrUnsupported is returned for conversions the reference can't format,
+// like kernel pointer extensions or floating point
+var ErrUnsupported = errors.New("cref: unsupported conversion")
+
+const (
+	conversionSpecifiers = "cdiopsuxXeEfFgGaAn%"
+	lengthModifiers      = "hlLqjzt"
+)
+
+// chunk is a piece of a format string containing literal text followed by
+// at most one conversion
+type chunk struct {
+	format     string
+	conversion byte
+	length     string
+	stars      int
+}
+
+// splitFormat splits a C format string into chunks with one conversion each
+func splitFormat(format string) ([]chunk, error) {
+	var chunks []chunk
+	for format != "" {
+		c := chunk{}
+		i := strings.IndexByte(format, '%')
+		for i != -1 && i+1 < len(format) && format[i+1] == '%' {
+			// skip %%, it is formatted along with the literal text
+			next := strings.IndexByte(format[i+2:], '%')
+			if next == -1 {
+				i = -1
+			} else {
+				i += 2 + next
+			}
+		}
+		if i == -1 {
+			c.format = format
+			chunks = append(chunks, c)
+			break
+		}
+
+		end := strings.IndexAny(format[i+1:], conversionSpecifiers)
+		if end == -1 {
+			return nil, ErrUnsupported
+		}
+		end += i + 1
+		c.format = format[:end+1]
+		c.conversion = format[end]
+		spec := format[i+1 : end]
+		c.stars = strings.Count(spec, "*")
+		c.length = spec[len(strings.TrimRight(spec, lengthModifiers)):]
+		format = format[end+1:]
+
+		// Pointer extensions like %pS consume the following alphanumerics
+		if c.conversion == 'p' && format != "" && isAlnum(format[0]) {
+			return nil, ErrUnsupported
+		}
+
+		chunks = append(chunks, c)
+	}
+	return chunks, nil
+}
+
+func isAlnum(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// Sprintf formats values with snprintf.  Each conversion is formatted by a
+// separate snprintf call with the argument passed as the C type implied by
+// its length modifier.
+func Sprintf(format string, args []cparse.Value) (string, error) {
+	chunks, err := splitFormat(format)
+	if err != nil {
+		return "", err
+	}
+
+	out := ""
+	for _, c := range chunks {
+		if c.conversion == 0 {
+			s, err := snprintfNone(c.format)
+			if err != nil {
+				return "", err
+			}
+			out += s
+			continue
+		}
+
+		if len(args) < c.stars+1 {
+			return "", errors.New("cref: not enough arguments")
+		}
+		var stars []int64
+		for _, a := range args[:c.stars] {
+			if !a.IsInt() {
+				return "", errors.New("cref: expected integer for '*'")
+			}
+			stars = append(stars, a.AsInt())
+		}
+		arg := args[c.stars]
+		args = args[c.stars+1:]
+
+		var s string
+		switch c.conversion {
+		case 's':
+			if !arg.IsString() {
+				return "", errors.New("cref: expected string for %s")
+			}
+			s, err = snprintfString(c.format, stars, arg.AsString())
+		case 'c', 'd', 'i', 'o', 'u', 'x', 'X', 'p':
+			if !arg.IsInt() {
+				return "", errors.New("cref: expected integer for %" + string(c.conversion))
+			}
+			length := c.length
+			if c.conversion == 'p' {
+				length = "p"
+			}
+			s, err = snprintfInt(c.format, stars, length, arg.AsInt())
+		default:
+			return "", ErrUnsupported
+		}
+		if err != nil {
+			return "", err
+		}
+		out += s
+	}
+
+	return out, nil
+}
@@ -0,0 +1,148 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build cgo
+
+package cref
+
+/*
+#include <stdio.h>
+#include <stdlib.h>
+#include <stddef.h>
+
+// Each helper passes the '*' arguments followed by the value, converted to
+// the C type the length modifier expects after default argument promotion.
+#define CREF_SNPRINTF(buf, n, f, nstars, s, v) \
+	((nstars) == 0 ? snprintf(buf, n, f, v) : \
+	 (nstars) == 1 ? snprintf(buf, n, f, (int)(s)[0], v) : \
+	 snprintf(buf, n, f, (int)(s)[0], (int)(s)[1], v))
+
+static int cref_none(char *buf, size_t n, const char *f) {
+	return snprintf(buf, n, f, 0);
+}
+static int cref_int(char *buf, size_t n, const char *f, int nstars, long long *s, long long v) {
+	return CREF_SNPRINTF(buf, n, f, nstars, s, (int)v);
+}
+static int cref_long(char *buf, size_t n, const char *f, int nstars, long long *s, long long v) {
+	return CREF_SNPRINTF(buf, n, f, nstars, s, (long)v);
+}
+static int cref_longlong(char *buf, size_t n, const char *f, int nstars, long long *s, long long v) {
+	return CREF_SNPRINTF(buf, n, f, nstars, s, v);
+}
+static int cref_size(char *buf, size_t n, const char *f, int nstars, long long *s, long long v) {
+	return CREF_SNPRINTF(buf, n, f, nstars, s, (size_t)v);
+}
+static int cref_ptr(char *buf, size_t n, const char *f, int nstars, long long *s, long long v) {
+	return CREF_SNPRINTF(buf, n, f, nstars, s, (void *)(size_t)v);
+}
+static int cref_string(char *buf, size_t n, const char *f, int nstars, long long *s, const char *v) {
+	return CREF_SNPRINTF(buf, n, f, nstars, s, v);
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"unsafe"
+)
+
+func cstars(stars []int64) (C.int, *C.longlong) {
+	s := make([]C.longlong, 2)
+	for i, v := range stars {
+		s[i] = C.longlong(v)
+	}
+	return C.int(len(stars)), &s[0]
+}
+
+// call runs an snprintf helper with a growing buffer until the output fits
+func call(f func(buf *C.char, n C.size_t) C.int) (string, error) {
+	n := 256
+	for {
+		buf := (*C.char)(C.malloc(C.size_t(n)))
+		r := f(buf, C.size_t(n))
+		if r < 0 {
+			C.free(unsafe.Pointer(buf))
+			return "", errors.New("cref: snprintf failed")
+		}
+		if int(r) < n {
+			s := C.GoStringN(buf, r)
+			C.free(unsafe.Pointer(buf))
+			return s, nil
+		}
+		C.free(unsafe.Pointer(buf))
+		n = int(r) + 1
+	}
+}
+
+func snprintfNone(format string) (string, error) {
+	f := C.CString(format)
+	defer C.free(unsafe.Pointer(f))
+	return call(func(buf *C.char, n C.size_t) C.int {
+		return C.cref_none(buf, n, f)
+	})
+}
+
+func snprintfInt(format string, stars []int64, length string, v int64) (string, error) {
+	if len(stars) > 2 {
+		return "", ErrUnsupported
+	}
+	f := C.CString(format)
+	defer C.free(unsafe.Pointer(f))
+	nstars, s := cstars(stars)
+
+	var helper func(*C.char, C.size_t, *C.char, C.int, *C.longlong, C.longlong) C.int
+	switch length {
+	case "", "h", "hh":
+		helper = func(b *C.char, n C.size_t, f *C.char, ns C.int, s *C.longlong, v C.longlong) C.int {
+			return C.cref_int(b, n, f, ns, s, v)
+		}
+	case "l":
+		helper = func(b *C.char, n C.size_t, f *C.char, ns C.int, s *C.longlong, v C.longlong) C.int {
+			return C.cref_long(b, n, f, ns, s, v)
+		}
+	case "ll", "L", "q", "j":
+		helper = func(b *C.char, n C.size_t, f *C.char, ns C.int, s *C.longlong, v C.longlong) C.int {
+			return C.cref_longlong(b, n, f, ns, s, v)
+		}
+	case "z", "t":
+		helper = func(b *C.char, n C.size_t, f *C.char, ns C.int, s *C.longlong, v C.longlong) C.int {
+			return C.cref_size(b, n, f, ns, s, v)
+		}
+	case "p":
+		helper = func(b *C.char, n C.size_t, f *C.char, ns C.int, s *C.longlong, v C.longlong) C.int {
+			return C.cref_ptr(b, n, f, ns, s, v)
+		}
+	default:
+		return "", ErrUnsupported
+	}
+
+	return call(func(buf *C.char, n C.size_t) C.int {
+		return helper(buf, n, f, nstars, s, C.longlong(v))
+	})
+}
+
+func snprintfString(format string, stars []int64, v string) (string, error) {
+	if len(stars) > 2 {
+		return "", ErrUnsupported
+	}
+	f := C.CString(format)
+	defer C.free(unsafe.Pointer(f))
+	cv := C.CString(v)
+	defer C.free(unsafe.Pointer(cv))
+	nstars, s := cstars(stars)
+
+	return call(func(buf *C.char, n C.size_t) C.int {
+		return C.cref_string(buf, n, f, nstars, s, cv)
+	})
+}
@@ -0,0 +1,29 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !cgo
+
+package cref
+
+func snprintfNone(format string) (string, error) {
+	return "", ErrNoCgo
+}
+
+func snprintfInt(format string, stars []int64, length string, v int64) (string, error) {
+	return "", ErrNoCgo
+}
+
+func snprintfString(format string, stars []int64, v string) (string, error) {
+	return "", ErrNoCgo
+}
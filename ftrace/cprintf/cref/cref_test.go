@@ -0,0 +1,53 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cref
+
+import (
+	"testing"
+
+	"github.com/google/traceout/ftrace/cparse"
+)
+
+func TestSprintf(t *testing.T) {
+	tests := []struct {
+		format string
+		args   []cparse.Value
+		want   string
+		err    error
+	}{
+		{"plain", nil, "plain", nil},
+		{"100%%", nil, "100%", nil},
+		{"pid=%d comm=%s", []cparse.Value{cparse.NewValueInt(1234, 4, true), cparse.NewValueString("bash")}, "pid=1234 comm=bash", nil},
+		{"%5d|%-5d|%05d", []cparse.Value{cparse.NewValueInt(42, 4, true), cparse.NewValueInt(42, 4, true), cparse.NewValueInt(42, 4, true)}, "   42|42   |00042", nil},
+		{"%u", []cparse.Value{cparse.NewValueInt(0xffffffff, 4, false)}, "4294967295", nil},
+		{"%llx", []cparse.Value{cparse.NewValueInt(0xdeadbeefcafe, 8, false)}, "deadbeefcafe", nil},
+		{"%#o", []cparse.Value{cparse.NewValueInt(8, 4, true)}, "010", nil},
+		{"%*d", []cparse.Value{cparse.NewValueInt(4, 4, true), cparse.NewValueInt(7, 4, true)}, "   7", nil},
+		{"%.3s", []cparse.Value{cparse.NewValueString("abcdef")}, "abc", nil},
+		{"%pS", []cparse.Value{cparse.NewValueInt(0, 8, false)}, "", ErrUnsupported},
+	}
+
+	for _, test := range tests {
+		got, err := Sprintf(test.format, test.args)
+		if err == ErrNoCgo {
+			t.Skip("built without cgo")
+		}
+		if err != test.err {
+			t.Errorf("Sprintf(%q): want error %v, got %v", test.format, test.err, err)
+		} else if got != test.want {
+			t.Errorf("Sprintf(%q): want %q, got %q", test.format, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cprintf
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Address families of struct sockaddr, for %pIS
+const (
+	afInet  = 2
+	afInet6 = 10
+)
+
+// formatIP implements the kernel's IP address extensions, %pI4 and %pI6 for
+// addresses in network order, and %pIS for a struct sockaddr_in or
+// sockaddr_in6.  The %pi forms print every digit, with no separators for
+// IPv6.  The flags are the kernel's: 'c' compresses IPv6 addresses, 'p',
+// 'f' and 's' add the port, flow label and scope of a sockaddr, and 'h'
+// and 'l' read IPv4 addresses in little endian order.
+func formatIP(b []byte, extension string) (string, bool) {
+	if len(extension) < 2 {
+		return "", false
+	}
+	padded := extension[0] == 'i'
+	flags := extension[2:]
+	switch extension[1] {
+	case '4':
+		if len(b) < 4 {
+			return "", false
+		}
+		return ip4String(b, padded, strings.ContainsAny(flags, "hl")), true
+	case '6':
+		if len(b) < 16 {
+			return "", false
+		}
+		if !padded && strings.Contains(flags, "c") {
+			return ip6Compressed(b), true
+		}
+		return ip6String(b, padded), true
+	case 'S':
+		return sockaddrString(b, padded, flags)
+	}
+	return "", false
+}
+
+func ip4String(b []byte, padded, reversed bool) string {
+	octets := make([]string, 4)
+	for i := range octets {
+		octet := b[i]
+		if reversed {
+			octet = b[3-i]
+		}
+		if padded {
+			octets[i] = fmt.Sprintf("%03d", octet)
+		} else {
+			octets[i] = strconv.Itoa(int(octet))
+		}
+	}
+	return strings.Join(octets, ".")
+}
+
+// ip6String prints every word of an IPv6 address
+func ip6String(b []byte, padded bool) string {
+	words := make([]string, 8)
+	for i := range words {
+		words[i] = fmt.Sprintf("%02x%02x", b[2*i], b[2*i+1])
+	}
+	if padded {
+		return strings.Join(words, "")
+	}
+	return strings.Join(words, ":")
+}
+
+// ip6Compressed prints an IPv6 address as the kernel's
+// ip6_compressed_string does, with the longest run of two or more zero
+// words replaced by "::", and IPv4 mapped and ISATAP addresses ending in
+// their IPv4 address
+func ip6Compressed(b []byte) string {
+	word := func(i int) uint16 {
+		return uint16(b[2*i])<<8 | uint16(b[2*i+1])
+	}
+	v4mapped := strings.Repeat("\x00", 10)+"\xff\xff" == string(b[:12])
+	isatap := b[8]|0x02 == 0x02 && b[9] == 0 && b[10] == 0x5e && b[11] == 0xfe
+	words := 8
+	if v4mapped || isatap {
+		words = 6
+	}
+
+	longest, zeros := 1, -1
+	for i := 0; i < words; i++ {
+		n := 0
+		for j := i; j < words && word(j) == 0; j++ {
+			n++
+		}
+		if n > longest {
+			longest, zeros = n, i
+		}
+	}
+
+	var out strings.Builder
+	needColon := false
+	for i := 0; i < words; i++ {
+		if i == zeros {
+			if needColon || i == 0 {
+				out.WriteByte(':')
+			}
+			out.WriteByte(':')
+			needColon = false
+			i += longest - 1
+			continue
+		}
+		if needColon {
+			out.WriteByte(':')
+		}
+		out.WriteString(strconv.FormatUint(uint64(word(i)), 16))
+		needColon = true
+	}
+	if words == 6 {
+		if needColon {
+			out.WriteByte(':')
+		}
+		out.WriteString(ip4String(b[12:], false, false))
+	}
+	return out.String()
+}
+
+// sockaddrString prints a struct sockaddr_in or sockaddr_in6, whose
+// family is in host order and port and flow label in network order
+func sockaddrString(b []byte, padded bool, flags string) (string, bool) {
+	if len(b) < 2 {
+		return "", false
+	}
+	port := func() string {
+		return strconv.Itoa(int(b[2])<<8 | int(b[3]))
+	}
+
+	switch int(b[0]) | int(b[1])<<8 {
+	case afInet:
+		if len(b) < 8 {
+			return "", false
+		}
+		s := ip4String(b[4:8], padded, false)
+		if strings.Contains(flags, "p") {
+			s += ":" + port()
+		}
+		return s, true
+	case afInet6:
+		// sizeof(struct sockaddr_in6)
+		if len(b) < 28 {
+			return "", false
+		}
+		var s string
+		if !padded && strings.Contains(flags, "c") {
+			s = ip6Compressed(b[8:24])
+		} else {
+			s = ip6String(b[8:24], padded)
+		}
+		if strings.Contains(flags, "p") {
+			s = "[" + s + "]:" + port()
+		}
+		if strings.Contains(flags, "f") {
+			flow := uint32(b[4])<<24 | uint32(b[5])<<16 | uint32(b[6])<<8 | uint32(b[7])
+			s += "/" + strconv.FormatUint(uint64(flow&0x0fffffff), 10)
+		}
+		if strings.Contains(flags, "s") {
+			scope := uint32(b[24]) | uint32(b[25])<<8 | uint32(b[26])<<16 | uint32(b[27])<<24
+			s += "%" + strconv.FormatUint(uint64(scope), 10)
+		}
+		return s, true
+	}
+	return "(invalid address)", true
+}
@@ -0,0 +1,65 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cprintf
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/traceout/ftrace/cparse"
+)
+
+func TestIPConversions(t *testing.T) {
+	v4 := str("\xc0\xa8\x01\x0a")
+	v6 := str("\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01")
+	sin := str("\x02\x00\x1f\x90\xc0\xa8\x01\x0a\x00\x00\x00\x00\x00\x00\x00\x00")
+	sin6 := str("\x0a\x00\x00\x50\x00\x01\x23\x45" +
+		"\xfe\x80\x00\x00\x00\x00\x00\x00\x02\x1b\x21\xff\xfe\x3a\x4f\x00" +
+		"\x02\x00\x00\x00")
+
+	tests := []struct {
+		format string
+		arg    cparse.Value
+		want   string
+	}{
+		{"%pI4", v4, "192.168.1.10"},
+		{"%pi4", v4, "192.168.001.010"},
+		{"%pI4h", v4, "10.1.168.192"},
+		{"%pI4n", v4, "192.168.1.10"},
+		{"%pI6", v6, "2001:0db8:0000:0000:0000:0000:0000:0001"},
+		{"%pi6", v6, "20010db8000000000000000000000001"},
+		{"%pI6c", v6, "2001:db8::1"},
+		{"%pI6c", str(strings.Repeat("\x00", 16)), "::"},
+		{"%pI6c", str("\x00\x01\x00\x00\x00\x01\x00\x00\x00\x00\x00\x01\x00\x00\x00\x01"), "1:0:1::1:0:1"},
+		{"%pI6c", str(strings.Repeat("\x00", 10) + "\xff\xff\x0a\x00\x00\x01"), "::ffff:10.0.0.1"},
+		{"%pIS", sin, "192.168.1.10"},
+		{"%pISpc", sin, "192.168.1.10:8080"},
+		{"%piS", sin, "192.168.001.010"},
+		{"%pISc", sin6, "fe80::21b:21ff:fe3a:4f00"},
+		{"%pISpc", sin6, "[fe80::21b:21ff:fe3a:4f00]:80"},
+		{"%pISpfsc", sin6, "[fe80::21b:21ff:fe3a:4f00]:80/74565%2"},
+		{"%pIS", str("\x01\x00/tmp/sock"), "(invalid address)"},
+		{"%pI4 port", str("\x01\x02"), "(efault) port"},
+	}
+
+	for _, test := range tests {
+		got, err := Sprintf(test.format, []cparse.Value{test.arg})
+		if err != nil {
+			t.Errorf("%q: unexpected error %s", test.format, err.Error())
+		} else if got != test.want {
+			t.Errorf("%q %s: want %q, got %q", test.format, test.arg.Dump(), test.want, got)
+		}
+	}
+}
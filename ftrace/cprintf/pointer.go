@@ -0,0 +1,198 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cprintf
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/traceout/ftrace/cparse"
+)
+
+// pointerExtension formats the memory a kernel %p extension points to,
+// given as a string of its raw bytes, and the extension, like "MF" for
+// %pMF.  It returns false if the memory can't be formatted
+// that way, for example because it is too short.
+type pointerExtension func(b []byte, extension string) (string, bool)
+
+// pointerExtensions are the %p extensions of the kernel's vsprintf that
+// format memory, by their first letter
+var pointerExtensions = map[byte]pointerExtension{
+	'M': formatMAC,
+	'm': formatMAC,
+	'I': formatIP,
+	'i': formatIP,
+	'U': formatUUID,
+}
+
+// pointerFunction applies a pointerExtension to its argument
+type pointerFunction struct {
+	extension string
+	format    pointerExtension
+}
+
+func (f pointerFunction) Get(ctx cparse.EvalContext, args []cparse.Value) cparse.Value {
+	if len(args) != 1 {
+		return cparse.NewValueError("expected 1 argument to %s", "%p"+f.extension)
+	}
+	if args[0].IsError() {
+		return args[0]
+	}
+	// Pointers that weren't copied into the event can't be followed, and
+	// print like the kernel prints a bad pointer
+	if !args[0].IsString() {
+		return cparse.NewValueString("(efault)")
+	}
+	s, ok := f.format([]byte(args[0].AsString()), f.extension)
+	if !ok {
+		return cparse.NewValueString("(efault)")
+	}
+	return cparse.NewValueString(s)
+}
+
+// applyPointerExtension rewrites a %p conversion followed by one of the
+// pointerExtensions into a %s of the formatted memory.  Like the kernel,
+// it takes every letter and digit after the 'p' as part of the extension.
+func applyPointerExtension(c Conversion) Conversion {
+	if c.Suffix == "" {
+		return c
+	}
+	format, ok := pointerExtensions[c.Suffix[0]]
+	if !ok {
+		return c
+	}
+	end := 1
+	for end < len(c.Suffix) && isAlnum(c.Suffix[end]) {
+		end++
+	}
+	extension := c.Suffix[:end]
+	c.Suffix = c.Suffix[end:]
+	c.Arg = cparse.CallFunction(pointerFunction{extension, format}, "%p"+extension, []cparse.Expression{c.Arg})
+	c.Conversion = 's'
+	c.Modifiers = ""
+	return c
+}
+
+func isDigits(s string) bool {
+	return strings.Trim(s, "0123456789") == ""
+}
+
+func isAlnum(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// formatMAC implements %pM, a 6 byte MAC address separated by colons, or
+// by dashes with the F flag, and %pm, without separators.  The R flag
+// reverses the bytes, for Bluetooth addresses.
+func formatMAC(b []byte, extension string) (string, bool) {
+	if len(b) < 6 {
+		return "", false
+	}
+	flags := extension[1:]
+	sep := ":"
+	switch {
+	case extension[0] == 'm':
+		sep = ""
+	case strings.Contains(flags, "F"):
+		sep = "-"
+	}
+	var octets []string
+	for i := 0; i < 6; i++ {
+		octet := b[i]
+		if strings.Contains(flags, "R") {
+			octet = b[5-i]
+		}
+		octets = append(octets, fmt.Sprintf("%02x", octet))
+	}
+	return strings.Join(octets, sep), true
+}
+
+// formatUUID implements %pU, a 16 byte UUID in big endian order, or with
+// the l flag in the little endian order of GUIDs.  B and L print the hex
+// digits in upper case.
+func formatUUID(b []byte, extension string) (string, bool) {
+	if len(b) < 16 {
+		return "", false
+	}
+	index := []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+	flags := extension[1:]
+	if strings.ContainsAny(flags, "lL") {
+		index = []int{3, 2, 1, 0, 5, 4, 7, 6, 8, 9, 10, 11, 12, 13, 14, 15}
+	}
+	var out strings.Builder
+	for i, j := range index {
+		switch i {
+		case 4, 6, 8, 10:
+			out.WriteByte('-')
+		}
+		fmt.Fprintf(&out, "%02x", b[j])
+	}
+	if strings.ContainsAny(flags, "BL") {
+		return strings.ToUpper(out.String()), true
+	}
+	return out.String(), true
+}
+
+// maxHexDump is the most bytes the kernel's %ph dumps
+const maxHexDump = 64
+
+// hexDumpFunction implements the kernel's %*ph, which takes the number of
+// bytes to dump and the buffer, and prints up to 64 bytes in hex separated
+// by spaces, or by colons for %*phC, dashes for %*phD and nothing for
+// %*phN.  Without a width, %ph dumps one byte.
+type hexDumpFunction struct {
+	flags string
+}
+
+func (f hexDumpFunction) Get(ctx cparse.EvalContext, args []cparse.Value) cparse.Value {
+	if len(args) != 2 {
+		return cparse.NewValueError("expected 2 arguments to %s", "%*ph"+f.flags)
+	}
+	if !args[0].IsInt() {
+		return cparse.NewValueError("expected integer hex dump size, got " + args[0].Dump())
+	}
+	if !args[1].IsString() {
+		return cparse.NewValueString("(efault)")
+	}
+	n := int(args[0].AsInt())
+	if n < 0 {
+		// A negative '*' width is left justification
+		n = -n
+	}
+	if n > maxHexDump {
+		n = maxHexDump
+	}
+	b := args[1].AsString()
+	if n > len(b) {
+		n = len(b)
+	}
+
+	sep := " "
+	if f.flags != "" {
+		switch f.flags[0] {
+		case 'C':
+			sep = ":"
+		case 'D':
+			sep = "-"
+		case 'N':
+			sep = ""
+		}
+	}
+	bytes := make([]string, n)
+	for i := range bytes {
+		bytes[i] = fmt.Sprintf("%02x", b[i])
+	}
+	return cparse.NewValueString(strings.Join(bytes, sep))
+}
@@ -0,0 +1,85 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cprintf
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/traceout/ftrace/cparse"
+)
+
+const testUUID = "\x00\x11\x22\x33\x44\x55\x66\x77\x88\x99\xaa\xbb\xcc\xdd\xee\xff"
+
+var pointerTests = []struct {
+	format string
+	arg    cparse.Value
+	want   string
+}{
+	{"%pM", str("\x00\x1b\x21\x0a\xbc\xff"), "00:1b:21:0a:bc:ff"},
+	{"%pMR", str("\x00\x1b\x21\x0a\xbc\xff"), "ff:bc:0a:21:1b:00"},
+	{"%pMF", str("\x00\x1b\x21\x0a\xbc\xff"), "00-1b-21-0a-bc-ff"},
+	{"%pm", str("\x00\x1b\x21\x0a\xbc\xff"), "001b210abcff"},
+	{"%pmR", str("\x00\x1b\x21\x0a\xbc\xff"), "ffbc0a211b00"},
+	{"[%pM]", str("\x00\x1b"), "[(efault)]"},
+	{"%pM", s32(42), "(efault)"},
+
+	{"%pU", str(testUUID), "00112233-4455-6677-8899-aabbccddeeff"},
+	{"%pUb", str(testUUID), "00112233-4455-6677-8899-aabbccddeeff"},
+	{"%pUB", str(testUUID), "00112233-4455-6677-8899-AABBCCDDEEFF"},
+	{"%pUl", str(testUUID), "33221100-5544-7766-8899-aabbccddeeff"},
+	{"%pUL", str(testUUID), "33221100-5544-7766-8899-AABBCCDDEEFF"},
+	{"%pU", str(testUUID[:15]), "(efault)"},
+
+	{"%ph", str(testUUID), "00"},
+	{"%4ph", str(testUUID), "00 11 22 33"},
+	{"%4phC", str(testUUID), "00:11:22:33"},
+	{"%4phD", str(testUUID), "00-11-22-33"},
+	{"%4phN", str(testUUID), "00112233"},
+	{"%32ph", str(testUUID[:2]), "00 11"},
+}
+
+func TestPointerExtensions(t *testing.T) {
+	for _, test := range pointerTests {
+		got, err := Sprintf(test.format, []cparse.Value{test.arg})
+		if err != nil {
+			t.Errorf("%q: unexpected error %s", test.format, err.Error())
+		} else if got != test.want {
+			t.Errorf("%q %s: want %q, got %q", test.format, test.arg.Dump(), test.want, got)
+		}
+	}
+}
+
+func TestPointerArgumentCount(t *testing.T) {
+	v := pointerFunction{extension: "M"}.Get(nil, nil)
+	if want := "value error: expected 1 argument to %pM"; !v.IsError() || v.AsError().Error() != want {
+		t.Errorf("want error %q got %s", want, v.Dump())
+	}
+	v = hexDumpFunction{flags: "N"}.Get(nil, nil)
+	if want := "value error: expected 2 arguments to %*phN"; !v.IsError() || v.AsError().Error() != want {
+		t.Errorf("want error %q got %s", want, v.Dump())
+	}
+}
+
+func TestHexDumpConversions(t *testing.T) {
+	buf := str(strings.Repeat("\xab", 100))
+	got, err := Sprintf("[%*ph] [%*phN] [%*phC] %d", []cparse.Value{s32(3), buf, s32(100), buf, s32(0), buf, s32(7)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "[ab ab ab] [" + strings.Repeat("ab", 64) + "] [] 7"; got != want {
+		t.Errorf("want %q got %q", want, got)
+	}
+}
@@ -22,17 +22,29 @@ significant portion of the work to a remote processor.
 Basics:
 Create an ftrace.FileProvider that can read and write the various
 tracing and proc files needed.  NewLocalFileProvider() can be used
-to create one that reads the files from the local path, or
+to create one that reads the files from the local path,
+NewAdbFileProvider() to trace an Android device from a host, or
 NewRecordingFileProvider() and NewTestFileProvider() can be used to
 create one that records and replays accesses for testing.
+NewTestFileProviderWithStreams() also scripts the trace pipes, with
+delays and errors, for testing how a capture handles them.
 For tracing a remote device, implement FileProvider over your
-choice of IPC.
+choice of IPC, or use the reference implementation in the remote
+package, which serves a FileProvider over net/rpc.
+When only the kernel source is available, the eventsrc package can
+generate event format files from the TRACE_EVENT definitions.
+The report package summarizes a capture as a self-contained HTML page.
+The analysis package derives facts such as per-thread scheduling
+states and CPU time from captured events.
 
 Create an ftrace object with NewFtrace, create the events
 with ftrace.NewEventType(), call ftrace.PrepareCapture()
 to open the trace pipes, enable the event types with etype.Enable()
 and the tracing with ftrace.Enable(), and then read the events
 from ftrace.Capture().
+
+Processes can define their own events with RegisterUserEvent, which
+are traced and decoded like kernel events under "user_events/".
 */
 
 package ftrace
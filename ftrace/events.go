@@ -19,6 +19,10 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"sync/atomic"
 	"time"
 )
 
@@ -26,14 +30,43 @@ const (
 	entryTypeDataMax    = 28
 	entryTypePadding    = 29
 	entryTypeTimeExt    = 30
+	entryTypeTimeStamp  = 31
 	entryTypeLenBits    = 5
 	entryTimeDeltaBits  = 27
 	entryTypeLenShift   = 0
 	entryTimeDeltaShift = entryTypeLenBits
 	entryTypeLenMask    = uint32((1 << entryTypeLenBits) - 1)
 	entryTimeDeltaMask  = uint32((1 << entryTimeDeltaBits) - 1)
+
+	// timeStampBits are the bits of an absolute timestamp record, the
+	// rest are those of the time before it
+	timeStampBits = 59
+	timeStampMSB  = ^uint64((1 << timeStampBits) - 1)
 )
 
+// headerEventDataMaxRegexp matches the line of events/header_event with the
+// largest type_len of data records, "data max type_len  == 28"
+var headerEventDataMaxRegexp = regexp.MustCompile(`data max type_len\s*==\s*(\d+)`)
+
+// readDataMaxTypeLen returns the largest type_len of data records from
+// events/header_event.  Kernels that add record types lower it, and the
+// types between it and entryTypeDataMax are unknown records.
+func readDataMaxTypeLen(fp FileProvider) uint32 {
+	data, err := fp.ReadFtraceFile("events/header_event")
+	if err != nil {
+		return entryTypeDataMax
+	}
+	m := headerEventDataMaxRegexp.FindSubmatch(data)
+	if m == nil {
+		return entryTypeDataMax
+	}
+	max, err := strconv.Atoi(string(m[1]))
+	if err != nil || max > entryTypeDataMax {
+		return entryTypeDataMax
+	}
+	return uint32(max)
+}
+
 type BadEventHeader struct {
 	What   string
 	Page   []byte
@@ -46,7 +79,40 @@ func (e BadEventHeader) Error() string {
 
 var BadPageHeader = errors.New("Bad page header")
 
-var order = binary.LittleEndian
+// UnknownRecordPolicy selects how the decoder handles ring buffer records
+// with a type it does not understand.  The length of an unknown record is
+// not known, so the rest of the page after it can never be decoded.
+type UnknownRecordPolicy int
+
+const (
+	// SkipUnknownRecords drops the rest of the page and reports an
+	// UnknownRecord error, which is printed as a warning.
+	SkipUnknownRecords UnknownRecordPolicy = iota
+	// AbortOnUnknownRecords stops reading the cpu's trace pipe.
+	AbortOnUnknownRecords
+	// EmitUnknownRecords drops the rest of the page, but returns it as an
+	// "unknown_record" Event carrying the raw bytes.
+	EmitUnknownRecords
+)
+
+type UnknownRecord struct {
+	TypeLen int
+	Offset  int
+}
+
+func (e UnknownRecord) Error() string {
+	return fmt.Sprintf("unknown ring buffer record type %d at %x", e.TypeLen, e.Offset)
+}
+
+// unknownRecordType is the EventType of Events created by EmitUnknownRecords
+var unknownRecordType = &EventType{
+	name:                "unknown_record",
+	pidField:            -1,
+	flagsField:          -1,
+	preemptField:        -1,
+	migrateDisableField: -1,
+	preemptLazyField:    -1,
+}
 
 // Returns a channel that provides individual events from a cpu raw ftrace pipe
 // Requires all enabled events to be registered or it will fail to parse
@@ -54,38 +120,66 @@ var order = binary.LittleEndian
 // Or just drop the page, mark lost events, and continue with the next page?
 // Write to doneCh to end
 func (f *Ftrace) getEvents(cpu int, doneCh <-chan bool) (<-chan Events, error) {
+	ch, _, err := f.getEventsPipe(cpu, doneCh)
+	return ch, err
+}
+
+// getEventsPipe is getEvents, also returning the trace pipe so it can be
+// closed to end a reader blocked on it
+func (f *Ftrace) getEventsPipe(cpu int, doneCh <-chan bool) (<-chan Events, io.Closer, error) {
 	rawDoneCh := make(chan bool)
 	eventCh := make(chan Events)
+	out := eventCh
+	if f.options.MaxBufferedEvents > 0 {
+		out = make(chan Events)
+		go f.bufferEvents(eventCh, out, doneCh)
+	}
 
-	rawCh, err := getRawFtraceChan(f.fp, cpu, rawDoneCh)
+	rawCh, pipe, err := getRawFtraceChan(f.fp, cpu, func() { f.setupCaptureThread(cpu) }, rawDoneCh)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+	f.pipes.add(pipe)
 
 	go func() {
 		defer close(rawDoneCh)
 		defer close(eventCh)
 
+		f.setupCaptureThread(cpu)
+
 		for {
 			select {
 			case <-doneCh:
 				return
+			case <-f.closeCh:
+				return
 			case buf, ok := <-rawCh:
 				if !ok {
 					// raw channel failed
 					return
 				}
 				events, err := f.decodePage(cpu, buf)
+				atomic.AddInt64(&f.metrics.pagesRead, 1)
 				if err != nil {
+					atomic.AddInt64(&f.metrics.decodeErrors, 1)
 					fmt.Println(err.Error())
 					// TODO: error over channel?
 				}
-				eventCh <- events
+				select {
+				case eventCh <- events:
+				case <-doneCh:
+					return
+				case <-f.closeCh:
+					return
+				}
+				if _, ok := err.(UnknownRecord); ok && f.options.UnknownRecords == AbortOnUnknownRecords {
+					return
+				}
 			}
 		}
 	}()
 
-	return eventCh, nil
+	return out, pipe, nil
 }
 
 func (f *Ftrace) decodePage(cpu int, data []byte) (events Events, err error) {
@@ -94,19 +188,43 @@ func (f *Ftrace) decodePage(cpu int, data []byte) (events Events, err error) {
 		return nil, err
 	}
 	when := page.values[f.pageHeaderFieldTimestamp].DecodeUint()
-	pageLen := int(page.values[f.pageHeaderFieldCommit].DecodeUint() & ((1 << 30) - 1))
+	commit := page.values[f.pageHeaderFieldCommit].DecodeUint()
+	pageLen := int(commit & (commitMissedStored - 1))
 	pageOffset := page.values[f.pageHeaderFieldData].field.offset
 
 	if pageLen < 0 || len(data) < pageOffset+pageLen {
 		return nil, BadPageHeader
 	}
 
+	if commit&commitMissedEvents != 0 {
+		atomic.AddInt64(&f.metrics.pagesAfterLoss, 1)
+		// The count is a long after the data, as long as the commit field
+		missedEnd := pageOffset + pageLen + page.values[f.pageHeaderFieldCommit].field.size
+		if commit&commitMissedStored != 0 && len(data) >= missedEnd {
+			missed := page.values[f.pageHeaderFieldCommit]
+			missed.contents = data[pageOffset+pageLen : missedEnd]
+			atomic.AddInt64(&f.metrics.lostEvents, int64(missed.DecodeUint()))
+		}
+	}
+
+	// The bit fields of the event headers are allocated from the most
+	// significant bit on big endian machines
+	order := f.byteOrder()
+	typeLenShift, timeDeltaShift := uint(entryTypeLenShift), uint(entryTimeDeltaShift)
+	if order == binary.BigEndian {
+		typeLenShift, timeDeltaShift = entryTimeDeltaBits, 0
+	}
+
 	fullData := data[0 : pageOffset+pageLen]
 	data = data[pageOffset : pageOffset+pageLen]
 
 	events = make(Events, 0, 64)
 
 	var lazyErr error
+	// The last event decoded from the page, which a kernel_stack that
+	// follows belongs to, and whether it was dropped
+	var last *Event
+	lastDropped := false
 dataLoop:
 	for len(data) > 0 {
 		if len(data) < 4 {
@@ -119,11 +237,11 @@ dataLoop:
 		entryHeader := order.Uint32(data)
 		data = data[4:]
 
-		typeLen := (entryHeader >> entryTypeLenShift) & entryTypeLenMask
-		timeDelta := uint64((entryHeader >> entryTimeDeltaShift) & entryTimeDeltaMask)
+		typeLen := (entryHeader >> typeLenShift) & entryTypeLenMask
+		timeDelta := uint64((entryHeader >> timeDeltaShift) & entryTimeDeltaMask)
 
 		switch {
-		case typeLen <= entryTypeDataMax:
+		case typeLen <= f.dataMaxTypeLen:
 			when += timeDelta
 
 			var dataLen int
@@ -148,22 +266,56 @@ dataLoop:
 			eventData := data[:dataLen]
 			data = data[(dataLen+3)&^0x3:]
 
+			// Dropped before decoding, so events outside the window
+			// cost as little as possible
+			if !f.inWindow(when + uint64(f.clockOffset)) {
+				atomic.AddInt64(&f.metrics.eventsFiltered, 1)
+				last, lastDropped = nil, true
+				continue
+			}
+
 			typeId := int(order.Uint16(eventData))
 
-			etype := f.eventTypes[typeId]
+			etype := f.eventType(typeId)
 			if etype == nil {
 				lazyErr = fmt.Errorf("unknown type ID: %d (0x%x)", typeId, typeId)
+				last, lastDropped = nil, false
 				continue
 			}
 
 			var event *Event
-			event, err = etype.DecodeEvent(eventData, cpu, when)
+			event, err = etype.DecodeEvent(eventData, cpu, when+uint64(f.clockOffset))
 			if err != nil {
 				lazyErr = err
+				last, lastDropped = nil, false
 				continue
 			}
 			event.ftrace = f
+			event.PreviousBoot = f.previousBoot
+			atomic.AddInt64(&f.metrics.eventsDecoded, 1)
+
+			if etype.isKernelStack() && (last != nil || lastDropped) {
+				// Attach the stack to its event, or drop it with it
+				if last != nil {
+					last.kernelStack = event.stackFrames()
+				}
+				last, lastDropped = nil, false
+				continue
+			}
+
+			if f.followed != nil && !f.follow(event) {
+				atomic.AddInt64(&f.metrics.eventsFiltered, 1)
+				last, lastDropped = nil, true
+				continue
+			}
+			if f.options.Filter != nil && !f.options.Filter.Match(event) {
+				atomic.AddInt64(&f.metrics.eventsFiltered, 1)
+				last, lastDropped = nil, true
+				continue
+			}
+			f.stops.check(event)
 			events = append(events, event)
+			last, lastDropped = event, false
 
 		case typeLen == entryTypePadding:
 			if timeDelta == 0 {
@@ -189,6 +341,36 @@ dataLoop:
 
 			timeDelta += uint64(timeDeltaExt) << entryTimeDeltaBits
 			when += timeDelta
+
+		case typeLen == entryTypeTimeStamp:
+			if len(data) < 4 {
+				err = BadEventHeader{"Not enough data for type time stamp", fullData, offset}
+				return
+			}
+
+			// An absolute timestamp, without its top bits, which are
+			// those of the time before it unless it wrapped
+			stamp := uint64(order.Uint32(data))<<entryTimeDeltaBits | timeDelta
+			data = data[4:]
+
+			prev := when
+			when = stamp | prev&timeStampMSB
+			if when < prev && prev&timeStampMSB != 0 {
+				when += 1 << timeStampBits
+			}
+
+		default:
+			err = UnknownRecord{int(typeLen), offset}
+			if f.options.UnknownRecords == EmitUnknownRecords {
+				events = append(events, &Event{
+					ftrace:   f,
+					etype:    unknownRecordType,
+					Cpu:      cpu,
+					When:     when + uint64(f.clockOffset),
+					contents: fullData[offset:],
+				})
+			}
+			return
 		}
 	}
 
@@ -206,14 +388,42 @@ type Event struct {
 	Flags    uint
 	Preempt  int
 	contents []byte
+	// kernelStack is the stack recorded after the event
+	kernelStack []uint64
+
+	// PreemptLazy and MigrateDisable are the lazy preempt and migrate
+	// disable counts of RT kernels, whose events have extra common fields
+	PreemptLazy    int
+	MigrateDisable int
+
+	// PreviousBoot is set for events read by ReadPreviousBoot, traced
+	// before the last reboot
+	PreviousBoot bool
 }
 
 func (e Event) String() string {
-	return fmt.Sprintf("%16s-%-5d [%03d] %s %6d.%06d: %s: %s",
-		e.ProcessName(), e.Pid, e.Cpu, e.FlagChars(), e.Seconds(), e.Microseconds(),
+	tgid := ""
+	if e.ftrace.recordTgid {
+		if t := e.Tgid(); t != 0 {
+			tgid = fmt.Sprintf("(%5d) ", t)
+		} else {
+			tgid = "(-----) "
+		}
+	}
+	return fmt.Sprintf("%16s-%-5d %s[%03d] %s %6d.%06d: %s: %s",
+		e.ProcessName(), e.Pid, tgid, e.Cpu, e.FlagChars(), e.Seconds(), e.Microseconds(),
 		e.etype.name, e.etype.Format(e))
 }
 
+// Tgid returns the thread group (process) id of the thread that generated the
+// event, or 0 if it is not known.  Requires Ftrace.EnableRecordTgid.
+func (e Event) Tgid() int {
+	if e.PreviousBoot {
+		return 0
+	}
+	return e.ftrace.processTgid(e.Pid)
+}
+
 func (e Event) Seconds() int {
 	return int(e.whenInMicroseconds() / 1e6)
 }
@@ -267,12 +477,45 @@ func (e Event) FlagChars() string {
 		f[3] = '0' + byte(e.Preempt)
 	}
 
+	// RT kernels follow the preempt count with their extra counts, in hex
+	count := func(c int) byte {
+		if c == 0 {
+			return '.'
+		}
+		return "0123456789abcdef"[c&0xf]
+	}
+	if e.etype != nil && e.etype.preemptLazyField >= 0 {
+		f = append(f, count(e.PreemptLazy))
+	}
+	if e.etype != nil && e.etype.migrateDisableField >= 0 {
+		f = append(f, count(e.MigrateDisable))
+	}
+
 	return string(f)
 }
 
+// EventType returns the type of the event
+func (e Event) EventType() *EventType {
+	return e.etype
+}
+
+// Raw returns the raw bytes of the event as read from the ring buffer
+func (e Event) Raw() []byte {
+	return e.contents
+}
+
+// Device returns the name of the device the event was captured on, as set by
+// Ftrace.SetDevice
+func (e Event) Device() string {
+	return e.ftrace.device
+}
+
 func (e Event) ProcessName() string {
 	if e.Pid == 0 {
 		return "<idle>"
+	} else if e.PreviousBoot {
+		// The names read now are of the current boot's pids
+		return "<...>"
 	} else if n := e.ftrace.processName(e.Pid); n != "" {
 		return n
 	} else {
@@ -284,6 +527,9 @@ type EventsByTime struct{ Events }
 
 func (e EventsByTime) Less(i, j int) bool {
 	if e.Events[i].When == e.Events[j].When {
+		if e.Events[i].Cpu == e.Events[j].Cpu {
+			return e.Events[i].Device() < e.Events[j].Device()
+		}
 		return e.Events[i].Cpu < e.Events[j].Cpu
 	}
 	return e.Events[i].When < e.Events[j].When
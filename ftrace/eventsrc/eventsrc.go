@@ -0,0 +1,401 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package eventsrc generates event format files from the TRACE_EVENT,
+// DECLARE_EVENT_CLASS and DEFINE_EVENT macros in kernel trace headers
+// (include/trace/events/*.h), for decoding traces when only the kernel
+// source is available.
+//
+// Field layouts are computed for a 64-bit little endian kernel.  Event IDs
+// are assigned by the kernel at boot, so they have to come from elsewhere,
+// for example the trace's own metadata.
+package eventsrc
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type EventDefinition struct {
+	System   string
+	Name     string
+	Fields   []FieldDefinition
+	PrintFmt string
+}
+
+type FieldDefinition struct {
+	Type   string
+	Name   string
+	Offset int
+	Size   int
+	Signed bool
+	// Number of elements of a fixed size array, or 0
+	ArrayLen int
+	// Dynamic arrays and strings are stored as a 4 byte __data_loc
+	Dynamic bool
+}
+
+// DefaultConstants are the array lengths used in common trace headers
+var DefaultConstants = map[string]int{
+	"TASK_COMM_LEN": 16,
+	"BDEVNAME_SIZE": 32,
+	"IFNAMSIZ":      16,
+}
+
+// The sizes of scalar types on a 64-bit kernel, and whether they are signed
+var typeSizes = map[string]struct {
+	size   int
+	signed bool
+}{
+	"char": {1, true}, "unsigned char": {1, false}, "signed char": {1, true},
+	"bool": {1, false}, "u8": {1, false}, "s8": {1, true}, "__u8": {1, false}, "__s8": {1, true},
+	"short": {2, true}, "unsigned short": {2, false},
+	"u16": {2, false}, "s16": {2, true}, "__u16": {2, false}, "__s16": {2, true},
+	"int": {4, true}, "unsigned int": {4, false}, "unsigned": {4, false},
+	"u32": {4, false}, "s32": {4, true}, "__u32": {4, false}, "__s32": {4, true},
+	"pid_t": {4, true}, "gfp_t": {4, false}, "dev_t": {4, false}, "uid_t": {4, false},
+	"gid_t": {4, false}, "clockid_t": {4, true},
+	"long": {8, true}, "unsigned long": {8, false}, "long long": {8, true},
+	"unsigned long long": {8, false}, "u64": {8, false}, "s64": {8, true},
+	"__u64": {8, false}, "__s64": {8, true}, "size_t": {8, false}, "ssize_t": {8, true},
+	"loff_t": {8, true}, "sector_t": {8, false}, "ktime_t": {8, true}, "blkcnt_t": {8, false},
+}
+
+const pointerSize = 8
+
+// commonFields is the header of every event
+var commonFields = []FieldDefinition{
+	{Type: "unsigned short", Name: "common_type", Offset: 0, Size: 2},
+	{Type: "unsigned char", Name: "common_flags", Offset: 2, Size: 1},
+	{Type: "unsigned char", Name: "common_preempt_count", Offset: 3, Size: 1},
+	{Type: "int", Name: "common_pid", Offset: 4, Size: 4, Signed: true},
+}
+
+type eventClass struct {
+	entry  string
+	printk string
+}
+
+var (
+	commentRe     = regexp.MustCompile(`(?s)/\*.*?\*/|//[^\n]*`)
+	traceSystemRe = regexp.MustCompile(`#define\s+TRACE_SYSTEM\s+(\w+)`)
+	macroRe       = regexp.MustCompile(`\b(TRACE_EVENT|DECLARE_EVENT_CLASS|DEFINE_EVENT)\s*\(`)
+)
+
+// Parse finds the event definitions in a trace header.  Events that can't be
+// converted, for example because they use an unknown type, are skipped and
+// returned as errors.  constants is used to evaluate array lengths, and may
+// be nil to use DefaultConstants.
+func Parse(src string, constants map[string]int) ([]*EventDefinition, []error) {
+	if constants == nil {
+		constants = DefaultConstants
+	}
+
+	src = commentRe.ReplaceAllString(src, " ")
+	system := ""
+	if m := traceSystemRe.FindStringSubmatch(src); m != nil {
+		system = m[1]
+	}
+
+	var defs []*EventDefinition
+	var errs []error
+	classes := make(map[string]eventClass)
+
+	for _, loc := range macroRe.FindAllStringSubmatchIndex(src, -1) {
+		macro := src[loc[2]:loc[3]]
+		args, err := splitArgs(src[loc[1]:])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", macro, err.Error()))
+			continue
+		}
+
+		var name string
+		var class eventClass
+		switch macro {
+		case "TRACE_EVENT", "DECLARE_EVENT_CLASS":
+			// name, TP_PROTO, TP_ARGS, TP_STRUCT__entry, TP_fast_assign, TP_printk
+			if len(args) != 6 {
+				errs = append(errs, fmt.Errorf("%s: expected 6 arguments, got %d", macro, len(args)))
+				continue
+			}
+			name = args[0]
+			class = eventClass{entry: args[3], printk: args[5]}
+			if macro == "DECLARE_EVENT_CLASS" {
+				classes[name] = class
+				continue
+			}
+		case "DEFINE_EVENT":
+			// class, name, TP_PROTO, TP_ARGS
+			if len(args) != 4 {
+				errs = append(errs, fmt.Errorf("%s: expected 4 arguments, got %d", macro, len(args)))
+				continue
+			}
+			var ok bool
+			class, ok = classes[args[0]]
+			if !ok {
+				errs = append(errs, fmt.Errorf("%s: unknown event class %s", args[1], args[0]))
+				continue
+			}
+			name = args[1]
+		}
+
+		def, err := newEventDefinition(system, name, class, constants)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", name, err.Error()))
+			continue
+		}
+		defs = append(defs, def)
+	}
+
+	return defs, errs
+}
+
+func newEventDefinition(system, name string, class eventClass, constants map[string]int) (*EventDefinition, error) {
+	def := &EventDefinition{
+		System: system,
+		Name:   name,
+	}
+
+	entries, err := macroArgs(class.entry, "TP_STRUCT__entry")
+	if err != nil {
+		return nil, err
+	}
+	offset := commonFields[len(commonFields)-1].Offset + commonFields[len(commonFields)-1].Size
+	for _, entry := range splitEntries(strings.Join(entries, ",")) {
+		f, err := parseEntry(entry, constants)
+		if err != nil {
+			return nil, err
+		}
+		align := f.Size
+		if f.ArrayLen > 0 {
+			align = f.Size / f.ArrayLen
+		}
+		if align > pointerSize {
+			align = pointerSize
+		}
+		if align > 0 && offset%align != 0 {
+			offset += align - offset%align
+		}
+		f.Offset = offset
+		offset += f.Size
+		def.Fields = append(def.Fields, f)
+	}
+
+	printk, err := macroArgs(class.printk, "TP_printk")
+	if err != nil {
+		return nil, err
+	}
+	def.PrintFmt = strings.Replace(strings.Join(printk, ", "), "__entry->", "REC->", -1)
+
+	return def, nil
+}
+
+// parseEntry parses one of the __field style macros in TP_STRUCT__entry
+func parseEntry(entry string, constants map[string]int) (FieldDefinition, error) {
+	open := strings.IndexByte(entry, '(')
+	if open == -1 {
+		return FieldDefinition{}, fmt.Errorf("unexpected entry %q", entry)
+	}
+	macro := strings.TrimSpace(entry[:open])
+	args, err := splitArgs(entry[open+1:])
+	if err != nil {
+		return FieldDefinition{}, err
+	}
+
+	expect := func(n int) error {
+		if len(args) != n {
+			return fmt.Errorf("%s: expected %d arguments, got %d", macro, n, len(args))
+		}
+		return nil
+	}
+
+	switch macro {
+	case "__field", "__field_ext":
+		if len(args) < 2 {
+			return FieldDefinition{}, expect(2)
+		}
+		size, signed, err := typeSize(args[0])
+		if err != nil {
+			return FieldDefinition{}, err
+		}
+		return FieldDefinition{Type: args[0], Name: args[1], Size: size, Signed: signed}, nil
+	case "__array":
+		if err := expect(3); err != nil {
+			return FieldDefinition{}, err
+		}
+		size, signed, err := typeSize(args[0])
+		if err != nil {
+			return FieldDefinition{}, err
+		}
+		n, err := arrayLen(args[2], constants)
+		if err != nil {
+			return FieldDefinition{}, err
+		}
+		return FieldDefinition{Type: args[0], Name: args[1], Size: size * n, Signed: signed, ArrayLen: n}, nil
+	case "__string":
+		if err := expect(2); err != nil {
+			return FieldDefinition{}, err
+		}
+		return FieldDefinition{Type: "char", Name: args[0], Size: 4, Dynamic: true}, nil
+	case "__dynamic_array":
+		if err := expect(3); err != nil {
+			return FieldDefinition{}, err
+		}
+		if _, _, err := typeSize(args[0]); err != nil {
+			return FieldDefinition{}, err
+		}
+		return FieldDefinition{Type: args[0], Name: args[1], Size: 4, Dynamic: true}, nil
+	}
+	return FieldDefinition{}, fmt.Errorf("unsupported entry macro %s", macro)
+}
+
+func typeSize(t string) (int, bool, error) {
+	t = strings.Join(strings.Fields(t), " ")
+	if strings.HasSuffix(t, "*") {
+		return pointerSize, false, nil
+	}
+	t = strings.TrimPrefix(t, "const ")
+	if s, ok := typeSizes[t]; ok {
+		return s.size, s.signed, nil
+	}
+	return 0, false, fmt.Errorf("unknown type %s", t)
+}
+
+func arrayLen(s string, constants map[string]int) (int, error) {
+	if n, ok := constants[s]; ok {
+		return n, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("unknown array length %s", s)
+	}
+	return n, nil
+}
+
+// macroArgs returns the arguments of a macro call like TP_printk(...)
+func macroArgs(s, macro string) ([]string, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, macro) {
+		return nil, fmt.Errorf("expected %s, got %.20q", macro, s)
+	}
+	s = strings.TrimSpace(strings.TrimPrefix(s, macro))
+	if !strings.HasPrefix(s, "(") {
+		return nil, fmt.Errorf("expected '(' after %s", macro)
+	}
+	return splitArgs(s[1:])
+}
+
+// splitArgs splits the arguments of a macro call at top level commas, starting
+// just after the opening parenthesis, up to the matching closing parenthesis.
+// Arguments are trimmed of whitespace.
+func splitArgs(s string) ([]string, error) {
+	var args []string
+	depth := 0
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"', '\'':
+			end, err := skipLiteral(s, i)
+			if err != nil {
+				return nil, err
+			}
+			i = end
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			if depth == 0 {
+				arg := strings.TrimSpace(s[start:i])
+				if arg != "" || len(args) > 0 {
+					args = append(args, arg)
+				}
+				return args, nil
+			}
+			depth--
+		case ',':
+			if depth == 0 {
+				args = append(args, strings.TrimSpace(s[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return nil, fmt.Errorf("missing ')'")
+}
+
+// skipLiteral returns the index of the closing quote of the string or
+// character literal starting at s[i]
+func skipLiteral(s string, i int) (int, error) {
+	quote := s[i]
+	for j := i + 1; j < len(s); j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case quote:
+			return j, nil
+		}
+	}
+	return 0, fmt.Errorf("unterminated literal")
+}
+
+// splitEntries splits the contents of TP_STRUCT__entry, a sequence of macro
+// calls that aren't separated by commas
+func splitEntries(s string) []string {
+	var entries []string
+	depth := 0
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				entries = append(entries, strings.TrimSpace(s[start:i+1]))
+				start = i + 1
+			}
+		}
+	}
+	return entries
+}
+
+// Format returns the event's format file, as found in
+// events/<system>/<name>/format
+func (def *EventDefinition) Format(id int) string {
+	out := fmt.Sprintf("name: %s\nID: %d\nformat:\n", def.Name, id)
+	for _, f := range commonFields {
+		out += f.formatLine()
+	}
+	out += "\n"
+	for _, f := range def.Fields {
+		out += f.formatLine()
+	}
+	out += "\nprint fmt: " + def.PrintFmt + "\n"
+	return out
+}
+
+func (f FieldDefinition) formatLine() string {
+	decl := f.Type + " " + f.Name
+	switch {
+	case f.Dynamic:
+		decl = "__data_loc " + f.Type + "[] " + f.Name
+	case f.ArrayLen > 0:
+		decl = fmt.Sprintf("%s %s[%d]", f.Type, f.Name, f.ArrayLen)
+	}
+	signed := 0
+	if f.Signed {
+		signed = 1
+	}
+	return fmt.Sprintf("\tfield:%s;\toffset:%d;\tsize:%d;\tsigned:%d;\n", decl, f.Offset, f.Size, signed)
+}
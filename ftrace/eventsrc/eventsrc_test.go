@@ -0,0 +1,163 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventsrc
+
+import (
+	"testing"
+
+	"github.com/google/traceout/ftrace"
+)
+
+const schedHeader = `#undef TRACE_SYSTEM
+#define TRACE_SYSTEM sched
+
+#include <linux/sched.h>
+#include <linux/tracepoint.h>
+
+/*
+ * Tracepoint for calling kthread_stop, performed to end a kthread:
+ */
+TRACE_EVENT(sched_kthread_stop,
+
+	TP_PROTO(struct task_struct *t),
+
+	TP_ARGS(t),
+
+	TP_STRUCT__entry(
+		__array(	char,	comm,	TASK_COMM_LEN	)
+		__field(	pid_t,	pid			)
+	),
+
+	TP_fast_assign(
+		memcpy(__entry->comm, t->comm, TASK_COMM_LEN);
+		__entry->pid	= t->pid;
+	),
+
+	TP_printk("comm=%s pid=%d", __entry->comm, __entry->pid)
+);
+
+DECLARE_EVENT_CLASS(sched_wakeup_template,
+
+	TP_PROTO(struct task_struct *p, int success),
+
+	TP_ARGS(__perf_task(p), success),
+
+	TP_STRUCT__entry(
+		__array(	char,	comm,	TASK_COMM_LEN	)
+		__field(	pid_t,	pid			)
+		__field(	int,	prio			)
+		__field(	int,	success			)
+		__field(	int,	target_cpu		)
+	),
+
+	TP_fast_assign(
+		memcpy(__entry->comm, p->comm, TASK_COMM_LEN);
+		__entry->pid		= p->pid;
+		__entry->prio		= p->prio;
+		__entry->success	= success;
+		__entry->target_cpu	= task_cpu(p);
+	),
+
+	TP_printk("comm=%s pid=%d prio=%d success=%d target_cpu=%03d",
+		  __entry->comm, __entry->pid, __entry->prio,
+		  __entry->success, __entry->target_cpu)
+);
+
+DEFINE_EVENT(sched_wakeup_template, sched_wakeup,
+	     TP_PROTO(struct task_struct *p, int success),
+	     TP_ARGS(p, success));
+
+TRACE_EVENT(sched_process_exec,
+
+	TP_PROTO(struct task_struct *p, pid_t old_pid,
+		 struct linux_binprm *bprm),
+
+	TP_ARGS(p, old_pid, bprm),
+
+	TP_STRUCT__entry(
+		__string(	filename,	bprm->filename	)
+		__field(	pid_t,		pid		)
+		__field(	u64,		start		)
+	),
+
+	TP_fast_assign(
+		__assign_str(filename, bprm->filename);
+		__entry->pid		= p->pid;
+	),
+
+	TP_printk("filename=%s pid=%d start=%llu", __get_str(filename),
+		  __entry->pid, __entry->start)
+);
+
+TRACE_EVENT(sched_unknown,
+	TP_PROTO(struct foo *f),
+	TP_ARGS(f),
+	TP_STRUCT__entry(
+		__field(	struct foo,	foo	)
+	),
+	TP_fast_assign(
+		__entry->foo = *f;
+	),
+	TP_printk("foo")
+);
+`
+
+func TestParse(t *testing.T) {
+	defs, errs := Parse(schedHeader, nil)
+	if len(errs) != 1 {
+		t.Errorf("expected 1 error for sched_unknown, got %v", errs)
+	}
+
+	tests := []struct {
+		name   string
+		fields map[string]interface{}
+		want   string
+	}{
+		{"sched_kthread_stop", map[string]interface{}{"comm": "kthreadd", "pid": 2},
+			"comm=kthreadd pid=2"},
+		{"sched_wakeup", map[string]interface{}{"comm": "bash", "pid": 1234, "prio": 120, "success": 1, "target_cpu": 2},
+			"comm=bash pid=1234 prio=120 success=1 target_cpu=002"},
+		{"sched_process_exec", map[string]interface{}{"filename": "/bin/ls", "pid": 1234, "start": 5},
+			"filename=/bin/ls pid=1234 start=5"},
+	}
+
+	if len(defs) != len(tests) {
+		t.Fatalf("expected %d events, got %d", len(tests), len(defs))
+	}
+	for i, test := range tests {
+		def := defs[i]
+		if def.System != "sched" || def.Name != test.name {
+			t.Errorf("want sched/%s, got %s/%s", test.name, def.System, def.Name)
+			continue
+		}
+		etype, err := ftrace.ParseEventFormat([]byte(def.Format(100 + i)))
+		if err != nil {
+			t.Errorf("%s: %s\n%s", def.Name, err.Error(), def.Format(100+i))
+			continue
+		}
+		got, err := etype.FormatFields(test.fields)
+		if err != nil {
+			t.Errorf("%s: %s", def.Name, err.Error())
+		} else if got != test.want {
+			t.Errorf("%s: want %q, got %q", def.Name, test.want, got)
+		}
+	}
+
+	// u64 start is aligned to 8 bytes after the 4 byte data_loc and pid
+	exec := defs[2]
+	if f := exec.Fields[2]; f.Name != "start" || f.Offset != 16 || f.Size != 8 {
+		t.Errorf("want start at offset 16 size 8, got %+v", f)
+	}
+}
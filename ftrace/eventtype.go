@@ -15,12 +15,14 @@
 package ftrace
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/google/traceout/ftrace/cparse"
 	"github.com/google/traceout/ftrace/cprintf"
@@ -32,26 +34,46 @@ type EventType struct {
 	id           int
 	fields       []eventField
 	size         int
+	printFmt     string
 	formatter    cparse.Expression
+	formatFunc   func(Event) string
 	pidField     int
 	flagsField   int
 	preemptField int
 	fileProvider FileProvider
+	defs         *KernelDefs
+	enabled      bool
+
+	// Common fields of RT kernels, -1 on others
+	migrateDisableField int
+	preemptLazyField    int
+
+	// lazyFormatter is set for types loaded from the format cache, whose
+	// print fmt is compiled on first use.  A pointer, as the cparse.Scope
+	// methods take EventType by value.
+	lazyFormatter *sync.Once
 }
 
 type eventField struct {
-	name    string
-	size    int
-	offset  int
-	signed  bool
-	array   bool
-	ftype   string
-	dataloc bool
+	name   string
+	size   int
+	offset int
+	signed bool
+	array  bool
+	// arrayLen is the number of elements of a fixed size array, or 0 if
+	// the format file gives it as an unexpanded macro
+	arrayLen int
+	ftype    string
+	dataloc  bool
+	// relloc is set for __rel_loc fields, a dataloc whose offset is from
+	// the end of the field rather than the start of the event
+	relloc bool
 }
 
 type eventFieldValue struct {
 	field    *eventField
 	contents []byte
+	order    binary.ByteOrder
 }
 
 var BadEvent error = errors.New("Bad event name")
@@ -74,7 +96,7 @@ func NewHeaderType(fp FileProvider, path string) (*EventType, error) {
 	return &etype, nil
 }
 
-func newEventType(fp FileProvider, path string) (*EventType, error) {
+func newEventType(fp FileProvider, path string, defs *KernelDefs, cache *formatCache) (*EventType, error) {
 	if !SafeFtracePath(path) {
 		return nil, BadEvent
 	}
@@ -83,15 +105,18 @@ func newEventType(fp FileProvider, path string) (*EventType, error) {
 		fileProvider: fp,
 		path:         path,
 		name:         filepath.Base(path),
+		defs:         defs,
 	}
-	err := etype.parseFormatFile()
+	formatbytes, err := etype.readEventFile("format")
 	if err != nil {
 		return nil, err
 	}
-
-	etype.pidField = etype.getFieldNum("common_pid")
-	etype.flagsField = etype.getFieldNum("common_flags")
-	etype.preemptField = etype.getFieldNum("common_preempt_count")
+	if !cache.load(&etype, path, formatbytes) {
+		if err := etype.parseFormatData(formatbytes); err != nil {
+			return nil, err
+		}
+		cache.store(&etype, path, formatbytes)
+	}
 
 	etype.finishNewType()
 
@@ -102,6 +127,56 @@ func (etype *EventType) Name() string {
 	return etype.name
 }
 
+// ID returns the event's ID from its format file, which identifies it in
+// the ring buffer
+func (etype *EventType) ID() int {
+	return etype.id
+}
+
+// FieldInfo describes a field of an event as listed in its format file
+type FieldInfo struct {
+	Name string
+	// C type of the field, or of an element for arrays and __data_loc fields
+	Type   string
+	Offset int
+	Size   int
+	Signed bool
+	// Fixed size array, Size is the size of the whole array
+	Array bool
+	// Dynamic array stored elsewhere in the event, the field holds its
+	// offset and length
+	DataLoc bool
+	// The DataLoc's offset is from the end of the field, as for user_events
+	RelLoc bool
+}
+
+// Fields returns the fields of the event, including the common fields, in
+// the order of the format file
+func (etype *EventType) Fields() []FieldInfo {
+	fields := make([]FieldInfo, len(etype.fields))
+	for i, f := range etype.fields {
+		fields[i] = FieldInfo{
+			Name:    f.name,
+			Type:    f.ftype,
+			Offset:  f.offset,
+			Size:    f.size,
+			Signed:  f.signed,
+			Array:   f.array,
+			DataLoc: f.dataloc,
+			RelLoc:  f.relloc,
+		}
+		if f.dataloc && f.ftype == "" {
+			fields[i].Type = "char"
+		}
+	}
+	return fields
+}
+
+// PrintFmt returns the unparsed "print fmt" line of the event's format file
+func (etype *EventType) PrintFmt() string {
+	return etype.printFmt
+}
+
 func (etype *EventType) finishNewType() {
 	for _, f := range etype.fields {
 		if etype.size < f.offset+f.size {
@@ -109,6 +184,13 @@ func (etype *EventType) finishNewType() {
 		}
 	}
 
+	// Not every event source has all the common fields, those missing
+	// are left -1
+	etype.pidField = etype.getFieldNum("common_pid")
+	etype.flagsField = etype.getFieldNum("common_flags")
+	etype.preemptField = etype.getFieldNum("common_preempt_count")
+	etype.migrateDisableField = etype.getFieldNum("common_migrate_disable")
+	etype.preemptLazyField = etype.getFieldNum("common_preempt_lazy_count")
 }
 
 func (etype *EventType) DecodeEvent(data []byte, cpu int, when uint64) (*Event, error) {
@@ -116,29 +198,56 @@ func (etype *EventType) DecodeEvent(data []byte, cpu int, when uint64) (*Event,
 	e.Cpu = cpu
 	e.When = when
 	if len(data) < etype.size {
-		return nil, BadEventData
+		if !etype.isKernelStack() {
+			return nil, BadEventData
+		}
+		// Stacks are recorded with only as many callers as they have
+		data = append(data[:len(data):len(data)], make([]byte, etype.size-len(data))...)
 	}
 	e.values = make([]eventFieldValue, len(etype.fields))
+	order := etype.byteOrder()
 	for i, f := range etype.fields {
 		e.values[i].field = &etype.fields[i]
 		e.values[i].contents = data[f.offset : f.offset+f.size]
+		e.values[i].order = order
 	}
 	e.etype = etype
 	e.contents = data
 
-	e.Pid = int(e.values[e.etype.pidField].DecodeInt())
-	e.Flags = uint(e.values[e.etype.flagsField].DecodeUint())
-	e.Preempt = int(e.values[e.etype.preemptField].DecodeInt())
+	// Missing common fields are left zero
+	if etype.pidField >= 0 {
+		e.Pid = int(e.values[etype.pidField].DecodeInt())
+	}
+	if etype.flagsField >= 0 {
+		e.Flags = uint(e.values[etype.flagsField].DecodeUint())
+	}
+	if etype.preemptField >= 0 {
+		e.Preempt = int(e.values[etype.preemptField].DecodeInt())
+	}
+	if etype.migrateDisableField >= 0 {
+		e.MigrateDisable = int(e.values[etype.migrateDisableField].DecodeUint())
+	}
+	if etype.preemptLazyField >= 0 {
+		e.PreemptLazy = int(e.values[etype.preemptLazyField].DecodeUint())
+	}
 
 	return &e, nil
 }
 
 func (etype *EventType) Enable() error {
-	return etype.writeEventFile("enable", []byte("1"))
+	err := etype.writeEventFile("enable", []byte("1"))
+	if err == nil {
+		etype.enabled = true
+	}
+	return err
 }
 
 func (etype *EventType) Disable() error {
-	return etype.writeEventFile("enable", []byte("0"))
+	err := etype.writeEventFile("enable", []byte("0"))
+	if err == nil {
+		etype.enabled = false
+	}
+	return err
 }
 
 func (etype *EventType) readEventFile(filename string) ([]byte, error) {
@@ -178,7 +287,11 @@ func (etype *EventType) parseFormatData(formatbytes []byte) (err error) {
 		value := strings.TrimSpace(line[colon+1:])
 
 		switch key {
-		case "name", "format":
+		case "name":
+			if etype.name == "" {
+				etype.name = value
+			}
+		case "format":
 			// ignored
 			continue
 		case "ID":
@@ -192,6 +305,11 @@ func (etype *EventType) parseFormatData(formatbytes []byte) (err error) {
 				return
 			}
 		case "print fmt":
+			if formatFunc := etype.goFormatter(); formatFunc != nil {
+				etype.printFmt = value
+				etype.formatFunc = formatFunc
+				continue
+			}
 			err = etype.parsePrintFmt(value)
 			if err != nil {
 				return
@@ -205,20 +323,10 @@ func (etype *EventType) parseFormatData(formatbytes []byte) (err error) {
 }
 
 // Reads the format file from sysfs and parses the necessary information out of it (id and fields for now)
-func (etype *EventType) parseFormatFile() (err error) {
-	formatbytes, err := etype.readEventFile("format")
-	if err != nil {
-		return
-	}
-
-	err = etype.parseFormatData(formatbytes)
-
-	return
-}
-
 // Takes a line containing everything following "field:" and adds it to the field list of the event
 func (etype *EventType) parseField(line string) (err error) {
 	var field eventField
+	signedGiven := false
 
 	s := strings.Split(line, ";")
 	if s == nil || len(s) == 0 {
@@ -243,12 +351,24 @@ func (etype *EventType) parseField(line string) (err error) {
 			return
 		}
 		field.array = true
+		field.arrayLen, _ = strconv.Atoi(field.name[bracket+1 : endBracket])
 		field.name = field.name[:bracket]
 	}
 
-	if strings.HasPrefix(field.ftype, "__data_loc char[]") {
-		field.ftype = strings.TrimPrefix(field.ftype, "__data_loc char[]")
+	// Dynamic arrays are "__data_loc u8[] data", or "__rel_loc char msg[]"
+	// as the format file moves the brackets of __rel_loc types to the name.
+	// The type of strings is left empty.
+	for _, loc := range []string{"__data_loc ", "__rel_loc "} {
+		if !strings.HasPrefix(field.ftype, loc) {
+			continue
+		}
+		field.ftype = strings.TrimSuffix(strings.TrimPrefix(field.ftype, loc), "[]")
+		if field.ftype == "char" {
+			field.ftype = ""
+		}
+		field.array = false
 		field.dataloc = true
+		field.relloc = loc == "__rel_loc "
 	}
 
 	for _, f := range s[1:] {
@@ -271,6 +391,7 @@ func (etype *EventType) parseField(line string) (err error) {
 			field.size, err = strconv.Atoi(value)
 		case "signed":
 			field.signed, err = strconv.ParseBool(value)
+			signedGiven = true
 		default:
 			err = fmt.Errorf("unknown field entry %s", key)
 		}
@@ -289,50 +410,87 @@ func (etype *EventType) parseField(line string) (err error) {
 		return
 	}
 
+	// Old kernels' format files don't say if fields are signed
+	if !signedGiven && !field.dataloc {
+		_, field.signed, _ = etype.cType(field.ftype)
+	}
+
 	etype.fields = append(etype.fields, field)
 
 	return
 }
 
+// goFormatter returns the function that formats events whose print fmt is
+// replaced with Go code, to add to it or because cparse can't parse it
+func (etype *EventType) goFormatter() func(Event) string {
+	switch {
+	case etype.isSyscallEvent():
+		return formatSyscall
+	case etype.isKernelStack():
+		return formatKernelStack
+	case etype.isUserStack():
+		return formatUserStack
+	case etype.isFunction():
+		return formatFunction
+	case etype.isPrint():
+		return formatPrint
+	case etype.isBprint():
+		return formatBprint
+	case etype.isBputs():
+		return formatBputs
+	}
+	return nil
+}
+
 func (etype *EventType) parsePrintFmt(format string) (err error) {
+	etype.printFmt = format
 	args, err := cparse.Parse(format, etype)
 	if err != nil {
-		return err
+		return fmt.Errorf("%s: print fmt: %w", etype.name, err)
 	}
-	etype.formatter, err = cprintf.NewPrintfFunction(args, mungePrintfConversions)
+	etype.formatter, err = cprintf.NewPrintfFunctionWithLongSize(args, mungePrintfConversions, etype.LongSize())
 	if err != nil {
-		return err
+		return fmt.Errorf("%s: print fmt: %w", etype.name, err)
 	}
 	return
 }
 
+// compileFormatter parses the print fmt of a type loaded from a
+// format cache, the first time it is needed
+func (etype *EventType) compileFormatter() {
+	if etype.lazyFormatter == nil {
+		return
+	}
+	etype.lazyFormatter.Do(func() {
+		etype.parsePrintFmt(etype.printFmt)
+	})
+}
+
+// mungePrintfConversions implements the %p extensions that format kernel
+// addresses: %pS and %ps as a symbol with and without its offset, %pB as
+// the return address in a backtrace, %pK as a plain address, and %pF and
+// %pf, the older names of %pS and %ps.  As in the kernel, the letters and
+// digits after the extension are flags, which don't change the output.
 func mungePrintfConversions(c cprintf.Conversion) cprintf.Conversion {
-	if c.Conversion == 'p' && len(c.Suffix) > 0 &&
-		(c.Suffix[0] == 'f' || c.Suffix[0] == 'F' || c.Suffix[0] == 'K') {
-
-		pointerModifier := c.Suffix[0]
-		c.Suffix = c.Suffix[1:]
-		name := ""
-		switch pointerModifier {
-		case 'f':
-			name = "__printk_pf"
-		case 'F':
-			name = "__printk_pF"
-		case 'K':
-			name = "__printk_pK"
-		default:
-			panic("unexpected pointer modifier " + string(pointerModifier))
-		}
-		function := kernelFunctions[name]
-		if function == nil {
-			c.Suffix = "FAILED POINTER MODIFIER " + string(pointerModifier) + " " + c.Suffix
-			return c
-		}
+	if c.Conversion != 'p' || c.Suffix == "" || strings.IndexByte("fFsSBK", c.Suffix[0]) == -1 {
+		return c
+	}
 
-		c.Arg = cparse.CallFunction(eventFunction{function}, name, []cparse.Expression{c.Arg})
-		c.Conversion = 's'
-		c.Modifiers = ""
+	name := "__printk_p" + c.Suffix[:1]
+	end := 1
+	for end < len(c.Suffix) && isAlnum(c.Suffix[end]) {
+		end++
+	}
+	c.Suffix = c.Suffix[end:]
+	function := kernelFunctions[name]
+	if function == nil {
+		c.Suffix = "FAILED POINTER MODIFIER " + name + " " + c.Suffix
+		return c
 	}
+
+	c.Arg = cparse.CallFunction(eventFunction{function}, name, []cparse.Expression{c.Arg})
+	c.Conversion = 's'
+	c.Modifiers = ""
 	return c
 }
 
@@ -346,6 +504,13 @@ func (etype *EventType) getFieldNum(field string) int {
 }
 
 func (etype *EventType) Format(e Event) string {
+	if etype == unknownRecordType {
+		return fmt.Sprintf("type_len=%d data=%x", e.byteOrder().Uint32(e.contents)&entryTypeLenMask, e.contents)
+	}
+	if etype.formatFunc != nil {
+		return etype.formatFunc(e)
+	}
+	etype.compileFormatter()
 	if etype.formatter == nil {
 		return "event type " + etype.path + " has no formatter"
 	}
@@ -361,11 +526,11 @@ func (v eventFieldValue) DecodeUint() uint64 {
 	case 1:
 		return uint64(v.contents[0])
 	case 2:
-		return uint64(order.Uint16(v.contents))
+		return uint64(v.order.Uint16(v.contents))
 	case 4:
-		return uint64(order.Uint32(v.contents))
+		return uint64(v.order.Uint32(v.contents))
 	case 8:
-		return order.Uint64(v.contents)
+		return v.order.Uint64(v.contents)
 	default:
 		return 0
 	}
@@ -376,11 +541,11 @@ func (v eventFieldValue) DecodeInt() int64 {
 	case 1:
 		return int64(int8(v.contents[0]))
 	case 2:
-		return int64(int16(order.Uint16(v.contents)))
+		return int64(int16(v.order.Uint16(v.contents)))
 	case 4:
-		return int64(int32(order.Uint32(v.contents)))
+		return int64(int32(v.order.Uint32(v.contents)))
 	case 8:
-		return int64(order.Uint64(v.contents))
+		return int64(v.order.Uint64(v.contents))
 	default:
 		return 0
 	}
@@ -396,18 +561,26 @@ func (v eventFieldValue) String() string {
 
 type eventVariable struct {
 	fieldNum int
+	size     int
 }
 
 func (ev eventVariable) Get(ctx cparse.EvalContext) cparse.Value {
 	e := ctx.(Event)
-	switch e.etype.fields[ev.fieldNum].ftype {
-	case "char":
+	f := e.etype.fields[ev.fieldNum]
+	switch {
+	case f.array && (f.ftype == "char" || f.ftype == "const char"):
 		s := string(e.values[ev.fieldNum].contents)
 		zero := strings.IndexByte(s, 0)
 		if zero != -1 {
 			s = s[:zero]
 		}
 		return cparse.NewValueString(s)
+	case f.array || f.isComposite():
+		// Other arrays and structs are passed to helpers like
+		// __print_array and __print_hex as a string of their raw bytes
+		return cparse.NewValueString(string(e.values[ev.fieldNum].contents))
+	case f.ftype == "bool" || f.ftype == "_Bool":
+		return cparse.NewValueBool(e.values[ev.fieldNum].DecodeUint() != 0)
 	default:
 		var i uint64
 		if e.etype.fields[ev.fieldNum].signed {
@@ -415,22 +588,97 @@ func (ev eventVariable) Get(ctx cparse.EvalContext) cparse.Value {
 		} else {
 			i = e.values[ev.fieldNum].DecodeUint()
 		}
+		if f := e.etype.fields[ev.fieldNum]; f.relloc {
+			// Make it a __data_loc, for __get_rel_str
+			i = uint64(e.absoluteDataLoc(f, uint32(i)))
+		}
 		return cparse.NewValueInt(i, e.etype.fields[ev.fieldNum].size, e.etype.fields[ev.fieldNum].signed)
 	}
 }
 
+// Size implements cparse.Sizer, as a char array field's value is its string
+// up to the first NUL rather than the whole array
+func (ev eventVariable) Size() int {
+	return ev.size
+}
+
+// Index implements cparse.Indexer for subscripts of fixed size array fields
+func (ev eventVariable) Index(ctx cparse.EvalContext, i int64) cparse.Value {
+	e := ctx.(Event)
+	f := e.etype.fields[ev.fieldNum]
+	if !f.array {
+		return cparse.NewValueError("subscript of non-array field %s", f.name)
+	}
+	size, signed, err := e.etype.elementType(f)
+	if err != nil {
+		return cparse.NewValueError(err.Error())
+	}
+	if i < 0 || i >= int64(f.size/size) {
+		return cparse.NewValueError("subscript %d of field %s out of range", i, f.name)
+	}
+	v := e.values[ev.fieldNum]
+	element := eventFieldValue{
+		field:    &eventField{size: size, signed: signed},
+		contents: v.contents[int(i)*size : int(i+1)*size],
+		order:    v.order,
+	}
+	if signed {
+		return cparse.NewValueInt(uint64(element.DecodeInt()), size, true)
+	}
+	return cparse.NewValueInt(element.DecodeUint(), size, false)
+}
+
+// elementType returns the size and signedness of an element of an array or
+// __data_loc field.  Fixed size arrays whose length is a macro and dynamic
+// arrays take them from the C type of the field.
+func (etype *EventType) elementType(f eventField) (int, bool, error) {
+	if f.array && f.arrayLen > 0 && f.size%f.arrayLen == 0 {
+		return f.size / f.arrayLen, f.signed, nil
+	}
+
+	ftype := f.ftype
+	if ftype == "" {
+		ftype = "char"
+	}
+	size, signed, ok := etype.cType(ftype)
+	if !ok {
+		return 0, false, fmt.Errorf("unknown element type %s of field %s", ftype, f.name)
+	}
+	return size, signed, nil
+}
+
+// cType returns the size and signedness of an integer C type, including the
+// kernel's typedefs, or false if it isn't one
+func (etype *EventType) cType(ctype string) (int, bool, bool) {
+	size, serr := cparse.Parse("sizeof("+ctype+")", etype)
+	signed, err := cparse.Parse("("+ctype+")-1 < 0", etype)
+	if serr != nil || err != nil || !size[0].IsConstant() || size[0].Value(nil).AsInt() <= 0 {
+		return 0, false, false
+	}
+	return int(size[0].Value(nil).AsInt()), signed[0].Value(nil).AsBool(), true
+}
+
+// isComposite returns true for struct and union fields, and others that
+// aren't integers
+func (f eventField) isComposite() bool {
+	if f.array || f.dataloc {
+		return false
+	}
+	switch f.size {
+	case 1, 2, 4, 8:
+		return strings.HasPrefix(f.ftype, "struct ") || strings.HasPrefix(f.ftype, "union ")
+	}
+	return true
+}
+
 func (etype EventType) GetVariable(name string) cparse.Variable {
 	recName := strings.TrimPrefix(name, "REC->")
 	f := etype.getFieldNum(recName)
 	if f >= 0 {
-		return eventVariable{f}
+		return eventVariable{f, etype.fields[f].size}
 	}
 
-	if v, ok := kernelConstants[name]; ok {
-		return cparse.NewConstantVariable(cparse.NewValueInt(uint64(int64(v)), 4, true))
-	}
-
-	return nil
+	return etype.kernelDefs().GetVariable(name)
 }
 
 type eventFunction struct {
@@ -449,5 +697,9 @@ func (etype EventType) GetFunction(name string) cparse.Function {
 }
 
 func (etype EventType) GetType(name string) string {
-	return kernelTypes[name]
+	return etype.kernelDefs().GetType(name)
+}
+
+func (etype EventType) LongSize() int {
+	return etype.kernelDefs().LongSize()
 }
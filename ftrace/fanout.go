@@ -0,0 +1,170 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Fanout passes the events of a capture to several independent
+// subscribers, for example a text writer, an exporter and an analysis
+// running together.  Each subscriber has its own buffer, so a slow one
+// drops events rather than holding up the others.  Subscribers share the
+// Events, which must not be modified.
+//
+//	fanout := ftrace.NewFanout()
+//	sub := fanout.Subscribe(10000, ftrace.DropOldest)
+//	go func() {
+//		for events := range sub.Events() {
+//			...
+//		}
+//	}()
+//	f.Capture(fanout.Publish)
+//	fanout.Close()
+type Fanout struct {
+	sync.Mutex
+	subscribers []*Subscriber
+	closed      bool
+}
+
+// Subscriber receives the events published to a Fanout
+type Subscriber struct {
+	// First, to be 64-bit aligned for atomics on 32-bit cpus
+	pending int64
+	dropped int64
+
+	fanout   *Fanout
+	in       chan Events
+	out      chan Events
+	quit     chan struct{}
+	quitOnce sync.Once
+	max      int
+	policy   DropPolicy
+}
+
+func NewFanout() *Fanout {
+	return &Fanout{}
+}
+
+// Subscribe adds a subscriber that buffers up to max events, dropping
+// pages of events by policy beyond that
+func (fo *Fanout) Subscribe(max int, policy DropPolicy) *Subscriber {
+	s := &Subscriber{
+		fanout: fo,
+		in:     make(chan Events),
+		out:    make(chan Events),
+		quit:   make(chan struct{}),
+		max:    max,
+		policy: policy,
+	}
+	go s.relay()
+
+	fo.Lock()
+	defer fo.Unlock()
+	if fo.closed {
+		close(s.in)
+	} else {
+		fo.subscribers = append(fo.subscribers, s)
+	}
+	return s
+}
+
+// Publish passes events to every subscriber.  It has the signature of a
+// Capture callback, and doesn't wait for slow subscribers.
+func (fo *Fanout) Publish(events Events) {
+	fo.Lock()
+	defer fo.Unlock()
+	for _, s := range fo.subscribers {
+		s.in <- events
+	}
+}
+
+// Close ends the subscriptions, closing the Events channel of each
+// subscriber once it has received the events buffered for it
+func (fo *Fanout) Close() {
+	fo.Lock()
+	defer fo.Unlock()
+	for _, s := range fo.subscribers {
+		close(s.in)
+	}
+	fo.subscribers = nil
+	fo.closed = true
+}
+
+// Events returns the channel the subscriber's events are delivered on,
+// which is closed when the Fanout is closed or the subscriber unsubscribes
+func (s *Subscriber) Events() <-chan Events {
+	return s.out
+}
+
+// Pending returns the number of events buffered for the subscriber, with
+// a page of filtered events counting as one
+func (s *Subscriber) Pending() int64 {
+	return atomic.LoadInt64(&s.pending)
+}
+
+// Dropped returns the number of events dropped because the subscriber
+// fell behind
+func (s *Subscriber) Dropped() int64 {
+	return atomic.LoadInt64(&s.dropped)
+}
+
+// Unsubscribe stops delivering events to the subscriber, discarding any
+// that are buffered
+func (s *Subscriber) Unsubscribe() {
+	fo := s.fanout
+	fo.Lock()
+	for i, sub := range fo.subscribers {
+		if sub == s {
+			fo.subscribers = append(fo.subscribers[:i], fo.subscribers[i+1:]...)
+			break
+		}
+	}
+	fo.Unlock()
+	s.quitOnce.Do(func() { close(s.quit) })
+}
+
+// relay buffers the events published to the subscriber until it receives
+// them, as bufferEvents does for a capture
+func (s *Subscriber) relay() {
+	defer close(s.out)
+
+	in := s.in
+	var ring eventRing
+	for in != nil || len(ring.batches) > 0 {
+		var send chan<- Events
+		var next Events
+		if len(ring.batches) > 0 {
+			send, next = s.out, ring.batches[0]
+		}
+
+		select {
+		case events, ok := <-in:
+			if !ok {
+				in = nil
+				continue
+			}
+			for _, d := range ring.push(events, s.max, s.policy) {
+				atomic.AddInt64(&s.dropped, int64(len(d)))
+			}
+		case send <- next:
+			ring.pop()
+		case <-s.quit:
+			return
+		}
+		atomic.StoreInt64(&s.pending, int64(ring.size))
+	}
+}
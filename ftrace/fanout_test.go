@@ -0,0 +1,112 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestFanout(t *testing.T) {
+	fanout := NewFanout()
+	fast := fanout.Subscribe(100, DropOldest)
+	slow := fanout.Subscribe(4, DropNewest)
+	gone := fanout.Subscribe(100, DropOldest)
+
+	fastIds := make(chan []int)
+	go func() {
+		var ids []int
+		for events := range fast.Events() {
+			ids = append(ids, events[0].Pid)
+		}
+		fastIds <- ids
+	}()
+
+	gone.Unsubscribe()
+	if _, ok := <-gone.Events(); ok {
+		t.Error("want no events after Unsubscribe")
+	}
+
+	// slow isn't read until everything is published
+	for id := 1; id <= 4; id++ {
+		fanout.Publish(testBatch(id, 2))
+	}
+	fanout.Close()
+
+	if ids := <-fastIds; len(ids) != 4 {
+		t.Errorf("want 4 batches for the fast subscriber got %v", ids)
+	}
+
+	var ids []int
+	for events := range slow.Events() {
+		ids = append(ids, events[0].Pid)
+	}
+	if len(ids) != 2 || ids[0] != 1 || ids[1] != 2 {
+		t.Errorf("want batches 1 and 2 for the slow subscriber got %v", ids)
+	}
+	if slow.Dropped() != 4 || slow.Pending() != 0 {
+		t.Errorf("want 4 dropped and none pending got %d and %d", slow.Dropped(), slow.Pending())
+	}
+	if fast.Dropped() != 0 {
+		t.Errorf("want none dropped for the fast subscriber got %d", fast.Dropped())
+	}
+
+	late := fanout.Subscribe(100, DropOldest)
+	if _, ok := <-late.Events(); ok {
+		t.Error("want no events after Close")
+	}
+}
+
+func TestFanoutConcurrentFormatting(t *testing.T) {
+	f := newTestFtrace(t, testFiles)
+	if _, err := f.NewEventType("sched/sched_wakeup"); err != nil {
+		t.Fatal(err)
+	}
+	events, err := f.decodePage(0, wakeupPage(1, 2, 1234))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Subscribers format the same events at once, looking up names
+	fanout := NewFanout()
+	var subscribers []*Subscriber
+	for i := 0; i < 4; i++ {
+		subscribers = append(subscribers, fanout.Subscribe(100, DropOldest))
+	}
+	done := make(chan bool)
+	for _, s := range subscribers {
+		go func(s *Subscriber) {
+			for events := range s.Events() {
+				for _, e := range events {
+					if e.String() == "" {
+						t.Error("want the event formatted")
+					}
+					if _, err := json.Marshal(e); err != nil {
+						t.Error(err)
+					}
+				}
+			}
+			done <- true
+		}(s)
+	}
+
+	for i := 0; i < 10; i++ {
+		fanout.Publish(events)
+	}
+	fanout.Close()
+	for range subscribers {
+		<-done
+	}
+}
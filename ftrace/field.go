@@ -0,0 +1,216 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/traceout/ftrace/cparse"
+)
+
+var NoSuchField error = errors.New("No such field")
+
+// Field returns the value of a field of the event.  char arrays and
+// __data_loc strings are returned as strings, other arrays and structs as
+// strings of their raw bytes, bools as 0 or 1, and everything else as
+// integers of the field's size and signedness.
+func (e Event) Field(name string) (cparse.Value, error) {
+	i, err := e.fieldNum(name)
+	if err != nil {
+		return cparse.Value{}, err
+	}
+
+	if f := e.etype.fields[i]; f.dataloc {
+		b, err := e.dataLoc(e.absoluteDataLoc(f, uint32(e.values[i].DecodeUint())))
+		if err != nil {
+			return cparse.Value{}, err
+		}
+		if f.ftype != "" {
+			return cparse.NewValueString(string(b)), nil
+		}
+		return cparse.NewValueString(cString(b)), nil
+	}
+	return eventVariable{fieldNum: i}.Get(e), nil
+}
+
+// Int returns the value of an integer field, sign extended if it is signed
+func (e Event) Int(name string) (int64, error) {
+	v, err := e.Field(name)
+	if err != nil {
+		return 0, err
+	}
+	if !v.IsInt() {
+		return 0, fmt.Errorf("field %s is not an integer", name)
+	}
+	return v.AsInt(), nil
+}
+
+// Uint returns the value of an integer field, truncated to the field's size
+func (e Event) Uint(name string) (uint64, error) {
+	v, err := e.Field(name)
+	if err != nil {
+		return 0, err
+	}
+	if !v.IsInt() {
+		return 0, fmt.Errorf("field %s is not an integer", name)
+	}
+	return v.AsUint64(), nil
+}
+
+// Str returns the value of a char array or __data_loc string field, up to
+// the first NUL
+func (e Event) Str(name string) (string, error) {
+	v, err := e.Field(name)
+	if err != nil {
+		return "", err
+	}
+	if !v.IsString() {
+		return "", fmt.Errorf("field %s is not a string", name)
+	}
+	return v.AsString(), nil
+}
+
+// Bytes returns the raw contents of a field, or of the dynamic array a
+// __data_loc field points to.  The returned slice shares the event's memory.
+func (e Event) Bytes(name string) ([]byte, error) {
+	i, err := e.fieldNum(name)
+	if err != nil {
+		return nil, err
+	}
+	if f := e.etype.fields[i]; f.dataloc {
+		return e.dataLoc(e.absoluteDataLoc(f, uint32(e.values[i].DecodeUint())))
+	}
+	return e.values[i].contents, nil
+}
+
+// FieldArray returns the elements of a fixed size array field, or of the
+// dynamic array a __data_loc field points to, as a []int8, []uint8, []int16,
+// []uint16, []int32, []uint32, []int64 or []uint64 for the size and
+// signedness of the array's element type.
+func (e Event) FieldArray(name string) (interface{}, error) {
+	i, err := e.fieldNum(name)
+	if err != nil {
+		return nil, err
+	}
+	f := e.etype.fields[i]
+	if !f.array && !f.dataloc {
+		return nil, fmt.Errorf("field %s is not an array", name)
+	}
+	size, signed, err := e.etype.elementType(f)
+	if err != nil {
+		return nil, err
+	}
+	b, err := e.Bytes(name)
+	if err != nil {
+		return nil, err
+	}
+
+	order := e.values[i].order
+	n := len(b) / size
+	switch {
+	case size == 1 && signed:
+		a := make([]int8, n)
+		for j := range a {
+			a[j] = int8(b[j])
+		}
+		return a, nil
+	case size == 1:
+		return append([]uint8(nil), b[:n]...), nil
+	case size == 2 && signed:
+		a := make([]int16, n)
+		for j := range a {
+			a[j] = int16(order.Uint16(b[2*j:]))
+		}
+		return a, nil
+	case size == 2:
+		a := make([]uint16, n)
+		for j := range a {
+			a[j] = order.Uint16(b[2*j:])
+		}
+		return a, nil
+	case size == 4 && signed:
+		a := make([]int32, n)
+		for j := range a {
+			a[j] = int32(order.Uint32(b[4*j:]))
+		}
+		return a, nil
+	case size == 4:
+		a := make([]uint32, n)
+		for j := range a {
+			a[j] = order.Uint32(b[4*j:])
+		}
+		return a, nil
+	case size == 8 && signed:
+		a := make([]int64, n)
+		for j := range a {
+			a[j] = int64(order.Uint64(b[8*j:]))
+		}
+		return a, nil
+	case size == 8:
+		a := make([]uint64, n)
+		for j := range a {
+			a[j] = order.Uint64(b[8*j:])
+		}
+		return a, nil
+	default:
+		return nil, fmt.Errorf("field %s has %d byte elements", name, size)
+	}
+}
+
+func (e Event) fieldNum(name string) (int, error) {
+	if e.etype == nil {
+		return -1, NoSuchField
+	}
+	i := e.etype.getFieldNum(name)
+	if i < 0 || i >= len(e.values) {
+		return -1, NoSuchField
+	}
+	return i, nil
+}
+
+// dataLoc returns the dynamic array described by a __data_loc value, the
+// length in the top 16 bits and the offset into the event in the bottom 16
+func (e Event) dataLoc(loc uint32) ([]byte, error) {
+	offset := int(loc & 0xffff)
+	length := int(loc >> 16)
+
+	if offset > len(e.contents)-1 {
+		return nil, fmt.Errorf("__data_loc offset %d too large", offset)
+	}
+	if offset+length > len(e.contents) {
+		return nil, fmt.Errorf("__data_loc length %d too large", length)
+	}
+	return e.contents[offset : offset+length], nil
+}
+
+// absoluteDataLoc turns the value of a __rel_loc field into a __data_loc,
+// with the offset from the start of the event
+func (e Event) absoluteDataLoc(f eventField, loc uint32) uint32 {
+	if !f.relloc {
+		return loc
+	}
+	offset := loc&0xffff + uint32(f.offset+f.size)
+	return loc&^0xffff | offset&0xffff
+}
+
+func cString(b []byte) string {
+	s := string(b)
+	if zero := strings.IndexByte(s, 0); zero != -1 {
+		s = s[:zero]
+	}
+	return s
+}
@@ -0,0 +1,216 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEventFields(t *testing.T) {
+	etype, err := ParseEventFormat([]byte(schedWakeupFormat))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := etype.DecodeEvent(schedWakeup(-2, "bash", 120, 3), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v, err := e.Int("pid"); err != nil || v != -2 {
+		t.Errorf("pid: want -2, got %d %v", v, err)
+	}
+	if v, err := e.Uint("pid"); err != nil || v != 0xfffffffe {
+		t.Errorf("pid: want 0xfffffffe, got %#x %v", v, err)
+	}
+	if v, err := e.Int("target_cpu"); err != nil || v != 3 {
+		t.Errorf("target_cpu: want 3, got %d %v", v, err)
+	}
+	if v, err := e.Str("comm"); err != nil || v != "bash" {
+		t.Errorf("comm: want bash, got %q %v", v, err)
+	}
+	if v, err := e.Bytes("comm"); err != nil || !bytes.Equal(v, append([]byte("bash"), make([]byte, 12)...)) {
+		t.Errorf("comm: unexpected bytes %q %v", v, err)
+	}
+	if _, err := e.Str("pid"); err == nil {
+		t.Error("pid: expected error reading integer as string")
+	}
+	if _, err := e.Int("comm"); err == nil {
+		t.Error("comm: expected error reading string as integer")
+	}
+	if _, err := e.Field("nonexistent"); err != NoSuchField {
+		t.Errorf("nonexistent: want NoSuchField, got %v", err)
+	}
+
+	wq, err := ParseEventFormat([]byte(workqueueExecuteStartFormat))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := wq.encodeFields(map[string]interface{}{"name": "events", "function": uint64(0xffffffff810a2c40)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err = wq.DecodeEvent(data, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := e.Str("name"); err != nil || v != "events" {
+		t.Errorf("name: want events, got %q %v", v, err)
+	}
+	if v, err := e.Bytes("name"); err != nil || string(v) != "events\x00" {
+		t.Errorf("name: unexpected bytes %q %v", v, err)
+	}
+	if v, err := e.Uint("function"); err != nil || v != 0xffffffff810a2c40 {
+		t.Errorf("function: want 0xffffffff810a2c40, got %#x %v", v, err)
+	}
+}
+
+func TestFieldArray(t *testing.T) {
+	etype, err := ParseEventFormat([]byte(printArrayFormat))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := etype.NewEvent(map[string]interface{}{
+		"regs": []byte{1, 0, 0, 0, 0xff, 0xff, 0xff, 0xff, 3, 0, 0, 0},
+		"mac":  []byte{0, 0x1b, 0x21, 0x3a, 0x4f, 0xff},
+		"ids":  []byte{1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x80},
+	}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		want interface{}
+	}{
+		{"regs", []uint32{1, 0xffffffff, 3}},
+		{"mac", []uint8{0, 0x1b, 0x21, 0x3a, 0x4f, 0xff}},
+		{"ids", []uint64{1, 0x8000000000000000}},
+	}
+	for _, test := range tests {
+		got, err := e.FieldArray(test.name)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		} else if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: want %v got %v", test.name, test.want, got)
+		}
+	}
+	if _, err := e.FieldArray("common_pid"); err == nil {
+		t.Error("common_pid: want error for a non-array field")
+	}
+
+	etype, err = ParseEventFormat([]byte(strings.Replace(printArrayFormat, "u32 regs[3]", "struct reg regs[N]", 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err = etype.NewEvent(map[string]interface{}{}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.FieldArray("regs"); err == nil {
+		t.Error("regs: want error for an array of structs")
+	}
+
+	etype, err = ParseEventFormat([]byte(subscriptFormat))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err = etype.NewEvent(map[string]interface{}{"deltas": []byte{0xfe, 0xff, 5, 0}}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := e.FieldArray("deltas"); err != nil || !reflect.DeepEqual(got, []int16{-2, 5}) {
+		t.Errorf("deltas: want [-2 5] got %v %v", got, err)
+	}
+
+	// The length of an array can be a macro the format file doesn't expand
+	etype, err = ParseEventFormat([]byte(strings.Replace(subscriptFormat, "deltas[2]", "deltas[NR_DELTAS]", 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err = etype.NewEvent(map[string]interface{}{"deltas": []byte{0xfe, 0xff, 5, 0}}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := e.FieldArray("deltas"); err != nil || !reflect.DeepEqual(got, []int16{-2, 5}) {
+		t.Errorf("deltas[NR_DELTAS]: want [-2 5] got %v %v", got, err)
+	}
+}
+
+// fieldTypesFormat is in the style of old kernels, whose format files don't
+// say if fields are signed
+const fieldTypesFormat = `name: field_types
+ID: 1023
+format:
+	field:unsigned short common_type;	offset:0;	size:2;
+	field:unsigned char common_flags;	offset:2;	size:1;
+	field:unsigned char common_preempt_count;	offset:3;	size:1;
+	field:int common_pid;	offset:4;	size:4;
+
+	field:bool ok;	offset:8;	size:1;
+	field:char state;	offset:9;	size:1;
+	field:s16 delta;	offset:10;	size:2;
+	field:pid_t pid;	offset:12;	size:4;
+	field:__u64 bytes;	offset:16;	size:8;
+	field:struct in6_addr saddr;	offset:24;	size:16;
+	field:struct callback_head rcu;	offset:40;	size:8;
+
+print fmt: "ok=%d state=%c delta=%d pid=%d bytes=%llu saddr=%pI6c", REC->ok, REC->state, REC->delta, REC->pid, REC->bytes, REC->saddr
+`
+
+func TestFieldTypes(t *testing.T) {
+	etype, err := ParseEventFormat([]byte(fieldTypesFormat))
+	if err != nil {
+		t.Fatal(err)
+	}
+	saddr := []byte{0x20, 0x01, 0x0d, 0xb8, 15: 1}
+	e, err := etype.NewEvent(map[string]interface{}{
+		"ok": 2, "state": 'R', "delta": -3, "pid": -1, "bytes": uint64(1) << 40,
+		"saddr": saddr, "rcu": []byte{1, 2, 3, 4, 5, 6, 7, 8},
+	}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ints := []struct {
+		name string
+		want int64
+	}{
+		{"ok", 1},
+		{"state", 'R'},
+		{"delta", -3},
+		{"pid", -1},
+		{"bytes", 1 << 40},
+		{"common_pid", 0},
+	}
+	for _, test := range ints {
+		if v, err := e.Int(test.name); err != nil || v != test.want {
+			t.Errorf("%s: want %d got %d %v", test.name, test.want, v, err)
+		}
+	}
+	for name, want := range map[string][]byte{"saddr": saddr, "rcu": {1, 2, 3, 4, 5, 6, 7, 8}} {
+		v, err := e.Field(name)
+		if err != nil || !v.IsString() || v.AsString() != string(want) {
+			t.Errorf("%s: want the raw bytes got %s %v", name, v.Dump(), err)
+		}
+	}
+
+	want := "ok=1 state=R delta=-3 pid=-1 bytes=1099511627776 saddr=2001:db8::1"
+	if got := etype.Format(*e); got != want {
+		t.Errorf("want %q got %q", want, got)
+	}
+}
@@ -16,8 +16,11 @@ package ftrace
 
 import (
 	"bytes"
-	"compress/gzip"
 	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
 	"io"
 	"io/ioutil"
 	"os"
@@ -25,7 +28,9 @@ import (
 	"path/filepath"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
 type FileProvider interface {
@@ -33,47 +38,149 @@ type FileProvider interface {
 	WriteFtraceFile(string, []byte) error
 	ReadProcFile(string) ([]byte, error)
 	OpenFtrace(string) (io.ReadCloser, error)
+	ReadPstoreFile(string) ([]byte, error)
 }
 
+// ftracePath is the traditional location of the tracing files, and the
+// prefix for tracing files in recordings and test data regardless of where
+// they were read from
 const ftracePath = "/sys/kernel/debug/tracing"
 const procPath = "/proc"
 
+// tracefsPaths are the standard tracefs locations, in order of preference
+var tracefsPaths = []string{
+	"/sys/kernel/tracing",
+	ftracePath,
+}
+
 var BadFtraceFileName error = errors.New("Bad file name")
 var BadProcFileName error = errors.New("Bad file name")
+var BadPstoreFileName error = errors.New("Bad file name")
 
-type localFileProvider struct{}
+type localFileProvider struct {
+	tracefsPath string
+	procPath    string
+	procPolicy  *ProcPolicy
+}
 
+// NewLocalFileProvider returns a FileProvider for the local machine, using
+// the tracefs mount found by FindTracefs.
 func NewLocalFileProvider() FileProvider {
-	return &localFileProvider{}
+	return NewLocalFileProviderAt("", "")
+}
+
+// NewLocalFileProviderAt returns a FileProvider for the local machine that
+// reads the tracing files from tracefsRoot and the proc files from procRoot,
+// for example to trace from inside a chroot or a container with tracefs bind
+// mounted somewhere else.  An empty tracefsRoot uses FindTracefs, and an
+// empty procRoot uses /proc.
+func NewLocalFileProviderAt(tracefsRoot, procRoot string) FileProvider {
+	return NewLocalFileProviderWithPolicy(tracefsRoot, procRoot, DefaultProcPolicy)
+}
+
+// NewLocalFileProviderWithPolicy is like NewLocalFileProviderAt, but only
+// allows reading the proc files allowed by policy instead of those allowed
+// by DefaultProcPolicy.
+func NewLocalFileProviderWithPolicy(tracefsRoot, procRoot string, policy *ProcPolicy) FileProvider {
+	if tracefsRoot == "" {
+		tracefsRoot = FindTracefs()
+	}
+	if procRoot == "" {
+		procRoot = procPath
+	}
+	return &localFileProvider{
+		tracefsPath: tracefsRoot,
+		procPath:    procRoot,
+		procPolicy:  policy,
+	}
+}
+
+// FindTracefs returns the directory containing the tracing files on the local
+// machine.  The TRACEFS environment variable overrides the search, otherwise
+// /sys/kernel/tracing, /sys/kernel/debug/tracing, and then any tracefs or
+// debugfs mounts in /proc/mounts are tried, and the first accessible one is
+// returned.  If none are accessible the traditional debugfs path is returned.
+func FindTracefs() string {
+	if dir := os.Getenv("TRACEFS"); dir != "" {
+		return dir
+	}
+
+	candidates := append([]string(nil), tracefsPaths...)
+	if mounts, err := ioutil.ReadFile(path.Join(procPath, "mounts")); err == nil {
+		candidates = append(candidates, tracefsMounts(string(mounts))...)
+	}
+
+	for _, dir := range candidates {
+		if isTracefs(dir) {
+			return dir
+		}
+	}
+
+	return ftracePath
+}
+
+// tracefsMounts returns the possible tracing directories from the contents
+// of /proc/mounts
+func tracefsMounts(mounts string) []string {
+	var dirs []string
+	for _, line := range strings.Split(mounts, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			continue
+		}
+		switch fields[2] {
+		case "tracefs":
+			dirs = append(dirs, fields[1])
+		case "debugfs":
+			dirs = append(dirs, path.Join(fields[1], "tracing"))
+		}
+	}
+	return dirs
+}
+
+func isTracefs(dir string) bool {
+	f, err := os.Open(path.Join(dir, "tracing_on"))
+	if err != nil {
+		return false
+	}
+	f.Close()
+	return true
 }
 
-func (localFileProvider) ReadFtraceFile(filename string) ([]byte, error) {
+func (fp *localFileProvider) ReadFtraceFile(filename string) ([]byte, error) {
 	if !SafeFtracePath(filename) {
 		return nil, BadFtraceFileName
 	}
-	return ioutil.ReadFile(path.Join(ftracePath, filename))
+	return ioutil.ReadFile(path.Join(fp.tracefsPath, filename))
 }
 
-func (localFileProvider) ReadProcFile(filename string) ([]byte, error) {
-	if !SafeProcPath(filename) {
+func (fp *localFileProvider) ReadProcFile(filename string) ([]byte, error) {
+	if !fp.procPolicy.Allowed(filename) {
 		return nil, BadProcFileName
 	}
-	return ioutil.ReadFile(path.Join(procPath, filename))
+	return ioutil.ReadFile(path.Join(fp.procPath, filename))
 }
 
-func (localFileProvider) WriteFtraceFile(filename string, data []byte) error {
+func (fp *localFileProvider) ReadPstoreFile(filename string) ([]byte, error) {
+	if !SafePstorePath(filename) {
+		return nil, BadPstoreFileName
+	}
+	return os.ReadFile(path.Join(pstorePath, filename))
+}
+
+func (fp *localFileProvider) WriteFtraceFile(filename string, data []byte) error {
 	if !SafeFtracePath(filename) {
 		return BadFtraceFileName
 	}
-	return ioutil.WriteFile(path.Join(ftracePath, filename), data, 0)
+	return ioutil.WriteFile(path.Join(fp.tracefsPath, filename), data, 0)
 }
 
-func (localFileProvider) OpenFtrace(filename string) (io.ReadCloser, error) {
+func (fp *localFileProvider) OpenFtrace(filename string) (io.ReadCloser, error) {
 	if !SafeFtracePath(filename) {
 		return nil, BadFtraceFileName
 	}
 
-	return os.Open(path.Join(ftracePath, filename))
+	return os.Open(path.Join(fp.tracefsPath, filename))
 }
 
 // recordingFileProvider
@@ -81,13 +188,25 @@ type recordingFileProvider struct {
 	FileProvider
 	sync.Mutex
 	files map[string]*recordedFileContents
+	codec string
 }
 
 func NewRecordingFileProvider(fp FileProvider) *recordingFileProvider {
 	return &recordingFileProvider{
 		FileProvider: fp,
 		files:        make(map[string]*recordedFileContents),
+		codec:        "gzip",
+	}
+}
+
+// SetCodec selects the compression codec used by Dump for files that cannot
+// be stored as plain text.  The default is gzip.
+func (fp *recordingFileProvider) SetCodec(name string) error {
+	if _, err := GetCodec(name); err != nil {
+		return err
 	}
+	fp.codec = name
+	return nil
 }
 
 func (fp *recordingFileProvider) ReadFtraceFile(filename string) ([]byte, error) {
@@ -118,6 +237,20 @@ func (fp *recordingFileProvider) ReadProcFile(filename string) ([]byte, error) {
 	return buf, err
 }
 
+func (fp *recordingFileProvider) ReadPstoreFile(filename string) ([]byte, error) {
+	buf, err := fp.FileProvider.ReadPstoreFile(filename)
+
+	if err == nil {
+		fp.Lock()
+		fp.files[path.Join(pstorePath, filename)] = &recordedFileContents{
+			buf: buf,
+		}
+		fp.Unlock()
+	}
+
+	return buf, err
+}
+
 func (fp *recordingFileProvider) WriteFtraceFile(filename string, data []byte) error {
 	return fp.FileProvider.WriteFtraceFile(filename, data)
 }
@@ -128,7 +261,7 @@ func (fp *recordingFileProvider) OpenFtrace(filename string) (io.ReadCloser, err
 		return f, err
 	}
 
-	contents := &recordedFileContents{}
+	contents := &recordedFileContents{streamed: true}
 	fp.Lock()
 	fp.files[filename] = contents
 	fp.Unlock()
@@ -136,6 +269,7 @@ func (fp *recordingFileProvider) OpenFtrace(filename string) (io.ReadCloser, err
 	return &recordingReadCloser{
 		ReadCloser: f,
 		contents:   contents,
+		last:       time.Now(),
 	}, nil
 }
 
@@ -162,58 +296,254 @@ func (fp *recordingFileProvider) Dump(filename string) error {
 
 	for _, f := range filenames {
 		fp.files[f].Lock()
-		data := fp.files[f].buf
+		data := fp.files[f].data()
 
 		out.WriteString("`" + f + "`: ")
 
-		s := string(data)
-
-		if canMultilineBackquote(s) {
-			out.WriteString("`" + s + "`")
-		} else {
-			compressedData := new(bytes.Buffer)
-			writer := gzip.NewWriter(compressedData)
-			writer.Write(data)
-			writer.Close()
-			data = compressedData.Bytes()
-			out.WriteString(strconv.QuoteToASCII(string(data)))
+		literal, err := fp.literal(data)
+		if err != nil {
+			fp.files[f].Unlock()
+			return err
 		}
-
-		out.WriteString(",\n")
+		out.WriteString(literal + ",\n")
 
 		fp.files[f].Unlock()
-		if err != nil {
-			return err
+	}
+	_, err = out.WriteString("}\n")
+	if err != nil {
+		return err
+	}
+
+	// The reads of streamed files, for NewTestFileProviderWithStreams
+	_, err = out.WriteString("\nvar reads = map[string][]TestRead{\n")
+	if err != nil {
+		return err
+	}
+	for _, f := range filenames {
+		contents := fp.files[f]
+		if !contents.streamed {
+			continue
+		}
+		contents.Lock()
+		out.WriteString("`" + f + "`: {\n")
+		for _, r := range contents.reads {
+			literal, err := fp.literal(r.data)
+			if err != nil {
+				contents.Unlock()
+				return err
+			}
+			fmt.Fprintf(out, "\t{Delay: %d, Data: %s},\n", r.delay, literal)
 		}
+		out.WriteString("},\n")
+		contents.Unlock()
 	}
 	_, err = out.WriteString("}\n")
 
 	return err
 }
 
+// literal returns data as a Go string literal, compressed with the
+// provider's codec unless it is text
+func (fp *recordingFileProvider) literal(data []byte) (string, error) {
+	s := string(data)
+	if canMultilineBackquote(s) {
+		return "`" + s + "`", nil
+	}
+	data, err := Compress(fp.codec, data)
+	if err != nil {
+		return "", err
+	}
+	return strconv.QuoteToASCII(string(data)), nil
+}
+
+// LoadRecording reads a file written by the Dump method of a recording
+// FileProvider and returns the recorded files, suitable for
+// NewTestFileProvider
+func LoadRecording(filename string) (map[string]string, error) {
+	files, _, err := LoadRecordingWithStreams(filename)
+	return files, err
+}
+
+// LoadRecordingWithStreams is LoadRecording that also returns the reads of
+// the recorded trace pipes, with their timing, suitable for
+// NewTestFileProviderWithStreams.  Recordings made before reads were
+// recorded have no streams.
+func LoadRecordingWithStreams(filename string) (map[string]string, map[string][]TestRead, error) {
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	f, err := parser.ParseFile(token.NewFileSet(), filename, append([]byte("package p\n"), src...), 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	files := make(map[string]string)
+	streams := make(map[string][]TestRead)
+	for _, decl := range f.Decls {
+		if isRecordedReads(decl) {
+			if err := loadRecordedReads(decl, streams); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		ast.Inspect(decl, func(n ast.Node) bool {
+			kv, ok := n.(*ast.KeyValueExpr)
+			if !ok {
+				return true
+			}
+			k, kok := kv.Key.(*ast.BasicLit)
+			v, vok := kv.Value.(*ast.BasicLit)
+			if !kok || !vok {
+				return false
+			}
+			key, kerr := strconv.Unquote(k.Value)
+			value, verr := strconv.Unquote(v.Value)
+			if kerr != nil || verr != nil {
+				err = fmt.Errorf("bad recording entry %s", k.Value)
+				return false
+			}
+			files[key] = value
+			return false
+		})
+	}
+
+	return files, streams, err
+}
+
+// isRecordedReads reports whether decl is the "var reads" written by Dump
+func isRecordedReads(decl ast.Decl) bool {
+	gen, ok := decl.(*ast.GenDecl)
+	if !ok || len(gen.Specs) != 1 {
+		return false
+	}
+	spec, ok := gen.Specs[0].(*ast.ValueSpec)
+	return ok && len(spec.Names) == 1 && spec.Names[0].Name == "reads" && len(spec.Values) == 1
+}
+
+func loadRecordedReads(decl ast.Decl, streams map[string][]TestRead) error {
+	bad := errors.New("bad recorded reads")
+	m, ok := decl.(*ast.GenDecl).Specs[0].(*ast.ValueSpec).Values[0].(*ast.CompositeLit)
+	if !ok {
+		return bad
+	}
+	for _, elt := range m.Elts {
+		kv, ok := elt.(*ast.KeyValueExpr)
+		if !ok {
+			return bad
+		}
+		k, ok := kv.Key.(*ast.BasicLit)
+		list, lok := kv.Value.(*ast.CompositeLit)
+		if !ok || !lok {
+			return bad
+		}
+		name, err := strconv.Unquote(k.Value)
+		if err != nil {
+			return fmt.Errorf("bad recording entry %s", k.Value)
+		}
+
+		reads := []TestRead{}
+		for _, e := range list.Elts {
+			read, ok := e.(*ast.CompositeLit)
+			if !ok {
+				return bad
+			}
+			var r TestRead
+			for _, field := range read.Elts {
+				kv, ok := field.(*ast.KeyValueExpr)
+				if !ok {
+					return bad
+				}
+				key, kok := kv.Key.(*ast.Ident)
+				value, vok := kv.Value.(*ast.BasicLit)
+				if !kok || !vok {
+					return bad
+				}
+				switch key.Name {
+				case "Delay":
+					delay, err := strconv.ParseInt(value.Value, 10, 64)
+					if err != nil {
+						return fmt.Errorf("bad delay in %s: %v", name, err)
+					}
+					r.Delay = time.Duration(delay)
+				case "Data":
+					data, err := strconv.Unquote(value.Value)
+					if err != nil {
+						return fmt.Errorf("bad data in %s: %v", name, err)
+					}
+					dr, err := NewDecompressingReader(strings.NewReader(data))
+					if err != nil {
+						return err
+					}
+					r.Data, err = ioutil.ReadAll(dr)
+					if err != nil {
+						return err
+					}
+				default:
+					return bad
+				}
+			}
+			reads = append(reads, r)
+		}
+		streams[name] = reads
+	}
+	return nil
+}
+
 type recordingReadCloser struct {
 	io.ReadCloser
 	contents *recordedFileContents
+	// last is when the previous read returned
+	last time.Time
 }
 
 func (r *recordingReadCloser) Read(buf []byte) (int, error) {
 	n, err := r.ReadCloser.Read(buf)
+	now := time.Now()
 	if n > 0 {
+		data := append([]byte(nil), buf[:n]...)
 		r.contents.Lock()
-		r.contents.buf = append(r.contents.buf, buf...)
+		r.contents.reads = append(r.contents.reads, recordedRead{now.Sub(r.last), data})
 		r.contents.Unlock()
 	}
+	r.last = now
 	return n, err
 }
 
 type recordedFileContents struct {
 	sync.Mutex
 	buf []byte
+	// streamed is set for files opened with OpenFtrace, whose reads are
+	// recorded one by one instead of in buf
+	streamed bool
+	reads    []recordedRead
+}
+
+// data returns the contents of the file, joining the reads of a streamed
+// one
+func (c *recordedFileContents) data() []byte {
+	if !c.streamed {
+		return c.buf
+	}
+	var data []byte
+	for _, r := range c.reads {
+		data = append(data, r.data...)
+	}
+	return data
+}
+
+// recordedRead is a read of a streamed file, and how long it took since
+// the previous one returned
+type recordedRead struct {
+	delay time.Duration
+	data  []byte
 }
 
 // testFileProvider
 type testFileProvider struct {
-	files map[string]string
+	files   map[string]string
+	streams map[string][]TestRead
 }
 
 func NewTestFileProvider(files map[string]string) FileProvider {
@@ -222,6 +552,75 @@ func NewTestFileProvider(files map[string]string) FileProvider {
 	}
 }
 
+// TestRead is one step of a stream scripted for
+// NewTestFileProviderWithStreams
+type TestRead struct {
+	// Delay is waited before the read returns, or until the stream is
+	// closed, which fails the read with os.ErrClosed
+	Delay time.Duration
+	// Data is returned over as many reads as the reader's buffer needs,
+	// so data shorter than the buffer is a short read
+	Data []byte
+	// Err is returned with the last of Data, for example an
+	// *os.PathError of syscall.EINTR or a mid-stream failure
+	Err error
+	// Block makes the read wait until the stream is closed and return
+	// io.EOF, like a trace pipe with no events closed by Ftrace.Close
+	Block bool
+}
+
+// NewTestFileProviderWithStreams is NewTestFileProvider with files opened
+// by OpenFtrace, like the trace pipes, read as scripted sequences of
+// reads, for testing the capture pipeline's ordering, cancellation and
+// error handling.  Each open replays the script from the start, and reads
+// past its end return io.EOF.
+func NewTestFileProviderWithStreams(files map[string]string, streams map[string][]TestRead) FileProvider {
+	return &testFileProvider{
+		files:   files,
+		streams: streams,
+	}
+}
+
+// testStream plays back a script of TestReads
+type testStream struct {
+	reads  []TestRead
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (s *testStream) Read(buf []byte) (int, error) {
+	if len(s.reads) == 0 {
+		return 0, io.EOF
+	}
+	r := &s.reads[0]
+	if r.Delay > 0 {
+		select {
+		case <-time.After(r.Delay):
+		case <-s.closed:
+			return 0, os.ErrClosed
+		}
+		r.Delay = 0
+	}
+	if r.Block {
+		<-s.closed
+		return 0, io.EOF
+	}
+
+	n := copy(buf, r.Data)
+	r.Data = r.Data[n:]
+	if len(r.Data) > 0 {
+		return n, nil
+	}
+	err := r.Err
+	s.reads = s.reads[1:]
+	return n, err
+}
+
+func (s *testStream) Close() error {
+	s.once.Do(func() { close(s.closed) })
+	return nil
+}
+
 func (fp *testFileProvider) ReadFtraceFile(filename string) ([]byte, error) {
 	if !SafeFtracePath(filename) {
 		return nil, BadFtraceFileName
@@ -238,6 +637,14 @@ func (fp *testFileProvider) ReadProcFile(filename string) ([]byte, error) {
 	return []byte(fp.files[path.Join(procPath, filename)]), nil
 }
 
+func (fp *testFileProvider) ReadPstoreFile(filename string) ([]byte, error) {
+	if !SafePstorePath(filename) {
+		return nil, BadPstoreFileName
+	}
+
+	return []byte(fp.files[path.Join(pstorePath, filename)]), nil
+}
+
 func (fp *testFileProvider) WriteFtraceFile(filename string, data []byte) error {
 	if !SafeFtracePath(filename) {
 		return BadFtraceFileName
@@ -251,22 +658,13 @@ func (fp *testFileProvider) OpenFtrace(filename string) (io.ReadCloser, error) {
 		return nil, BadFtraceFileName
 	}
 
-	data := []byte(fp.files[filename])
-	if len(data) > 4 && data[0] == 0x1f && data[1] == 0x8b && data[2] == 0x08 && data[3] == 0x00 {
-		return gzip.NewReader(bytes.NewBuffer(data))
+	if reads, ok := fp.streams[filename]; ok {
+		return &testStream{
+			reads:  append([]TestRead(nil), reads...),
+			closed: make(chan struct{}),
+		}, nil
 	}
-
-	return &testReader{
-		Reader: bytes.NewReader(data),
-	}, nil
-}
-
-type testReader struct {
-	*bytes.Reader
-}
-
-func (t *testReader) Close() error {
-	return nil
+	return NewDecompressingReader(bytes.NewReader([]byte(fp.files[filename])))
 }
 
 // Utility functions
@@ -280,12 +678,75 @@ func SafeFtracePath(path string) bool {
 	return true
 }
 
+// SafeProcPath returns true if DefaultProcPolicy allows reading path
 func SafeProcPath(path string) bool {
-	return procFileWhitelist[path]
+	return DefaultProcPolicy.Allowed(path)
 }
 
-var procFileWhitelist = map[string]bool{
-	"kallsyms": true,
+// SafePstorePath returns true if path names a function trace file kept by
+// pstore, the only pstore files a FileProvider may read
+func SafePstorePath(path string) bool {
+	return !strings.Contains(path, "/") && strings.HasPrefix(path, "ftrace-ramoops")
+}
+
+// ProcPolicy is a whitelist of files under /proc that a FileProvider may
+// read.  Patterns are matched one path component at a time with path.Match,
+// and the component "<pid>" matches any process or thread id, so
+// "<pid>/comm" allows reading the name of any process.
+type ProcPolicy struct {
+	patterns [][]string
+}
+
+// DefaultProcPolicy is used by FileProviders that are not given a policy
+var DefaultProcPolicy = NewProcPolicy("kallsyms", "<pid>/comm", "<pid>/maps", "sys/kernel/arch",
+	"sys/kernel/osrelease", "sys/kernel/version")
+
+func NewProcPolicy(patterns ...string) *ProcPolicy {
+	p := &ProcPolicy{}
+	p.Allow(patterns...)
+	return p
+}
+
+// Allow adds patterns to the policy
+func (p *ProcPolicy) Allow(patterns ...string) {
+	for _, pattern := range patterns {
+		p.patterns = append(p.patterns, strings.Split(pattern, "/"))
+	}
+}
+
+// Allowed returns true if any pattern in the policy matches name
+func (p *ProcPolicy) Allowed(name string) bool {
+	components := strings.Split(name, "/")
+	for _, c := range components {
+		if c == ".." || c == "." || c == "" {
+			return false
+		}
+	}
+
+	for _, pattern := range p.patterns {
+		if procPatternMatch(pattern, components) {
+			return true
+		}
+	}
+	return false
+}
+
+func procPatternMatch(pattern, components []string) bool {
+	if len(pattern) != len(components) {
+		return false
+	}
+	for i, p := range pattern {
+		if p == "<pid>" {
+			if _, err := strconv.ParseUint(components[i], 10, 32); err != nil {
+				return false
+			}
+			continue
+		}
+		if ok, err := path.Match(p, components[i]); !ok || err != nil {
+			return false
+		}
+	}
+	return true
 }
 
 func canMultilineBackquote(s string) bool {
@@ -0,0 +1,206 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestTracefsMounts(t *testing.T) {
+	mounts := `sysfs /sys sysfs rw,nosuid,nodev,noexec,relatime 0 0
+debugfs /sys/kernel/debug debugfs rw,nosuid,nodev,noexec,relatime 0 0
+tracefs /mnt/trace tracefs rw,nosuid,nodev,noexec,relatime 0 0
+`
+	want := []string{"/sys/kernel/debug/tracing", "/mnt/trace"}
+	if got := tracefsMounts(mounts); !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v got %v", want, got)
+	}
+}
+
+func TestProcPolicy(t *testing.T) {
+	p := NewProcPolicy("kallsyms", "<pid>/comm", "<pid>/task/<pid>/comm", "sys/kernel/*")
+
+	allowed := []string{"kallsyms", "1/comm", "1234/task/1235/comm", "sys/kernel/osrelease"}
+	denied := []string{"self/comm", "1/cmdline", "../etc/passwd", "1/../kallsyms",
+		"sys/kernel/random/uuid", "/kallsyms", "kallsyms/"}
+
+	for _, name := range allowed {
+		if !p.Allowed(name) {
+			t.Errorf("%s should be allowed", name)
+		}
+	}
+	for _, name := range denied {
+		if p.Allowed(name) {
+			t.Errorf("%s should not be allowed", name)
+		}
+	}
+}
+
+func streamFtrace(t *testing.T, streams map[string][]TestRead) *Ftrace {
+	f, err := New(NewTestFileProviderWithStreams(testFiles, streams))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.NewEventType("sched/sched_wakeup"); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func wakeupPage(pids ...int) []byte {
+	page := &testPage{timestamp: 1000000000}
+	for _, pid := range pids {
+		page.addEvent(100, schedWakeup(pid, "bash", 120, 0))
+	}
+	return page.bytes()
+}
+
+func TestStreamedPages(t *testing.T) {
+	eintr := &os.PathError{Op: "read", Path: "trace_pipe_raw", Err: syscall.EINTR}
+	f := streamFtrace(t, map[string][]TestRead{
+		"per_cpu/cpu0/trace_pipe_raw": {
+			{Data: wakeupPage(1, 2)},
+			{Err: eintr},
+			{Delay: 10 * time.Millisecond, Data: wakeupPage(3)},
+		},
+		"per_cpu/cpu1/trace_pipe_raw": {
+			{Data: wakeupPage(10)},
+			{Err: errors.New("device gone")},
+			{Data: wakeupPage(11)},
+		},
+	})
+
+	if err := f.PrepareCapture(2, make(chan bool)); err != nil {
+		t.Fatal(err)
+	}
+	var cpu0, cpu1 []int
+	f.Capture(func(events Events) {
+		for _, e := range events {
+			if e.Cpu == 0 {
+				cpu0 = append(cpu0, e.Pid)
+			} else {
+				cpu1 = append(cpu1, e.Pid)
+			}
+		}
+	})
+
+	// The read interrupted by a signal is retried, the failed one ends
+	// the cpu's capture
+	if want := []int{1, 2, 3}; !reflect.DeepEqual(cpu0, want) {
+		t.Errorf("want cpu0 pids %v got %v", want, cpu0)
+	}
+	if want := []int{10}; !reflect.DeepEqual(cpu1, want) {
+		t.Errorf("want cpu1 pids %v got %v", want, cpu1)
+	}
+}
+
+func TestStreamCancel(t *testing.T) {
+	f := streamFtrace(t, map[string][]TestRead{
+		"per_cpu/cpu0/trace_pipe_raw": {
+			{Data: wakeupPage(1)},
+			{Block: true},
+		},
+	})
+
+	doneCh := make(chan bool)
+	if err := f.PrepareCapture(1, doneCh); err != nil {
+		t.Fatal(err)
+	}
+	captureDone := make(chan bool)
+	go func() {
+		f.Capture(func(events Events) {
+			if len(events) > 0 {
+				close(doneCh)
+			}
+		})
+		close(captureDone)
+	}()
+
+	select {
+	case <-captureDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Capture didn't return after doneCh")
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecordedReads(t *testing.T) {
+	page0, page1 := wakeupPage(1), wakeupPage(2, 3)
+	rfp := NewRecordingFileProvider(NewTestFileProviderWithStreams(testFiles, map[string][]TestRead{
+		"per_cpu/cpu0/trace_pipe_raw": {
+			{Data: page0},
+			{Delay: 20 * time.Millisecond, Data: page1},
+		},
+	}))
+	if _, err := rfp.ReadFtraceFile("events/header_page"); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := rfp.OpenFtrace("per_cpu/cpu0/trace_pipe_raw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4096)
+	for {
+		if _, err := r.Read(buf); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.Close()
+
+	filename := filepath.Join(t.TempDir(), "recording")
+	if err := rfp.Dump(filename); err != nil {
+		t.Fatal(err)
+	}
+	files, streams, err := LoadRecordingWithStreams(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The whole pipe is kept too, for NewTestFileProvider
+	pipe, err := NewTestFileProvider(files).OpenFtrace("per_cpu/cpu0/trace_pipe_raw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := io.ReadAll(pipe); !bytes.Equal(got, append(page0, page1...)) {
+		t.Errorf("want the pages concatenated got %d bytes", len(got))
+	}
+	if files[ftracePath+"/events/header_page"] != testFiles[ftracePath+"/events/header_page"] {
+		t.Error("header_page not recorded")
+	}
+
+	reads := streams["per_cpu/cpu0/trace_pipe_raw"]
+	if len(reads) != 2 {
+		t.Fatalf("want 2 reads got %d", len(reads))
+	}
+	if !bytes.Equal(reads[0].Data, page0) || !bytes.Equal(reads[1].Data, page1) {
+		t.Error("recorded reads don't match the pages")
+	}
+	if reads[1].Delay < 20*time.Millisecond {
+		t.Errorf("want the second read delayed by at least 20ms got %v", reads[1].Delay)
+	}
+}
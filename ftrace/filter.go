@@ -0,0 +1,88 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/google/traceout/ftrace/cparse"
+)
+
+// Filter is a C expression over event fields that selects which events
+// Capture returns, for example "prev_prio < 100 && next_pid != 0" or
+// "comm == \"bash\"".  Fields are named as in the event's format file, with
+// or without "REC->".  Events of a type that lacks a field used by the
+// expression don't match.
+type Filter struct {
+	expr string
+
+	sync.Mutex
+	// expr parsed in the scope of each event type seen
+	compiled map[*EventType]cparse.Expression
+}
+
+// emptyScope resolves no symbols, for checking the syntax of filters
+type emptyScope struct{}
+
+func (emptyScope) GetVariable(name string) cparse.Variable { return nil }
+func (emptyScope) GetFunction(name string) cparse.Function { return nil }
+func (emptyScope) GetType(name string) string              { return "" }
+
+// NewFilter parses a filter expression.  Field names are only checked
+// against each event type when the first event of that type is filtered.
+func NewFilter(expr string) (*Filter, error) {
+	if _, err := parseFilter(expr, emptyScope{}); err != nil {
+		return nil, err
+	}
+	return &Filter{
+		expr:     expr,
+		compiled: make(map[*EventType]cparse.Expression),
+	}, nil
+}
+
+func parseFilter(expr string, scope cparse.Scope) (cparse.Expression, error) {
+	e, err := cparse.Parse(expr, scope)
+	if err != nil {
+		return nil, err
+	}
+	if len(e) != 1 {
+		return nil, fmt.Errorf("expected one filter expression, got %d", len(e))
+	}
+	return e[0], nil
+}
+
+func (filter *Filter) String() string {
+	return filter.expr
+}
+
+// Match returns true if the expression is true for the event
+func (filter *Filter) Match(e *Event) bool {
+	filter.Lock()
+	expr, ok := filter.compiled[e.etype]
+	if !ok {
+		// Parse errors can't happen for a valid filter, but if they do the
+		// nil expression matches nothing
+		expr, _ = parseFilter(filter.expr, e.etype)
+		filter.compiled[e.etype] = expr
+	}
+	filter.Unlock()
+
+	if expr == nil {
+		return false
+	}
+	v := expr.Value(*e)
+	return v.IsInt() && v.AsBool()
+}
@@ -0,0 +1,77 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	f := newTestFtrace(t, testFiles)
+	if _, err := f.NewEventType("sched/sched_wakeup"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.NewEventType("task/task_newtask"); err != nil {
+		t.Fatal(err)
+	}
+
+	page := &testPage{}
+	page.addEvent(0, schedWakeup(1, "init", 120, 0))
+	page.addEvent(0, schedWakeup(2, "kthreadd", 90, 1))
+	page.addEvent(0, schedWakeup(3, "bash", 100, 2))
+	page.addEvent(0, taskNewtask(3, 4, "bash"))
+	page.addEvent(0, schedWakeup(4, "bash", 120, 3))
+
+	tests := []struct {
+		expr string
+		pids []int
+	}{
+		{"prio < 110", []int{2, 3}},
+		{"REC->prio >= 100 && target_cpu != 0", []int{3, 4}},
+		{`comm == "bash"`, []int{3, 3, 4}},
+		{`comm == "bash" && pid > 3`, []int{3, 4}},
+		{"common_pid == 1 || common_pid == 3", []int{1, 3, 3}},
+		{"1", []int{1, 2, 3, 3, 4}},
+		{"nonexistent == 0", nil},
+	}
+
+	for _, test := range tests {
+		filter, err := NewFilter(test.expr)
+		if err != nil {
+			t.Errorf("%s: %s", test.expr, err.Error())
+			continue
+		}
+		f.options.Filter = filter
+
+		events, err := f.decodePage(0, page.bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var pids []int
+		for _, e := range events {
+			pids = append(pids, e.Pid)
+		}
+		if !reflect.DeepEqual(pids, test.pids) {
+			t.Errorf("%s: want events from pids %v got %v", test.expr, test.pids, pids)
+		}
+	}
+
+	for _, expr := range []string{"prio <", "(prio", "prio, pid"} {
+		if _, err := NewFilter(expr); err == nil {
+			t.Errorf("%s: expected error", expr)
+		}
+	}
+}
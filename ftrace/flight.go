@@ -0,0 +1,155 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// snapshotIdle is how long Snapshot waits for more pages from a cpu after
+// its last one, and snapshotFirstPage how long for its first, which may be
+// slow to come through adb or a remote agent
+const (
+	snapshotIdle      = 100 * time.Millisecond
+	snapshotFirstPage = 2 * time.Second
+)
+
+// SetOverwrite sets the overwrite trace option.  With it on the ring buffer
+// keeps the newest events, dropping the oldest when it is full, so tracing
+// can run unread like a flight recorder until Snapshot is called.  With it
+// off, new events are dropped when the buffer is full.
+func (f *Ftrace) SetOverwrite(overwrite bool) error {
+	value := "0"
+	if overwrite {
+		value = "1"
+	}
+	return f.fp.WriteFtraceFile("options/overwrite", []byte(value))
+}
+
+// Snapshot turns tracing off and reads what is left in the ring buffers of
+// the first cpus cpus, or of every cpu if there are fewer, sorted by time.
+// Together with SetOverwrite it gives the last events before a problem,
+// without the overhead of streaming events all along.  Call it when the
+// stop condition fires, instead of PrepareCaptureWithOptions and Capture.
+func (f *Ftrace) Snapshot(cpus int, options CaptureOptions) (Events, error) {
+	return f.snapshot(cpus, options, false)
+}
+
+// snapshot is Snapshot, marking the events with previousBoot
+func (f *Ftrace) snapshot(cpus int, options CaptureOptions, previousBoot bool) (Events, error) {
+	f.options = options
+	f.windowStart = 0
+	f.previousBoot = previousBoot
+	if err := f.Disable(); err != nil {
+		return nil, err
+	}
+
+	// Reading a pipe empties its buffer, so find the cpus first rather
+	// than lose what was read when a later pipe fails to open
+	doneCh := make(chan bool)
+	defer close(doneCh)
+
+	var pipes []io.Closer
+	defer func() {
+		// Unblocks the readers of buffers that had nothing left
+		for _, pipe := range pipes {
+			pipe.Close()
+		}
+	}()
+
+	pageCh := make(chan Events)
+	var wg sync.WaitGroup
+	for _, cpu := range f.snapshotCPUs(cpus) {
+		ch, pipe, err := f.getEventsPipe(cpu, doneCh)
+		if err != nil {
+			return nil, err
+		}
+		pipes = append(pipes, pipe)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// The pipes don't report when the buffers are empty, so a
+			// cpu is done when no page has arrived for a while
+			timeout := time.NewTimer(snapshotFirstPage)
+			defer timeout.Stop()
+			for {
+				select {
+				case events, ok := <-ch:
+					if !ok {
+						return
+					}
+					select {
+					case pageCh <- events:
+					case <-doneCh:
+						return
+					}
+					if !timeout.Stop() {
+						<-timeout.C
+					}
+					timeout.Reset(snapshotIdle)
+				case <-timeout.C:
+					return
+				}
+			}
+		}()
+	}
+
+	readersDone := make(chan bool)
+	go func() {
+		wg.Wait()
+		close(readersDone)
+	}()
+
+	var snapshot Events
+	for {
+		select {
+		case events := <-pageCh:
+			atomic.AddInt64(&f.metrics.pagesDelivered, 1)
+			snapshot = append(snapshot, events...)
+			continue
+		case <-readersDone:
+		}
+		break
+	}
+
+	sort.Stable(EventsByTime{Events: snapshot})
+	return snapshot, nil
+}
+
+// snapshotCPUs returns the cpus among the first max with events to read:
+// those with a per_cpu directory, up to the first missing one, leaving out
+// those whose stats count no entries.  cpu 0 is always read, so a missing
+// one fails when its pipe is opened.
+func (f *Ftrace) snapshotCPUs(max int) []int {
+	cpus := []int{0}
+	for cpu := 1; cpu < max; cpu++ {
+		data, err := f.fp.ReadFtraceFile(fmt.Sprintf("per_cpu/cpu%d/stats", cpu))
+		if err != nil {
+			break
+		}
+		if stats, err := parseCPUStats(string(data)); err == nil &&
+			stats.Entries == 0 && strings.Contains(string(data), "entries:") {
+			continue
+		}
+		cpus = append(cpus, cpu)
+	}
+	return cpus
+}
@@ -0,0 +1,134 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+	page0 := &testPage{timestamp: 2000000000}
+	page0.addEvent(0, schedWakeup(1, "init", 120, 0))
+	page1 := &testPage{timestamp: 1000000000}
+	page1.addEvent(0, schedWakeup(2, "kthreadd", 120, 1))
+
+	files := testFilesWith(map[string]string{
+		"per_cpu/cpu0/trace_pipe_raw": string(page0.bytes()),
+		"per_cpu/cpu1/trace_pipe_raw": string(page1.bytes()),
+	})
+	fp := &writeLogFileProvider{FileProvider: NewTestFileProvider(files)}
+	f, err := New(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.NewEventType("sched/sched_wakeup"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.SetOverwrite(true); err != nil {
+		t.Fatal(err)
+	}
+	events, err := f.Snapshot(2, CaptureOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := [][2]string{{"options/overwrite", "1"}, {"tracing_on", "0"}}
+	if len(fp.writes) != len(want) || fp.writes[0] != want[0] || fp.writes[1] != want[1] {
+		t.Errorf("want writes %v got %v", want, fp.writes)
+	}
+
+	if len(events) != 2 {
+		t.Fatalf("want 2 events got %d", len(events))
+	}
+	if events[0].Pid != 2 || events[1].Pid != 1 {
+		t.Errorf("want events sorted by time got pids %d, %d", events[0].Pid, events[1].Pid)
+	}
+}
+
+func TestSnapshotSlowPipes(t *testing.T) {
+	// cpu 0's first page comes late, as through adb, and cpu 1's pipe
+	// blocks once empty until it is closed
+	f := streamFtrace(t, map[string][]TestRead{
+		"per_cpu/cpu0/trace_pipe_raw": {
+			{Delay: 3 * snapshotIdle, Data: wakeupPage(1)},
+		},
+		"per_cpu/cpu1/trace_pipe_raw": {
+			{Data: wakeupPage(2)},
+			{Block: true},
+		},
+	})
+
+	done := make(chan Events)
+	go func() {
+		events, err := f.Snapshot(2, CaptureOptions{})
+		if err != nil {
+			t.Error(err)
+		}
+		done <- events
+	}()
+	select {
+	case events := <-done:
+		if len(events) != 2 {
+			t.Errorf("want the events of both cpus got %v", events)
+		}
+	case <-time.After(2 * snapshotFirstPage):
+		t.Fatal("Snapshot didn't return")
+	}
+}
+
+// writeTracefs writes files, named relative to the tracefs root, under dir
+func writeTracefs(t *testing.T, dir string, files map[string]string) {
+	for name, data := range files {
+		name = filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSnapshotFewerCPUs(t *testing.T) {
+	// A machine with one cpu, where per_cpu/cpu1 doesn't exist
+	dir := t.TempDir()
+	writeTracefs(t, dir, map[string]string{
+		"tracing_on":                       "1\n",
+		"events/header_page":               headerPageFormat,
+		"events/sched/sched_wakeup/format": schedWakeupFormat,
+		"per_cpu/cpu0/stats":               "entries: 2\n",
+		"per_cpu/cpu0/trace_pipe_raw":      string(wakeupPage(1, 2)),
+	})
+	f, err := New(NewLocalFileProviderAt(dir, t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.NewEventType("sched/sched_wakeup"); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := f.Snapshot(32, CaptureOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Errorf("want the 2 events of cpu 0 got %v", events)
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// followedPids is the set of pids being traced by FollowPid
+type followedPids struct {
+	sync.Mutex
+	pids map[int]bool
+}
+
+// FollowPid limits the capture to events from the process root and, like
+// strace -f, any processes or threads it creates after the call.  The
+// kernel is asked to do the filtering with set_event_pid and the event-fork
+// option, and the task_newtask event is enabled so that children are also
+// tracked and filtered here, which covers kernels without those files.
+// Without kernel support, early events of a child that are decoded on
+// another cpu before its task_newtask event may be dropped.
+// Call it after registering event types and before PrepareCapture.
+func (f *Ftrace) FollowPid(root int) error {
+	newtask := f.eventTypeByPath("task/task_newtask")
+	if newtask == nil {
+		var err error
+		newtask, err = f.NewEventType("task/task_newtask")
+		if err != nil {
+			return err
+		}
+	}
+	err := newtask.Enable()
+	if err != nil {
+		return err
+	}
+
+	f.followed = &followedPids{
+		pids: map[int]bool{root: true},
+	}
+
+	// Older kernels don't support these, userspace filtering covers them
+	f.fp.WriteFtraceFile("set_event_pid", []byte(strconv.Itoa(root)))
+	f.fp.WriteFtraceFile("options/event-fork", []byte("1"))
+
+	return nil
+}
+
+// FollowedPids returns the pids currently being followed after a call to
+// FollowPid, including the root and all children seen so far
+func (f *Ftrace) FollowedPids() []int {
+	if f.followed == nil {
+		return nil
+	}
+	f.followed.Lock()
+	defer f.followed.Unlock()
+
+	pids := []int{}
+	for pid := range f.followed.pids {
+		pids = append(pids, pid)
+	}
+	sort.Ints(pids)
+	return pids
+}
+
+// follow returns true if the event is from a followed process, and records
+// new children of followed processes
+func (f *Ftrace) follow(e *Event) bool {
+	f.followed.Lock()
+	defer f.followed.Unlock()
+
+	if !f.followed.pids[e.Pid] {
+		return false
+	}
+
+	if e.etype.path == "task/task_newtask" {
+		if child := e.etype.getFieldNum("pid"); child >= 0 {
+			f.followed.pids[int(e.values[child].DecodeInt())] = true
+		}
+	}
+
+	return true
+}
+
+func (f *Ftrace) eventTypeByPath(path string) *EventType {
+	for _, etype := range f.eventTypes {
+		if etype.path == path {
+			return etype
+		}
+	}
+	return nil
+}
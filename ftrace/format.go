@@ -0,0 +1,174 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"fmt"
+)
+
+// ParseEventFormat parses the contents of an event's format file without
+// reading anything from tracefs.  The returned EventType can format events
+// with FormatFields, but can't be enabled.
+func ParseEventFormat(format []byte) (*EventType, error) {
+	etype := EventType{}
+	err := etype.parseFormatData(format)
+	if err != nil {
+		return nil, err
+	}
+
+	etype.finishNewType()
+
+	return &etype, nil
+}
+
+// FormatFields renders the event's print fmt with the given field values
+// instead of a recorded event.  Integer fields take any Go integer type,
+// char arrays and __data_loc strings take a string or []byte.  Fields that
+// are not in the map are zero.
+func (etype *EventType) FormatFields(fields map[string]interface{}) (string, error) {
+	data, err := etype.encodeFields(fields)
+	if err != nil {
+		return "", err
+	}
+
+	e, err := etype.DecodeEvent(data, 0, 0)
+	if err != nil {
+		return "", err
+	}
+
+	etype.compileFormatter()
+	if etype.formatter == nil {
+		return "", fmt.Errorf("event type %s has no formatter", etype.name)
+	}
+	v := etype.formatter.Value(*e)
+	if v.IsError() {
+		return "", v.AsError()
+	}
+	if !v.IsString() {
+		return "", fmt.Errorf("formatter expected string, got %s", v.Dump())
+	}
+	return v.AsString(), nil
+}
+
+// NewEvent builds an event from field values, taken as by FormatFields, to
+// synthesize events or to test code that consumes them.  The event doesn't
+// belong to an Ftrace, so only its fields, type, Cpu, When and Pid can be
+// used.
+func (etype *EventType) NewEvent(fields map[string]interface{}, cpu int, when uint64) (*Event, error) {
+	data, err := etype.encodeFields(fields)
+	if err != nil {
+		return nil, err
+	}
+	return etype.DecodeEvent(data, cpu, when)
+}
+
+// encodeFields builds the raw contents of an event from field values, with
+// dynamic arrays appended after the fixed size fields
+func (etype *EventType) encodeFields(fields map[string]interface{}) ([]byte, error) {
+	data := make([]byte, etype.size)
+	order := etype.byteOrder()
+
+	for name, value := range fields {
+		i := etype.getFieldNum(name)
+		if i < 0 {
+			return nil, fmt.Errorf("event type %s has no field %s", etype.name, name)
+		}
+		f := &etype.fields[i]
+		contents := data[f.offset : f.offset+f.size]
+
+		if b, ok := bytesValue(value); ok {
+			switch {
+			case f.dataloc:
+				if f.size != 4 {
+					return nil, fmt.Errorf("field %s: unexpected __data_loc size %d", name, f.size)
+				}
+				b = append(b, 0)
+				offset := len(data)
+				if f.relloc {
+					offset -= f.offset + f.size
+				}
+				order.PutUint32(contents, uint32(len(b))<<16|uint32(offset))
+				data = append(data, b...)
+			case f.array || f.isComposite():
+				copy(contents, b)
+			default:
+				return nil, fmt.Errorf("field %s: can't store %T in %s", name, value, f.ftype)
+			}
+			continue
+		}
+
+		v, ok := intValue(value)
+		if !ok {
+			return nil, fmt.Errorf("field %s: unsupported value type %T", name, value)
+		}
+		switch f.size {
+		case 1:
+			contents[0] = byte(v)
+		case 2:
+			order.PutUint16(contents, uint16(v))
+		case 4:
+			order.PutUint32(contents, uint32(v))
+		case 8:
+			order.PutUint64(contents, v)
+		default:
+			return nil, fmt.Errorf("field %s: can't store an integer in %d bytes", name, f.size)
+		}
+	}
+
+	return data, nil
+}
+
+func bytesValue(value interface{}) ([]byte, bool) {
+	switch v := value.(type) {
+	case string:
+		return []byte(v), true
+	case []byte:
+		return append([]byte(nil), v...), true
+	}
+	return nil, false
+}
+
+func intValue(value interface{}) (uint64, bool) {
+	switch v := value.(type) {
+	case int:
+		return uint64(v), true
+	case int8:
+		return uint64(v), true
+	case int16:
+		return uint64(v), true
+	case int32:
+		return uint64(v), true
+	case int64:
+		return uint64(v), true
+	case uint:
+		return uint64(v), true
+	case uint8:
+		return uint64(v), true
+	case uint16:
+		return uint64(v), true
+	case uint32:
+		return uint64(v), true
+	case uint64:
+		return v, true
+	case uintptr:
+		return uint64(v), true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
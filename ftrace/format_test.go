@@ -0,0 +1,350 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/traceout/ftrace/cparse"
+)
+
+const workqueueExecuteStartFormat = `name: workqueue_execute_start
+ID: 301
+format:
+	field:unsigned short common_type;	offset:0;	size:2;	signed:0;
+	field:unsigned char common_flags;	offset:2;	size:1;	signed:0;
+	field:unsigned char common_preempt_count;	offset:3;	size:1;	signed:0;
+	field:int common_pid;	offset:4;	size:4;	signed:1;
+
+	field:void * work;	offset:8;	size:8;	signed:0;
+	field:void * function;	offset:16;	size:8;	signed:0;
+	field:__data_loc char[] name;	offset:24;	size:4;	signed:0;
+
+print fmt: "work struct %p: function %pf name %s", REC->work, REC->function, __get_str(name)
+`
+
+const printHexFormat = `name: print_hex
+ID: 302
+format:
+	field:unsigned short common_type;	offset:0;	size:2;	signed:0;
+	field:unsigned char common_flags;	offset:2;	size:1;	signed:0;
+	field:unsigned char common_preempt_count;	offset:3;	size:1;	signed:0;
+	field:int common_pid;	offset:4;	size:4;	signed:1;
+
+	field:int len;	offset:8;	size:4;	signed:1;
+	field:__data_loc u8[] data;	offset:12;	size:4;	signed:0;
+
+print fmt: "len=%d data=%s all=%s str=%s", REC->len, __print_hex(__get_dynamic_array(data), REC->len), __print_hex(__get_dynamic_array(data), __get_dynamic_array_len(data)), __print_hex_str(__get_dynamic_array(data), REC->len)
+`
+
+const printArrayFormat = `name: print_array
+ID: 303
+format:
+	field:unsigned short common_type;	offset:0;	size:2;	signed:0;
+	field:unsigned char common_flags;	offset:2;	size:1;	signed:0;
+	field:unsigned char common_preempt_count;	offset:3;	size:1;	signed:0;
+	field:int common_pid;	offset:4;	size:4;	signed:1;
+
+	field:u32 regs[3];	offset:8;	size:12;	signed:0;
+	field:u8 mac[6];	offset:20;	size:6;	signed:0;
+	field:__data_loc u64[] ids;	offset:28;	size:4;	signed:0;
+
+print fmt: "regs=%s mac=%s ids=%s", __print_array(REC->regs, 3, 4), __print_hex(REC->mac, 6), __print_array(__get_dynamic_array(ids), __get_dynamic_array_len(ids) / sizeof(u64), sizeof(u64))
+`
+
+const ipiRaiseFormat = `name: ipi_raise
+ID: 304
+format:
+	field:unsigned short common_type;	offset:0;	size:2;	signed:0;
+	field:unsigned char common_flags;	offset:2;	size:1;	signed:0;
+	field:unsigned char common_preempt_count;	offset:3;	size:1;	signed:0;
+	field:int common_pid;	offset:4;	size:4;	signed:1;
+
+	field:__data_loc unsigned long[] target_cpus;	offset:8;	size:4;	signed:0;
+	field:const char * reason;	offset:16;	size:8;	signed:0;
+
+print fmt: "target_mask=%s cpus=%*pbl", __get_bitmask(target_cpus), __get_dynamic_array_len(target_cpus) * 8, __get_dynamic_array(target_cpus)
+`
+
+const delStationFormat = `name: rdev_del_station
+ID: 1021
+format:
+	field:unsigned short common_type;	offset:0;	size:2;	signed:0;
+	field:unsigned char common_flags;	offset:2;	size:1;	signed:0;
+	field:unsigned char common_preempt_count;	offset:3;	size:1;	signed:0;
+	field:int common_pid;	offset:4;	size:4;	signed:1;
+
+	field:char wiphy_name[32];	offset:8;	size:32;	signed:0;
+	field:u8 sta_mac[6];	offset:40;	size:6;	signed:0;
+	field:u8 subtype;	offset:46;	size:1;	signed:0;
+
+print fmt: "%s, sta mac: %pM, subtype: %u", REC->wiphy_name, REC->sta_mac, REC->subtype
+`
+
+const subscriptFormat = `name: subscript
+ID: 1022
+format:
+	field:unsigned short common_type;	offset:0;	size:2;	signed:0;
+	field:unsigned char common_flags;	offset:2;	size:1;	signed:0;
+	field:unsigned char common_preempt_count;	offset:3;	size:1;	signed:0;
+	field:int common_pid;	offset:4;	size:4;	signed:1;
+
+	field:u32 regs[3];	offset:8;	size:12;	signed:0;
+	field:s16 deltas[2];	offset:20;	size:4;	signed:1;
+	field:char comm[16];	offset:24;	size:16;	signed:0;
+	field:int i;	offset:40;	size:4;	signed:1;
+
+print fmt: "r0=%x r2=%x ri=%u d=%d,%d c=%c b=%s n=%lu,%lu", REC->regs[0], REC->regs[2], REC->regs[REC->i], REC->deltas[0], -REC->deltas[1], REC->comm[1], REC->comm[0] == 'b' ? "yes" : "no", sizeof(REC->regs) / sizeof(u32), sizeof(REC->comm)
+`
+
+func TestFormatFields(t *testing.T) {
+	tests := []struct {
+		format string
+		fields map[string]interface{}
+		want   string
+	}{
+		{schedWakeupFormat,
+			map[string]interface{}{"comm": "bash", "pid": 1234, "prio": 120, "success": true, "target_cpu": 2},
+			"comm=bash pid=1234 prio=120 success=1 target_cpu=002"},
+		{schedWakeupFormat,
+			map[string]interface{}{"comm": []byte("kworker/0:1"), "pid": int32(-1)},
+			"comm=kworker/0:1 pid=-1 prio=0 success=0 target_cpu=000"},
+		{taskNewtaskFormat,
+			map[string]interface{}{"pid": 1, "comm": "init", "clone_flags": uint64(0x1200011), "oom_score_adj": -1000},
+			"pid=1 comm=init clone_flags=1200011 oom_score_adj=-1000"},
+		{workqueueExecuteStartFormat,
+			map[string]interface{}{"work": uint64(0xffff88003f4a0e00), "function": uint64(0xffffffff810a2c40), "name": "events"},
+			"work struct ffff88003f4a0e00: function 0xffffffff810a2c40 name events"},
+		{printHexFormat,
+			map[string]interface{}{"len": 3, "data": []byte{0x01, 0xab, 0x00, 0xff}},
+			"len=3 data=01 ab 00 all=01 ab 00 ff 00 str=01ab00"},
+		{printArrayFormat,
+			map[string]interface{}{
+				"regs": []byte{1, 0, 0, 0, 0xff, 0xff, 0, 0, 0, 0, 0, 0x80},
+				"mac":  []byte{0, 0x1b, 0x21, 0x3a, 0x4f, 0xff},
+				"ids":  []byte{1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x80},
+			},
+			"regs={0x1,0xffff,0x80000000} mac=00 1b 21 3a 4f ff ids={0x1,0x8000000000000000}"},
+		{ipiRaiseFormat,
+			map[string]interface{}{"target_cpus": []byte{0x4f, 0, 0, 0, 0, 0, 0}},
+			"target_mask=00000000,0000004f cpus=0-3,6"},
+		{delStationFormat,
+			map[string]interface{}{"wiphy_name": "phy0", "sta_mac": []byte{0, 0x1b, 0x21, 0x3a, 0x4f, 0}, "subtype": 12},
+			"phy0, sta mac: 00:1b:21:3a:4f:00, subtype: 12"},
+		{subscriptFormat,
+			map[string]interface{}{"regs": []byte{1, 0, 0, 0, 2, 0, 0, 0, 0xff, 0xff, 0, 0}, "deltas": []byte{0xfe, 0xff, 5, 0}, "comm": "bash", "i": 1},
+			"r0=1 r2=ffff ri=2 d=-2,-5 c=a b=yes n=3,16"},
+	}
+
+	for _, test := range tests {
+		etype, err := ParseEventFormat([]byte(test.format))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := etype.FormatFields(test.fields)
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", etype.Name(), err.Error())
+		} else if got != test.want {
+			t.Errorf("%s: want %q, got %q", etype.Name(), test.want, got)
+		}
+	}
+
+	etype, _ := ParseEventFormat([]byte(schedWakeupFormat))
+	if _, err := etype.FormatFields(map[string]interface{}{"nonexistent": 1}); err == nil {
+		t.Error("expected error for unknown field")
+	}
+	if _, err := etype.FormatFields(map[string]interface{}{"pid": "1234"}); err == nil {
+		t.Error("expected error for string in integer field")
+	}
+}
+
+func TestEventTypeFields(t *testing.T) {
+	etype, err := ParseEventFormat([]byte(workqueueExecuteStartFormat))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if etype.ID() != 301 {
+		t.Errorf("want ID 301, got %d", etype.ID())
+	}
+
+	want := []FieldInfo{
+		{Name: "common_type", Type: "unsigned short", Offset: 0, Size: 2},
+		{Name: "common_flags", Type: "unsigned char", Offset: 2, Size: 1},
+		{Name: "common_preempt_count", Type: "unsigned char", Offset: 3, Size: 1},
+		{Name: "common_pid", Type: "int", Offset: 4, Size: 4, Signed: true},
+		{Name: "work", Type: "void *", Offset: 8, Size: 8},
+		{Name: "function", Type: "void *", Offset: 16, Size: 8},
+		{Name: "name", Type: "char", Offset: 24, Size: 4, DataLoc: true},
+	}
+	got := etype.Fields()
+	if len(got) != len(want) {
+		t.Fatalf("want %d fields, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("want %+v, got %+v", want[i], got[i])
+		}
+	}
+
+	etype, _ = ParseEventFormat([]byte(schedWakeupFormat))
+	if f := etype.Fields()[4]; f != (FieldInfo{Name: "comm", Type: "char", Offset: 8, Size: 16, Array: true}) {
+		t.Errorf("unexpected comm field %+v", f)
+	}
+}
+
+func TestNewEvent(t *testing.T) {
+	etype, err := ParseEventFormat([]byte(workqueueExecuteStartFormat))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := etype.NewEvent(map[string]interface{}{
+		"common_pid": 42,
+		"work":       0x1234,
+	}, 3, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Pid != 42 || e.Cpu != 3 || e.When != 1000 {
+		t.Errorf("want pid 42 cpu 3 when 1000 got %d %d %d", e.Pid, e.Cpu, e.When)
+	}
+	if work, err := e.Uint("work"); err != nil || work != 0x1234 {
+		t.Errorf("want work 0x1234 got %x, %v", work, err)
+	}
+}
+
+func TestNullStrings(t *testing.T) {
+	etype, err := ParseEventFormat([]byte(workqueueExecuteStartFormat))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := etype.NewEvent(map[string]interface{}{"work": 0x1234, "name": "events"}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A __data_loc pointing past the end of the event
+	binary.LittleEndian.PutUint32(e.contents[24:], 8<<16|0x1000)
+	want := "work struct 0000000000001234: function 0x0 name (null)"
+	if got := etype.Format(*e); got != want {
+		t.Errorf("want %q got %q", want, got)
+	}
+}
+
+func TestPrintFmtErrors(t *testing.T) {
+	format := strings.Replace(schedWakeupFormat, "REC->prio,", "REC->prio +,", 1)
+	_, err := ParseEventFormat([]byte(format))
+	if err == nil {
+		t.Fatal("want an error for a bad print fmt")
+	}
+	want := `sched_wakeup: print fmt: 1:85: expected expression to the right of + near "+, REC->success, REC->ta..."`
+	if err.Error() != want {
+		t.Errorf("want %s got %s", want, err.Error())
+	}
+	var pe *cparse.ParseError
+	if !errors.As(err, &pe) || pe.Column() != 85 {
+		t.Errorf("want a ParseError at column 85 got %v", err)
+	}
+}
+
+const noCommonFieldsFormat = `name: no_common
+ID: 1024
+format:
+	field:unsigned short common_type;	offset:0;	size:2;	signed:0;
+
+	field:u64 lat;	offset:8;	size:8;	signed:0;
+
+print fmt: "lat=%llu", REC->lat
+`
+
+func TestMissingCommonFields(t *testing.T) {
+	etype, err := ParseEventFormat([]byte(noCommonFieldsFormat))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := etype.NewEvent(map[string]interface{}{"common_type": 1024, "lat": 1500}, 2, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Pid != 0 || e.Flags != 0 || e.Preempt != 0 || e.Cpu != 2 {
+		t.Errorf("want zero common fields on cpu 2 got pid %d flags %d preempt %d cpu %d", e.Pid, e.Flags, e.Preempt, e.Cpu)
+	}
+	if got := etype.Format(*e); got != "lat=1500" {
+		t.Errorf("want lat=1500 got %q", got)
+	}
+	if _, err := e.Int("common_pid"); err != NoSuchField {
+		t.Errorf("common_pid: want NoSuchField got %v", err)
+	}
+}
+
+// rtSchedWakeupFormat is sched_wakeup on an RT kernel, with extra common
+// fields
+const rtSchedWakeupFormat = `name: sched_wakeup
+ID: 62
+format:
+	field:unsigned short common_type;	offset:0;	size:2;	signed:0;
+	field:unsigned char common_flags;	offset:2;	size:1;	signed:0;
+	field:unsigned char common_preempt_count;	offset:3;	size:1;	signed:0;
+	field:int common_pid;	offset:4;	size:4;	signed:1;
+	field:unsigned char common_migrate_disable;	offset:8;	size:1;	signed:0;
+	field:unsigned char common_preempt_lazy_count;	offset:9;	size:1;	signed:0;
+
+	field:char comm[16];	offset:12;	size:16;	signed:1;
+	field:pid_t pid;	offset:28;	size:4;	signed:1;
+	field:int prio;	offset:32;	size:4;	signed:1;
+	field:int target_cpu;	offset:36;	size:4;	signed:1;
+
+print fmt: "comm=%s pid=%d prio=%d target_cpu=%03d", REC->comm, REC->pid, REC->prio, REC->target_cpu
+`
+
+func TestRTCommonFields(t *testing.T) {
+	etype, err := ParseEventFormat([]byte(rtSchedWakeupFormat))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		fields map[string]interface{}
+		want   string
+	}{
+		{map[string]interface{}{}, "......"},
+		{map[string]interface{}{"common_flags": 1, "common_preempt_count": 2, "common_migrate_disable": 1}, "d..2.1"},
+		{map[string]interface{}{"common_preempt_lazy_count": 11, "common_migrate_disable": 2}, "....b2"},
+	}
+	for _, test := range tests {
+		fields := map[string]interface{}{"comm": "bash", "pid": 1234}
+		for k, v := range test.fields {
+			fields[k] = v
+		}
+		e, err := etype.NewEvent(fields, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := e.FlagChars(); got != test.want {
+			t.Errorf("%v: want %q got %q", test.fields, test.want, got)
+		}
+		if got, want := etype.Format(*e), "comm=bash pid=1234 prio=0 target_cpu=000"; got != want {
+			t.Errorf("want %q got %q", want, got)
+		}
+	}
+
+	// Events of other kernels have only the usual four flag characters
+	etype, _ = ParseEventFormat([]byte(schedWakeupFormat))
+	e, _ := etype.NewEvent(map[string]interface{}{"common_preempt_count": 1}, 0, 0)
+	if got := e.FlagChars(); got != "...1" {
+		t.Errorf("want ...1 got %q", got)
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// formatCache keeps the parsed format files of event types on disk, for
+// tools that start captures often and would otherwise parse the same
+// formats every run.  Entries are kept for one kernel build, identified by
+// its release and version strings, and are checked against a hash of the
+// format file, as tracefs doesn't give format files meaningful mtimes.
+// The print fmt of a cached type is compiled the first time an event of
+// that type is formatted, rather than when the type is registered.
+type formatCache struct {
+	sync.Mutex
+	filename string
+	kernel   string
+	formats  map[string]cachedFormat
+	dirty    bool
+}
+
+// formatCacheFile is the JSON encoding of a formatCache
+type formatCacheFile struct {
+	Kernel  string
+	Formats map[string]cachedFormat
+}
+
+type cachedFormat struct {
+	// Sum is the SHA-256 of the format file the entry was parsed from
+	Sum      string
+	Name     string
+	ID       int
+	Fields   []cachedField
+	PrintFmt string
+}
+
+type cachedField struct {
+	Name     string
+	Type     string
+	Size     int
+	Offset   int
+	Signed   bool
+	Array    bool
+	ArrayLen int
+	Dataloc  bool
+	Relloc   bool
+}
+
+// UseFormatCache makes event types registered from now on come from the
+// cache in filename, which is created if it doesn't exist.  A cache
+// written for another kernel build is discarded.  SaveFormatCache writes
+// back the types that weren't in it.
+func (f *Ftrace) UseFormatCache(filename string) error {
+	c := &formatCache{
+		filename: filename,
+		kernel:   kernelBuild(f.fp),
+		formats:  make(map[string]cachedFormat),
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		var file formatCacheFile
+		if err := json.Unmarshal(data, &file); err != nil {
+			return err
+		}
+		if file.Kernel == c.kernel && file.Formats != nil {
+			c.formats = file.Formats
+		} else {
+			c.dirty = true
+		}
+	}
+
+	f.formatCache = c
+	return nil
+}
+
+// SaveFormatCache writes the cache set by UseFormatCache, if any types
+// were added to it
+func (f *Ftrace) SaveFormatCache() error {
+	if f.formatCache == nil {
+		return nil
+	}
+	return f.formatCache.save()
+}
+
+// kernelBuild identifies the running kernel, the version string holds the
+// build number and time so it changes when a kernel is rebuilt
+func kernelBuild(fp FileProvider) string {
+	var build []string
+	for _, name := range []string{"sys/kernel/osrelease", "sys/kernel/version"} {
+		data, _ := fp.ReadProcFile(name)
+		build = append(build, strings.TrimSpace(string(data)))
+	}
+	return strings.Join(build, " ")
+}
+
+func formatSum(format []byte) string {
+	sum := sha256.Sum256(format)
+	return hex.EncodeToString(sum[:])
+}
+
+// load fills in etype from the entry for path, if there is one for format
+func (c *formatCache) load(etype *EventType, path string, format []byte) bool {
+	if c == nil {
+		return false
+	}
+	c.Lock()
+	cached, ok := c.formats[path]
+	c.Unlock()
+	if !ok || cached.Sum != formatSum(format) {
+		return false
+	}
+
+	etype.name = cached.Name
+	etype.id = cached.ID
+	etype.fields = make([]eventField, len(cached.Fields))
+	for i, f := range cached.Fields {
+		etype.fields[i] = eventField{
+			name:     f.Name,
+			ftype:    f.Type,
+			size:     f.Size,
+			offset:   f.Offset,
+			signed:   f.Signed,
+			array:    f.Array,
+			arrayLen: f.ArrayLen,
+			dataloc:  f.Dataloc,
+			relloc:   f.Relloc,
+		}
+	}
+	etype.printFmt = cached.PrintFmt
+	if formatFunc := etype.goFormatter(); formatFunc != nil {
+		etype.formatFunc = formatFunc
+	} else {
+		etype.lazyFormatter = new(sync.Once)
+	}
+	return true
+}
+
+// store adds the entry for an event type parsed from format
+func (c *formatCache) store(etype *EventType, path string, format []byte) {
+	if c == nil {
+		return
+	}
+	cached := cachedFormat{
+		Sum:      formatSum(format),
+		Name:     etype.name,
+		ID:       etype.id,
+		Fields:   make([]cachedField, len(etype.fields)),
+		PrintFmt: etype.printFmt,
+	}
+	for i, f := range etype.fields {
+		cached.Fields[i] = cachedField{
+			Name:     f.name,
+			Type:     f.ftype,
+			Size:     f.size,
+			Offset:   f.offset,
+			Signed:   f.signed,
+			Array:    f.array,
+			ArrayLen: f.arrayLen,
+			Dataloc:  f.dataloc,
+			Relloc:   f.relloc,
+		}
+	}
+
+	c.Lock()
+	c.formats[path] = cached
+	c.dirty = true
+	c.Unlock()
+}
+
+// save writes the cache to a temporary file renamed over the old one, so
+// that concurrent tools never read a partial cache
+func (c *formatCache) save() error {
+	c.Lock()
+	defer c.Unlock()
+	if !c.dirty {
+		return nil
+	}
+
+	data, err := json.Marshal(formatCacheFile{Kernel: c.kernel, Formats: c.formats})
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(c.filename), filepath.Base(c.filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), c.filename); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	c.dirty = false
+	return nil
+}
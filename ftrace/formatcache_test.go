@@ -0,0 +1,83 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func formatCacheFtrace(t *testing.T, filename, version string) *Ftrace {
+	f := newTestFtraceWith(t, map[string]string{
+		"/proc/sys/kernel/osrelease": "6.1.0\n",
+		"/proc/sys/kernel/version":   version,
+	})
+	if err := f.UseFormatCache(filename); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestFormatCache(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "formats")
+	page := &testPage{timestamp: 1000000000}
+	page.addEvent(500, schedWakeup(1234, "bash", 120, 1))
+
+	f := formatCacheFtrace(t, filename, "#1 SMP\n")
+	etype, err := f.NewEventType("sched/sched_wakeup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if etype.lazyFormatter != nil {
+		t.Error("want a parsed type when the cache is empty")
+	}
+	events, err := f.decodePage(0, page.bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := events[0].String()
+	if err := f.SaveFormatCache(); err != nil {
+		t.Fatal(err)
+	}
+
+	f = formatCacheFtrace(t, filename, "#1 SMP\n")
+	etype, err = f.NewEventType("sched/sched_wakeup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if etype.lazyFormatter == nil {
+		t.Fatal("want the type loaded from the cache")
+	}
+	if etype.ID() != 62 || etype.getFieldNum("target_cpu") < 0 {
+		t.Errorf("cached type has ID %d fields %v", etype.ID(), etype.Fields())
+	}
+	events, err = f.decodePage(0, page.bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := events[0].String(); got != want {
+		t.Errorf("want %q got %q", want, got)
+	}
+
+	// A rebuilt kernel discards the cache
+	f = formatCacheFtrace(t, filename, "#2 SMP\n")
+	etype, err = f.NewEventType("sched/sched_wakeup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if etype.lazyFormatter != nil {
+		t.Error("want a parsed type after the kernel changed")
+	}
+}
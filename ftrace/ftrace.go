@@ -15,19 +15,60 @@
 package ftrace
 
 import (
+	"bytes"
 	"fmt"
+	"path"
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type Ftrace struct {
-	fp                   FileProvider
-	eventTypes           map[int]*EventType
-	selectCases          []reflect.SelectCase
-	cachedProcessNames   map[int]string
-	isCachedProcessNames bool
-	cachedKallsyms       map[uint64]string
+	// metrics and windowStart are used with 64-bit atomics, so they come
+	// first to be 64-bit aligned on 32-bit cpus, where only the start of
+	// the struct is.  metrics is all 64-bit words, so windowStart is too.
+	metrics metrics
+	// windowStart is the timestamp of the first event, for relative time
+	// windows
+	windowStart uint64
+
+	fp          *closableFileProvider
+	eventTypes  map[int]*EventType
+	selectCases []reflect.SelectCase
+
+	// caches guards the process names, tgids, kernel symbols and printk
+	// formats, which events look up as they are formatted, possibly by
+	// several goroutines at once
+	caches              sync.Mutex
+	cachedProcessNames  map[int]string
+	processNamesRead    time.Time
+	missingProcessNames map[int]time.Time
+	cachedKallsyms      kernelSymbols
+	kallsymsRead        time.Time
+	userSymbolizer      *UserSymbolizer
+	options             CaptureOptions
+	device              string
+	clockOffset         int64
+	followed            *followedPids
+	recordTgid          bool
+	cachedTgids         map[int]int
+	tgidsRead           time.Time
+	lazyTypes           *lazyEventTypes
+	closeCh             chan struct{}
+	pipes               openPipes
+	stops               *stopTriggers
+	syscalls            map[int]string
+	printkFormats       map[uint64]string
+	printkFormatsRead   time.Time
+	defs                *KernelDefs
+	formatCache         *formatCache
+	previousBoot        bool
+
+	// dataMaxTypeLen is the largest type_len of data records
+	dataMaxTypeLen uint32
 
 	pageHeader               *EventType
 	pageHeaderFieldTimestamp int
@@ -37,8 +78,11 @@ type Ftrace struct {
 
 func New(fp FileProvider) (*Ftrace, error) {
 	f := &Ftrace{
-		fp:         fp,
+		fp:         &closableFileProvider{fp: fp},
 		eventTypes: make(map[int]*EventType),
+		closeCh:    make(chan struct{}),
+		stops:      newStopTriggers(),
+		defs:       NewKernelDefs(),
 	}
 
 	err := f.init()
@@ -60,18 +104,34 @@ func (f *Ftrace) init() error {
 	f.pageHeaderFieldCommit = f.pageHeader.getFieldNum("commit")
 	f.pageHeaderFieldData = f.pageHeader.getFieldNum("data")
 
+	f.pageHeader.defs = f.defs
+	f.dataMaxTypeLen = readDataMaxTypeLen(f.fp)
+	f.detectArch()
+
 	f.cachedProcessNames = make(map[int]string)
+	f.missingProcessNames = make(map[int]time.Time)
+
+	// Enums that the kernel didn't replace in format files
+	if data, err := f.fp.ReadFtraceFile("eval_map"); err == nil {
+		f.defs.LoadEvalMap(bytes.NewReader(data))
+	}
+
+	f.registerBuiltinTypes()
 
 	return nil
 }
 
 func (f *Ftrace) NewEventType(path string) (*EventType, error) {
-	etype, err := newEventType(f.fp, path)
+	etype, err := newEventType(f.fp, path, f.defs, f.formatCache)
 	if err != nil {
 		return nil, err
 	}
 
-	if f.eventTypes[etype.id] != nil {
+	if existing := f.eventTypes[etype.id]; existing != nil {
+		// The builtin events are registered by New
+		if existing.path == path && isBuiltinPath(path) {
+			return existing, nil
+		}
 		err := fmt.Errorf("event id %d already exists", etype.id)
 		return nil, err
 	}
@@ -80,6 +140,116 @@ func (f *Ftrace) NewEventType(path string) (*EventType, error) {
 	return etype, nil
 }
 
+// AvailableEvents returns the events the kernel can trace, from
+// available_events, as "<system>/<event>" paths that can be passed to
+// NewEventType
+func (f *Ftrace) AvailableEvents() ([]string, error) {
+	available, err := f.fp.ReadFtraceFile("available_events")
+	if err != nil {
+		return nil, err
+	}
+	return eventPaths(available), nil
+}
+
+// eventPaths converts the "system:event" lines of available_events and
+// set_event to "<system>/<event>" paths
+func eventPaths(list []byte) []string {
+	var events []string
+	for _, line := range strings.Split(string(list), "\n") {
+		v := strings.SplitN(strings.TrimSpace(line), ":", 2)
+		if len(v) != 2 || v[0] == "" || v[1] == "" {
+			continue
+		}
+		events = append(events, v[0]+"/"+v[1])
+	}
+	return events
+}
+
+// NewEventTypes registers every available event matching pattern, which
+// is a "<system>/<event>" path that may contain path.Match wildcards, such
+// as "sched/*" or "irq/irq_handler_*".  A pattern without wildcards is
+// passed to NewEventType.  Events that are already registered are returned
+// as they are, so patterns may overlap.
+func (f *Ftrace) NewEventTypes(pattern string) ([]*EventType, error) {
+	if !strings.ContainsAny(pattern, "*?[") {
+		if etype := f.eventTypeByPath(pattern); etype != nil {
+			return []*EventType{etype}, nil
+		}
+		etype, err := f.NewEventType(pattern)
+		if err != nil {
+			return nil, err
+		}
+		return []*EventType{etype}, nil
+	}
+
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	available, err := f.AvailableEvents()
+	if err != nil {
+		return nil, err
+	}
+
+	registered := make(map[string]*EventType)
+	for _, etype := range f.eventTypes {
+		registered[etype.path] = etype
+	}
+
+	var etypes []*EventType
+	for _, name := range available {
+		if matched, _ := path.Match(pattern, name); !matched {
+			continue
+		}
+		if etype := registered[name]; etype != nil {
+			etypes = append(etypes, etype)
+			continue
+		}
+		etype, err := f.NewEventType(name)
+		if err != nil {
+			return nil, err
+		}
+		etypes = append(etypes, etype)
+	}
+
+	if len(etypes) == 0 {
+		return nil, fmt.Errorf("no events match %s", pattern)
+	}
+	return etypes, nil
+}
+
+// SetDevice names the device this Ftrace reads from.  The name is returned
+// by Event.Device() for each of its events, to tell apart events from
+// several devices captured together with CaptureAll.
+func (f *Ftrace) SetDevice(name string) {
+	f.device = name
+}
+
+// SetClockOffset sets a number of nanoseconds added to the timestamp of
+// every event, to move events from several devices onto a common timebase.
+func (f *Ftrace) SetClockOffset(offset int64) {
+	f.clockOffset = offset
+}
+
+// SetTraceClock selects the clock used for event timestamps, for example
+// "local", "global", "mono", "boot" or "tai".  Devices with synchronized
+// wall clocks can be traced on a common timebase with "tai".
+func (f *Ftrace) SetTraceClock(clock string) error {
+	return f.fp.WriteFtraceFile("trace_clock", []byte(clock))
+}
+
+// EnableRecordTgid turns on the record-tgid trace option, which makes the
+// kernel save the thread group id of each traced thread in saved_tgids, and
+// adds the tgid column to Event.String to match the kernel's output.
+func (f *Ftrace) EnableRecordTgid() error {
+	err := f.fp.WriteFtraceFile("options/record-tgid", []byte("1"))
+	if err != nil {
+		return err
+	}
+	f.recordTgid = true
+	return nil
+}
+
 func (f *Ftrace) Enable() error {
 	return f.fp.WriteFtraceFile("tracing_on", []byte("1"))
 }
@@ -96,7 +266,101 @@ func (f *Ftrace) ReadKernelTrace() ([]byte, error) {
 	return f.fp.ReadFtraceFile("trace")
 }
 
+// CaptureOptions controls how PrepareCaptureWithOptions reads and decodes
+// the trace pipes.  The zero value gives the default behavior.
+type CaptureOptions struct {
+	// UnknownRecords selects what to do with ring buffer records of a type
+	// the decoder does not understand, for example from a newer kernel.
+	UnknownRecords UnknownRecordPolicy
+
+	// PinCPUs pins the goroutines reading and decoding each cpu's trace
+	// pipe to that cpu, to reduce cross-cpu cache traffic and perturbation
+	// of the traced system.  Only useful with a local FileProvider.
+	PinCPUs bool
+
+	// Priority sets the scheduling policy of the threads reading and
+	// decoding the trace pipes, so the capture either yields to the traced
+	// workload or keeps up with it.  The zero value leaves it unchanged.
+	Priority ThreadPriority
+
+	// Filter drops events that don't match it, see NewFilter
+	Filter *Filter
+
+	// DiscoverEventTypes registers the types of events that weren't
+	// registered with NewEventType when they are first decoded, by finding
+	// the format file with their ID, instead of dropping them.
+	DiscoverEventTypes bool
+
+	// Window drops events outside a time window, see TimeWindow
+	Window TimeWindow
+
+	// MaxBufferedEvents bounds the number of decoded events of each cpu
+	// held for Capture's callback when it falls behind, dropping events
+	// by DropPolicy beyond that.  The zero value buffers nothing, and the
+	// trace pipes aren't read while the callback is busy.
+	MaxBufferedEvents int
+	DropPolicy        DropPolicy
+}
+
+// SchedPolicy is a Linux scheduling policy for capture threads
+type SchedPolicy int
+
+const (
+	// SchedOther is the default time sharing policy, adjusted by Nice
+	SchedOther SchedPolicy = iota
+	// SchedIdle only runs the capture when nothing else wants the cpu
+	SchedIdle
+	// SchedFIFO runs the capture at a realtime priority, which needs
+	// CAP_SYS_NICE
+	SchedFIFO
+)
+
+func (p SchedPolicy) String() string {
+	switch p {
+	case SchedOther:
+		return "other"
+	case SchedIdle:
+		return "idle"
+	case SchedFIFO:
+		return "fifo"
+	}
+	return fmt.Sprintf("SchedPolicy(%d)", int(p))
+}
+
+type ThreadPriority struct {
+	Policy SchedPolicy
+	// Nice level for SchedOther, -20 to 19
+	Nice int
+	// Realtime priority for SchedFIFO, 1 to 99
+	RTPriority int
+}
+
+// setupCaptureThread applies the thread options to the calling goroutine,
+// which reads or decodes a cpu's trace pipe
+func (f *Ftrace) setupCaptureThread(cpu int) {
+	if f.options.PinCPUs {
+		if err := pinThread(cpu); err != nil {
+			fmt.Println(err.Error())
+		}
+	}
+	if f.options.Priority != (ThreadPriority{}) {
+		if err := setThreadPriority(f.options.Priority); err != nil {
+			fmt.Println(err.Error())
+		}
+	}
+}
+
 func (f *Ftrace) PrepareCapture(cpus int, doneCh <-chan bool) error {
+	return f.PrepareCaptureWithOptions(cpus, doneCh, CaptureOptions{})
+}
+
+func (f *Ftrace) PrepareCaptureWithOptions(cpus int, doneCh <-chan bool, options CaptureOptions) error {
+	f.options = options
+	f.windowStart = 0
+	f.previousBoot = false
+	if options.DiscoverEventTypes && f.lazyTypes == nil {
+		f.lazyTypes = &lazyEventTypes{}
+	}
 	f.selectCases = []reflect.SelectCase{
 		reflect.SelectCase{
 			Dir:  reflect.SelectRecv,
@@ -132,56 +396,141 @@ func (f *Ftrace) Capture(callback func(Events)) {
 			continue
 		}
 		if recv.Type() == eventArrayType {
+			atomic.AddInt64(&f.metrics.pagesDelivered, 1)
 			events := recv.Interface().(Events)
 			callback(events)
 		}
+		select {
+		case <-f.stops.stopped:
+			return
+		default:
+		}
 	}
 }
 
+// CaptureAll captures from several prepared Ftrace objects at once, calling
+// callback with the events of each one as they arrive.  Calls to callback
+// are serialized.  It returns when all of the captures have ended.
+func CaptureAll(ftraces []*Ftrace, callback func(Events)) {
+	eventsCh := make(chan Events)
+	doneCh := make(chan bool)
+
+	for _, f := range ftraces {
+		go func(f *Ftrace) {
+			f.Capture(func(events Events) {
+				eventsCh <- events
+			})
+			doneCh <- true
+		}(f)
+	}
+
+	for running := len(ftraces); running > 0; {
+		select {
+		case events := <-eventsCh:
+			callback(events)
+		case <-doneCh:
+			running--
+		}
+	}
+}
+
+// processNameRefreshInterval limits how often saved_cmdlines and saved_tgids
+// are reread, and how often /proc/<pid>/comm is retried for a pid with no
+// known name
+const processNameRefreshInterval = time.Second
+
+// processName returns the name of a pid from saved_cmdlines.  Processes
+// started after saved_cmdlines was last read are found by rereading it, or
+// failing that from /proc/<pid>/comm, which may be the name after an exec
+// rather than at the time of the event.
 func (f *Ftrace) processName(pid int) string {
-	if !f.isCachedProcessNames {
-		f.isCachedProcessNames = true
-		processNameFile, err := f.fp.ReadFtraceFile("saved_cmdlines")
-		if err != nil {
-			return ""
+	f.caches.Lock()
+	defer f.caches.Unlock()
+
+	if n, ok := f.cachedProcessNames[pid]; ok {
+		return n
+	}
+
+	now := time.Now()
+	if now.Sub(f.processNamesRead) >= processNameRefreshInterval {
+		f.processNamesRead = now
+		f.readSavedCmdlines()
+		if n, ok := f.cachedProcessNames[pid]; ok {
+			return n
 		}
-		processNames := strings.Split(string(processNameFile), "\n")
-		for _, n := range processNames {
-			v := strings.SplitN(n, " ", 2)
-			if len(v) != 2 {
-				continue
-			}
-			p, err := strconv.Atoi(v[0])
-			if err != nil {
-				continue
-			}
-			f.cachedProcessNames[p] = v[1]
+	}
+
+	if now.Sub(f.missingProcessNames[pid]) >= processNameRefreshInterval {
+		comm, err := f.fp.ReadProcFile(strconv.Itoa(pid) + "/comm")
+		if n := strings.TrimSuffix(string(comm), "\n"); err == nil && n != "" {
+			delete(f.missingProcessNames, pid)
+			f.cachedProcessNames[pid] = n
+			return n
 		}
+		f.missingProcessNames[pid] = now
 	}
 
-	return f.cachedProcessNames[pid]
+	return ""
 }
 
-func (f *Ftrace) kernelSymbol(addr uint64) string {
-	if f.cachedKallsyms == nil {
-		f.cachedKallsyms = make(map[uint64]string)
-		// TODO: through fp
-		kallsymsFile, err := f.fp.ReadProcFile("kallsyms")
+func (f *Ftrace) readSavedCmdlines() {
+	processNameFile, err := f.fp.ReadFtraceFile("saved_cmdlines")
+	if err != nil {
+		return
+	}
+	processNames := strings.Split(string(processNameFile), "\n")
+	for _, n := range processNames {
+		v := strings.SplitN(n, " ", 2)
+		if len(v) != 2 {
+			continue
+		}
+		p, err := strconv.Atoi(v[0])
 		if err != nil {
-			return ""
-		}
-		kallsyms := strings.Split(string(kallsymsFile), "\n")
-		for _, k := range kallsyms {
-			v := strings.SplitN(k, " ", 3)
-			if len(v) != 3 {
-				continue
-			}
-			a, err := strconv.ParseUint(v[0], 16, 64)
-			if err != nil {
-				continue
-			}
-			f.cachedKallsyms[a] = strings.Replace(v[2], "\t", " ", -1)
-		}
-	}
-	return f.cachedKallsyms[addr]
+			continue
+		}
+		f.cachedProcessNames[p] = v[1]
+	}
+}
+
+// processTgid returns the thread group id of a pid from saved_tgids, which
+// is reread for threads started since it was last read
+func (f *Ftrace) processTgid(pid int) int {
+	f.caches.Lock()
+	defer f.caches.Unlock()
+
+	if tgid, ok := f.cachedTgids[pid]; ok {
+		return tgid
+	}
+
+	now := time.Now()
+	if now.Sub(f.tgidsRead) >= processNameRefreshInterval {
+		f.tgidsRead = now
+		f.readSavedTgids()
+	}
+	return f.cachedTgids[pid]
+}
+
+func (f *Ftrace) readSavedTgids() {
+	tgidFile, err := f.fp.ReadFtraceFile("saved_tgids")
+	if err != nil {
+		return
+	}
+	if f.cachedTgids == nil {
+		f.cachedTgids = make(map[int]int)
+	}
+	for _, line := range strings.Split(string(tgidFile), "\n") {
+		v := strings.Fields(line)
+		if len(v) != 2 {
+			continue
+		}
+		p, err := strconv.Atoi(v[0])
+		if err != nil {
+			continue
+		}
+		tgid, err := strconv.Atoi(v[1])
+		if err != nil {
+			continue
+		}
+		f.cachedTgids[p] = tgid
+	}
 }
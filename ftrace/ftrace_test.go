@@ -0,0 +1,429 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"encoding/binary"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+const headerPageFormat = `	field: u64 timestamp;	offset:0;	size:8;	signed:0;
+	field: local_t commit;	offset:8;	size:8;	signed:1;
+	field: int overwrite;	offset:8;	size:1;	signed:1;
+	field: char data;	offset:16;	size:4080;	signed:1;
+`
+
+const schedWakeupFormat = `name: sched_wakeup
+ID: 62
+format:
+	field:unsigned short common_type;	offset:0;	size:2;	signed:0;
+	field:unsigned char common_flags;	offset:2;	size:1;	signed:0;
+	field:unsigned char common_preempt_count;	offset:3;	size:1;	signed:0;
+	field:int common_pid;	offset:4;	size:4;	signed:1;
+
+	field:char comm[16];	offset:8;	size:16;	signed:0;
+	field:pid_t pid;	offset:24;	size:4;	signed:1;
+	field:int prio;	offset:28;	size:4;	signed:1;
+	field:int success;	offset:32;	size:4;	signed:1;
+	field:int target_cpu;	offset:36;	size:4;	signed:1;
+
+print fmt: "comm=%s pid=%d prio=%d success=%d target_cpu=%03d", REC->comm, REC->pid, REC->prio, REC->success, REC->target_cpu
+`
+
+const taskNewtaskFormat = `name: task_newtask
+ID: 110
+format:
+	field:unsigned short common_type;	offset:0;	size:2;	signed:0;
+	field:unsigned char common_flags;	offset:2;	size:1;	signed:0;
+	field:unsigned char common_preempt_count;	offset:3;	size:1;	signed:0;
+	field:int common_pid;	offset:4;	size:4;	signed:1;
+
+	field:pid_t pid;	offset:8;	size:4;	signed:1;
+	field:char comm[16];	offset:12;	size:16;	signed:1;
+	field:unsigned long clone_flags;	offset:32;	size:8;	signed:0;
+	field:short oom_score_adj;	offset:40;	size:2;	signed:1;
+
+print fmt: "pid=%d comm=%s clone_flags=%lx oom_score_adj=%hd", REC->pid, REC->comm, REC->clone_flags, REC->oom_score_adj
+`
+
+var testFiles = map[string]string{
+	"/sys/kernel/debug/tracing/events/header_page":               headerPageFormat,
+	"/sys/kernel/debug/tracing/events/sched/sched_wakeup/format": schedWakeupFormat,
+	"/sys/kernel/debug/tracing/events/task/task_newtask/format":  taskNewtaskFormat,
+	"/sys/kernel/debug/tracing/saved_cmdlines":                   "1234 bash\n",
+	"/sys/kernel/debug/tracing/saved_tgids":                      "1234 1200\n",
+}
+
+func newTestFtrace(t *testing.T, files map[string]string) *Ftrace {
+	f, err := New(NewTestFileProvider(files))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// testFilesWith returns a copy of testFiles with the files in extra added or
+// replaced, for tests that change the files once the Ftrace is created
+func testFilesWith(extra map[string]string) map[string]string {
+	files := make(map[string]string, len(testFiles)+len(extra))
+	for k, v := range testFiles {
+		files[k] = v
+	}
+	for k, v := range extra {
+		files[k] = v
+	}
+	return files
+}
+
+// newTestFtraceWith is newTestFtrace of testFiles with the files in extra
+// added or replaced
+func newTestFtraceWith(t *testing.T, extra map[string]string) *Ftrace {
+	return newTestFtrace(t, testFilesWith(extra))
+}
+
+// testPage builds a raw ring buffer page from a list of records
+type testPage struct {
+	timestamp uint64
+	data      []byte
+}
+
+func (p *testPage) addRecord(typeLen int, delta uint32, payload []byte) {
+	p.data = binary.LittleEndian.AppendUint32(p.data, uint32(typeLen)|delta<<entryTimeDeltaShift)
+	p.data = append(p.data, payload...)
+}
+
+// addEvent adds a data record, payload is padded to a multiple of 4 bytes
+func (p *testPage) addEvent(delta uint32, payload []byte) {
+	for len(payload)%4 != 0 {
+		payload = append(payload, 0)
+	}
+	p.addRecord(len(payload)/4, delta, payload)
+}
+
+func (p *testPage) bytes() []byte {
+	page := binary.LittleEndian.AppendUint64(nil, p.timestamp)
+	page = binary.LittleEndian.AppendUint64(page, uint64(len(p.data)))
+	page = append(page, p.data...)
+	return append(page, make([]byte, 4096-len(page))...)
+}
+
+func schedWakeup(pid int, comm string, prio, targetCpu int) []byte {
+	b := make([]byte, 40)
+	binary.LittleEndian.PutUint16(b[0:], 62)
+	binary.LittleEndian.PutUint32(b[4:], uint32(pid))
+	copy(b[8:24], comm)
+	binary.LittleEndian.PutUint32(b[24:], uint32(pid))
+	binary.LittleEndian.PutUint32(b[28:], uint32(prio))
+	binary.LittleEndian.PutUint32(b[32:], 1)
+	binary.LittleEndian.PutUint32(b[36:], uint32(targetCpu))
+	return b
+}
+
+func taskNewtask(parent, child int, comm string) []byte {
+	b := make([]byte, 44)
+	binary.LittleEndian.PutUint16(b[0:], 110)
+	binary.LittleEndian.PutUint32(b[4:], uint32(parent))
+	binary.LittleEndian.PutUint32(b[8:], uint32(child))
+	copy(b[12:28], comm)
+	return b
+}
+
+func TestDecodePage(t *testing.T) {
+	f := newTestFtrace(t, testFiles)
+	if _, err := f.NewEventType("sched/sched_wakeup"); err != nil {
+		t.Fatal(err)
+	}
+
+	page := &testPage{timestamp: 1000000000}
+	page.addEvent(500, schedWakeup(1234, "bash", 120, 1))
+
+	events, err := f.decodePage(2, page.bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("want 1 event got %d", len(events))
+	}
+
+	want := "            bash-1234  [002] ....      1.000001: sched_wakeup: comm=bash pid=1234 prio=120 success=1 target_cpu=001"
+	if got := events[0].String(); got != want {
+		t.Errorf("want\n%s\ngot\n%s", want, got)
+	}
+
+	f.recordTgid = true
+	want = "            bash-1234  ( 1200) [002] ....      1.000001: sched_wakeup: comm=bash pid=1234 prio=120 success=1 target_cpu=001"
+	if got := events[0].String(); got != want {
+		t.Errorf("want\n%s\ngot\n%s", want, got)
+	}
+}
+
+// futureHeaderEvent is events/header_event of a kernel with a record type
+// more than this package knows, 28
+const futureHeaderEvent = `# compressed entry header
+	type_len    :    5 bits
+	time_delta  :   27 bits
+	array       :   32 bits
+
+	padding     : type == 29
+	time_extend : type == 30
+	time_stamp : type == 31
+	data max type_len  == 27
+`
+
+func TestUnknownRecordPolicy(t *testing.T) {
+	f := newTestFtraceWith(t, map[string]string{
+		"/sys/kernel/debug/tracing/events/header_event": futureHeaderEvent,
+	})
+	if _, err := f.NewEventType("sched/sched_wakeup"); err != nil {
+		t.Fatal(err)
+	}
+
+	page := &testPage{}
+	page.addEvent(0, schedWakeup(1, "a", 120, 0))
+	page.addRecord(28, 0, []byte{0xaa, 0xbb, 0xcc, 0xdd})
+	page.addEvent(0, schedWakeup(2, "b", 120, 0))
+
+	for _, policy := range []UnknownRecordPolicy{SkipUnknownRecords, AbortOnUnknownRecords, EmitUnknownRecords} {
+		f.options.UnknownRecords = policy
+		events, err := f.decodePage(0, page.bytes())
+		if _, ok := err.(UnknownRecord); !ok {
+			t.Errorf("policy %d: want UnknownRecord error, got %v", policy, err)
+		}
+
+		want := 1
+		if policy == EmitUnknownRecords {
+			want = 2
+		}
+		if len(events) != want {
+			t.Fatalf("policy %d: want %d events got %d", policy, want, len(events))
+		}
+		if policy == EmitUnknownRecords {
+			if events[1].etype.Name() != "unknown_record" {
+				t.Errorf("want unknown_record event, got %s", events[1].etype.Name())
+			}
+			if len(events[1].contents) != 4+4+4+40 {
+				t.Errorf("want unknown_record to contain the rest of the page, got %d bytes",
+					len(events[1].contents))
+			}
+		}
+	}
+}
+
+func TestTimeStampRecord(t *testing.T) {
+	f := newTestFtrace(t, testFiles)
+	if _, err := f.NewEventType("sched/sched_wakeup"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The top bits of the page's time are kept, the rest replaced
+	page := &testPage{timestamp: 1<<59 | 1000}
+	page.addEvent(100, schedWakeup(1, "a", 120, 0))
+	stamp := uint64(5000000000)
+	page.addRecord(entryTypeTimeStamp, uint32(stamp)&entryTimeDeltaMask,
+		binary.LittleEndian.AppendUint32(nil, uint32(stamp>>entryTimeDeltaBits)))
+	page.addEvent(10, schedWakeup(2, "b", 120, 0))
+
+	events, err := f.decodePage(0, page.bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("want 2 events got %d", len(events))
+	}
+	if want := uint64(1<<59 | 1100); events[0].When != want {
+		t.Errorf("want %d got %d", want, events[0].When)
+	}
+	if want := 1<<59 | stamp + 10; events[1].When != want {
+		t.Errorf("want %d after the time stamp got %d", want, events[1].When)
+	}
+}
+
+func TestCaptureAll(t *testing.T) {
+	var ftraces []*Ftrace
+	for i, device := range []string{"phone", "watch"} {
+		page := &testPage{timestamp: uint64(1000 * (i + 1))}
+		page.addEvent(0, schedWakeup(100+i, device, 120, 0))
+
+		files := testFilesWith(map[string]string{
+			"per_cpu/cpu0/trace_pipe_raw": string(page.bytes()),
+		})
+
+		f := newTestFtrace(t, files)
+		if _, err := f.NewEventType("sched/sched_wakeup"); err != nil {
+			t.Fatal(err)
+		}
+		f.SetDevice(device)
+		f.SetClockOffset(int64(-1000 * i))
+		if err := f.PrepareCapture(1, make(chan bool)); err != nil {
+			t.Fatal(err)
+		}
+		ftraces = append(ftraces, f)
+	}
+
+	var events Events
+	CaptureAll(ftraces, func(e Events) {
+		events = append(events, e...)
+	})
+	sort.Sort(EventsByTime{events})
+
+	if len(events) != 2 {
+		t.Fatalf("want 2 events got %d", len(events))
+	}
+	for i, device := range []string{"phone", "watch"} {
+		if events[i].Device() != device {
+			t.Errorf("event %d: want device %s got %s", i, device, events[i].Device())
+		}
+		if events[i].When != 1000 {
+			t.Errorf("event %d: want timestamp 1000 got %d", i, events[i].When)
+		}
+	}
+}
+
+func TestFollowPid(t *testing.T) {
+	f := newTestFtrace(t, testFiles)
+	if _, err := f.NewEventType("sched/sched_wakeup"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.FollowPid(100); err != nil {
+		t.Fatal(err)
+	}
+
+	page := &testPage{}
+	page.addEvent(0, schedWakeup(99, "other", 120, 0))
+	page.addEvent(0, schedWakeup(100, "root", 120, 0))
+	page.addEvent(0, taskNewtask(100, 101, "child"))
+	page.addEvent(0, schedWakeup(101, "child", 120, 0))
+	page.addEvent(0, taskNewtask(99, 102, "other child"))
+	page.addEvent(0, schedWakeup(102, "other child", 120, 0))
+
+	events, err := f.decodePage(0, page.bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pids []int
+	for _, e := range events {
+		pids = append(pids, e.Pid)
+	}
+	if want := []int{100, 100, 101}; !reflect.DeepEqual(pids, want) {
+		t.Errorf("want events from pids %v got %v", want, pids)
+	}
+	if want := []int{100, 101}; !reflect.DeepEqual(f.FollowedPids(), want) {
+		t.Errorf("want followed pids %v got %v", want, f.FollowedPids())
+	}
+}
+
+func TestProcessNameRefresh(t *testing.T) {
+	files := testFilesWith(nil)
+	f := newTestFtrace(t, files)
+
+	if n := f.processName(1234); n != "bash" {
+		t.Errorf("want bash got %s", n)
+	}
+
+	// A process started after saved_cmdlines was read is found in /proc
+	files["/proc/4321/comm"] = "late\n"
+	if n := f.processName(4321); n != "late" {
+		t.Errorf("want late got %s", n)
+	}
+
+	// Misses are not retried immediately
+	if n := f.processName(5555); n != "" {
+		t.Errorf("want no name got %s", n)
+	}
+	files["/proc/5555/comm"] = "later\n"
+	if n := f.processName(5555); n != "" {
+		t.Errorf("want no name before the refresh interval got %s", n)
+	}
+	f.missingProcessNames[5555] = time.Time{}
+	if n := f.processName(5555); n != "later" {
+		t.Errorf("want later got %s", n)
+	}
+}
+
+func TestProcessTgidRefresh(t *testing.T) {
+	files := testFilesWith(nil)
+	f := newTestFtrace(t, files)
+
+	if tgid := f.processTgid(1234); tgid != 1200 {
+		t.Errorf("want tgid 1200 got %d", tgid)
+	}
+
+	// A thread started after saved_tgids was read is found by rereading
+	// it, once the refresh interval has passed
+	files["/sys/kernel/debug/tracing/saved_tgids"] += "4321 4300\n"
+	if tgid := f.processTgid(4321); tgid != 0 {
+		t.Errorf("want no tgid before the refresh interval got %d", tgid)
+	}
+	f.tgidsRead = time.Time{}
+	if tgid := f.processTgid(4321); tgid != 4300 {
+		t.Errorf("want tgid 4300 got %d", tgid)
+	}
+}
+
+func TestAvailableEvents(t *testing.T) {
+	f := newTestFtraceWith(t, map[string]string{
+		"/sys/kernel/debug/tracing/available_events": "sched:sched_wakeup\ntask:task_newtask\n\nbogus\nirq:softirq_entry\n",
+	})
+
+	events, err := f.AvailableEvents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"sched/sched_wakeup", "task/task_newtask", "irq/softirq_entry"}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("want %v got %v", want, events)
+	}
+}
+
+func TestNewEventTypes(t *testing.T) {
+	f := newTestFtraceWith(t, map[string]string{
+		"/sys/kernel/debug/tracing/available_events": "sched:sched_wakeup\ntask:task_newtask\n",
+	})
+
+	etypes, err := f.NewEventTypes("sched/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(etypes) != 1 || etypes[0].Name() != "sched_wakeup" {
+		t.Errorf("want [sched_wakeup] got %v", etypes)
+	}
+
+	// Registered events are returned again
+	etypes, err = f.NewEventTypes("*/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(etypes) != 2 || etypes[0].Name() != "sched_wakeup" || etypes[1].Name() != "task_newtask" {
+		t.Errorf("want [sched_wakeup task_newtask] got %v", etypes)
+	}
+	etypes, err = f.NewEventTypes("sched/sched_wakeup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(etypes) != 1 || etypes[0] != f.eventTypeByPath("sched/sched_wakeup") {
+		t.Errorf("want the registered sched_wakeup got %v", etypes)
+	}
+
+	if _, err := f.NewEventTypes("irq/*"); err == nil {
+		t.Error("expected an error for a pattern matching nothing")
+	}
+	if _, err := f.NewEventTypes("sched/["); err == nil {
+		t.Error("expected an error for a bad pattern")
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// NoPreviousBoot is returned by ReadPreviousBoot when the instance's buffer
+// holds events of the current boot
+var NoPreviousBoot error = errors.New("Buffer holds no events of the previous boot")
+
+// instanceGlobalFiles are the tracing files only found at the top level,
+// shared by every instance
+var instanceGlobalFiles = map[string]bool{
+	"available_events": true,
+	"eval_map":         true,
+	"printk_formats":   true,
+	"saved_cmdlines":   true,
+	"saved_tgids":      true,
+}
+
+// instanceFileProvider reads and writes the tracing files of an instance
+// instead of the top level ones
+type instanceFileProvider struct {
+	fp     FileProvider
+	prefix string
+}
+
+// NewInstanceFileProvider returns a FileProvider for the tracing instance
+// name, the directory instances/<name> of the tracefs mount, which has its
+// own ring buffers, events and options.  Files that only exist at the top
+// level, like available_events and saved_cmdlines, are read from there.
+// Persistent ring buffers, set up with the reserve_mem= and
+// trace_instance=<name>@<reserved> boot parameters, are such instances.
+func NewInstanceFileProvider(fp FileProvider, name string) FileProvider {
+	return &instanceFileProvider{fp: fp, prefix: path.Join("instances", name)}
+}
+
+func (fp *instanceFileProvider) instancePath(filename string) string {
+	if instanceGlobalFiles[filename] {
+		return filename
+	}
+	return path.Join(fp.prefix, filename)
+}
+
+func (fp *instanceFileProvider) ReadFtraceFile(filename string) ([]byte, error) {
+	return fp.fp.ReadFtraceFile(fp.instancePath(filename))
+}
+
+func (fp *instanceFileProvider) WriteFtraceFile(filename string, data []byte) error {
+	return fp.fp.WriteFtraceFile(fp.instancePath(filename), data)
+}
+
+func (fp *instanceFileProvider) ReadProcFile(filename string) ([]byte, error) {
+	return fp.fp.ReadProcFile(filename)
+}
+
+func (fp *instanceFileProvider) ReadPstoreFile(filename string) ([]byte, error) {
+	return fp.fp.ReadPstoreFile(filename)
+}
+
+func (fp *instanceFileProvider) OpenFtrace(filename string) (io.ReadCloser, error) {
+	return fp.fp.OpenFtrace(fp.instancePath(filename))
+}
+
+// LastBootInfo is the content of a persistent instance's last_boot_info
+// file
+type LastBootInfo struct {
+	// PreviousBoot is set when the buffer still holds the events traced
+	// before the last reboot.  It is cleared by the kernel once the
+	// buffer is reset, by tracing into it or clearing it.
+	PreviousBoot bool
+	// TextAddr is the address of the previous boot's kernel text, which
+	// differs from the current one's when KASLR moved it
+	TextAddr uint64
+	// Modules are the addresses modules were loaded at in the previous
+	// boot, for kernels that record them
+	Modules []LastBootModule
+}
+
+// LastBootModule is a module loaded in the previous boot
+type LastBootModule struct {
+	Addr uint64
+	Name string
+}
+
+// LastBootInfo reads the last_boot_info file of the instance the Ftrace was
+// created for with NewInstanceFileProvider.  It fails for instances without
+// a persistent ring buffer, and on kernels before 6.12.
+func (f *Ftrace) LastBootInfo() (*LastBootInfo, error) {
+	data, err := f.fp.ReadFtraceFile("last_boot_info")
+	if err != nil {
+		return nil, err
+	}
+	return parseLastBootInfo(data)
+}
+
+// parseLastBootInfo parses "# Current", or "<addr>\t[kernel]" followed by
+// a "<addr>\t<module>" line for each module
+func parseLastBootInfo(data []byte) (*LastBootInfo, error) {
+	info := &LastBootInfo{}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		v := strings.Fields(line)
+		if len(v) != 2 {
+			return nil, fmt.Errorf("last_boot_info line %d: bad line %q", i+1, line)
+		}
+		addr, err := strconv.ParseUint(v[0], 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("last_boot_info line %d: %v", i+1, err)
+		}
+		if v[1] == "[kernel]" {
+			info.PreviousBoot = true
+			info.TextAddr = addr
+		} else {
+			info.Modules = append(info.Modules, LastBootModule{Addr: addr, Name: v[1]})
+		}
+	}
+	return info, nil
+}
+
+// ReadPreviousBoot reads the events a persistent instance's buffer kept
+// through the last reboot, from the first cpus cpus and sorted by time, or
+// returns NoPreviousBoot if the buffer was reset since.  The events are
+// marked with PreviousBoot.  Event types are found as they are decoded,
+// by their IDs in the current kernel, so the events of a different build
+// may be misread.  Pids are of the previous boot and aren't named.
+// Reading empties the buffer, so the events can only be read once; cpus
+// beyond those with a buffer are skipped as with Snapshot.
+func (f *Ftrace) ReadPreviousBoot(cpus int, options CaptureOptions) (Events, error) {
+	info, err := f.LastBootInfo()
+	if err != nil {
+		return nil, err
+	}
+	if !info.PreviousBoot {
+		return nil, NoPreviousBoot
+	}
+
+	if f.lazyTypes == nil {
+		f.lazyTypes = &lazyEventTypes{}
+	}
+	return f.snapshot(cpus, options, true)
+}
@@ -0,0 +1,142 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// bootInstanceFiles are testFiles with the events also in the instance
+// boot_map, whose last_boot_info is lastBootInfo
+func bootInstanceFiles(lastBootInfo string) map[string]string {
+	files := testFilesWith(map[string]string{
+		"/sys/kernel/debug/tracing/available_events":                  "sched:sched_wakeup\n",
+		"/sys/kernel/debug/tracing/events/sched/sched_wakeup/id":      "62\n",
+		"/sys/kernel/debug/tracing/instances/boot_map/last_boot_info": lastBootInfo,
+		"instances/boot_map/per_cpu/cpu0/trace_pipe_raw":              string(wakeupPage(1, 2)),
+		"per_cpu/cpu0/trace_pipe_raw":                                 string(wakeupPage(3)),
+	})
+	for k, v := range files {
+		if strings.HasPrefix(k, ftracePath+"/events/") {
+			files[strings.Replace(k, ftracePath, ftracePath+"/instances/boot_map", 1)] = v
+		}
+	}
+	return files
+}
+
+func TestParseLastBootInfo(t *testing.T) {
+	info, err := parseLastBootInfo([]byte("ffffffff9a000000\t[kernel]\nffffffffc0a00000\text4\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.PreviousBoot || info.TextAddr != 0xffffffff9a000000 {
+		t.Errorf("want the previous boot's text address got %+v", info)
+	}
+	if len(info.Modules) != 1 || info.Modules[0] != (LastBootModule{0xffffffffc0a00000, "ext4"}) {
+		t.Errorf("want ext4 got %v", info.Modules)
+	}
+
+	info, err = parseLastBootInfo([]byte("# Current\n"))
+	if err != nil || info.PreviousBoot {
+		t.Errorf("want the current boot got %+v, %v", info, err)
+	}
+
+	if _, err := parseLastBootInfo([]byte("kernel\n")); err == nil {
+		t.Error("want an error for a bad line")
+	}
+}
+
+func TestReadPreviousBoot(t *testing.T) {
+	fp := NewTestFileProvider(bootInstanceFiles("ffffffff9a000000\t[kernel]\n"))
+	f, err := New(NewInstanceFileProvider(fp, "boot_map"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := f.ReadPreviousBoot(1, CaptureOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Pid != 1 || events[1].Pid != 2 {
+		t.Fatalf("want the instance's 2 events got %v", events)
+	}
+	for _, e := range events {
+		if !e.PreviousBoot || e.ProcessName() != "<...>" {
+			t.Errorf("want an unnamed previous boot event got %v", e)
+		}
+	}
+	data, err := json.Marshal(events[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"previous_boot":true`) {
+		t.Errorf("want previous_boot in %s", data)
+	}
+
+	// Live events of the same Ftrace aren't marked
+	if _, err := f.NewEventType("sched/sched_wakeup"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.PrepareCapture(1, make(chan bool)); err != nil {
+		t.Fatal(err)
+	}
+	f.Capture(func(events Events) {
+		for _, e := range events {
+			if e.PreviousBoot {
+				t.Errorf("want a live event got %v", e)
+			}
+		}
+	})
+}
+
+func TestReadPreviousBootReset(t *testing.T) {
+	fp := NewTestFileProvider(bootInstanceFiles("# Current\n"))
+	f, err := New(NewInstanceFileProvider(fp, "boot_map"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.ReadPreviousBoot(1, CaptureOptions{}); err != NoPreviousBoot {
+		t.Errorf("want NoPreviousBoot got %v", err)
+	}
+}
+
+func TestReadPreviousBootLocal(t *testing.T) {
+	dir := t.TempDir()
+	writeTracefs(t, dir, map[string]string{
+		"available_events":                                    "sched:sched_wakeup\n",
+		"events/header_page":                                  headerPageFormat,
+		"instances/boot_map/events/header_page":               headerPageFormat,
+		"instances/boot_map/tracing_on":                       "0\n",
+		"instances/boot_map/last_boot_info":                   "ffffffff9a000000\t[kernel]\n",
+		"instances/boot_map/events/sched/sched_wakeup/id":     "62\n",
+		"instances/boot_map/events/sched/sched_wakeup/format": schedWakeupFormat,
+		"instances/boot_map/per_cpu/cpu0/stats":               "entries: 2\n",
+		"instances/boot_map/per_cpu/cpu0/trace_pipe_raw":      string(wakeupPage(1, 2)),
+	})
+	f, err := New(NewInstanceFileProvider(NewLocalFileProviderAt(dir, t.TempDir()), "boot_map"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := f.ReadPreviousBoot(32, CaptureOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || !events[0].PreviousBoot {
+		t.Errorf("want the 2 previous boot events of cpu 0 got %v", events)
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"context"
+	"iter"
+	"reflect"
+	"sync/atomic"
+)
+
+// Events returns the events of the capture set up with PrepareCapture, as
+// an alternative to Capture's callback:
+//
+//	for e, err := range s.Events(ctx) {
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
+//
+// The sequence ends like Capture, when the trace pipes end, the capture's
+// doneCh fires or a StopOn trigger fires.  It also ends when ctx is done,
+// with ctx's error as the last element.  Breaking out of the loop leaves
+// the capture's goroutines running until its doneCh fires or the Ftrace is
+// closed.
+func (s *Session) Events(ctx context.Context) iter.Seq2[*Event, error] {
+	f := s.f
+	return func(yield func(*Event, error) bool) {
+		eventArrayType := reflect.TypeOf(Events{})
+		cases := append([]reflect.SelectCase{{
+			Dir:  reflect.SelectRecv,
+			Chan: reflect.ValueOf(ctx.Done()),
+		}}, f.selectCases...)
+
+		for len(cases) > 2 {
+			if err := ctx.Err(); err != nil {
+				yield(nil, err)
+				return
+			}
+
+			chosen, recv, recvOK := reflect.Select(cases)
+			if chosen == 0 {
+				yield(nil, ctx.Err())
+				return
+			}
+			if chosen == 1 {
+				// The capture's doneCh
+				return
+			}
+			if !recvOK {
+				cases = append(cases[:chosen], cases[chosen+1:]...)
+				f.selectCases = append(f.selectCases[:chosen-1], f.selectCases[chosen:]...)
+				continue
+			}
+			if recv.Type() == eventArrayType {
+				atomic.AddInt64(&f.metrics.pagesDelivered, 1)
+				for _, e := range recv.Interface().(Events) {
+					if !yield(e, nil) {
+						return
+					}
+				}
+			}
+			select {
+			case <-f.stops.stopped:
+				return
+			default:
+			}
+		}
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"context"
+	"testing"
+)
+
+func iterFtrace(t *testing.T) (*Ftrace, *Session) {
+	page := &testPage{timestamp: 1000000000}
+	for pid := 1; pid <= 3; pid++ {
+		page.addEvent(100, schedWakeup(pid, "bash", 120, 1))
+	}
+	f := newTestFtraceWith(t, map[string]string{
+		"per_cpu/cpu0/trace_pipe_raw": string(page.bytes()),
+	})
+	if _, err := f.NewEventType("sched/sched_wakeup"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.PrepareCapture(1, make(chan bool)); err != nil {
+		t.Fatal(err)
+	}
+	s, err := f.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f, s
+}
+
+func TestSessionEvents(t *testing.T) {
+	_, s := iterFtrace(t)
+	var pids []int
+	for e, err := range s.Events(context.Background()) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		pids = append(pids, e.Pid)
+	}
+	if len(pids) != 3 || pids[0] != 1 || pids[2] != 3 {
+		t.Errorf("want pids 1 to 3 got %v", pids)
+	}
+
+	_, s = iterFtrace(t)
+	pids = nil
+	for e := range s.Events(context.Background()) {
+		pids = append(pids, e.Pid)
+		if len(pids) == 2 {
+			break
+		}
+	}
+	if len(pids) != 2 {
+		t.Errorf("want to stop after 2 events got %v", pids)
+	}
+
+	_, s = iterFtrace(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var errs []error
+	for e, err := range s.Events(ctx) {
+		if e != nil {
+			t.Errorf("want no events after cancel got %v", e)
+		}
+		errs = append(errs, err)
+	}
+	if len(errs) != 1 || errs[0] != context.Canceled {
+		t.Errorf("want context.Canceled got %v", errs)
+	}
+}
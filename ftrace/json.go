@@ -0,0 +1,89 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+type jsonEvent struct {
+	Timestamp uint64                 `json:"timestamp"`
+	Cpu       int                    `json:"cpu"`
+	Pid       int                    `json:"pid"`
+	Tgid      int                    `json:"tgid,omitempty"`
+	Comm      string                 `json:"comm"`
+	Device    string                 `json:"device,omitempty"`
+	Event     string                 `json:"event"`
+	Fields    map[string]interface{} `json:"fields"`
+	Stack     []string               `json:"kernel_stack,omitempty"`
+	// PreviousBoot marks events traced before the last reboot
+	PreviousBoot bool `json:"previous_boot,omitempty"`
+}
+
+// MarshalJSON encodes the event as an object with the timestamp in
+// nanoseconds, cpu, pid, comm, event name and a map of the event's fields,
+// without the common fields.  Strings are decoded, other arrays are encoded
+// as base64 of their raw bytes.
+func (e Event) MarshalJSON() ([]byte, error) {
+	j := jsonEvent{
+		Timestamp:    e.When,
+		Cpu:          e.Cpu,
+		Pid:          e.Pid,
+		Comm:         e.ProcessName(),
+		Device:       e.Device(),
+		Event:        e.etype.name,
+		PreviousBoot: e.PreviousBoot,
+	}
+	if e.ftrace.recordTgid {
+		j.Tgid = e.Tgid()
+	}
+
+	fields, err := e.fieldMap()
+	if err != nil {
+		return nil, err
+	}
+	j.Fields = fields
+	if e.kernelStack != nil {
+		j.Stack = e.KernelStackSymbols()
+	}
+
+	return json.Marshal(j)
+}
+
+// fieldMap returns the values of the event's fields, without the common
+// fields.  Strings are decoded, other arrays are returned as raw bytes.
+func (e Event) fieldMap() (map[string]interface{}, error) {
+	fields := make(map[string]interface{})
+	for _, f := range e.etype.fields {
+		if strings.HasPrefix(f.name, "common_") {
+			continue
+		}
+		if f.array && f.ftype != "char" || f.dataloc && f.ftype != "" {
+			b, err := e.Bytes(f.name)
+			if err != nil {
+				return nil, err
+			}
+			fields[f.name] = b
+			continue
+		}
+		v, err := e.Field(f.name)
+		if err != nil {
+			return nil, err
+		}
+		fields[f.name] = v.AsInterface()
+	}
+	return fields, nil
+}
@@ -0,0 +1,44 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestEventMarshalJSON(t *testing.T) {
+	f := newTestFtrace(t, testFiles)
+	etype, err := ParseEventFormat([]byte(schedWakeupFormat))
+	if err != nil {
+		t.Fatal(err)
+	}
+	etype.name = "sched_wakeup"
+	e, err := etype.DecodeEvent(schedWakeup(1234, "bash", 120, 2), 1, 1000001000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.ftrace = f
+
+	got, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"timestamp":1000001000,"cpu":1,"pid":1234,"comm":"bash","event":"sched_wakeup",` +
+		`"fields":{"comm":"bash","pid":1234,"prio":120,"success":1,"target_cpu":2}}`
+	if string(got) != want {
+		t.Errorf("want %s\ngot  %s", want, got)
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// kallsymsRefreshInterval limits how often kallsyms is reread when an address
+// can't be resolved, for example because a module was loaded mid-trace
+const kallsymsRefreshInterval = time.Second
+
+type kernelSymbol struct {
+	addr   uint64
+	name   string
+	module string
+}
+
+// kernelSymbols is a symbol table sorted by address
+type kernelSymbols []kernelSymbol
+
+func (s kernelSymbols) Len() int           { return len(s) }
+func (s kernelSymbols) Less(i, j int) bool { return s[i].addr < s[j].addr }
+func (s kernelSymbols) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// parseKallsyms parses the contents of /proc/kallsyms.  Symbols at address 0,
+// which is all of them when kptr_restrict hides addresses, are dropped.
+func parseKallsyms(kallsyms string) kernelSymbols {
+	var syms kernelSymbols
+	for _, k := range strings.Split(kallsyms, "\n") {
+		v := strings.SplitN(k, " ", 3)
+		if len(v) != 3 {
+			continue
+		}
+		a, err := strconv.ParseUint(v[0], 16, 64)
+		if err != nil || a == 0 {
+			continue
+		}
+		sym := kernelSymbol{addr: a, name: v[2]}
+		if tab := strings.IndexByte(v[2], '\t'); tab != -1 {
+			sym.name = v[2][:tab]
+			sym.module = strings.Trim(v[2][tab+1:], " []")
+		}
+		syms = append(syms, sym)
+	}
+	sort.Stable(syms)
+	return syms
+}
+
+// lookup finds the symbol containing addr.  The size of a symbol extends to
+// the next symbol at a higher address, so the last symbol has no size and
+// is never found.
+func (s kernelSymbols) lookup(addr uint64) (sym *kernelSymbol, offset, size uint64) {
+	i := sort.Search(len(s), func(i int) bool { return s[i].addr > addr })
+	if i == 0 || i == len(s) {
+		return nil, 0, 0
+	}
+	next := s[i].addr
+	start := s[i-1].addr
+	// Prefer the first of several symbols at the same address
+	for i > 1 && s[i-2].addr == start {
+		i--
+	}
+	return &s[i-1], addr - start, next - start
+}
+
+// format formats addr the way the kernel's %pS and %ps conversions do: the
+// symbol name, followed by "+0xoff/0xsize" if offset is true, followed by the
+// module name in brackets.  Addresses without a symbol are printed in hex.
+func (s kernelSymbols) format(addr uint64, offset bool) string {
+	sym, off, size := s.lookup(addr)
+	if sym == nil {
+		return fmt.Sprintf("0x%x", addr)
+	}
+
+	ret := sym.name
+	if offset {
+		ret += fmt.Sprintf("+0x%x/0x%x", off, size)
+	}
+	if sym.module != "" {
+		ret += " [" + sym.module + "]"
+	}
+	return ret
+}
+
+// formatBacktrace formats a return address the way the kernel's %pB does,
+// with the symbol and offset of the byte before it, plus one.  The call
+// that returns to addr can be the last instruction of a function that
+// doesn't return, so addr itself can be in the next function.
+func (s kernelSymbols) formatBacktrace(addr uint64) string {
+	sym, off, size := s.lookup(addr - 1)
+	if sym == nil {
+		return fmt.Sprintf("0x%x", addr)
+	}
+
+	ret := sym.name + fmt.Sprintf("+0x%x/0x%x", off+1, size)
+	if sym.module != "" {
+		ret += " [" + sym.module + "]"
+	}
+	return ret
+}
+
+// kallsyms returns the kernel's symbols.  If addr doesn't resolve kallsyms
+// is reread, as it may be in a module loaded since.  Rereads are limited to
+// one per kallsymsRefreshInterval, including when kallsyms couldn't be
+// read or kptr_restrict hid its addresses.
+func (f *Ftrace) kallsyms(addr uint64) kernelSymbols {
+	if f == nil {
+		// Events that weren't captured, see FormatFields
+		return nil
+	}
+	f.caches.Lock()
+	defer f.caches.Unlock()
+
+	now := time.Now()
+	if sym, _, _ := f.cachedKallsyms.lookup(addr); sym == nil &&
+		now.Sub(f.kallsymsRead) >= kallsymsRefreshInterval {
+
+		f.readKallsyms(now)
+	}
+	return f.cachedKallsyms
+}
+
+// kernelSymbol formats a kernel address as a symbol name, with an offset if
+// requested
+func (f *Ftrace) kernelSymbol(addr uint64, offset bool) string {
+	return f.kallsyms(addr).format(addr, offset)
+}
+
+// kernelBacktraceSymbol formats a return address from a stack trace
+func (f *Ftrace) kernelBacktraceSymbol(addr uint64) string {
+	return f.kallsyms(addr - 1).formatBacktrace(addr)
+}
+
+func (f *Ftrace) readKallsyms(now time.Time) {
+	f.kallsymsRead = now
+	kallsymsFile, err := f.fp.ReadProcFile("kallsyms")
+	if err != nil {
+		return
+	}
+	f.cachedKallsyms = parseKallsyms(string(kallsymsFile))
+}
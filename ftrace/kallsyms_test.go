@@ -0,0 +1,162 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"testing"
+	"time"
+)
+
+const testKallsyms = `ffffffff81000000 T _text
+ffffffff81000000 T startup_64
+ffffffff81000100 T secondary_startup_64
+ffffffff810a2c40 T try_to_wake_up
+ffffffff810a3110 T wake_up_process
+ffffffffa0000000 t ext4_fill_super	[ext4]
+ffffffffa0000400 t ext4_put_super	[ext4]
+0000000000000000 T hidden
+`
+
+func TestKernelSymbols(t *testing.T) {
+	syms := parseKallsyms(testKallsyms)
+
+	tests := []struct {
+		addr   uint64
+		offset bool
+		want   string
+	}{
+		{0xffffffff810a2c40, true, "try_to_wake_up+0x0/0x4d0"},
+		{0xffffffff810a2c7b, true, "try_to_wake_up+0x3b/0x4d0"},
+		{0xffffffff810a2c7b, false, "try_to_wake_up"},
+		{0xffffffff81000010, true, "_text+0x10/0x100"},
+		{0xffffffffa0000010, true, "ext4_fill_super+0x10/0x400 [ext4]"},
+		{0xffffffffa0000010, false, "ext4_fill_super [ext4]"},
+		{0xffffffff80000000, true, "0xffffffff80000000"},
+		{0xffffffffa0000500, true, "0xffffffffa0000500"},
+	}
+
+	for _, test := range tests {
+		if got := syms.format(test.addr, test.offset); got != test.want {
+			t.Errorf("%x: want %q, got %q", test.addr, test.want, got)
+		}
+	}
+}
+
+func TestKallsymsReload(t *testing.T) {
+	files := testFilesWith(map[string]string{
+		"/proc/kallsyms": testKallsyms,
+	})
+	f := newTestFtrace(t, files)
+
+	if s := f.kernelSymbol(0xffffffff810a2c7b, true); s != "try_to_wake_up+0x3b/0x4d0" {
+		t.Errorf("want try_to_wake_up+0x3b/0x4d0 got %s", s)
+	}
+
+	// A module loaded after kallsyms was read is found once the refresh
+	// interval has passed
+	files["/proc/kallsyms"] = testKallsyms +
+		"ffffffffc0100000 t btusb_probe\t[btusb]\nffffffffc0100200 t btusb_disconnect\t[btusb]\n"
+	if s := f.kernelSymbol(0xffffffffc0100010, false); s != "0xffffffffc0100010" {
+		t.Errorf("want no symbol before the refresh interval got %s", s)
+	}
+	f.kallsymsRead = time.Time{}
+	if s := f.kernelSymbol(0xffffffffc0100010, false); s != "btusb_probe [btusb]" {
+		t.Errorf("want btusb_probe [btusb] got %s", s)
+	}
+}
+
+func TestKallsymsRestricted(t *testing.T) {
+	files := testFilesWith(map[string]string{
+		// kptr_restrict zeroes every address
+		"/proc/kallsyms": "0000000000000000 T _text\n0000000000000000 T try_to_wake_up\n",
+	})
+	f := newTestFtrace(t, files)
+
+	if s := f.kernelSymbol(0xffffffff810a2c7b, false); s != "0xffffffff810a2c7b" {
+		t.Errorf("want no symbol got %s", s)
+	}
+
+	// kallsyms isn't reread on every lookup until the refresh interval
+	files["/proc/kallsyms"] = testKallsyms
+	if s := f.kernelSymbol(0xffffffff810a2c7b, false); s != "0xffffffff810a2c7b" {
+		t.Errorf("want no symbol before the refresh interval got %s", s)
+	}
+	f.kallsymsRead = time.Time{}
+	if s := f.kernelSymbol(0xffffffff810a2c7b, false); s != "try_to_wake_up" {
+		t.Errorf("want try_to_wake_up got %s", s)
+	}
+}
+
+func TestKernelBacktraceSymbols(t *testing.T) {
+	syms := parseKallsyms(testKallsyms)
+
+	tests := []struct {
+		addr uint64
+		want string
+	}{
+		{0xffffffff810a2c7b, "try_to_wake_up+0x3b/0x4d0"},
+		// A call at the end of try_to_wake_up returns to wake_up_process
+		{0xffffffff810a3110, "try_to_wake_up+0x4d0/0x4d0"},
+		{0xffffffffa0000010, "ext4_fill_super+0x10/0x400 [ext4]"},
+		{0xffffffff81000000, "0xffffffff81000000"},
+	}
+
+	for _, test := range tests {
+		if got := syms.formatBacktrace(test.addr); got != test.want {
+			t.Errorf("%x: want %q, got %q", test.addr, test.want, got)
+		}
+	}
+}
+
+const kfreeFormat = `name: kfree
+ID: 481
+format:
+	field:unsigned short common_type;	offset:0;	size:2;	signed:0;
+	field:unsigned char common_flags;	offset:2;	size:1;	signed:0;
+	field:unsigned char common_preempt_count;	offset:3;	size:1;	signed:0;
+	field:int common_pid;	offset:4;	size:4;	signed:1;
+
+	field:unsigned long call_site;	offset:8;	size:8;	signed:0;
+	field:const void * ptr;	offset:16;	size:8;	signed:0;
+
+print fmt: "call_site=%pS %ps %pSR ret=%pB ptr=%pK.", REC->call_site, REC->call_site, REC->call_site, REC->call_site, REC->ptr
+`
+
+func TestSymbolConversions(t *testing.T) {
+	f := newTestFtraceWith(t, map[string]string{
+		"/proc/kallsyms": testKallsyms,
+		"/sys/kernel/debug/tracing/events/kmem/kfree/format": kfreeFormat,
+	})
+	etype, err := f.NewEventType("kmem/kfree")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := etype.NewEvent(map[string]interface{}{"call_site": uint64(0xffffffff810a3110), "ptr": uint64(0xffff888003a4c000)}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.ftrace = f
+	want := "call_site=wake_up_process+0x0/0x1ef5cef0 wake_up_process wake_up_process+0x0/0x1ef5cef0 ret=try_to_wake_up+0x4d0/0x4d0 ptr=ffff888003a4c000."
+	if got := etype.Format(*e); got != want {
+		t.Errorf("want %q got %q", want, got)
+	}
+
+	// %pK is as wide as the kernel's pointers
+	f.KernelDefs().SetLongSize(4)
+	if got, want := kernelPointer(f, 0xc0a4c000), "c0a4c000"; got != want {
+		t.Errorf("want %q for a 32-bit kernel got %q", want, got)
+	}
+}
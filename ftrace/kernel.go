@@ -15,25 +15,36 @@
 package ftrace
 
 import (
+	"encoding/binary"
+	"fmt"
 	"strings"
 
 	"github.com/google/traceout/ftrace/cparse"
+	"github.com/google/traceout/ftrace/cprintf"
 )
 
 type kernelFunc func(cparse.EvalContext, []cparse.Value) cparse.Value
 
 var kernelFunctions = map[string]kernelFunc{
-	"__print_flags":    printFlags,
-	"__print_symbolic": printSymbolic,
-	"__get_str":        getString,
-	"__printk_pf":      printkFunctionPointer,
-	"__printk_pF":      printkFunctionPointerOffset,
-	"__printk_pk":      printkKernelSymbol,
-	/* TODO:
-	   __print_symbolic
-	   __print_hex
-	   __get_dynamic_array?
-	*/
+	"__print_flags":           printFlags,
+	"__print_symbolic":        printSymbolic,
+	"__print_hex":             printHex,
+	"__print_hex_str":         printHexStr,
+	"__print_array":           printArray,
+	"__get_str":               getString,
+	"__get_rel_str":           getString,
+	"__get_dynamic_array":     getDynamicArray,
+	"__get_dynamic_array_len": getDynamicArrayLen,
+	"__get_bitmask":           getBitmask,
+	"__get_rel_bitmask":       getBitmask,
+	"__get_cpumask":           getBitmask,
+	"__get_rel_cpumask":       getBitmask,
+	"__printk_pf":             printkPointer("f", symbolName),
+	"__printk_pF":             printkPointer("F", symbolOffset),
+	"__printk_ps":             printkPointer("s", symbolName),
+	"__printk_pS":             printkPointer("S", symbolOffset),
+	"__printk_pB":             printkPointer("B", (*Ftrace).kernelBacktraceSymbol),
+	"__printk_pK":             printkPointer("K", kernelPointer),
 }
 
 var kernelConstants = map[string]int{
@@ -56,8 +67,53 @@ var kernelConstants = map[string]int{
 	"TLB_LOCAL_MM_SHOOTDOWN":   3,
 }
 
+// kernelTypes are the typedefs print fmts cast to, as C types of the kernel
+// where long is the kernel's long, so 4 bytes on 32 bit kernels
 var kernelTypes = map[string]string{
-	"gfp_t": "unsigned int",
+	"gfp_t":      "unsigned int",
+	"dev_t":      "unsigned int",
+	"pid_t":      "int",
+	"uid_t":      "unsigned int",
+	"gid_t":      "unsigned int",
+	"umode_t":    "unsigned short",
+	"sector_t":   "unsigned long long",
+	"blkcnt_t":   "unsigned long long",
+	"loff_t":     "long long",
+	"size_t":     "unsigned long",
+	"ssize_t":    "long",
+	"ino_t":      "unsigned long",
+	"dma_addr_t": "unsigned long",
+	"u8":         "unsigned char",
+	"u16":        "unsigned short",
+	"u32":        "unsigned int",
+	"u64":        "unsigned long long",
+	"s8":         "signed char",
+	"s16":        "short",
+	"s32":        "int",
+	"s64":        "long long",
+	"__u8":       "unsigned char",
+	"__u16":      "unsigned short",
+	"__u32":      "unsigned int",
+	"__u64":      "unsigned long long",
+	"__s8":       "signed char",
+	"__s16":      "short",
+	"__s32":      "int",
+	"__s64":      "long long",
+	"__le16":     "unsigned short",
+	"__le32":     "unsigned int",
+	"__le64":     "unsigned long long",
+	"__be16":     "unsigned short",
+	"__be32":     "unsigned int",
+	"__be64":     "unsigned long long",
+	"uint8_t":    "unsigned char",
+	"uint16_t":   "unsigned short",
+	"uint32_t":   "unsigned int",
+	"uint64_t":   "unsigned long long",
+	"int8_t":     "signed char",
+	"int16_t":    "short",
+	"int32_t":    "int",
+	"int64_t":    "long long",
+	"bool":       "_Bool",
 }
 
 func printFlags(ctx cparse.EvalContext, args []cparse.Value) cparse.Value {
@@ -147,69 +203,181 @@ func getString(ctx cparse.EvalContext, args []cparse.Value) cparse.Value {
 	if !args[0].IsInt() {
 		return cparse.NewValueError("expected integer as first argument to __get_str")
 	}
-	i := int(args[0].AsInt())
+	b, err := e.dataLoc(uint32(args[0].AsInt()))
+	if err != nil {
+		// Print it like the NULL strings the kernel records
+		return cparse.NewValueString("(null)")
+	}
+	return cparse.NewValueString(cString(b))
+}
+
+// getDynamicArray returns the contents of a __data_loc array as a string
+// of raw bytes, for helpers like __print_hex
+func getDynamicArray(ctx cparse.EvalContext, args []cparse.Value) cparse.Value {
+	e := ctx.(Event)
 
-	offset := i & 0xffff
-	length := i >> 16
+	if len(args) != 1 {
+		return cparse.NewValueError("expected 1 argument to __get_dynamic_array")
+	}
 
-	if offset > len(e.contents)-1 {
-		return cparse.NewValueError("__get_str offset %d too large", offset)
+	if !args[0].IsInt() {
+		return cparse.NewValueError("expected integer as first argument to __get_dynamic_array")
+	}
+	b, err := e.dataLoc(uint32(args[0].AsInt()))
+	if err != nil {
+		return cparse.NewValueError("__get_dynamic_array: %s", err.Error())
 	}
+	return cparse.NewValueString(string(b))
+}
 
-	if offset+length > len(e.contents) {
-		return cparse.NewValueError("__get_str length %d too large", length)
+// getDynamicArrayLen returns the length in bytes of a __data_loc array
+func getDynamicArrayLen(ctx cparse.EvalContext, args []cparse.Value) cparse.Value {
+	if len(args) != 1 {
+		return cparse.NewValueError("expected 1 argument to __get_dynamic_array_len")
 	}
 
-	s := string(e.contents[offset : offset+length])
-	zero := strings.IndexByte(s, 0)
-	if zero != -1 {
-		s = s[:zero]
+	if !args[0].IsInt() {
+		return cparse.NewValueError("expected integer as first argument to __get_dynamic_array_len")
 	}
-	return cparse.NewValueString(s)
+	return cparse.NewValueInt(uint64(args[0].AsInt())>>16&0xffff, 4, true)
 }
 
-func printkFunctionPointer(ctx cparse.EvalContext, args []cparse.Value) cparse.Value {
+// getBitmask prints a __data_loc bitmap like %*pb, as comma separated 32 bit
+// chunks in hex
+func getBitmask(ctx cparse.EvalContext, args []cparse.Value) cparse.Value {
 	e := ctx.(Event)
 
 	if len(args) != 1 {
-		return cparse.NewValueError("expected 1 argument to __printk_pf")
+		return cparse.NewValueError("expected 1 argument to __get_bitmask")
 	}
 
 	if !args[0].IsInt() {
-		return cparse.NewValueError("expected integer as first argument to __printk_pf")
+		return cparse.NewValueError("expected integer as first argument to __get_bitmask")
+	}
+	b, err := e.dataLoc(uint32(args[0].AsInt()))
+	if err != nil {
+		return cparse.NewValueError("__get_bitmask: %s", err.Error())
 	}
-	addr := uint64(args[0].AsInt())
+	if size := e.etype.kernelDefs().LongSize(); e.byteOrder() == binary.BigEndian && len(b)%size == 0 {
+		// FormatBitmap takes little endian longs
+		swapped := make([]byte, len(b))
+		for i := range b {
+			swapped[i] = b[i/size*size+size-1-i%size]
+		}
+		b = swapped
+	}
+	return cparse.NewValueString(cprintf.FormatBitmap(b, len(b)*8, false))
+}
 
-	return cparse.NewValueString(e.ftrace.kernelSymbol(addr))
+// printHex prints the first len bytes of buf as space separated hex
+func printHex(ctx cparse.EvalContext, args []cparse.Value) cparse.Value {
+	return hexDump("__print_hex", " ", args)
 }
 
-func printkFunctionPointerOffset(ctx cparse.EvalContext, args []cparse.Value) cparse.Value {
-	e := ctx.(Event)
+// printHexStr prints the first len bytes of buf as hex, without separators
+func printHexStr(ctx cparse.EvalContext, args []cparse.Value) cparse.Value {
+	return hexDump("__print_hex_str", "", args)
+}
 
-	if len(args) != 1 {
-		return cparse.NewValueError("expected 1 argument to __printk_pf")
+// hexDump implements the (buf, len) hex printing helpers
+func hexDump(name, sep string, args []cparse.Value) cparse.Value {
+	if len(args) != 2 {
+		return cparse.NewValueError("expected 2 arguments to %s", name)
 	}
 
-	if !args[0].IsInt() {
-		return cparse.NewValueError("expected integer as first argument to __printk_pf")
+	if !args[0].IsString() {
+		return cparse.NewValueError("expected array as first argument to %s", name)
 	}
-	addr := uint64(args[0].AsInt())
+	buf := args[0].AsString()
 
-	// TODO: find function before addr, print offset
-	return cparse.NewValueString(e.ftrace.kernelSymbol(addr))
+	if !args[1].IsInt() {
+		return cparse.NewValueError("expected integer as second argument to %s", name)
+	}
+	if n := args[1].AsInt(); n >= 0 && int(n) < len(buf) {
+		buf = buf[:n]
+	}
+
+	hex := make([]string, len(buf))
+	for i := 0; i < len(buf); i++ {
+		hex[i] = fmt.Sprintf("%02x", buf[i])
+	}
+	return cparse.NewValueString(strings.Join(hex, sep))
 }
 
-func printkKernelSymbol(ctx cparse.EvalContext, args []cparse.Value) cparse.Value {
-	e := ctx.(Event)
+// printArray prints count elements of el_size bytes from array in hex, as
+// "{0x1,0x2}"
+func printArray(ctx cparse.EvalContext, args []cparse.Value) cparse.Value {
+	if len(args) != 3 {
+		return cparse.NewValueError("expected 3 arguments to __print_array")
+	}
 
-	if len(args) != 1 {
-		return cparse.NewValueError("expected 1 argument to __printk_pf")
+	if !args[0].IsString() {
+		return cparse.NewValueError("expected array as first argument to __print_array")
 	}
+	array := args[0].AsString()
 
-	if !args[0].IsInt() {
-		return cparse.NewValueError("expected integer as first argument to __printk_pf")
+	if !args[1].IsInt() || !args[2].IsInt() {
+		return cparse.NewValueError("expected integers as count and element size arguments to __print_array")
+	}
+	count := int(args[1].AsInt())
+	size := int(args[2].AsInt())
+	if size <= 0 {
+		return cparse.NewValueError("__print_array: bad element size %d", size)
+	}
+
+	var elems []string
+	order := ctx.(Event).byteOrder()
+	for i := 0; i < count; i++ {
+		if (i+1)*size > len(array) {
+			break
+		}
+		el := []byte(array[i*size : (i+1)*size])
+		switch size {
+		case 1:
+			elems = append(elems, fmt.Sprintf("0x%x", el[0]))
+		case 2:
+			elems = append(elems, fmt.Sprintf("0x%x", order.Uint16(el)))
+		case 4:
+			elems = append(elems, fmt.Sprintf("0x%x", order.Uint32(el)))
+		case 8:
+			elems = append(elems, fmt.Sprintf("0x%x", order.Uint64(el)))
+		default:
+			elems = append(elems, fmt.Sprintf("BAD SIZE:%d 0x%x", size, el[0]))
+		}
+	}
+	return cparse.NewValueString("{" + strings.Join(elems, ",") + "}")
+}
+
+// printkPointer returns the kernel function that implements a %p extension
+// formatting a kernel address, named __printk_p<extension>
+func printkPointer(extension string, format func(f *Ftrace, addr uint64) string) kernelFunc {
+	name := "__printk_p" + extension
+	return func(ctx cparse.EvalContext, args []cparse.Value) cparse.Value {
+		e := ctx.(Event)
+
+		if len(args) != 1 {
+			return cparse.NewValueError("expected 1 argument to " + name)
+		}
+
+		if !args[0].IsInt() {
+			return cparse.NewValueError("expected integer as first argument to " + name)
+		}
+		addr := args[0].AsUint64()
+
+		return cparse.NewValueString(format(e.ftrace, addr))
 	}
-	addr := uint64(args[0].AsInt())
+}
+
+func symbolName(f *Ftrace, addr uint64) string {
+	return f.kernelSymbol(addr, false)
+}
+
+func symbolOffset(f *Ftrace, addr uint64) string {
+	return f.kernelSymbol(addr, true)
+}
 
-	return cparse.NewValueString(e.ftrace.kernelSymbol(addr))
+// kernelPointer formats an address like %pK does for privileged readers,
+// padded to the width of the traced kernel's pointers
+func kernelPointer(f *Ftrace, addr uint64) string {
+	return fmt.Sprintf("%0*x", 2*f.defs.LongSize(), addr)
 }
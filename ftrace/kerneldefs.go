@@ -0,0 +1,231 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/traceout/ftrace/cparse"
+)
+
+// KernelDefs holds the kernel constants that event print fmts refer to by
+// name, like HI_SOFTIRQ, whose values can change between kernel versions,
+// and the typedefs they cast to and the Arch, which can change between
+// machines.  Each Ftrace has its own, starting with the values of
+// recent kernels, see Ftrace.KernelDefs.  Print fmts are parsed when their
+// event types are registered, so the traced kernel's values must be set
+// before then.
+type KernelDefs struct {
+	constants map[string]int64
+	types     map[string]string
+	arch      Arch
+}
+
+// defaultKernelDefs is used by event types parsed without an Ftrace
+var defaultKernelDefs = NewKernelDefs()
+
+// NewKernelDefs returns KernelDefs holding the values of recent kernels
+func NewKernelDefs() *KernelDefs {
+	d := &KernelDefs{
+		constants: make(map[string]int64),
+		types:     make(map[string]string),
+		arch:      defaultArch,
+	}
+	for name, v := range kernelConstants {
+		d.constants[name] = int64(v)
+	}
+	for name, t := range kernelTypes {
+		d.types[name] = t
+	}
+	return d
+}
+
+// SetConstant sets the value of a constant
+func (d *KernelDefs) SetConstant(name string, value int64) {
+	d.constants[name] = value
+}
+
+// Constant returns the value of a constant, and whether it is known
+func (d *KernelDefs) Constant(name string) (int64, bool) {
+	v, ok := d.constants[name]
+	return v, ok
+}
+
+// SetType defines a typedef as a C integer type, like "unsigned long", or
+// as another typedef.  A long in it is the kernel's long, see SetLongSize.
+func (d *KernelDefs) SetType(name, ctype string) error {
+	ctype = strings.Join(strings.Fields(ctype), " ")
+	if t, ok := d.types[ctype]; ok {
+		ctype = t
+	}
+	if _, ok := d.eval("(" + ctype + ")0"); !ok {
+		return fmt.Errorf("invalid type for %s: %q", name, ctype)
+	}
+	d.types[name] = ctype
+	return nil
+}
+
+// SetLongSize sets the size of long on the traced kernel, 8 or 4 bytes,
+// which is that of the typedefs defined as longs
+func (d *KernelDefs) SetLongSize(size int) {
+	d.arch.LongSize = size
+}
+
+// LongSize returns the size of long on the traced kernel, implementing
+// cparse.LongSizer
+func (d *KernelDefs) LongSize() int {
+	return d.arch.LongSize
+}
+
+// SetArch sets the machine the traced kernel runs on, for kernels that
+// don't say, or whose events are decoded elsewhere.  New detects it when
+// the kernel exports the kernel.arch sysctl.
+func (d *KernelDefs) SetArch(arch Arch) {
+	d.arch = arch
+}
+
+// Arch returns the machine the traced kernel runs on
+func (d *KernelDefs) Arch() Arch {
+	return d.arch
+}
+
+var (
+	commentRe = regexp.MustCompile(`(?s)/\*.*?\*/|//[^\n]*`)
+	defineRe  = regexp.MustCompile(`(?m)^[ \t]*#[ \t]*define[ \t]+([A-Za-z_]\w*)[ \t]+(.+)$`)
+	enumRe    = regexp.MustCompile(`enum\b[^{;]*\{([^}]*)\}`)
+)
+
+// LoadConstants reads constants from C source, such as kernel headers or a
+// list written for the traced kernel.  It takes "#define NAME value" lines
+// and enum definitions, whose members count up from 0 or from the last
+// explicit value.  Values are C expressions over numbers and constants
+// already known, and definitions that aren't constants, like macros with
+// arguments, are skipped.  It returns the number of constants read.
+func (d *KernelDefs) LoadConstants(r io.Reader) (int, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return 0, err
+	}
+	src := commentRe.ReplaceAllString(string(data), " ")
+
+	n := 0
+	for _, m := range defineRe.FindAllStringSubmatch(src, -1) {
+		if v, ok := d.eval(m[2]); ok {
+			d.constants[m[1]] = v
+			n++
+		}
+	}
+
+	for _, m := range enumRe.FindAllStringSubmatch(src, -1) {
+		next := int64(0)
+		for _, member := range strings.Split(m[1], ",") {
+			v := strings.SplitN(member, "=", 2)
+			name := strings.TrimSpace(v[0])
+			if name == "" {
+				continue
+			}
+			if len(v) == 2 {
+				var ok bool
+				if next, ok = d.eval(v[1]); !ok {
+					// The rest of the enum counts from an unknown value
+					break
+				}
+			}
+			d.constants[name] = next
+			next++
+			n++
+		}
+	}
+	return n, nil
+}
+
+// LoadEvalMap reads enum values in the format of the eval_map file, which
+// kernels built with CONFIG_TRACE_EVAL_MAP_FILE export for the enums named
+// by TRACE_DEFINE_ENUM, as "HI_SOFTIRQ 0 (irq)" lines.  The system an enum
+// was defined for is ignored, as print fmts of other systems can use it
+// too.  It returns the number of values read.
+func (d *KernelDefs) LoadEvalMap(r io.Reader) (int, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, line := range strings.Split(string(data), "\n") {
+		v := strings.Fields(line)
+		if len(v) < 2 {
+			continue
+		}
+		value, err := strconv.ParseInt(v[1], 0, 64)
+		if err != nil {
+			continue
+		}
+		d.constants[v[0]] = value
+		n++
+	}
+	return n, nil
+}
+
+// eval evaluates a constant C expression
+func (d *KernelDefs) eval(expr string) (int64, bool) {
+	exprs, err := cparse.Parse(strings.TrimSpace(expr), d)
+	if err != nil || len(exprs) != 1 || !exprs[0].IsConstant() {
+		return 0, false
+	}
+	v := exprs[0].Value(nil)
+	if !v.IsInt() {
+		return 0, false
+	}
+	return v.AsInt(), true
+}
+
+// GetVariable implements cparse.Scope for constant expressions
+func (d *KernelDefs) GetVariable(name string) cparse.Variable {
+	v, ok := d.constants[name]
+	if !ok {
+		return nil
+	}
+	if int64(int32(v)) == v {
+		return cparse.NewConstantVariable(cparse.NewValueInt(uint64(v), 4, true))
+	}
+	return cparse.NewConstantVariable(cparse.NewValueInt(uint64(v), 8, true))
+}
+
+func (d *KernelDefs) GetFunction(name string) cparse.Function {
+	return nil
+}
+
+// GetType implements cparse.Scope
+func (d *KernelDefs) GetType(name string) string {
+	return d.types[name]
+}
+
+// KernelDefs returns the kernel constants used to parse the print fmts of
+// event types registered from now on
+func (f *Ftrace) KernelDefs() *KernelDefs {
+	return f.defs
+}
+
+// kernelDefs returns the definitions the event type is parsed with
+func (etype *EventType) kernelDefs() *KernelDefs {
+	if etype.defs == nil {
+		return defaultKernelDefs
+	}
+	return etype.defs
+}
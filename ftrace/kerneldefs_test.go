@@ -0,0 +1,194 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"strings"
+	"testing"
+)
+
+const testKernelHeader = `
+/* softirqs, renumbered */
+enum
+{
+	HI_SOFTIRQ=0,
+	TIMER_SOFTIRQ,
+	NET_TX_SOFTIRQ = 5, // skips
+	NET_RX_SOFTIRQ,
+	NR_SOFTIRQS
+};
+
+#define PAGE_SHIFT	12
+#define PAGE_SIZE	(1 << PAGE_SHIFT)
+#define pfn_to_page(pfn) (mem_map + (pfn))
+#define NOT_A_NUMBER	"string"
+
+enum unknown_start {
+	FIRST = BIT(3),
+	SECOND,
+};
+`
+
+func TestLoadConstants(t *testing.T) {
+	d := NewKernelDefs()
+	n, err := d.LoadConstants(strings.NewReader(testKernelHeader))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 7 {
+		t.Errorf("want 7 constants read, got %d", n)
+	}
+
+	want := map[string]int64{
+		"HI_SOFTIRQ":     0,
+		"TIMER_SOFTIRQ":  1,
+		"NET_TX_SOFTIRQ": 5,
+		"NET_RX_SOFTIRQ": 6,
+		"NR_SOFTIRQS":    7,
+		"PAGE_SHIFT":     12,
+		"PAGE_SIZE":      4096,
+		// Still the default
+		"RCU_SOFTIRQ": 9,
+	}
+	for name, v := range want {
+		if got, ok := d.Constant(name); !ok || got != v {
+			t.Errorf("%s: want %d got %d %v", name, v, got, ok)
+		}
+	}
+	for _, name := range []string{"pfn_to_page", "NOT_A_NUMBER", "FIRST", "SECOND"} {
+		if _, ok := d.Constant(name); ok {
+			t.Errorf("%s: want no value", name)
+		}
+	}
+
+	// Other Ftraces keep the defaults
+	if v, _ := NewKernelDefs().Constant("NET_RX_SOFTIRQ"); v != 3 {
+		t.Errorf("want the default NET_RX_SOFTIRQ 3 got %d", v)
+	}
+}
+
+func TestKernelDefsParse(t *testing.T) {
+	f := newTestFtraceWith(t, map[string]string{
+		"/sys/kernel/debug/tracing/events/irq/softirq_entry/format": `name: softirq_entry
+ID: 20
+format:
+	field:unsigned short common_type;	offset:0;	size:2;	signed:0;
+	field:unsigned char common_flags;	offset:2;	size:1;	signed:0;
+	field:unsigned char common_preempt_count;	offset:3;	size:1;	signed:0;
+	field:int common_pid;	offset:4;	size:4;	signed:1;
+
+	field:unsigned int vec;	offset:8;	size:4;	signed:0;
+
+print fmt: "vec=%u [action=%s]", REC->vec, __print_symbolic(REC->vec, { HI_SOFTIRQ, "HI" }, { NET_RX_SOFTIRQ, "NET_RX" })
+`,
+	})
+	f.KernelDefs().SetConstant("NET_RX_SOFTIRQ", 6)
+	etype, err := f.NewEventType("irq/softirq_entry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := etype.NewEvent(map[string]interface{}{"vec": 6}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "vec=6 [action=NET_RX]", etype.Format(*e); got != want {
+		t.Errorf("want %q got %q", want, got)
+	}
+}
+
+func TestKernelTypes(t *testing.T) {
+	d := NewKernelDefs()
+	if err := d.SetType("my_len_t", "unsigned  long"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetType("my_dev_t", "dev_t"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetType("bad_t", "struct page *"); err == nil {
+		t.Error("want an error for a non-integer type")
+	}
+
+	tests := []struct {
+		expr   string
+		want64 int64
+		want32 int64
+	}{
+		{"(size_t)-1 > 0", 1, 1},
+		{"(ssize_t)-1 < 0", 1, 1},
+		{"(size_t)-1", -1, 0xffffffff},
+		{"(dma_addr_t)-1", -1, 0xffffffff},
+		{"(sector_t)-1", -1, -1},
+		{"(umode_t)0x12345", 0x2345, 0x2345},
+		{"(pid_t)0xffffffff", -1, -1},
+		{"(my_len_t)-1", -1, 0xffffffff},
+		{"(my_dev_t)-1", 0xffffffff, 0xffffffff},
+		{"(__u16)-1", 0xffff, 0xffff},
+		{"(int8_t)0xff", -1, -1},
+		{"(bool)1", 1, 1},
+		{"sizeof(bool)", 1, 1},
+	}
+	for _, size := range []int{8, 4} {
+		d.SetLongSize(size)
+		for _, test := range tests {
+			want := test.want64
+			if size == 4 {
+				want = test.want32
+			}
+			if got, ok := d.eval(test.expr); !ok || got != want {
+				t.Errorf("long size %d: %s: want %d got %d %v", size, test.expr, want, got, ok)
+			}
+		}
+	}
+}
+
+func TestKernelLongSize(t *testing.T) {
+	files := testFilesWith(nil)
+	if f := newTestFtrace(t, files); f.KernelDefs().LongSize() != 8 {
+		t.Errorf("want long size 8 got %d", f.KernelDefs().LongSize())
+	}
+	files["/sys/kernel/debug/tracing/events/header_page"] = strings.Replace(headerPageFormat,
+		"local_t commit;	offset:8;	size:8", "local_t commit;	offset:8;	size:4", 1)
+	if f := newTestFtrace(t, files); f.KernelDefs().LongSize() != 4 {
+		t.Errorf("want long size 4 got %d", f.KernelDefs().LongSize())
+	}
+}
+
+const testEvalMap = `HI_SOFTIRQ 0 (irq)
+NET_RX_SOFTIRQ 6 (irq)
+XPRT_TRANSPORT_TCP 6 (sunrpc)
+bad line
+`
+
+func TestEvalMap(t *testing.T) {
+	f := newTestFtraceWith(t, map[string]string{
+		"/sys/kernel/debug/tracing/eval_map": testEvalMap,
+	})
+
+	want := map[string]int64{
+		"HI_SOFTIRQ":         0,
+		"NET_RX_SOFTIRQ":     6,
+		"XPRT_TRANSPORT_TCP": 6,
+	}
+	for name, v := range want {
+		if got, ok := f.KernelDefs().Constant(name); !ok || got != v {
+			t.Errorf("%s: want %d got %d %v", name, v, got, ok)
+		}
+	}
+
+	n, err := NewKernelDefs().LoadEvalMap(strings.NewReader(testEvalMap))
+	if err != nil || n != 3 {
+		t.Errorf("want 3 values read got %d %v", n, err)
+	}
+}
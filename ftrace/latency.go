@@ -0,0 +1,82 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import "fmt"
+
+// latencyMarkThreshold is the delay to the next event in microseconds above
+// which the kernel marks an event with '!'
+const latencyMarkThreshold = 100
+
+// LatencyHeader is the column description printed by the kernel at the top
+// of the trace file with the latency-format trace option
+const LatencyHeader = `#                  _------=> CPU#            
+#                 / _-----=> irqs-off        
+#                | / _----=> need-resched    
+#                || / _---=> hardirq/softirq 
+#                ||| / _--=> preempt-depth   
+#                |||| /     delay             
+#  cmd     pid   ||||| time  |   caller      
+#     \   /      |||||  \    |   /           
+`
+
+// LatencyFormatter formats events like the kernel's trace file with the
+// latency-format trace option.  Timestamps are printed in microseconds
+// relative to the first event formatted, followed by a mark for large delays
+// to the next event.
+type LatencyFormatter struct {
+	start   uint64
+	started bool
+}
+
+func NewLatencyFormatter() *LatencyFormatter {
+	return &LatencyFormatter{}
+}
+
+// Format formats an event in latency format.  next is the event that
+// follows it in time order, or nil if it is not known.
+func (lf *LatencyFormatter) Format(e, next *Event) string {
+	if !lf.started {
+		lf.start = e.When
+		lf.started = true
+	}
+
+	mark := byte(' ')
+	if next != nil && next.When > e.When {
+		delay := (next.When - e.When) / 1000
+		if delay > latencyMarkThreshold {
+			mark = '!'
+		} else if delay > 1 {
+			mark = '+'
+		}
+	}
+
+	return fmt.Sprintf("%8.8s-%-5d %3d%s %4dus%c: %s: %s",
+		e.ProcessName(), e.Pid, e.Cpu, e.FlagChars(), (e.When-lf.start)/1000, mark,
+		e.etype.name, e.etype.Format(*e))
+}
+
+// FormatEvents formats events that are sorted by time in latency format
+func (lf *LatencyFormatter) FormatEvents(events Events) []string {
+	lines := make([]string, len(events))
+	for i, e := range events {
+		var next *Event
+		if i+1 < len(events) {
+			next = events[i+1]
+		}
+		lines[i] = lf.Format(e, next)
+	}
+	return lines
+}
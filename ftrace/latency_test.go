@@ -0,0 +1,50 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLatencyFormat(t *testing.T) {
+	f := newTestFtraceWith(t, map[string]string{
+		"/sys/kernel/debug/tracing/saved_cmdlines": "1234 bash\n1300 kworker/u16:2\n",
+	})
+	if _, err := f.NewEventType("sched/sched_wakeup"); err != nil {
+		t.Fatal(err)
+	}
+
+	page := &testPage{timestamp: 5000000000}
+	page.addEvent(0, schedWakeup(1234, "bash", 120, 2))
+	page.addEvent(2500, schedWakeup(1300, "kworker/u16:2", 120, 0))
+	page.addEvent(500, schedWakeup(1234, "bash", 120, 2))
+	page.addEvent(250000, schedWakeup(1234, "bash", 120, 2))
+	events, err := f.decodePage(3, page.bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"    bash-1234    3....    0us+: sched_wakeup: comm=bash pid=1234 prio=120 success=1 target_cpu=002",
+		"kworker/-1300    3....    2us : sched_wakeup: comm=kworker/u16:2 pid=1300 prio=120 success=1 target_cpu=000",
+		"    bash-1234    3....    3us!: sched_wakeup: comm=bash pid=1234 prio=120 success=1 target_cpu=002",
+		"    bash-1234    3....  253us : sched_wakeup: comm=bash pid=1234 prio=120 success=1 target_cpu=002",
+	}
+	got := NewLatencyFormatter().FormatEvents(events)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want\n%q\ngot\n%q", want, got)
+	}
+}
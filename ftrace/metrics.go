@@ -0,0 +1,124 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"expvar"
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// Page header commit flags, set when the kernel dropped events before the
+// page and when the number it dropped is stored after the page's data
+const (
+	commitMissedEvents = 1 << 31
+	commitMissedStored = 1 << 30
+)
+
+// Metrics are counters of the health of a capture, for monitoring long
+// running captures
+type Metrics struct {
+	// PagesRead is the number of pages read from the trace pipes
+	PagesRead int64
+	// EventsDecoded is the number of events decoded from the pages,
+	// including those dropped by filters
+	EventsDecoded int64
+	// EventsFiltered is the number of decoded events dropped by the
+	// capture's Filter or FollowPid
+	EventsFiltered int64
+	// DecodeErrors is the number of pages that failed to decode completely
+	DecodeErrors int64
+	// LostEvents is the number of events the kernel reported dropping
+	// between pages, where it knows how many
+	LostEvents int64
+	// PagesAfterLoss is the number of pages preceded by dropped events
+	PagesAfterLoss int64
+	// Backlog is the number of pages read but not yet passed to Capture's
+	// callback
+	Backlog int64
+	// EventsDropped is the number of decoded events dropped because
+	// Capture's callback fell behind, see CaptureOptions.MaxBufferedEvents
+	EventsDropped int64
+}
+
+// metrics are the counters behind Metrics, updated atomically by the
+// capture goroutines.  It must only hold int64s, which are aligned as long
+// as the struct is, see Ftrace.
+type metrics struct {
+	pagesRead      int64
+	pagesDelivered int64
+	eventsDecoded  int64
+	eventsFiltered int64
+	decodeErrors   int64
+	lostEvents     int64
+	pagesAfterLoss int64
+	pagesDropped   int64
+	eventsDropped  int64
+}
+
+// Metrics returns the current counters of the capture
+func (f *Ftrace) Metrics() Metrics {
+	m := &f.metrics
+	read := atomic.LoadInt64(&m.pagesRead)
+	return Metrics{
+		PagesRead:      read,
+		EventsDecoded:  atomic.LoadInt64(&m.eventsDecoded),
+		EventsFiltered: atomic.LoadInt64(&m.eventsFiltered),
+		DecodeErrors:   atomic.LoadInt64(&m.decodeErrors),
+		LostEvents:     atomic.LoadInt64(&m.lostEvents),
+		PagesAfterLoss: atomic.LoadInt64(&m.pagesAfterLoss),
+		Backlog:        read - atomic.LoadInt64(&m.pagesDelivered) - atomic.LoadInt64(&m.pagesDropped),
+		EventsDropped:  atomic.LoadInt64(&m.eventsDropped),
+	}
+}
+
+// PublishExpvar publishes the capture's Metrics as the expvar name, served
+// with the other expvars on /debug/vars
+func (f *Ftrace) PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return f.Metrics()
+	}))
+}
+
+// WritePrometheus writes the metrics in the Prometheus text format, with
+// names starting with traceout_ and a device label if device isn't empty
+func (m Metrics) WritePrometheus(w io.Writer, device string) error {
+	labels := ""
+	if device != "" {
+		labels = fmt.Sprintf("{device=%q}", device)
+	}
+
+	for _, c := range []struct {
+		name, kind, help string
+		value            int64
+	}{
+		{"pages_read_total", "counter", "Pages read from the trace pipes.", m.PagesRead},
+		{"events_decoded_total", "counter", "Events decoded from the trace pipes.", m.EventsDecoded},
+		{"events_filtered_total", "counter", "Decoded events dropped by filters.", m.EventsFiltered},
+		{"decode_errors_total", "counter", "Pages that failed to decode.", m.DecodeErrors},
+		{"lost_events_total", "counter", "Events the kernel reported dropping.", m.LostEvents},
+		{"pages_after_loss_total", "counter", "Pages preceded by dropped events.", m.PagesAfterLoss},
+		{"backlog_pages", "gauge", "Pages read but not yet consumed.", m.Backlog},
+		{"events_dropped_total", "counter", "Events dropped for a slow consumer.", m.EventsDropped},
+	} {
+		_, err := fmt.Fprintf(w, "# HELP traceout_%s %s\n# TYPE traceout_%s %s\ntraceout_%s%s %d\n",
+			c.name, c.help, c.name, c.kind, c.name, labels, c.value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+func TestAtomicsAligned(t *testing.T) {
+	var f Ftrace
+	if off := unsafe.Offsetof(f.metrics); off != 0 {
+		t.Errorf("want metrics first got offset %d", off)
+	}
+	if off := unsafe.Offsetof(f.windowStart); off%8 != 0 {
+		t.Errorf("want windowStart 64-bit aligned got offset %d", off)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	page := &testPage{timestamp: 1000000000}
+	page.addEvent(0, schedWakeup(1, "init", 120, 0))
+	page.addEvent(0, schedWakeup(2, "kthreadd", 120, 0))
+
+	// Mark the page as following 7 lost events
+	data := page.bytes()
+	commit := binary.LittleEndian.Uint64(data[8:])
+	binary.LittleEndian.PutUint64(data[8:], commit|commitMissedEvents|commitMissedStored)
+	binary.LittleEndian.PutUint64(data[16+commit:], 7)
+
+	f := newTestFtraceWith(t, map[string]string{
+		"per_cpu/cpu0/trace_pipe_raw": string(data),
+	})
+	if _, err := f.NewEventType("sched/sched_wakeup"); err != nil {
+		t.Fatal(err)
+	}
+	filter, err := NewFilter("pid == 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.PrepareCaptureWithOptions(1, make(chan bool), CaptureOptions{Filter: filter}); err != nil {
+		t.Fatal(err)
+	}
+	f.Capture(func(Events) {})
+
+	want := Metrics{
+		PagesRead:      1,
+		EventsDecoded:  2,
+		EventsFiltered: 1,
+		LostEvents:     7,
+		PagesAfterLoss: 1,
+	}
+	if m := f.Metrics(); m != want {
+		t.Errorf("want %+v got %+v", want, m)
+	}
+
+	var buf bytes.Buffer
+	if err := want.WritePrometheus(&buf, "phone"); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"# TYPE traceout_lost_events_total counter",
+		`traceout_lost_events_total{device="phone"} 7`,
+		`traceout_backlog_pages{device="phone"} 0`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("missing %q in\n%s", line, buf.String())
+		}
+	}
+}
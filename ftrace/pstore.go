@@ -0,0 +1,94 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// pstorePath is where the kernel mounts pstore
+const pstorePath = "/sys/fs/pstore"
+
+// ReadPstoreFtrace reads the function trace the ramoops pstore backend
+// kept through a crash, from the ftrace-ramoops-<n> files in /sys/fs/pstore
+// of the machine fp reads from.  The kernel only saves a trace when
+// function tracing to pstore was turned on, with
+// /sys/kernel/debug/pstore/record_ftrace.
+func ReadPstoreFtrace(fp FileProvider) ([]byte, error) {
+	var data []byte
+	for i := 0; ; i++ {
+		buf, err := fp.ReadPstoreFile(fmt.Sprintf("ftrace-ramoops-%d", i))
+		if err != nil || len(buf) == 0 {
+			// Past the last record
+			if i == 0 {
+				return nil, fmt.Errorf("no ftrace-ramoops files in %s", pstorePath)
+			}
+			return data, nil
+		}
+		data = append(data, buf...)
+	}
+}
+
+// pstoreLineRegexp matches the lines the kernel prints for pstore function
+// trace records, "CPU:%d ts:%llu %08lx  %08lx  %ps <- %pS"
+var pstoreLineRegexp = regexp.MustCompile(`^CPU:(\d+) ts:(\d+) ([0-9a-f]+)\s+([0-9a-f]+)`)
+
+// DecodePstoreFtrace decodes a function trace read by ReadPstoreFtrace
+// into "function" events, in order of time.  They are formatted with the
+// kernel's current symbols, which only name the functions of the crashed
+// kernel if it was the same build and KASLR didn't move it.  pstore saves
+// no pid or flags, so those are zero.
+func (f *Ftrace) DecodePstoreFtrace(data []byte) (Events, error) {
+	etype := f.eventTypeByPath("ftrace/function")
+	if etype == nil {
+		var err error
+		etype, err = newEventType(f.fp, "ftrace/function", f.defs, f.formatCache)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var events Events
+	for i, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+		m := pstoreLineRegexp.FindStringSubmatch(line)
+		if m == nil {
+			return nil, fmt.Errorf("pstore line %d: bad function trace record %q", i+1, line)
+		}
+		cpu, _ := strconv.Atoi(m[1])
+		when, err := strconv.ParseUint(m[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("pstore line %d: %v", i+1, err)
+		}
+		ip, _ := strconv.ParseUint(m[3], 16, 64)
+		parent, _ := strconv.ParseUint(m[4], 16, 64)
+
+		e, err := etype.NewEvent(map[string]interface{}{"ip": ip, "parent_ip": parent}, cpu, when)
+		if err != nil {
+			return nil, err
+		}
+		e.ftrace = f
+		events = append(events, e)
+	}
+
+	sort.Stable(EventsByTime{events})
+	return events, nil
+}
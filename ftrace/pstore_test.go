@@ -0,0 +1,82 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"testing"
+)
+
+const functionFormat = `name: function
+ID: 1
+format:
+	field:unsigned short common_type;	offset:0;	size:2;	signed:0;
+	field:unsigned char common_flags;	offset:2;	size:1;	signed:0;
+	field:unsigned char common_preempt_count;	offset:3;	size:1;	signed:0;
+	field:int common_pid;	offset:4;	size:4;	signed:1;
+
+	field:unsigned long ip;	offset:8;	size:8;	signed:0;
+	field:unsigned long parent_ip;	offset:16;	size:8;	signed:0;
+
+print fmt: " %ps <-- %ps", (void *)REC->ip, (void *)REC->parent_ip
+`
+
+func TestPstoreFtrace(t *testing.T) {
+	files := testFilesWith(map[string]string{
+		"/sys/kernel/debug/tracing/events/ftrace/function/format": functionFormat,
+		"/proc/kallsyms":                  testKallsyms,
+		"/sys/fs/pstore/ftrace-ramoops-0": "CPU:0 ts:2000 ffffffff810a3110  ffffffff810a2c7b  wake_up_process <- try_to_wake_up+0x3b/0x4d0\n",
+		"/sys/fs/pstore/ftrace-ramoops-1": "CPU:1 ts:1000 ffffffff810a2c40  ffffffffa0000010  try_to_wake_up <- ext4_fill_super+0x10/0x400 [ext4]\n",
+		"/sys/fs/pstore/dmesg-ramoops-0":  "Kernel panic\n",
+	})
+	fp := NewTestFileProvider(files)
+	f := newTestFtrace(t, files)
+
+	data, err := ReadPstoreFtrace(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := f.DecodePstoreFtrace(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		cpu    int
+		when   uint64
+		format string
+	}{
+		{1, 1000, " try_to_wake_up <-- ext4_fill_super [ext4]"},
+		{0, 2000, " wake_up_process <-- try_to_wake_up"},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("want %d events got %d", len(want), len(events))
+	}
+	for i, w := range want {
+		e := events[i]
+		if e.Cpu != w.cpu || e.When != w.when || e.EventType().Format(*e) != w.format {
+			t.Errorf("event %d: want %+v got cpu %d when %d %q", i, w, e.Cpu, e.When, e.EventType().Format(*e))
+		}
+	}
+
+	if _, err := f.DecodePstoreFtrace([]byte("garbage\n")); err == nil {
+		t.Error("want an error for a bad record")
+	}
+	if _, err := ReadPstoreFtrace(NewTestFileProvider(testFiles)); err == nil {
+		t.Error("want an error without ftrace-ramoops files")
+	}
+	if _, err := fp.ReadPstoreFile("dmesg-ramoops-0"); err != BadPstoreFileName {
+		t.Errorf("want BadPstoreFileName for a console log got %v", err)
+	}
+}
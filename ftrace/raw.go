@@ -26,19 +26,23 @@ const (
 )
 
 // Returns a channel that provides [page size]byte chunks from a cpu raw ftrace pipe
+// setup is called first on the reading goroutine
 // Write to doneCh to end
-func getRawFtraceChan(fp FileProvider, cpu int, doneCh <-chan bool) (<-chan []byte, error) {
+// The pipe is returned so it can be closed to unblock the reader
+func getRawFtraceChan(fp FileProvider, cpu int, setup func(), doneCh <-chan bool) (<-chan []byte, io.Closer, error) {
 	ch := make(chan []byte)
 
 	f, err := fp.OpenFtrace(fmt.Sprintf(perCpuRawPipeFmt, cpu))
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	go func() {
 		defer f.Close()
 		defer close(ch)
 
+		setup()
+
 		for {
 			var buf = make([]byte, syscall.Getpagesize())
 			n, err := f.Read(buf)
@@ -55,11 +59,11 @@ func getRawFtraceChan(fp FileProvider, cpu int, doneCh <-chan bool) (<-chan []by
 			case <-doneCh:
 				// This goroutine may be blocked in the Read above, so this may never fire if no
 				// trace events are pending
-				break
+				return
 			case ch <- buf[0:n]:
 			}
 		}
 	}()
 
-	return ch, nil
+	return ch, f, nil
 }
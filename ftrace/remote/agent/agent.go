@@ -0,0 +1,68 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// agent runs on a traced device and serves its tracing files to btrace -remote
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/google/traceout/ftrace"
+	"github.com/google/traceout/ftrace/remote"
+)
+
+var (
+	network string
+	address string
+	serial  string
+	baud    int
+)
+
+func init() {
+	flag.StringVar(&network, "net", "tcp", "network to listen on (tcp or unix)")
+	flag.StringVar(&address, "listen", ":6061", "address to listen on")
+	flag.StringVar(&serial, "serial", "", "serve on a serial device instead of the network")
+	flag.IntVar(&baud, "baud", 115200, "baud rate for -serial")
+}
+
+func main() {
+	flag.Parse()
+
+	s := remote.NewServer(ftrace.NewLocalFileProvider())
+
+	if serial != "" {
+		conn, err := remote.OpenSerial(serial, baud)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		s.ServeConn(conn)
+		return
+	}
+
+	l, err := net.Listen(network, address)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	err = s.Serve(l)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+// This file implements a simple framing layer for byte streams that may
+// contain unrelated data, like a serial console that also carries kernel
+// messages and a login prompt.  Each write is sent as a frame:
+//   magic (4 bytes) | payload length (4 bytes) | payload | crc32 of payload (4 bytes)
+// The reader discards anything between frames.  A frame that fails its
+// CRC can't be skipped, the RPC stream would lose its place, so it ends
+// the connection.
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"sync"
+)
+
+var frameMagic = []byte{0xf7, 'T', 'O', 0x01}
+
+const (
+	frameHeaderSize  = 8
+	frameTrailerSize = 4
+	// maxFrameSize is the largest Read reply, with room for the RPC
+	// header around it
+	maxFrameSize = maxReadSize + 4096
+)
+
+// BadFrame is returned when a frame fails its CRC, after which the stream
+// can't be trusted
+var BadFrame error = errors.New("Corrupted frame")
+
+type framedConn struct {
+	conn io.ReadWriteCloser
+	r    *bufio.Reader
+
+	writeLock sync.Mutex
+
+	// remaining payload of the current frame
+	pending []byte
+}
+
+// NewFramedConn wraps a byte stream that may contain unrelated data, so
+// that it can be used as the transport for a Client and Server.  Both
+// ends of the stream must be wrapped.
+func NewFramedConn(conn io.ReadWriteCloser) io.ReadWriteCloser {
+	return &framedConn{
+		conn: conn,
+		r:    bufio.NewReaderSize(conn, 64*1024),
+	}
+}
+
+func (f *framedConn) Write(buf []byte) (int, error) {
+	frame := make([]byte, 0, frameHeaderSize+len(buf)+frameTrailerSize)
+	frame = append(frame, frameMagic...)
+	frame = binary.LittleEndian.AppendUint32(frame, uint32(len(buf)))
+	frame = append(frame, buf...)
+	frame = binary.LittleEndian.AppendUint32(frame, crc32.ChecksumIEEE(buf))
+
+	f.writeLock.Lock()
+	defer f.writeLock.Unlock()
+
+	_, err := f.conn.Write(frame)
+	if err != nil {
+		return 0, err
+	}
+	return len(buf), nil
+}
+
+func (f *framedConn) Read(buf []byte) (int, error) {
+	for len(f.pending) == 0 {
+		payload, err := f.readFrame()
+		if err != nil {
+			return 0, err
+		}
+		f.pending = payload
+	}
+
+	n := copy(buf, f.pending)
+	f.pending = f.pending[n:]
+	return n, nil
+}
+
+// readFrame returns the payload of the next valid frame in the stream
+func (f *framedConn) readFrame() ([]byte, error) {
+	for {
+		err := f.sync()
+		if err != nil {
+			return nil, err
+		}
+
+		header, err := f.r.Peek(frameHeaderSize)
+		if err != nil {
+			return nil, err
+		}
+		size := int(binary.LittleEndian.Uint32(header[len(frameMagic):]))
+		if size > maxFrameSize {
+			// Not a real frame, skip the magic and resync
+			f.r.Discard(1)
+			continue
+		}
+
+		frame := make([]byte, frameHeaderSize+size+frameTrailerSize)
+		_, err = io.ReadFull(f.r, frame)
+		if err != nil {
+			return nil, err
+		}
+
+		payload := frame[frameHeaderSize : frameHeaderSize+size]
+		crc := binary.LittleEndian.Uint32(frame[frameHeaderSize+size:])
+		if crc != crc32.ChecksumIEEE(payload) {
+			// Corrupted frame, or a false match on the magic.  There is no
+			// retransmission, and dropping a frame would desync the RPC
+			// codec, so give up on the connection.
+			return nil, BadFrame
+		}
+
+		return payload, nil
+	}
+}
+
+// sync discards data until the reader is positioned at a frame magic
+func (f *framedConn) sync() error {
+	for {
+		b, err := f.r.Peek(len(frameMagic))
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(b, frameMagic) {
+			return nil
+		}
+		f.r.Discard(1)
+	}
+}
+
+func (f *framedConn) Close() error {
+	return f.conn.Close()
+}
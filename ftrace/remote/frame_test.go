@@ -0,0 +1,94 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"github.com/google/traceout/ftrace"
+)
+
+type bufferConn struct {
+	bytes.Buffer
+}
+
+func (b *bufferConn) Close() error {
+	return nil
+}
+
+func TestFrameSkipsNoise(t *testing.T) {
+	var wire bufferConn
+	w := NewFramedConn(&wire)
+
+	wire.WriteString("[   12.345678] console noise\r\nlogin: ")
+	w.Write([]byte("first"))
+	wire.Write(frameMagic)
+	wire.WriteString("truncated magic")
+	w.Write([]byte("second"))
+
+	// A frame too large to be real is skipped as noise
+	wire.Write(frameMagic)
+	wire.Write([]byte{0, 0, 0, 0x10})
+	w.Write([]byte("third"))
+
+	// Corrupt the payload of the fourth frame, which ends the stream
+	w.Write([]byte("corrupt"))
+	wire.Bytes()[wire.Len()-frameTrailerSize-1] ^= 0xff
+	w.Write([]byte("lost"))
+
+	r := NewFramedConn(&wire)
+	got, err := ioutil.ReadAll(r)
+	if err != BadFrame {
+		t.Errorf("want BadFrame got %v", err)
+	}
+	if string(got) != "firstsecondthird" {
+		t.Errorf("want firstsecondthird got %q", got)
+	}
+}
+
+// noisyConn inserts a console message in front of every write
+type noisyConn struct {
+	net.Conn
+}
+
+func (n noisyConn) Write(buf []byte) (int, error) {
+	n.Conn.Write([]byte("\r\n[ 1.000000] printk\r\n"))
+	return n.Conn.Write(buf)
+}
+
+func TestFramedRemote(t *testing.T) {
+	server, client := net.Pipe()
+	s := NewServer(ftrace.NewTestFileProvider(testFiles))
+	go s.ServeConn(NewFramedConn(noisyConn{server}))
+	c := NewClient(NewFramedConn(noisyConn{client}))
+	defer c.Close()
+
+	f, err := c.OpenFtrace("per_cpu/cpu0/trace_pipe_raw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	buf, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != testFiles["per_cpu/cpu0/trace_pipe_raw"] {
+		t.Errorf("read %d bytes, want %d", len(buf), len(testFiles["per_cpu/cpu0/trace_pipe_raw"]))
+	}
+}
@@ -0,0 +1,295 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*
+Package remote is a reference implementation of an ftrace.FileProvider that
+forwards all file accesses to another machine.
+
+A Server runs on the traced device and wraps a local FileProvider (usually
+ftrace.NewLocalFileProvider()).  A Client runs on the host and implements
+ftrace.FileProvider by making calls to the Server, so all decoding and
+formatting happens on the host.  The transport is net/rpc over any
+io.ReadWriteCloser, so it works over TCP, unix sockets, pipes to a
+subprocess, or serial lines.
+
+For devices without a network, OpenSerial configures a serial line on each
+end and wraps it in a framing layer that skips console output between
+frames; run the agent with -serial on the device and btrace with -serial on
+the host.
+
+Trace pipes opened with OpenFtrace are streamed by issuing Read calls on a
+server-side handle; each call returns at most one read's worth of data from
+the underlying file.
+*/
+package remote
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/rpc"
+	"sync"
+
+	"github.com/google/traceout/ftrace"
+)
+
+const serviceName = "FileProvider"
+
+// maxReadSize limits the amount of data returned by a single Read call
+const maxReadSize = 1 << 20
+
+var BadHandle = errors.New("Bad remote file handle")
+
+type WriteArgs struct {
+	Name string
+	Data []byte
+}
+
+type ReadArgs struct {
+	Handle int
+	Size   int
+}
+
+type ReadReply struct {
+	Data []byte
+	EOF  bool
+}
+
+// Server serves a FileProvider to remote Clients
+type Server struct {
+	fp ftrace.FileProvider
+}
+
+// service is the type registered with net/rpc; all of its exported methods
+// are RPC calls.  Each connection has its own, so handles are only valid on
+// the connection that opened them.
+type service struct {
+	fp ftrace.FileProvider
+
+	sync.Mutex
+	files      map[int]io.ReadCloser
+	nextHandle int
+}
+
+func NewServer(fp ftrace.FileProvider) *Server {
+	return &Server{fp: fp}
+}
+
+// Serve accepts connections on the listener and serves each one in a new
+// goroutine.  It returns when the listener fails.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+// ServeConn serves a single connection, and returns when the client hangs
+// up.  The files the client left open are closed.
+func (s *Server) ServeConn(conn io.ReadWriteCloser) {
+	svc := &service{
+		fp:    s.fp,
+		files: make(map[int]io.ReadCloser),
+	}
+	server := rpc.NewServer()
+	if err := server.RegisterName(serviceName, svc); err != nil {
+		panic(err)
+	}
+	server.ServeConn(&serviceConn{ReadWriteCloser: conn, svc: svc})
+	svc.closeAll()
+}
+
+// serviceConn closes the files of its service once the client hangs up,
+// which ends the Read calls blocked on a trace pipe that ServeConn would
+// otherwise wait for
+type serviceConn struct {
+	io.ReadWriteCloser
+	svc *service
+}
+
+func (c *serviceConn) Read(buf []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Read(buf)
+	if err != nil {
+		c.svc.closeAll()
+	}
+	return n, err
+}
+
+func (s *service) closeAll() {
+	s.Lock()
+	files := s.files
+	s.files = make(map[int]io.ReadCloser)
+	s.Unlock()
+	for _, f := range files {
+		f.Close()
+	}
+}
+
+func (s *service) ReadFtraceFile(name string, reply *[]byte) (err error) {
+	*reply, err = s.fp.ReadFtraceFile(name)
+	return
+}
+
+func (s *service) WriteFtraceFile(args WriteArgs, reply *bool) error {
+	return s.fp.WriteFtraceFile(args.Name, args.Data)
+}
+
+func (s *service) ReadProcFile(name string, reply *[]byte) (err error) {
+	*reply, err = s.fp.ReadProcFile(name)
+	return
+}
+
+func (s *service) ReadPstoreFile(name string, reply *[]byte) (err error) {
+	*reply, err = s.fp.ReadPstoreFile(name)
+	return
+}
+
+func (s *service) OpenFtrace(name string, handle *int) error {
+	f, err := s.fp.OpenFtrace(name)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	s.nextHandle++
+	*handle = s.nextHandle
+	s.files[*handle] = f
+	s.Unlock()
+
+	return nil
+}
+
+func (s *service) Read(args ReadArgs, reply *ReadReply) error {
+	s.Lock()
+	f := s.files[args.Handle]
+	s.Unlock()
+	if f == nil {
+		return BadHandle
+	}
+
+	size := args.Size
+	if size > maxReadSize {
+		size = maxReadSize
+	}
+	buf := make([]byte, size)
+	n, err := f.Read(buf)
+	reply.Data = buf[:n]
+	if err == io.EOF {
+		reply.EOF = true
+		err = nil
+	}
+	return err
+}
+
+func (s *service) Close(handle int, reply *bool) error {
+	s.Lock()
+	f := s.files[handle]
+	delete(s.files, handle)
+	s.Unlock()
+	if f == nil {
+		return BadHandle
+	}
+	return f.Close()
+}
+
+// Client is a FileProvider that forwards all accesses to a Server
+type Client struct {
+	rpc *rpc.Client
+}
+
+// NewClient returns a Client that makes calls over conn
+func NewClient(conn io.ReadWriteCloser) *Client {
+	return &Client{
+		rpc: rpc.NewClient(conn),
+	}
+}
+
+// Dial connects to a Server listening on the given network address
+func Dial(network, address string) (*Client, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn), nil
+}
+
+func (c *Client) call(method string, args interface{}, reply interface{}) error {
+	return c.rpc.Call(serviceName+"."+method, args, reply)
+}
+
+func (c *Client) ReadFtraceFile(name string) ([]byte, error) {
+	var buf []byte
+	err := c.call("ReadFtraceFile", name, &buf)
+	return buf, err
+}
+
+func (c *Client) WriteFtraceFile(name string, data []byte) error {
+	var reply bool
+	return c.call("WriteFtraceFile", WriteArgs{name, data}, &reply)
+}
+
+func (c *Client) ReadProcFile(name string) ([]byte, error) {
+	var buf []byte
+	err := c.call("ReadProcFile", name, &buf)
+	return buf, err
+}
+
+func (c *Client) ReadPstoreFile(name string) ([]byte, error) {
+	var buf []byte
+	err := c.call("ReadPstoreFile", name, &buf)
+	return buf, err
+}
+
+func (c *Client) OpenFtrace(name string) (io.ReadCloser, error) {
+	var handle int
+	err := c.call("OpenFtrace", name, &handle)
+	if err != nil {
+		return nil, err
+	}
+	return &remoteReader{
+		client: c,
+		handle: handle,
+	}, nil
+}
+
+// Close closes the connection to the Server
+func (c *Client) Close() error {
+	return c.rpc.Close()
+}
+
+type remoteReader struct {
+	client *Client
+	handle int
+}
+
+func (r *remoteReader) Read(buf []byte) (int, error) {
+	var reply ReadReply
+	err := r.client.call("Read", ReadArgs{r.handle, len(buf)}, &reply)
+	if err != nil {
+		return 0, err
+	}
+	n := copy(buf, reply.Data)
+	if reply.EOF && n == 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+func (r *remoteReader) Close() error {
+	var reply bool
+	return r.client.call("Close", r.handle, &reply)
+}
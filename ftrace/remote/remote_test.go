@@ -0,0 +1,135 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/traceout/ftrace"
+)
+
+var testFiles = map[string]string{
+	"/sys/kernel/debug/tracing/events/header_page": "header",
+	"/proc/kallsyms":              "ffffffff81000000 T _stext\n",
+	"per_cpu/cpu0/trace_pipe_raw": string(bytes.Repeat([]byte{0, 1, 2, 3}, 4096)),
+}
+
+func newTestClient() *Client {
+	server, client := net.Pipe()
+	s := NewServer(ftrace.NewTestFileProvider(testFiles))
+	go s.ServeConn(server)
+	return NewClient(client)
+}
+
+func TestRemoteReadFiles(t *testing.T) {
+	c := newTestClient()
+	defer c.Close()
+
+	buf, err := c.ReadFtraceFile("events/header_page")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "header" {
+		t.Error("ReadFtraceFile want header got", string(buf))
+	}
+
+	buf, err = c.ReadProcFile("kallsyms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != testFiles["/proc/kallsyms"] {
+		t.Error("ReadProcFile want", testFiles["/proc/kallsyms"], "got", string(buf))
+	}
+
+	if _, err = c.ReadProcFile("self/environ"); err == nil {
+		t.Error("ReadProcFile of non-whitelisted file succeeded")
+	}
+
+	if err = c.WriteFtraceFile("tracing_on", []byte("1")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRemoteOpenFtrace(t *testing.T) {
+	c := newTestClient()
+	defer c.Close()
+
+	f, err := c.OpenFtrace("per_cpu/cpu0/trace_pipe_raw")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != testFiles["per_cpu/cpu0/trace_pipe_raw"] {
+		t.Errorf("OpenFtrace read %d bytes, want %d", len(buf), len(testFiles["per_cpu/cpu0/trace_pipe_raw"]))
+	}
+
+	if err = f.Close(); err != nil {
+		t.Error(err)
+	}
+	if err = f.Close(); err == nil {
+		t.Error("second Close succeeded")
+	}
+}
+
+func TestRemoteHangUpClosesFiles(t *testing.T) {
+	server, client := net.Pipe()
+	fp := ftrace.NewTestFileProviderWithStreams(testFiles, map[string][]ftrace.TestRead{
+		"per_cpu/cpu0/trace_pipe_raw": {{Block: true}},
+	})
+	served := make(chan bool)
+	go func() {
+		NewServer(fp).ServeConn(server)
+		close(served)
+	}()
+
+	c := NewClient(client)
+	f, err := c.OpenFtrace("per_cpu/cpu0/trace_pipe_raw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ioutil.ReadAll(f)
+
+	// The Read blocked on the pipe ends with the connection
+	time.Sleep(10 * time.Millisecond)
+	c.Close()
+	select {
+	case <-served:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ServeConn didn't return")
+	}
+}
+
+func TestRemoteHandlesPerConnection(t *testing.T) {
+	c := newTestClient()
+	defer c.Close()
+	if _, err := c.OpenFtrace("per_cpu/cpu0/trace_pipe_raw"); err != nil {
+		t.Fatal(err)
+	}
+
+	other := newTestClient()
+	defer other.Close()
+	var reply ReadReply
+	if err := other.call("Read", ReadArgs{Handle: 1, Size: 16}, &reply); err == nil || err.Error() != BadHandle.Error() {
+		t.Errorf("want %v reading another connection's handle got %v", BadHandle, err)
+	}
+}
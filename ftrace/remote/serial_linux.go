@@ -0,0 +1,91 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// CBAUD from asm-generic/termbits.h, not exported by package syscall
+const cbaud = 0010017
+
+var baudRates = map[int]uint32{
+	9600:    syscall.B9600,
+	19200:   syscall.B19200,
+	38400:   syscall.B38400,
+	57600:   syscall.B57600,
+	115200:  syscall.B115200,
+	230400:  syscall.B230400,
+	460800:  syscall.B460800,
+	921600:  syscall.B921600,
+	1000000: syscall.B1000000,
+	1500000: syscall.B1500000,
+	2000000: syscall.B2000000,
+	3000000: syscall.B3000000,
+	4000000: syscall.B4000000,
+}
+
+// OpenSerial opens a serial device, puts it in raw mode at the given baud
+// rate, and returns a framed stream suitable for NewClient or
+// Server.ServeConn.  The same call is used on both ends of the line.
+func OpenSerial(device string, baud int) (io.ReadWriteCloser, error) {
+	speed, ok := baudRates[baud]
+	if !ok {
+		return nil, fmt.Errorf("unsupported baud rate %d", baud)
+	}
+
+	f, err := os.OpenFile(device, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	err = setRaw(f.Fd(), speed)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %s", device, err.Error())
+	}
+
+	return NewFramedConn(f), nil
+}
+
+// setRaw is the equivalent of cfmakeraw and cfsetspeed
+func setRaw(fd uintptr, speed uint32) error {
+	var t syscall.Termios
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TCGETS, uintptr(unsafe.Pointer(&t)))
+	if errno != 0 {
+		return errno
+	}
+
+	t.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP |
+		syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON | syscall.IXOFF
+	t.Oflag &^= syscall.OPOST
+	t.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	t.Cflag &^= syscall.CSIZE | syscall.PARENB | cbaud
+	// TCSETS takes the speed from the CBAUD bits only, and the Ispeed and
+	// Ospeed fields don't exist on every architecture, like mips
+	t.Cflag |= syscall.CS8 | syscall.CREAD | syscall.CLOCAL | speed
+	t.Cc[syscall.VMIN] = 1
+	t.Cc[syscall.VTIME] = 0
+
+	_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TCSETS, uintptr(unsafe.Pointer(&t)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
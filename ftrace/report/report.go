@@ -0,0 +1,334 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package report summarizes a capture as a single self-contained HTML file,
+// with per-cpu timelines, the processes that ran the longest, IRQ counts and
+// block I/O latency percentiles, for sharing results with people who don't
+// have trace viewers.
+//
+// It uses the sched_switch, irq_handler_entry, block_rq_issue and
+// block_rq_complete events, and leaves out the sections whose events were
+// not captured.
+package report
+
+import (
+	"fmt"
+	"hash/fnv"
+	"html/template"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/google/traceout/ftrace"
+	"github.com/google/traceout/ftrace/analysis"
+)
+
+// maxSlices limits the slices drawn per cpu to keep the file small
+const maxSlices = 20000
+
+// topProcesses is how many processes are listed by runtime
+const topProcesses = 20
+
+// Report is the summary of a capture
+type Report struct {
+	// Start and End are the timestamps of the first and last events, in
+	// nanoseconds
+	Start, End uint64
+	Events     int
+
+	CPUs      []CPUTimeline
+	Processes []ProcessRuntime
+	IRQs      []IRQCount
+	Block     []BlockLatency
+}
+
+// Slice is a time a process ran on a cpu
+type Slice struct {
+	Start, End uint64
+	Pid        int
+	Comm       string
+}
+
+// CPUTimeline holds what ran on a cpu, leaving out the idle task
+type CPUTimeline struct {
+	CPU    int
+	Slices []Slice
+}
+
+// ProcessRuntime is the total time a process ran on all cpus
+type ProcessRuntime struct {
+	Pid     int
+	Comm    string
+	Runtime time.Duration
+}
+
+// IRQCount is the number of times an IRQ's handler ran
+type IRQCount struct {
+	IRQ   int64
+	Name  string
+	Count int
+}
+
+// BlockLatency summarizes the time from issuing block requests to a device
+// until they complete
+type BlockLatency struct {
+	Device             string
+	Count              int
+	P50, P90, P99, Max time.Duration
+}
+
+// New computes the report for events, which must be sorted by time
+func New(events ftrace.Events) *Report {
+	r := &Report{Events: len(events)}
+	if len(events) == 0 {
+		return r
+	}
+	r.Start = events[0].When
+	r.End = events[len(events)-1].When
+
+	r.schedule(events)
+	r.irqs(events)
+	r.block(events)
+	return r
+}
+
+func (r *Report) schedule(events ftrace.Events) {
+	type running struct {
+		since uint64
+		pid   int
+		comm  string
+	}
+	current := make(map[int]running)
+	cpus := make(map[int]*CPUTimeline)
+	runtime := make(map[int]*ProcessRuntime)
+
+	end := func(cpu int, when uint64) {
+		c, ok := current[cpu]
+		if !ok || c.pid == 0 || when <= c.since {
+			return
+		}
+		t := cpus[cpu]
+		if t == nil {
+			t = &CPUTimeline{CPU: cpu}
+			cpus[cpu] = t
+		}
+		if len(t.Slices) < maxSlices {
+			t.Slices = append(t.Slices, Slice{c.since, when, c.pid, c.comm})
+		}
+		p := runtime[c.pid]
+		if p == nil {
+			p = &ProcessRuntime{Pid: c.pid}
+			runtime[c.pid] = p
+		}
+		p.Comm = c.comm
+		p.Runtime += time.Duration(when - c.since)
+	}
+
+	for _, e := range events {
+		if e.EventType().Name() != "sched_switch" {
+			continue
+		}
+		pid, err := e.Int("next_pid")
+		if err != nil {
+			continue
+		}
+		comm, _ := e.Str("next_comm")
+		end(e.Cpu, e.When)
+		current[e.Cpu] = running{e.When, int(pid), comm}
+	}
+	for cpu := range current {
+		end(cpu, r.End)
+	}
+
+	for _, t := range cpus {
+		r.CPUs = append(r.CPUs, *t)
+	}
+	sort.Slice(r.CPUs, func(i, j int) bool { return r.CPUs[i].CPU < r.CPUs[j].CPU })
+
+	for _, p := range runtime {
+		r.Processes = append(r.Processes, *p)
+	}
+	sort.Slice(r.Processes, func(i, j int) bool {
+		if r.Processes[i].Runtime != r.Processes[j].Runtime {
+			return r.Processes[i].Runtime > r.Processes[j].Runtime
+		}
+		return r.Processes[i].Pid < r.Processes[j].Pid
+	})
+	if len(r.Processes) > topProcesses {
+		r.Processes = r.Processes[:topProcesses]
+	}
+}
+
+func (r *Report) irqs(events ftrace.Events) {
+	counts := make(map[int64]*IRQCount)
+	for _, e := range events {
+		if e.EventType().Name() != "irq_handler_entry" {
+			continue
+		}
+		irq, err := e.Int("irq")
+		if err != nil {
+			continue
+		}
+		c := counts[irq]
+		if c == nil {
+			c = &IRQCount{IRQ: irq}
+			c.Name, _ = e.Str("name")
+			counts[irq] = c
+		}
+		c.Count++
+	}
+
+	for _, c := range counts {
+		r.IRQs = append(r.IRQs, *c)
+	}
+	sort.Slice(r.IRQs, func(i, j int) bool {
+		if r.IRQs[i].Count != r.IRQs[j].Count {
+			return r.IRQs[i].Count > r.IRQs[j].Count
+		}
+		return r.IRQs[i].IRQ < r.IRQs[j].IRQ
+	})
+}
+
+func (r *Report) block(events ftrace.Events) {
+	b := analysis.NewBlockLatencies()
+	for _, e := range events {
+		b.Add(e)
+	}
+	for dev, d := range b.ByDevice() {
+		r.Block = append(r.Block, BlockLatency{
+			Device: analysis.DevName(dev),
+			Count:  d.Count,
+			P50:    d.P50,
+			P90:    d.P90,
+			P99:    d.P99,
+			Max:    d.Max,
+		})
+	}
+	sort.Slice(r.Block, func(i, j int) bool { return r.Block[i].Device < r.Block[j].Device })
+}
+
+// timelineWidth is the width of the timeline drawing in pixels
+const timelineWidth = 1000
+
+type svgRect struct {
+	X, Width float64
+	Color    string
+	Title    string
+}
+
+type svgRow struct {
+	CPU   int
+	Y     int
+	Rects []svgRect
+}
+
+// color picks a stable color for a pid
+func color(pid int) string {
+	h := fnv.New32a()
+	fmt.Fprint(h, pid)
+	return fmt.Sprintf("hsl(%d, 60%%, 55%%)", h.Sum32()%360)
+}
+
+func (r *Report) rows() []svgRow {
+	duration := float64(r.End - r.Start)
+	if duration == 0 {
+		duration = 1
+	}
+	var rows []svgRow
+	for i, t := range r.CPUs {
+		row := svgRow{CPU: t.CPU, Y: i * 20}
+		for _, s := range t.Slices {
+			x := float64(s.Start-r.Start) / duration * timelineWidth
+			w := float64(s.End-s.Start) / duration * timelineWidth
+			if w < 0.1 {
+				w = 0.1
+			}
+			row.Rects = append(row.Rects, svgRect{
+				X:     x,
+				Width: w,
+				Color: color(s.Pid),
+				Title: fmt.Sprintf("%s-%d %v", s.Comm, s.Pid, time.Duration(s.End-s.Start)),
+			})
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>traceout report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 0.2em 0.6em; text-align: right; }
+th { background: #eee; }
+td.name { text-align: left; }
+</style>
+</head>
+<body>
+<h1>traceout report</h1>
+<p>{{.Report.Events}} events over {{.Duration}}</p>
+{{if .Rows}}
+<h2>CPU timeline</h2>
+<svg width="{{.Width}}" height="{{.Height}}" viewBox="-60 0 {{.Width}} {{.Height}}">
+{{range .Rows}}<text x="-55" y="{{.Y}}" dy="14" font-size="12">cpu {{.CPU}}</text>
+<g transform="translate(0,{{.Y}})">{{range .Rects}}<rect x="{{printf "%.2f" .X}}" width="{{printf "%.2f" .Width}}" height="18" fill="{{.Color}}"><title>{{.Title}}</title></rect>{{end}}</g>
+{{end}}</svg>
+{{end}}
+{{if .Report.Processes}}
+<h2>Top processes by runtime</h2>
+<table>
+<tr><th>pid</th><th>name</th><th>runtime</th></tr>
+{{range .Report.Processes}}<tr><td>{{.Pid}}</td><td class="name">{{.Comm}}</td><td>{{.Runtime}}</td></tr>
+{{end}}</table>
+{{end}}
+{{if .Report.IRQs}}
+<h2>IRQs</h2>
+<table>
+<tr><th>irq</th><th>name</th><th>count</th></tr>
+{{range .Report.IRQs}}<tr><td>{{.IRQ}}</td><td class="name">{{.Name}}</td><td>{{.Count}}</td></tr>
+{{end}}</table>
+{{end}}
+{{if .Report.Block}}
+<h2>Block I/O latency</h2>
+<table>
+<tr><th>device</th><th>requests</th><th>p50</th><th>p90</th><th>p99</th><th>max</th></tr>
+{{range .Report.Block}}<tr><td class="name">{{.Device}}</td><td>{{.Count}}</td><td>{{.P50}}</td><td>{{.P90}}</td><td>{{.P99}}</td><td>{{.Max}}</td></tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
+
+// WriteHTML writes the report as a single HTML page with no external
+// resources
+func (r *Report) WriteHTML(w io.Writer) error {
+	rows := r.rows()
+	return reportTemplate.Execute(w, struct {
+		Report   *Report
+		Duration time.Duration
+		Rows     []svgRow
+		Width    int
+		Height   int
+	}{
+		Report:   r,
+		Duration: time.Duration(r.End - r.Start),
+		Rows:     rows,
+		Width:    timelineWidth + 60,
+		Height:   len(rows) * 20,
+	})
+}
@@ -0,0 +1,146 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/traceout/ftrace"
+)
+
+const commonFields = `	field:unsigned short common_type;	offset:0;	size:2;	signed:0;
+	field:unsigned char common_flags;	offset:2;	size:1;	signed:0;
+	field:unsigned char common_preempt_count;	offset:3;	size:1;	signed:0;
+	field:int common_pid;	offset:4;	size:4;	signed:1;
+`
+
+const schedSwitchFormat = `name: sched_switch
+ID: 61
+format:
+` + commonFields + `
+	field:char prev_comm[16];	offset:8;	size:16;	signed:0;
+	field:pid_t prev_pid;	offset:24;	size:4;	signed:1;
+	field:int prev_prio;	offset:28;	size:4;	signed:1;
+	field:long prev_state;	offset:32;	size:8;	signed:1;
+	field:char next_comm[16];	offset:40;	size:16;	signed:0;
+	field:pid_t next_pid;	offset:56;	size:4;	signed:1;
+	field:int next_prio;	offset:60;	size:4;	signed:1;
+
+print fmt: "prev_comm=%s prev_pid=%d next_comm=%s next_pid=%d", REC->prev_comm, REC->prev_pid, REC->next_comm, REC->next_pid
+`
+
+const irqHandlerEntryFormat = `name: irq_handler_entry
+ID: 95
+format:
+` + commonFields + `
+	field:int irq;	offset:8;	size:4;	signed:1;
+	field:__data_loc char[] name;	offset:12;	size:4;	signed:1;
+
+print fmt: "irq=%d name=%s", REC->irq, __get_str(name)
+`
+
+const blockRqFormat = `name: %s
+ID: %d
+format:
+` + commonFields + `
+	field:dev_t dev;	offset:8;	size:4;	signed:0;
+	field:sector_t sector;	offset:16;	size:8;	signed:0;
+	field:unsigned int nr_sector;	offset:24;	size:4;	signed:0;
+
+print fmt: "%%d,%%d %%llu + %%u", ((unsigned int) ((REC->dev) >> 20)), ((unsigned int) ((REC->dev) & ((1U << 20) - 1))), (unsigned long long)REC->sector, REC->nr_sector
+`
+
+func eventType(t *testing.T, format string) *ftrace.EventType {
+	etype, err := ftrace.ParseEventFormat([]byte(format))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return etype
+}
+
+type testEvents struct {
+	t      *testing.T
+	events ftrace.Events
+}
+
+func (te *testEvents) add(etype *ftrace.EventType, cpu int, when uint64, fields map[string]interface{}) {
+	e, err := etype.NewEvent(fields, cpu, when)
+	if err != nil {
+		te.t.Fatal(err)
+	}
+	te.events = append(te.events, e)
+}
+
+func TestReport(t *testing.T) {
+	schedSwitch := eventType(t, schedSwitchFormat)
+	irqEntry := eventType(t, irqHandlerEntryFormat)
+	issue := eventType(t, strings.Replace(strings.Replace(blockRqFormat, "%s", "block_rq_issue", 1), "%d", "1001", 1))
+	complete := eventType(t, strings.Replace(strings.Replace(blockRqFormat, "%s", "block_rq_complete", 1), "%d", "1002", 1))
+
+	te := &testEvents{t: t}
+	ms := uint64(time.Millisecond)
+	te.add(schedSwitch, 0, 0, map[string]interface{}{"next_pid": 10, "next_comm": "make"})
+	te.add(schedSwitch, 1, 1*ms, map[string]interface{}{"next_pid": 20, "next_comm": "cc1"})
+	te.add(irqEntry, 0, 2*ms, map[string]interface{}{"irq": 42, "name": "eth0"})
+	te.add(issue, 1, 2*ms, map[string]interface{}{"dev": 8 << 20, "sector": 100})
+	te.add(issue, 1, 3*ms, map[string]interface{}{"dev": 8 << 20, "sector": 200})
+	te.add(schedSwitch, 0, 4*ms, map[string]interface{}{"next_pid": 0, "next_comm": "swapper/0"})
+	te.add(irqEntry, 0, 5*ms, map[string]interface{}{"irq": 42, "name": "eth0"})
+	te.add(complete, 1, 6*ms, map[string]interface{}{"dev": 8 << 20, "sector": 100})
+	te.add(complete, 1, 9*ms, map[string]interface{}{"dev": 8 << 20, "sector": 200})
+	te.add(schedSwitch, 1, 10*ms, map[string]interface{}{"next_pid": 10, "next_comm": "make"})
+
+	r := New(te.events)
+
+	wantCPUs := []CPUTimeline{
+		{0, []Slice{{0, 4 * ms, 10, "make"}}},
+		{1, []Slice{{1 * ms, 10 * ms, 20, "cc1"}}},
+	}
+	if !reflect.DeepEqual(r.CPUs, wantCPUs) {
+		t.Errorf("want cpus %v got %v", wantCPUs, r.CPUs)
+	}
+
+	wantProcesses := []ProcessRuntime{
+		{20, "cc1", 9 * time.Millisecond},
+		{10, "make", 4 * time.Millisecond},
+	}
+	if !reflect.DeepEqual(r.Processes, wantProcesses) {
+		t.Errorf("want processes %v got %v", wantProcesses, r.Processes)
+	}
+
+	wantIRQs := []IRQCount{{42, "eth0", 2}}
+	if !reflect.DeepEqual(r.IRQs, wantIRQs) {
+		t.Errorf("want irqs %v got %v", wantIRQs, r.IRQs)
+	}
+
+	wantBlock := []BlockLatency{{"8,0", 2, 4 * time.Millisecond, 6 * time.Millisecond, 6 * time.Millisecond, 6 * time.Millisecond}}
+	if !reflect.DeepEqual(r.Block, wantBlock) {
+		t.Errorf("want block %v got %v", wantBlock, r.Block)
+	}
+
+	var buf bytes.Buffer
+	if err := r.WriteHTML(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"10 events over 10ms", "<title>make-10 4ms</title>", "<td class=\"name\">cc1</td><td>9ms</td>", "<td class=\"name\">eth0</td><td>2</td>", "<td class=\"name\">8,0</td><td>2</td><td>4ms</td>"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("report doesn't contain %q", want)
+		}
+	}
+}
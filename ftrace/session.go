@@ -0,0 +1,178 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"path"
+	"strings"
+)
+
+// Session saves the kernel's tracing configuration when it is created and
+// puts it back on Close, so a capture doesn't permanently change how the
+// machine is set up for tracing.
+type Session struct {
+	f *Ftrace
+	// fp is used to restore the configuration even after the Ftrace is
+	// closed
+	fp       FileProvider
+	saved    tracingState
+	triggers []kernelTrigger
+}
+
+// tracingState is a snapshot of the tracefs files a capture changes
+type tracingState struct {
+	files map[string]string
+	// events holds the enabled events as "<system>:<event>"
+	events []string
+	// filters maps enabled events' paths to their filters
+	filters map[string]string
+}
+
+// sessionFiles are restored in order, tracing_on last so nothing is
+// traced while the rest is put back.  Those a kernel doesn't have, like
+// options/record-tgid before 4.14, are left out.
+var sessionFiles = []string{
+	"current_tracer",
+	"buffer_size_kb",
+	"trace_clock",
+	"set_event_pid",
+	"options/event-fork",
+	"options/record-tgid",
+	"options/overwrite",
+	"options/stacktrace",
+	"tracing_on",
+}
+
+// NewSession saves the current tracing configuration, to be restored when
+// the returned Session is closed.  The Session may be closed after the
+// Ftrace.
+func (f *Ftrace) NewSession() (*Session, error) {
+	saved, err := saveTracingState(f.fp)
+	if err != nil {
+		return nil, err
+	}
+	return &Session{f: f, fp: f.fp.fp, saved: saved}, nil
+}
+
+// Ftrace returns the Ftrace the Session was created from
+func (s *Session) Ftrace() *Ftrace {
+	return s.f
+}
+
+// Close removes the Session's kernel triggers and restores the tracing
+// configuration saved by NewSession.  It puts back as much as it can, and
+// returns the first error.
+func (s *Session) Close() error {
+	var firstErr error
+	for _, t := range s.triggers {
+		err := s.fp.WriteFtraceFile(path.Join("events", t.path, "trigger"), []byte("!"+t.trigger))
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	s.triggers = nil
+
+	if err := restoreTracingState(s.fp, s.saved); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	return firstErr
+}
+
+func saveTracingState(fp FileProvider) (state tracingState, err error) {
+	state.files = make(map[string]string)
+	state.filters = make(map[string]string)
+
+	for _, name := range sessionFiles {
+		data, err := fp.ReadFtraceFile(name)
+		if err != nil {
+			// Missing from this kernel, so nothing changes it
+			continue
+		}
+		state.files[name] = currentSetting(name, string(data))
+	}
+
+	data, err := fp.ReadFtraceFile("set_event")
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		state.events = append(state.events, line)
+
+		p := strings.Replace(line, ":", "/", 1)
+		var filter []byte
+		filter, err = fp.ReadFtraceFile(path.Join("events", p, "filter"))
+		if err != nil {
+			return
+		}
+		state.filters[p] = strings.TrimSpace(string(filter))
+	}
+
+	return state, nil
+}
+
+// currentSetting turns the contents of a tracefs file into what needs to
+// be written back to it
+func currentSetting(name, data string) string {
+	switch name {
+	case "trace_clock":
+		// "[local] global counter ..." with the current clock in brackets
+		start := strings.Index(data, "[")
+		end := strings.Index(data, "]")
+		if start >= 0 && end > start {
+			return data[start+1 : end]
+		}
+	case "buffer_size_kb":
+		// "7 (expanded: 1408)" before the buffer is first used
+		if fields := strings.Fields(data); len(fields) > 0 {
+			return fields[0]
+		}
+	}
+	return strings.TrimSpace(data)
+}
+
+func restoreTracingState(fp FileProvider, state tracingState) error {
+	var firstErr error
+	write := func(name, data string) {
+		err := fp.WriteFtraceFile(name, []byte(data))
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	if on, ok := state.files["tracing_on"]; ok && on != "0" {
+		write("tracing_on", "0")
+	}
+
+	// Writing set_event replaces the enabled events
+	write("set_event", strings.Join(state.events, "\n"))
+	for p, filter := range state.filters {
+		if filter == "none" {
+			filter = "0"
+		}
+		write(path.Join("events", p, "filter"), filter)
+	}
+
+	for _, name := range sessionFiles {
+		if data, ok := state.files[name]; ok {
+			write(name, data)
+		}
+	}
+
+	return firstErr
+}
@@ -0,0 +1,137 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeLogFileProvider records the ftrace files written through it
+type writeLogFileProvider struct {
+	FileProvider
+	writes [][2]string
+}
+
+func (fp *writeLogFileProvider) WriteFtraceFile(filename string, data []byte) error {
+	fp.writes = append(fp.writes, [2]string{filename, string(data)})
+	return fp.FileProvider.WriteFtraceFile(filename, data)
+}
+
+func TestSession(t *testing.T) {
+	files := testFilesWith(map[string]string{
+		"/sys/kernel/debug/tracing/tracing_on":                       "1\n",
+		"/sys/kernel/debug/tracing/current_tracer":                   "nop\n",
+		"/sys/kernel/debug/tracing/buffer_size_kb":                   "7 (expanded: 1408)\n",
+		"/sys/kernel/debug/tracing/trace_clock":                      "local [global] counter uptime perf mono mono_raw boot\n",
+		"/sys/kernel/debug/tracing/set_event_pid":                    "",
+		"/sys/kernel/debug/tracing/options/event-fork":               "0\n",
+		"/sys/kernel/debug/tracing/options/record-tgid":              "0\n",
+		"/sys/kernel/debug/tracing/options/overwrite":                "1\n",
+		"/sys/kernel/debug/tracing/options/stacktrace":               "0\n",
+		"/sys/kernel/debug/tracing/set_event":                        "sched:sched_wakeup\n",
+		"/sys/kernel/debug/tracing/events/sched/sched_wakeup/filter": "pid == 1\n",
+	})
+	fp := &writeLogFileProvider{FileProvider: NewTestFileProvider(files)}
+
+	f, err := New(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := f.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Ftrace() != f {
+		t.Error("Session.Ftrace doesn't return its Ftrace")
+	}
+
+	// The session restores the configuration after the Ftrace is closed
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	fp.writes = nil
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := [][2]string{
+		{"tracing_on", "0"},
+		{"set_event", "sched:sched_wakeup"},
+		{"events/sched/sched_wakeup/filter", "pid == 1"},
+		{"current_tracer", "nop"},
+		{"buffer_size_kb", "7"},
+		{"trace_clock", "global"},
+		{"set_event_pid", ""},
+		{"options/event-fork", "0"},
+		{"options/record-tgid", "0"},
+		{"options/overwrite", "1"},
+		{"options/stacktrace", "0"},
+		{"tracing_on", "1"},
+	}
+	if !reflect.DeepEqual(fp.writes, want) {
+		t.Errorf("want writes\n%v\ngot\n%v", want, fp.writes)
+	}
+}
+
+func TestSessionMissingFiles(t *testing.T) {
+	// A kernel without options/record-tgid and options/stacktrace, where
+	// FollowPid filtered events to a pid
+	dir := t.TempDir()
+	writeTracefs(t, dir, map[string]string{
+		"events/task/task_newtask/format": taskNewtaskFormat,
+		"events/header_page":              headerPageFormat,
+		"tracing_on":                      "1\n",
+		"current_tracer":                  "nop\n",
+		"buffer_size_kb":                  "1408\n",
+		"trace_clock":                     "[local] global\n",
+		"set_event":                       "",
+		"set_event_pid":                   "",
+		"options/event-fork":              "0\n",
+		"options/overwrite":               "1\n",
+	})
+	f, err := New(NewLocalFileProviderAt(dir, t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := f.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.FollowPid(1234); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{"set_event_pid": "", "options/event-fork": "0"} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Errorf("want %s restored to %q got %q", name, want, data)
+		}
+	}
+	for _, name := range []string{"options/record-tgid", "options/stacktrace"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("want %s left missing got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"strings"
+)
+
+// kernelStackPath is the builtin event the kernel records stack traces as,
+// right after the event they belong to
+const kernelStackPath = "ftrace/kernel_stack"
+
+// EnableStacktrace turns on the stacktrace trace option, which makes the
+// kernel record its stack after every event.  The stacks are attached to
+// their events, see Event.KernelStack.
+func (f *Ftrace) EnableStacktrace() error {
+	if err := f.registerKernelStack(); err != nil {
+		return err
+	}
+	return f.fp.WriteFtraceFile("options/stacktrace", []byte("1"))
+}
+
+// registerKernelStack registers the kernel_stack event type to decode stack
+// traces, in case New couldn't
+func (f *Ftrace) registerKernelStack() error {
+	_, err := f.NewEventType(kernelStackPath)
+	return err
+}
+
+// StacktraceOn makes the kernel record its stack each time an event of type
+// etype matching filter, a kernel event filter such as "prev_state == 2", is
+// traced, or every event of the type for an empty filter.  The stacks are
+// attached to their events, see Event.KernelStack.  The trigger is removed
+// when the Session is closed.
+func (s *Session) StacktraceOn(etype *EventType, filter string) error {
+	if err := s.f.registerKernelStack(); err != nil {
+		return err
+	}
+	trigger := "stacktrace"
+	if filter != "" {
+		trigger += " if " + filter
+	}
+	err := etype.writeEventFile("trigger", []byte(trigger))
+	if err != nil {
+		return err
+	}
+	s.triggers = append(s.triggers, kernelTrigger{etype.path, trigger})
+	return nil
+}
+
+// isKernelStack returns whether the event type is the builtin kernel_stack
+func (etype *EventType) isKernelStack() bool {
+	return etype.name == "kernel_stack" && etype.getFieldNum("caller") >= 0 && etype.getFieldNum("size") >= 0
+}
+
+// stackFrames decodes the addresses of a kernel_stack or user_stack event.
+// A kernel_stack's caller array holds size entries, which may run past the
+// length given in the format file, and older kernels end it early with
+// ULONG_MAX.  A user_stack's is always full, padded with zeroes.
+func (e Event) stackFrames() []uint64 {
+	caller := e.etype.fields[e.etype.getFieldNum("caller")]
+	size := e.etype.kernelDefs().LongSize()
+	if caller.size%size != 0 {
+		size = 4
+	}
+	n := int64(caller.size / size)
+	if e.etype.getFieldNum("size") >= 0 {
+		var err error
+		if n, err = e.Int("size"); err != nil {
+			return nil
+		}
+	}
+
+	var frames []uint64
+	order := e.byteOrder()
+	for i := 0; i < int(n); i++ {
+		offset := caller.offset + i*size
+		if offset+size > len(e.contents) {
+			break
+		}
+		var addr uint64
+		if size == 4 {
+			addr = uint64(order.Uint32(e.contents[offset:]))
+			if addr == 1<<32-1 {
+				break
+			}
+		} else {
+			addr = order.Uint64(e.contents[offset:])
+			if addr == 1<<64-1 {
+				break
+			}
+		}
+		if addr == 0 {
+			break
+		}
+		frames = append(frames, addr)
+	}
+	return frames
+}
+
+// KernelStack returns the kernel stack recorded after the event by
+// EnableStacktrace or StacktraceOn, innermost frame first, or nil if there
+// is none.  Stacks that couldn't be attached to their event, because it was
+// on the previous page, are returned as kernel_stack events.
+func (e Event) KernelStack() []uint64 {
+	if e.etype != nil && e.etype.isKernelStack() {
+		return e.stackFrames()
+	}
+	return e.kernelStack
+}
+
+// KernelStackSymbols returns the kernel stack of the event as symbol names
+// from kallsyms, with offsets
+func (e Event) KernelStackSymbols() []string {
+	stack := e.KernelStack()
+	if stack == nil {
+		return nil
+	}
+	symbols := make([]string, len(stack))
+	for i, addr := range stack {
+		symbols[i] = e.ftrace.kernelSymbol(addr, true)
+	}
+	return symbols
+}
+
+// formatKernelStack formats a kernel_stack event like the kernel.  Its print
+// fmt indexes into the caller array, which cparse can't parse.
+func formatKernelStack(e Event) string {
+	lines := []string{"<stack trace>"}
+	for _, addr := range e.stackFrames() {
+		lines = append(lines, " => "+e.ftrace.kernelSymbol(addr, false))
+	}
+	return strings.Join(lines, "\n")
+}
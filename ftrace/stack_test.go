@@ -0,0 +1,169 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+const kernelStackFormat = `name: kernel_stack
+ID: 4
+format:
+	field:unsigned short common_type;	offset:0;	size:2;	signed:0;
+	field:unsigned char common_flags;	offset:2;	size:1;	signed:0;
+	field:unsigned char common_preempt_count;	offset:3;	size:1;	signed:0;
+	field:int common_pid;	offset:4;	size:4;	signed:1;
+
+	field:int size;	offset:8;	size:4;	signed:1;
+	field:unsigned long caller[8];	offset:16;	size:64;	signed:0;
+
+print fmt: "\t=> %ps\n\t=> %ps\n\t=> %ps\n\t=> %ps\n\t=> %ps\n\t=> %ps\n\t=> %ps\n\t=> %ps\n", (void *)REC->caller[0], (void *)REC->caller[1], (void *)REC->caller[2], (void *)REC->caller[3], (void *)REC->caller[4], (void *)REC->caller[5], (void *)REC->caller[6], (void *)REC->caller[7]
+`
+
+func kernelStack(pid int, frames ...uint64) []byte {
+	b := make([]byte, 16+8*len(frames))
+	binary.LittleEndian.PutUint16(b[0:], 4)
+	binary.LittleEndian.PutUint32(b[4:], uint32(pid))
+	binary.LittleEndian.PutUint32(b[8:], uint32(len(frames)))
+	for i, addr := range frames {
+		binary.LittleEndian.PutUint64(b[16+8*i:], addr)
+	}
+	return b
+}
+
+func newStackTestFtrace(t *testing.T) (*Ftrace, *writeLogFileProvider) {
+	files := testFilesWith(map[string]string{
+		"/sys/kernel/debug/tracing/events/ftrace/kernel_stack/format": kernelStackFormat,
+		"/sys/kernel/debug/tracing/options/stacktrace":                "0\n",
+		"/proc/kallsyms": testKallsyms,
+	})
+
+	fp := &writeLogFileProvider{FileProvider: NewTestFileProvider(files)}
+	f, err := New(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.NewEventType("sched/sched_wakeup"); err != nil {
+		t.Fatal(err)
+	}
+	return f, fp
+}
+
+func TestKernelStack(t *testing.T) {
+	f, fp := newStackTestFtrace(t)
+	if err := f.EnableStacktrace(); err != nil {
+		t.Fatal(err)
+	}
+	if want := [2]string{"options/stacktrace", "1"}; len(fp.writes) != 1 || fp.writes[0] != want {
+		t.Errorf("want writes %v got %v", want, fp.writes)
+	}
+
+	page := &testPage{timestamp: 1000000000}
+	page.addEvent(0, kernelStack(1, 0xffffffff81000010))
+	page.addEvent(0, schedWakeup(1234, "bash", 120, 1))
+	page.addEvent(0, kernelStack(1234, 0xffffffff810a2c7b, 0xffffffff810a3120, 1<<64-1, 0xffffffff81000010))
+	page.addEvent(0, schedWakeup(1, "init", 120, 1))
+
+	events, err := f.decodePage(0, page.bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("want 3 events got %d", len(events))
+	}
+
+	// A stack first on the page has lost its event
+	if name := events[0].EventType().Name(); name != "kernel_stack" {
+		t.Errorf("want a kernel_stack event first got %s", name)
+	}
+	if want, got := []string{"_text+0x10/0x100"}, events[0].KernelStackSymbols(); !reflect.DeepEqual(got, want) {
+		t.Errorf("want stack %v got %v", want, got)
+	}
+	if want, got := "<stack trace>\n => _text", events[0].EventType().Format(*events[0]); got != want {
+		t.Errorf("want %q got %q", want, got)
+	}
+
+	if want, got := []uint64{0xffffffff810a2c7b, 0xffffffff810a3120}, events[1].KernelStack(); !reflect.DeepEqual(got, want) {
+		t.Errorf("want stack %x got %x", want, got)
+	}
+	if want, got := "try_to_wake_up+0x3b/0x4d0", events[1].KernelStackSymbols(); len(got) != 2 || got[0] != want {
+		t.Errorf("want stack starting at %s got %v", want, got)
+	}
+	if events[2].KernelStack() != nil {
+		t.Errorf("want no stack got %x", events[2].KernelStack())
+	}
+}
+
+func TestKernelStackFiltered(t *testing.T) {
+	f, _ := newStackTestFtrace(t)
+	if err := f.EnableStacktrace(); err != nil {
+		t.Fatal(err)
+	}
+	filter, err := NewFilter("pid != 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.options.Filter = filter
+
+	page := &testPage{timestamp: 1000000000}
+	page.addEvent(0, schedWakeup(1, "init", 120, 1))
+	page.addEvent(0, kernelStack(1, 0xffffffff81000010))
+	page.addEvent(0, schedWakeup(1234, "bash", 120, 1))
+	page.addEvent(0, kernelStack(1234, 0xffffffff810a2c7b))
+
+	events, err := f.decodePage(0, page.bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("want the stack of a filtered event dropped with it, got %d events", len(events))
+	}
+	if want, got := []uint64{0xffffffff810a2c7b}, events[0].KernelStack(); !reflect.DeepEqual(got, want) {
+		t.Errorf("want stack %x got %x", want, got)
+	}
+}
+
+func TestStacktraceOn(t *testing.T) {
+	f, fp := newStackTestFtrace(t)
+	etype := f.eventTypeByPath("sched/sched_wakeup")
+	s, err := f.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp.writes = nil
+	if err := s.StacktraceOn(etype, "prio < 100"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.StacktraceOn(etype, ""); err != nil {
+		t.Fatal(err)
+	}
+	if f.eventType(4) == nil {
+		t.Error("kernel_stack not registered")
+	}
+
+	fp.writes = nil
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := [][2]string{
+		{"events/sched/sched_wakeup/trigger", "!stacktrace if prio < 100"},
+		{"events/sched/sched_wakeup/trigger", "!stacktrace"},
+	}
+	if len(fp.writes) < 2 || fp.writes[0] != want[0] || fp.writes[1] != want[1] {
+		t.Errorf("want trigger writes %v got %v", want, fp.writes)
+	}
+}
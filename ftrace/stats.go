@@ -0,0 +1,100 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// CPUStats are the kernel's counters for one cpu's ring buffer, from
+// per_cpu/cpu<n>/stats
+type CPUStats struct {
+	// Entries is the number of events in the buffer
+	Entries uint64
+	// Overrun is the number of events lost to overwriting, with the
+	// overwrite option on
+	Overrun uint64
+	// CommitOverrun is the number of events lost because too many nested
+	// events were written at once
+	CommitOverrun uint64
+	// Bytes is the number of bytes read from the buffer
+	Bytes uint64
+	// DroppedEvents is the number of events lost because the buffer was
+	// full, with the overwrite option off
+	DroppedEvents uint64
+	// ReadEvents is the number of events read from the buffer
+	ReadEvents uint64
+}
+
+// Lost returns the number of events the kernel failed to record
+func (s CPUStats) Lost() uint64 {
+	return s.Overrun + s.CommitOverrun + s.DroppedEvents
+}
+
+// maxCPUs bounds NumCPUs, as the kernel's NR_CPUS does
+const maxCPUs = 8192
+
+// NumCPUs returns the number of cpus with a ring buffer, counting the
+// per_cpu directories up to the first missing one, for the cpus argument
+// of Snapshot and ReadPreviousBoot.  It is at least 1.
+func (f *Ftrace) NumCPUs() int {
+	for cpu := 1; cpu < maxCPUs; cpu++ {
+		if _, err := f.fp.ReadFtraceFile(fmt.Sprintf("per_cpu/cpu%d/stats", cpu)); err != nil {
+			return cpu
+		}
+	}
+	return maxCPUs
+}
+
+// CPUStats reads the ring buffer counters of a cpu
+func (f *Ftrace) CPUStats(cpu int) (CPUStats, error) {
+	data, err := f.fp.ReadFtraceFile(fmt.Sprintf("per_cpu/cpu%d/stats", cpu))
+	if err != nil {
+		return CPUStats{}, err
+	}
+	return parseCPUStats(string(data))
+}
+
+func parseCPUStats(data string) (CPUStats, error) {
+	var stats CPUStats
+	counters := map[string]*uint64{
+		"entries":        &stats.Entries,
+		"overrun":        &stats.Overrun,
+		"commit overrun": &stats.CommitOverrun,
+		"bytes":          &stats.Bytes,
+		"dropped events": &stats.DroppedEvents,
+		"read events":    &stats.ReadEvents,
+	}
+
+	for _, line := range strings.Split(data, "\n") {
+		v := strings.SplitN(line, ":", 2)
+		if len(v) != 2 {
+			continue
+		}
+		counter, ok := counters[strings.TrimSpace(v[0])]
+		if !ok {
+			// Timestamps and counters this package doesn't know about
+			continue
+		}
+		n, err := strconv.ParseUint(strings.TrimSpace(v[1]), 10, 64)
+		if err != nil {
+			return stats, fmt.Errorf("bad %s in stats: %s", v[0], err)
+		}
+		*counter = n
+	}
+	return stats, nil
+}
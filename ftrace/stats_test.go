@@ -0,0 +1,75 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"testing"
+)
+
+const cpuStats = `entries: 12
+overrun: 3
+commit overrun: 1
+bytes: 4096
+oldest event ts:  1234.567890
+now ts:  1240.000000
+dropped events: 5
+read events: 100
+`
+
+func TestCPUStats(t *testing.T) {
+	f := newTestFtraceWith(t, map[string]string{
+		"/sys/kernel/debug/tracing/per_cpu/cpu1/stats": cpuStats,
+	})
+
+	stats, err := f.CPUStats(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := CPUStats{
+		Entries:       12,
+		Overrun:       3,
+		CommitOverrun: 1,
+		Bytes:         4096,
+		DroppedEvents: 5,
+		ReadEvents:    100,
+	}
+	if stats != want {
+		t.Errorf("want %+v got %+v", want, stats)
+	}
+	if stats.Lost() != 9 {
+		t.Errorf("want 9 lost got %d", stats.Lost())
+	}
+
+	if _, err := parseCPUStats("overrun: many\n"); err == nil {
+		t.Error("expected an error for a bad counter")
+	}
+}
+
+func TestNumCPUs(t *testing.T) {
+	dir := t.TempDir()
+	writeTracefs(t, dir, map[string]string{
+		"events/header_page": headerPageFormat,
+		"per_cpu/cpu0/stats": cpuStats,
+		"per_cpu/cpu1/stats": cpuStats,
+		"per_cpu/cpu2/stats": cpuStats,
+	})
+	f, err := New(NewLocalFileProviderAt(dir, t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := f.NumCPUs(); n != 3 {
+		t.Errorf("want 3 cpus got %d", n)
+	}
+}
@@ -0,0 +1,367 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StringReader reads the NUL terminated string at an address in a process,
+// for syscall arguments that point to file names.  The raw_syscalls events
+// only hold the pointer, so the string is read after the syscall, and may
+// have changed or be gone.
+type StringReader interface {
+	ReadString(pid int, addr uint64) (string, error)
+}
+
+// maxStringArg is the longest string argument read, the kernel's PATH_MAX
+const maxStringArg = 4096
+
+// ProcMemReader is a StringReader for local processes, reading their memory
+// from the mem file in Root, a proc mount, or /proc if it is empty
+type ProcMemReader struct {
+	Root string
+}
+
+func (r ProcMemReader) ReadString(pid int, addr uint64) (string, error) {
+	root := r.Root
+	if root == "" {
+		root = procPath
+	}
+	mem, err := os.Open(path.Join(root, strconv.Itoa(pid), "mem"))
+	if err != nil {
+		return "", err
+	}
+	defer mem.Close()
+
+	// Read in small chunks, as the string may end just before an unmapped
+	// page
+	var s []byte
+	buf := make([]byte, 256)
+	for len(s) < maxStringArg {
+		n, err := mem.ReadAt(buf, int64(addr)+int64(len(s)))
+		if nul := bytes.IndexByte(buf[:n], 0); nul >= 0 {
+			return string(append(s, buf[:nul]...)), nil
+		}
+		s = append(s, buf[:n]...)
+		if err != nil {
+			return "", err
+		}
+	}
+	return string(s), nil
+}
+
+// Kinds of syscall arguments decoded by StraceFormatter
+type syscallArg int
+
+const (
+	argInt syscallArg = iota
+	argHex
+	argFd
+	argDirfd
+	argPath
+	argOpenFlags
+	argMode
+	argProt
+	argMmapFlags
+)
+
+// syscallArgs are the arguments of the syscalls StraceFormatter decodes
+var syscallArgs = map[string][]syscallArg{
+	"read":       {argFd, argHex, argInt},
+	"write":      {argFd, argHex, argInt},
+	"pread64":    {argFd, argHex, argInt, argInt},
+	"pwrite64":   {argFd, argHex, argInt, argInt},
+	"close":      {argFd},
+	"open":       {argPath, argOpenFlags, argMode},
+	"openat":     {argDirfd, argPath, argOpenFlags, argMode},
+	"creat":      {argPath, argMode},
+	"stat":       {argPath, argHex},
+	"lstat":      {argPath, argHex},
+	"fstat":      {argFd, argHex},
+	"newfstatat": {argDirfd, argPath, argHex, argHex},
+	"access":     {argPath, argInt},
+	"faccessat":  {argDirfd, argPath, argInt},
+	"unlink":     {argPath},
+	"unlinkat":   {argDirfd, argPath, argHex},
+	"mkdir":      {argPath, argMode},
+	"mkdirat":    {argDirfd, argPath, argMode},
+	"chdir":      {argPath},
+	"readlink":   {argPath, argHex, argInt},
+	"readlinkat": {argDirfd, argPath, argHex, argInt},
+	"execve":     {argPath, argHex, argHex},
+	"mmap":       {argHex, argInt, argProt, argMmapFlags, argFd, argHex},
+	"mprotect":   {argHex, argInt, argProt},
+	"munmap":     {argHex, argInt},
+	"dup":        {argFd},
+	"dup2":       {argFd, argFd},
+	"dup3":       {argFd, argFd, argOpenFlags},
+	"lseek":      {argFd, argInt, argInt},
+	"fsync":      {argFd},
+	"ioctl":      {argFd, argHex, argHex},
+	"fcntl":      {argFd, argInt, argHex},
+}
+
+// syscallReturnsAddress are the syscalls whose return value is printed in
+// hex
+var syscallReturnsAddress = map[string]bool{
+	"mmap": true,
+	"brk":  true,
+}
+
+type flagName struct {
+	flag uint64
+	name string
+}
+
+// openFlags are the open flags besides the access mode.  O_DIRECT,
+// O_LARGEFILE, O_DIRECTORY and O_NOFOLLOW differ between architectures.
+func openFlags(arch string) []flagName {
+	direct, largefile, directory, nofollow := uint64(0040000), uint64(0100000), uint64(0200000), uint64(0400000)
+	if arch == "arm64" {
+		directory, nofollow, direct, largefile = 0040000, 0100000, 0200000, 0400000
+	}
+	return []flagName{
+		{04010000, "O_SYNC"},
+		{020000000 | directory, "O_TMPFILE"},
+		{00000100, "O_CREAT"},
+		{00000200, "O_EXCL"},
+		{00000400, "O_NOCTTY"},
+		{00001000, "O_TRUNC"},
+		{00002000, "O_APPEND"},
+		{00004000, "O_NONBLOCK"},
+		{00010000, "O_DSYNC"},
+		{00020000, "O_ASYNC"},
+		{direct, "O_DIRECT"},
+		{largefile, "O_LARGEFILE"},
+		{directory, "O_DIRECTORY"},
+		{nofollow, "O_NOFOLLOW"},
+		{01000000, "O_NOATIME"},
+		{02000000, "O_CLOEXEC"},
+		{010000000, "O_PATH"},
+	}
+}
+
+var protFlags = []flagName{
+	{0x1, "PROT_READ"},
+	{0x2, "PROT_WRITE"},
+	{0x4, "PROT_EXEC"},
+}
+
+var mmapFlags = []flagName{
+	{0x03, "MAP_SHARED_VALIDATE"},
+	{0x01, "MAP_SHARED"},
+	{0x02, "MAP_PRIVATE"},
+	{0x10, "MAP_FIXED"},
+	{0x20, "MAP_ANONYMOUS"},
+	{0x100, "MAP_GROWSDOWN"},
+	{0x800, "MAP_DENYWRITE"},
+	{0x1000, "MAP_EXECUTABLE"},
+	{0x2000, "MAP_LOCKED"},
+	{0x4000, "MAP_NORESERVE"},
+	{0x8000, "MAP_POPULATE"},
+	{0x10000, "MAP_NONBLOCK"},
+	{0x20000, "MAP_STACK"},
+	{0x40000, "MAP_HUGETLB"},
+	{0x80000, "MAP_SYNC"},
+	{0x100000, "MAP_FIXED_NOREPLACE"},
+}
+
+// formatFlags names the flags set in v, in hex for any left over.  Flags
+// with several bits, listed before their parts, match only if all are set.
+func formatFlags(v uint64, flags []flagName) []string {
+	var names []string
+	for _, f := range flags {
+		if v&f.flag == f.flag {
+			names = append(names, f.name)
+			v &^= f.flag
+		}
+	}
+	if v != 0 {
+		names = append(names, fmt.Sprintf("%#x", v))
+	}
+	return names
+}
+
+// errnoNames are the names of the errors of asm-generic/errno-base.h, and
+// of a few common ones from asm-generic/errno.h
+var errnoNames = map[int64]string{
+	1: "EPERM", 2: "ENOENT", 3: "ESRCH", 4: "EINTR", 5: "EIO", 6: "ENXIO",
+	7: "E2BIG", 8: "ENOEXEC", 9: "EBADF", 10: "ECHILD", 11: "EAGAIN",
+	12: "ENOMEM", 13: "EACCES", 14: "EFAULT", 15: "ENOTBLK", 16: "EBUSY",
+	17: "EEXIST", 18: "EXDEV", 19: "ENODEV", 20: "ENOTDIR", 21: "EISDIR",
+	22: "EINVAL", 23: "ENFILE", 24: "EMFILE", 25: "ENOTTY", 26: "ETXTBSY",
+	27: "EFBIG", 28: "ENOSPC", 29: "ESPIPE", 30: "EROFS", 31: "EMLINK",
+	32: "EPIPE", 33: "EDOM", 34: "ERANGE", 36: "ENAMETOOLONG", 38: "ENOSYS",
+	39: "ENOTEMPTY", 40: "ELOOP", 61: "ENODATA", 95: "EOPNOTSUPP",
+	110: "ETIMEDOUT", 111: "ECONNREFUSED", 115: "EINPROGRESS",
+}
+
+// maxErrno is the largest error number returned by syscalls
+const maxErrno = 4095
+
+type straceCall struct {
+	nr   int64
+	args string
+	when uint64
+}
+
+// StraceFormatter formats raw_syscalls events as strace-like lines, one for
+// each syscall, printed at its sys_exit with the arguments of the thread's
+// preceding sys_enter.  The arguments of a curated set of syscalls are
+// decoded: fds, open flags, modes and mmap protections and flags, and file
+// names if there is a StringReader; other arguments are printed in hex.
+type StraceFormatter struct {
+	arch    string
+	strings StringReader
+	calls   map[int]straceCall
+}
+
+// NewStraceFormatter returns a StraceFormatter for a kernel of arch, as for
+// SyscallName, reading file names with reader, which may be nil to print
+// their addresses
+func NewStraceFormatter(arch string, reader StringReader) *StraceFormatter {
+	return &StraceFormatter{
+		arch:    arch,
+		strings: reader,
+		calls:   make(map[int]straceCall),
+	}
+}
+
+// Format returns the line of a sys_exit, and false for other events.  Events
+// must be formatted in time order, and sys_enter events must be formatted
+// soon after the syscall to read their file names.
+func (sf *StraceFormatter) Format(e *Event) (string, bool) {
+	if !e.etype.isSyscallEvent() {
+		return "", false
+	}
+	nr, err := e.Int("id")
+	if err != nil {
+		return "", false
+	}
+	name := SyscallName(sf.arch, int(nr))
+	if name == "" {
+		name = fmt.Sprintf("syscall_%d", nr)
+	}
+
+	if e.etype.name == "sys_enter" {
+		sf.calls[e.Pid] = straceCall{nr, sf.formatArgs(e, name), e.When}
+		return "", false
+	}
+
+	ret, _ := e.Int("ret")
+	result := sf.formatReturn(name, ret)
+	prefix := fmt.Sprintf("%6d.%06d [pid %5d] ", e.Seconds(), e.Microseconds(), e.Pid)
+	call, ok := sf.calls[e.Pid]
+	if !ok || call.nr != nr {
+		return prefix + fmt.Sprintf("<... %s resumed> = %s", name, result), true
+	}
+	delete(sf.calls, e.Pid)
+	elapsed := time.Duration(e.When - call.when)
+	return prefix + fmt.Sprintf("%s(%s) = %s <%.6f>", name, call.args, result, elapsed.Seconds()), true
+}
+
+func (sf *StraceFormatter) formatArgs(e *Event, name string) string {
+	raw, _ := e.Bytes("args")
+	size := len(raw) / 6
+	if size != 4 && size != 8 {
+		return "?"
+	}
+	var args []uint64
+	order := e.byteOrder()
+	for i := 0; i+size <= len(raw); i += size {
+		if size == 4 {
+			args = append(args, uint64(order.Uint32(raw[i:])))
+		} else {
+			args = append(args, order.Uint64(raw[i:]))
+		}
+	}
+
+	kinds, ok := syscallArgs[name]
+	if !ok {
+		// Unknown arguments, so all six in hex
+		kinds = []syscallArg{argHex, argHex, argHex, argHex, argHex, argHex}
+	}
+	var s []string
+	for i, kind := range kinds {
+		s = append(s, sf.formatArg(e.Pid, kind, args[i], size))
+	}
+	return strings.Join(s, ", ")
+}
+
+// signed returns an argument as a C int, or long if the kernel is 64 bit
+func signed(v uint64, size int) int64 {
+	if size == 4 {
+		return int64(int32(v))
+	}
+	return int64(v)
+}
+
+func (sf *StraceFormatter) formatArg(pid int, kind syscallArg, v uint64, size int) string {
+	switch kind {
+	case argInt:
+		return strconv.FormatInt(signed(v, size), 10)
+	case argFd:
+		return strconv.FormatInt(int64(int32(v)), 10)
+	case argDirfd:
+		if int32(v) == -100 {
+			return "AT_FDCWD"
+		}
+		return strconv.FormatInt(int64(int32(v)), 10)
+	case argPath:
+		if v == 0 {
+			return "NULL"
+		}
+		if sf.strings != nil {
+			if s, err := sf.strings.ReadString(pid, v); err == nil {
+				return strconv.Quote(s)
+			}
+		}
+		return fmt.Sprintf("%#x", v)
+	case argOpenFlags:
+		access := []string{"O_RDONLY", "O_WRONLY", "O_RDWR", "0x3"}[v&3]
+		return strings.Join(append([]string{access}, formatFlags(v&^3, openFlags(sf.arch))...), "|")
+	case argMode:
+		return fmt.Sprintf("%#o", v)
+	case argProt:
+		if v == 0 {
+			return "PROT_NONE"
+		}
+		return strings.Join(formatFlags(v, protFlags), "|")
+	case argMmapFlags:
+		return strings.Join(formatFlags(v, mmapFlags), "|")
+	}
+	return fmt.Sprintf("%#x", v)
+}
+
+func (sf *StraceFormatter) formatReturn(name string, ret int64) string {
+	if ret < 0 && ret >= -maxErrno {
+		errno, ok := errnoNames[-ret]
+		if !ok {
+			errno = fmt.Sprintf("errno %d", -ret)
+		}
+		return "-1 " + errno
+	}
+	if syscallReturnsAddress[name] {
+		return fmt.Sprintf("%#x", uint64(ret))
+	}
+	return strconv.FormatInt(ret, 10)
+}
@@ -0,0 +1,126 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"strconv"
+	"testing"
+	"unsafe"
+)
+
+// testStrings is a StringReader of fixed strings
+type testStrings map[uint64]string
+
+func (s testStrings) ReadString(pid int, addr uint64) (string, error) {
+	if str, ok := s[addr]; ok {
+		return str, nil
+	}
+	return "", fmt.Errorf("no string at %x", addr)
+}
+
+func TestStraceFormatter(t *testing.T) {
+	enter, err := ParseEventFormat([]byte(sysEnterFormat))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exit, err := ParseEventFormat([]byte(sysExitFormat))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wakeup, err := ParseEventFormat([]byte(schedWakeupFormat))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event := func(etype *EventType, pid int, when uint64, fields map[string]interface{}) *Event {
+		fields["common_pid"] = pid
+		e, err := etype.NewEvent(fields, 0, when)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+	args := func(args ...uint64) []byte {
+		b := make([]byte, 48)
+		for i, a := range args {
+			binary.LittleEndian.PutUint64(b[i*8:], a)
+		}
+		return b
+	}
+
+	events := []*Event{
+		event(enter, 10, 1000000000, map[string]interface{}{"id": 257, "args": args(0xffffff9c, 0x1000, 02000000|0100|01, 0644)}),
+		event(wakeup, 10, 1000005000, map[string]interface{}{"pid": 20}),
+		event(exit, 10, 1000012000, map[string]interface{}{"id": 257, "ret": 3}),
+		event(enter, 10, 2000000000, map[string]interface{}{"id": 257, "args": args(5, 0x2000, 0200000, 0)}),
+		event(exit, 10, 2000001000, map[string]interface{}{"id": 257, "ret": -2}),
+		event(enter, 10, 3000000000, map[string]interface{}{"id": 9, "args": args(0, 4096, 3, 0x22, 0xffffffff, 0)}),
+		event(exit, 10, 3000000100, map[string]interface{}{"id": 9, "ret": int64(0x7f0000001000)}),
+		// Entered before the capture
+		event(exit, 20, 3500000000, map[string]interface{}{"id": 0, "ret": 12}),
+		event(enter, 10, 4000000000, map[string]interface{}{"id": 100000, "args": args(1, 2)}),
+		event(exit, 10, 4000000000, map[string]interface{}{"id": 100000, "ret": -4095}),
+	}
+
+	sf := NewStraceFormatter("amd64", testStrings{0x1000: "/etc/passwd"})
+	var lines []string
+	for _, e := range events {
+		if line, ok := sf.Format(e); ok {
+			lines = append(lines, line)
+		}
+	}
+	want := []string{
+		`     1.000012 [pid    10] openat(AT_FDCWD, "/etc/passwd", O_WRONLY|O_CREAT|O_CLOEXEC, 0644) = 3 <0.000012>`,
+		`     2.000001 [pid    10] openat(5, 0x2000, O_RDONLY|O_DIRECTORY, 0) = -1 ENOENT <0.000001>`,
+		`     3.000000 [pid    10] mmap(0x0, 4096, PROT_READ|PROT_WRITE, MAP_PRIVATE|MAP_ANONYMOUS, -1, 0x0) = 0x7f0000001000 <0.000000>`,
+		`     3.500000 [pid    20] <... read resumed> = 12`,
+		`     4.000000 [pid    10] syscall_100000(0x1, 0x2, 0x0, 0x0, 0x0, 0x0) = -1 errno 4095 <0.000000>`,
+	}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("want\n%q\ngot\n%q", want, lines)
+	}
+
+	// On arm64 0200000 is O_DIRECT
+	sf = NewStraceFormatter("arm64", nil)
+	sf.Format(event(enter, 10, 0, map[string]interface{}{"id": 56, "args": args(0xffffff9c, 0x1000, 0200000, 0)}))
+	line, _ := sf.Format(event(exit, 10, 0, map[string]interface{}{"id": 56, "ret": 3}))
+	if want := `     0.000000 [pid    10] openat(AT_FDCWD, 0x1000, O_RDONLY|O_DIRECT, 0) = 3 <0.000000>`; line != want {
+		t.Errorf("want\n%s\ngot\n%s", want, line)
+	}
+}
+
+func TestProcMemReader(t *testing.T) {
+	s := []byte("/tmp/some/file\x00")
+	addr := uint64(uintptr(unsafe.Pointer(&s[0])))
+	if _, err := os.Stat(path.Join("/proc", strconv.Itoa(os.Getpid()), "mem")); err != nil {
+		t.Skip(err)
+	}
+
+	got, err := ProcMemReader{}.ReadString(os.Getpid(), addr)
+	if err != nil {
+		t.Skip(err)
+	}
+	if got != "/tmp/some/file" {
+		t.Errorf("want /tmp/some/file got %q", got)
+	}
+	if _, err := (ProcMemReader{Root: t.TempDir()}).ReadString(os.Getpid(), addr); err == nil {
+		t.Errorf("expected an error for a missing mem file")
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var NoSyscallTable error = errors.New("No syscall table for architecture")
+
+// SyscallName returns the name of syscall number nr on arch, a GOARCH name
+// like amd64 or arm64, or "" if it isn't known
+func SyscallName(arch string, nr int) string {
+	return syscallTables[arch][nr]
+}
+
+// SyscallArchs returns the architectures SyscallName knows
+func SyscallArchs() []string {
+	var archs []string
+	for arch := range syscallTables {
+		archs = append(archs, arch)
+	}
+	sort.Strings(archs)
+	return archs
+}
+
+// ResolveSyscalls makes the raw_syscalls sys_enter and sys_exit events
+// print the name of the syscall, like sys_openat, in place of the kernel's
+// NR 257, using the syscall numbers of arch, the architecture of the traced
+// kernel
+func (f *Ftrace) ResolveSyscalls(arch string) error {
+	table, ok := syscallTables[arch]
+	if !ok {
+		return NoSyscallTable
+	}
+	f.syscalls = table
+	return nil
+}
+
+// isSyscallEvent returns whether the event type is raw_syscalls/sys_enter or
+// sys_exit
+func (etype *EventType) isSyscallEvent() bool {
+	switch etype.name {
+	case "sys_enter":
+		return etype.getFieldNum("id") >= 0 && etype.getFieldNum("args") >= 0
+	case "sys_exit":
+		return etype.getFieldNum("id") >= 0 && etype.getFieldNum("ret") >= 0
+	}
+	return false
+}
+
+// formatSyscall formats raw_syscalls events like the kernel, with the name
+// of the syscall in place of its number if the Ftrace resolves syscall
+// names.  The print fmt of sys_enter indexes into its args array, which
+// cparse can't parse, so both events are formatted here.
+func formatSyscall(e Event) string {
+	id, _ := e.Int("id")
+	name := fmt.Sprintf("NR %d", id)
+	if e.ftrace != nil {
+		if n := e.ftrace.syscalls[int(id)]; n != "" {
+			name = "sys_" + n
+		}
+	}
+
+	if e.etype.name == "sys_exit" {
+		ret, _ := e.Int("ret")
+		return fmt.Sprintf("%s = %d", name, ret)
+	}
+
+	// args is an array of six unsigned longs of the kernel's word size
+	args, _ := e.Bytes("args")
+	size := len(args) / 6
+	order := e.byteOrder()
+	var s []string
+	for i := 0; size > 0 && i+size <= len(args); i += size {
+		var arg uint64
+		if size == 4 {
+			arg = uint64(order.Uint32(args[i:]))
+		} else {
+			arg = order.Uint64(args[i:])
+		}
+		s = append(s, fmt.Sprintf("%x", arg))
+	}
+	return name + " (" + strings.Join(s, ", ") + ")"
+}
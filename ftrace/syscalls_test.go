@@ -0,0 +1,118 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+const sysEnterFormat = `name: sys_enter
+ID: 22
+format:
+	field:unsigned short common_type;	offset:0;	size:2;	signed:0;
+	field:unsigned char common_flags;	offset:2;	size:1;	signed:0;
+	field:unsigned char common_preempt_count;	offset:3;	size:1;	signed:0;
+	field:int common_pid;	offset:4;	size:4;	signed:1;
+
+	field:long id;	offset:8;	size:8;	signed:1;
+	field:unsigned long args[6];	offset:16;	size:48;	signed:0;
+
+print fmt: "NR %ld (%lx, %lx, %lx, %lx, %lx, %lx)", REC->id, REC->args[0], REC->args[1], REC->args[2], REC->args[3], REC->args[4], REC->args[5]
+`
+
+const sysExitFormat = `name: sys_exit
+ID: 21
+format:
+	field:unsigned short common_type;	offset:0;	size:2;	signed:0;
+	field:unsigned char common_flags;	offset:2;	size:1;	signed:0;
+	field:unsigned char common_preempt_count;	offset:3;	size:1;	signed:0;
+	field:int common_pid;	offset:4;	size:4;	signed:1;
+
+	field:long id;	offset:8;	size:8;	signed:1;
+	field:long ret;	offset:16;	size:8;	signed:1;
+
+print fmt: "NR %ld = %ld", REC->id, REC->ret
+`
+
+func TestResolveSyscalls(t *testing.T) {
+	f := newTestFtrace(t, testFiles)
+
+	format := func(format string, fields map[string]interface{}) string {
+		etype, err := ParseEventFormat([]byte(format))
+		if err != nil {
+			t.Fatal(err)
+		}
+		e, err := etype.NewEvent(fields, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e.ftrace = f
+		return etype.Format(*e)
+	}
+
+	if got, want := format(sysExitFormat, map[string]interface{}{"id": 257, "ret": 3}), "NR 257 = 3"; got != want {
+		t.Errorf("unresolved: want %q got %q", want, got)
+	}
+	args := make([]byte, 48)
+	binary.LittleEndian.PutUint64(args, 0xffffff9c)
+	binary.LittleEndian.PutUint64(args[8:], 0x7ffd1000)
+	if got, want := format(sysEnterFormat, map[string]interface{}{"id": 257, "args": args}), "NR 257 (ffffff9c, 7ffd1000, 0, 0, 0, 0)"; got != want {
+		t.Errorf("unresolved: want %q got %q", want, got)
+	}
+
+	if err := f.ResolveSyscalls("vax"); err != NoSyscallTable {
+		t.Errorf("want NoSyscallTable got %v", err)
+	}
+	if err := f.ResolveSyscalls("amd64"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		format string
+		fields map[string]interface{}
+		want   string
+	}{
+		{sysExitFormat, map[string]interface{}{"id": 257, "ret": 3}, "sys_openat = 3"},
+		{sysEnterFormat, map[string]interface{}{"id": 0}, "sys_read (0, 0, 0, 0, 0, 0)"},
+		{sysExitFormat, map[string]interface{}{"id": 100000, "ret": -38}, "NR 100000 = -38"},
+	} {
+		if got := format(c.format, c.fields); got != c.want {
+			t.Errorf("want %q got %q", c.want, got)
+		}
+	}
+}
+
+func TestSyscallName(t *testing.T) {
+	for _, c := range []struct {
+		arch string
+		nr   int
+		want string
+	}{
+		{"amd64", 257, "openat"},
+		{"arm64", 56, "openat"},
+		{"arm64", 79, "newfstatat"},
+		{"386", 5, "open"},
+		{"amd64", 100000, ""},
+		{"vax", 1, ""},
+	} {
+		if got := SyscallName(c.arch, c.nr); got != c.want {
+			t.Errorf("%s %d: want %q got %q", c.arch, c.nr, c.want, got)
+		}
+	}
+	if got := SyscallArchs(); len(got) != 3 || got[0] != "386" {
+		t.Errorf("unexpected archs %v", got)
+	}
+}
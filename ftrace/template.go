@@ -0,0 +1,115 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"bytes"
+	"text/template"
+)
+
+// TemplateFormatter formats events with text/template templates instead of
+// Event.String.  The templates are executed with a TemplateData.
+type TemplateFormatter struct {
+	global  *template.Template
+	byEvent map[string]*template.Template
+}
+
+// TemplateData is the data passed to the templates of a TemplateFormatter
+type TemplateData struct {
+	Comm      string
+	Pid       int
+	Tgid      int
+	Cpu       int
+	Device    string
+	Flags     string
+	Timestamp uint64
+	// Timestamp split into whole seconds and microseconds, as printed by
+	// Event.String
+	Seconds      int
+	Microseconds int
+	Event        string
+	Fields       map[string]interface{}
+	// The event's print fmt output and the full line from Event.String
+	Text string
+	Line string
+}
+
+// NewTemplateFormatter parses a global template, used for events without
+// a template of their own, and templates for individual event types keyed
+// by event name.  An empty global template formats events without their own
+// template with Event.String.
+func NewTemplateFormatter(global string, byEvent map[string]string) (*TemplateFormatter, error) {
+	tf := &TemplateFormatter{
+		byEvent: make(map[string]*template.Template),
+	}
+
+	if global != "" {
+		t, err := template.New("global").Parse(global)
+		if err != nil {
+			return nil, err
+		}
+		tf.global = t
+	}
+
+	for name, text := range byEvent {
+		t, err := template.New(name).Parse(text)
+		if err != nil {
+			return nil, err
+		}
+		tf.byEvent[name] = t
+	}
+
+	return tf, nil
+}
+
+// Format executes the template for the event's type
+func (tf *TemplateFormatter) Format(e *Event) (string, error) {
+	t := tf.byEvent[e.etype.name]
+	if t == nil {
+		t = tf.global
+	}
+	if t == nil {
+		return e.String(), nil
+	}
+
+	fields, err := e.fieldMap()
+	if err != nil {
+		return "", err
+	}
+
+	data := TemplateData{
+		Comm:         e.ProcessName(),
+		Pid:          e.Pid,
+		Cpu:          e.Cpu,
+		Device:       e.Device(),
+		Flags:        e.FlagChars(),
+		Timestamp:    e.When,
+		Seconds:      e.Seconds(),
+		Microseconds: e.Microseconds(),
+		Event:        e.etype.name,
+		Fields:       fields,
+		Text:         e.etype.Format(*e),
+		Line:         e.String(),
+	}
+	if e.ftrace.recordTgid {
+		data.Tgid = e.Tgid()
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
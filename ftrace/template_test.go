@@ -0,0 +1,68 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import "testing"
+
+func TestTemplateFormatter(t *testing.T) {
+	f := newTestFtrace(t, testFiles)
+	if _, err := f.NewEventType("sched/sched_wakeup"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.NewEventType("task/task_newtask"); err != nil {
+		t.Fatal(err)
+	}
+
+	page := &testPage{timestamp: 1000001000}
+	page.addEvent(0, schedWakeup(1234, "bash", 120, 2))
+	page.addEvent(0, taskNewtask(1234, 1300, "bash"))
+	events, err := f.decodePage(1, page.bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		global  string
+		byEvent map[string]string
+		want    []string
+	}{
+		{"{{.Seconds}}.{{printf \"%06d\" .Microseconds}} cpu{{.Cpu}} {{.Comm}}:{{.Pid}} {{.Event}}", nil,
+			[]string{"1.000001 cpu1 bash:1234 sched_wakeup", "1.000001 cpu1 bash:1234 task_newtask"}},
+		{"{{.Event}}: {{.Text}}",
+			map[string]string{"sched_wakeup": "wakeup {{.Fields.comm}} prio {{.Fields.prio}} on {{.Fields.target_cpu}}"},
+			[]string{"wakeup bash prio 120 on 2", "task_newtask: pid=1300 comm=bash clone_flags=0 oom_score_adj=0"}},
+		{"", map[string]string{"task_newtask": "fork {{.Fields.pid}}"},
+			[]string{events[0].String(), "fork 1300"}},
+	}
+
+	for _, test := range tests {
+		tf, err := NewTemplateFormatter(test.global, test.byEvent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, e := range events {
+			got, err := tf.Format(e)
+			if err != nil {
+				t.Errorf("%s: %s", test.global, err.Error())
+			} else if got != test.want[i] {
+				t.Errorf("want %q got %q", test.want[i], got)
+			}
+		}
+	}
+
+	if _, err := NewTemplateFormatter("{{.Comm", nil); err == nil {
+		t.Error("expected template parse error")
+	}
+}
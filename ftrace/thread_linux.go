@@ -0,0 +1,86 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"fmt"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// cpuSetWords is the size of the kernel's cpu_set_t for up to 1024 cpus
+const cpuSetWords = 1024 / 64
+
+// pinThread locks the calling goroutine to its OS thread and sets the
+// thread's affinity to a single cpu.  The thread is discarded when the
+// goroutine exits.  On error the goroutine is left unpinned.
+func pinThread(cpu int) error {
+	if cpu < 0 || cpu >= cpuSetWords*64 {
+		return fmt.Errorf("can't pin to cpu %d", cpu)
+	}
+
+	runtime.LockOSThread()
+
+	var mask [cpuSetWords]uint64
+	mask[cpu/64] = 1 << uint(cpu%64)
+	_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETAFFINITY, 0,
+		uintptr(len(mask)*8), uintptr(unsafe.Pointer(&mask[0])))
+	if errno != 0 {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("can't pin to cpu %d: %s", cpu, errno.Error())
+	}
+	return nil
+}
+
+const (
+	schedOther = 0
+	schedFIFO  = 1
+	schedIdle  = 5
+)
+
+// setThreadPriority locks the calling goroutine to its OS thread and sets
+// the thread's scheduling policy and priority.  On error the goroutine is
+// left unlocked.
+func setThreadPriority(p ThreadPriority) error {
+	runtime.LockOSThread()
+
+	policy := schedOther
+	param := struct{ priority int32 }{}
+	switch p.Policy {
+	case SchedIdle:
+		policy = schedIdle
+	case SchedFIFO:
+		policy = schedFIFO
+		param.priority = int32(p.RTPriority)
+	}
+
+	tid := syscall.Gettid()
+	_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETSCHEDULER, uintptr(tid),
+		uintptr(policy), uintptr(unsafe.Pointer(&param)))
+	if errno != 0 {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("can't set scheduling policy %s: %s", p.Policy, errno.Error())
+	}
+
+	if p.Policy == SchedOther && p.Nice != 0 {
+		err := syscall.Setpriority(syscall.PRIO_PROCESS, tid, p.Nice)
+		if err != nil {
+			runtime.UnlockOSThread()
+			return fmt.Errorf("can't set nice level %d: %s", p.Nice, err.Error())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"syscall"
+	"testing"
+	"unsafe"
+)
+
+func TestPinThread(t *testing.T) {
+	errCh := make(chan error)
+	maskCh := make(chan [cpuSetWords]uint64)
+
+	go func() {
+		err := pinThread(0)
+		errCh <- err
+		if err != nil {
+			return
+		}
+		var mask [cpuSetWords]uint64
+		syscall.RawSyscall(syscall.SYS_SCHED_GETAFFINITY, 0,
+			uintptr(len(mask)*8), uintptr(unsafe.Pointer(&mask[0])))
+		maskCh <- mask
+	}()
+
+	if err := <-errCh; err != nil {
+		// cpu 0 may not be in this process's allowed set
+		t.Skip(err.Error())
+	}
+	mask := <-maskCh
+	if mask[0] != 1 {
+		t.Errorf("want affinity mask 1, got %#x", mask[0])
+	}
+
+	if err := pinThread(-1); err == nil {
+		t.Error("expected error pinning to cpu -1")
+	}
+}
+
+func TestSetThreadPriority(t *testing.T) {
+	type result struct {
+		err    error
+		policy uintptr
+		nice   int
+	}
+
+	tests := []struct {
+		p      ThreadPriority
+		policy uintptr
+		nice   int
+	}{
+		{ThreadPriority{Policy: SchedIdle}, schedIdle, 0},
+		{ThreadPriority{Nice: 5}, schedOther, 5},
+	}
+
+	for _, test := range tests {
+		ch := make(chan result)
+		go func() {
+			var r result
+			r.err = setThreadPriority(test.p)
+			if r.err == nil {
+				tid := syscall.Gettid()
+				r.policy, _, _ = syscall.RawSyscall(syscall.SYS_SCHED_GETSCHEDULER, uintptr(tid), 0, 0)
+				prio, _ := syscall.Getpriority(syscall.PRIO_PROCESS, tid)
+				// The raw syscall returns 20 - nice
+				r.nice = 20 - prio
+			}
+			ch <- r
+		}()
+
+		r := <-ch
+		if r.err != nil {
+			t.Errorf("%+v: %s", test.p, r.err.Error())
+			continue
+		}
+		if r.policy != test.policy || r.nice != test.nice {
+			t.Errorf("%+v: want policy %d nice %d, got policy %d nice %d",
+				test.p, test.policy, test.nice, r.policy, r.nice)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+
+package ftrace
+
+import "fmt"
+
+func pinThread(cpu int) error {
+	return fmt.Errorf("can't pin to cpu %d: not supported", cpu)
+}
+
+func setThreadPriority(p ThreadPriority) error {
+	return fmt.Errorf("can't set thread priority: not supported")
+}
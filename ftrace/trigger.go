@@ -0,0 +1,123 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// kernelStopPollInterval is how often KernelStopped reads tracing_on
+const kernelStopPollInterval = 100 * time.Millisecond
+
+// stopTriggers ends a capture when an event matches one of its predicates
+type stopTriggers struct {
+	sync.Mutex
+	predicates map[*EventType][]func(*Event) bool
+	// stopped is closed when a trigger fires
+	stopped chan struct{}
+	once    sync.Once
+}
+
+func newStopTriggers() *stopTriggers {
+	return &stopTriggers{
+		predicates: make(map[*EventType][]func(*Event) bool),
+		stopped:    make(chan struct{}),
+	}
+}
+
+func (t *stopTriggers) add(etype *EventType, predicate func(*Event) bool) {
+	t.Lock()
+	defer t.Unlock()
+	t.predicates[etype] = append(t.predicates[etype], predicate)
+}
+
+// check fires the triggers if e matches one of them
+func (t *stopTriggers) check(e *Event) {
+	t.Lock()
+	predicates := t.predicates[e.etype]
+	t.Unlock()
+
+	for _, predicate := range predicates {
+		if predicate == nil || predicate(e) {
+			t.once.Do(func() { close(t.stopped) })
+			return
+		}
+	}
+}
+
+// StopOn ends the capture when an event of type etype for which predicate
+// returns true is decoded, for example "stop when sched_process_exit is
+// seen for pid X".  A nil predicate matches every event of the type, and a
+// Filter's Match method can be used for a C expression over the event's
+// fields.  Capture returns after passing on the page with the matching
+// event; Stopped can be used to end other kinds of capture.
+func (s *Session) StopOn(etype *EventType, predicate func(*Event) bool) {
+	s.f.stops.add(etype, predicate)
+}
+
+// StopOnKernel makes the kernel turn tracing off as soon as an event of type
+// etype matching filter, a kernel event filter such as "pid == 42", is
+// traced, or any event of the type for an empty filter.  Nothing after the
+// trigger reaches the ring buffer, which suits flight recorder captures
+// read with Snapshot.  The trigger is removed when the Session is closed.
+func (s *Session) StopOnKernel(etype *EventType, filter string) error {
+	trigger := "traceoff"
+	if filter != "" {
+		trigger += " if " + filter
+	}
+	err := etype.writeEventFile("trigger", []byte(trigger))
+	if err != nil {
+		return err
+	}
+	s.triggers = append(s.triggers, kernelTrigger{etype.path, trigger})
+	return nil
+}
+
+// KernelStopped returns a channel that is closed once the kernel turns
+// tracing off, as a StopOnKernel trigger does, noticed by polling
+// tracing_on.  Call it after Enable.  Polling ends when doneCh fires.
+func (s *Session) KernelStopped(doneCh <-chan bool) <-chan struct{} {
+	stopped := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(kernelStopPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-doneCh:
+				return
+			case <-ticker.C:
+			}
+			data, err := s.f.fp.ReadFtraceFile("tracing_on")
+			if err == nil && strings.TrimSpace(string(data)) == "0" {
+				close(stopped)
+				return
+			}
+		}
+	}()
+	return stopped
+}
+
+// Stopped returns a channel that is closed when a StopOn trigger fires
+func (s *Session) Stopped() <-chan struct{} {
+	return s.f.stops.stopped
+}
+
+// kernelTrigger is a trigger written to an event's trigger file
+type kernelTrigger struct {
+	path    string
+	trigger string
+}
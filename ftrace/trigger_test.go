@@ -0,0 +1,115 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStopOn(t *testing.T) {
+	fp := &writeLogFileProvider{FileProvider: NewTestFileProvider(testFiles)}
+	f, err := New(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	etype, err := f.NewEventType("sched/sched_wakeup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := f.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.StopOn(etype, func(e *Event) bool { return e.Pid == 42 })
+
+	page := &testPage{timestamp: 1000000000}
+	page.addEvent(0, schedWakeup(1, "init", 120, 0))
+	if _, err := f.decodePage(0, page.bytes()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-s.Stopped():
+		t.Fatal("stopped on an event that doesn't match")
+	default:
+	}
+
+	page.addEvent(0, schedWakeup(42, "victim", 120, 0))
+	events, err := f.decodePage(0, page.bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Errorf("want the matching event returned, got %d events", len(events))
+	}
+	select {
+	case <-s.Stopped():
+	default:
+		t.Fatal("not stopped on a matching event")
+	}
+
+	fp.writes = nil
+	if err := s.StopOnKernel(etype, "pid == 42"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := [][2]string{
+		{"events/sched/sched_wakeup/trigger", "traceoff if pid == 42"},
+		{"events/sched/sched_wakeup/trigger", "!traceoff if pid == 42"},
+	}
+	if len(fp.writes) < 2 || fp.writes[0] != want[0] || fp.writes[1] != want[1] {
+		t.Errorf("want trigger writes %v got %v", want, fp.writes)
+	}
+}
+
+func TestKernelStopped(t *testing.T) {
+	dir := t.TempDir()
+	writeTracefs(t, dir, map[string]string{
+		"events/header_page": headerPageFormat,
+		"set_event":          "",
+		"tracing_on":         "1\n",
+	})
+	f, err := New(NewLocalFileProviderAt(dir, t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := f.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	doneCh := make(chan bool)
+	defer close(doneCh)
+
+	stopped := s.KernelStopped(doneCh)
+	select {
+	case <-stopped:
+		t.Fatal("want no stop while tracing is on")
+	case <-time.After(2 * kernelStopPollInterval):
+	}
+
+	// As a traceoff trigger does
+	if err := ioutil.WriteFile(filepath.Join(dir, "tracing_on"), []byte("0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Error("want a stop once tracing is off")
+	}
+}
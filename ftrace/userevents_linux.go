@@ -0,0 +1,140 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+// ioctls on user_events_data, from linux/user_events.h.  Their size is that
+// of a pointer, not of the struct passed.
+const (
+	diagIOCSReg   = 3<<30 | uintptr(unsafe.Sizeof(uintptr(0)))<<16 | '*'<<8 | 0
+	diagIOCSUnreg = 1<<30 | uintptr(unsafe.Sizeof(uintptr(0)))<<16 | '*'<<8 | 2
+)
+
+// userReg is struct user_reg, which is packed
+type userReg struct {
+	size       uint32
+	enableBit  uint8
+	enableSize uint8
+	flags      uint16
+	enableAddr uint64
+	nameArgs   uint64
+	writeIndex uint32
+}
+
+const userRegSize = 28
+
+// userUnreg is struct user_unreg
+type userUnreg struct {
+	size        uint32
+	disableBit  uint8
+	reserved    uint8
+	reserved2   uint16
+	disableAddr uint64
+}
+
+// UserEvent is an event defined by this process through the user_events
+// ABI of Linux 6.4 and later.  Once registered it can be enabled and
+// decoded like any kernel event, as "user_events/<name>".
+type UserEvent struct {
+	file       *os.File
+	name       string
+	writeIndex uint32
+	// enabled has bit 0 set by the kernel while the event is enabled, so
+	// it must not move while registered
+	enabled *uint32
+}
+
+// RegisterUserEvent defines an event from a user_events definition like
+// "myevent u32 count;char[16] name;__rel_loc char[] msg", through the
+// user_events_data file of the tracefs at tracefsRoot, or FindTracefs if
+// it is empty.  The event stays registered until Close.
+func RegisterUserEvent(tracefsRoot, definition string) (*UserEvent, error) {
+	if tracefsRoot == "" {
+		tracefsRoot = FindTracefs()
+	}
+	file, err := os.OpenFile(path.Join(tracefsRoot, "user_events_data"), os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	// The name may be followed by ":<flags>" and the fields
+	u := &UserEvent{file: file, name: definition, enabled: new(uint32)}
+	if end := strings.IndexAny(definition, " :"); end != -1 {
+		u.name = definition[:end]
+	}
+
+	nameArgs := append([]byte(definition), 0)
+	reg := userReg{
+		size:       userRegSize,
+		enableSize: 4,
+		enableAddr: uint64(uintptr(unsafe.Pointer(u.enabled))),
+		nameArgs:   uint64(uintptr(unsafe.Pointer(&nameArgs[0]))),
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, file.Fd(), diagIOCSReg, uintptr(unsafe.Pointer(&reg)))
+	runtime.KeepAlive(nameArgs)
+	if errno != 0 {
+		file.Close()
+		return nil, fmt.Errorf("can't register user event %q: %s", definition, errno.Error())
+	}
+	u.writeIndex = reg.writeIndex
+	return u, nil
+}
+
+// Name returns the name of the event, without its system
+func (u *UserEvent) Name() string {
+	return u.name
+}
+
+// Enabled returns whether the event is enabled, so that callers can skip
+// building events nobody is tracing
+func (u *UserEvent) Enabled() bool {
+	return atomic.LoadUint32(u.enabled)&1 != 0
+}
+
+// Write records an event with payload as its fields after the common
+// fields, laid out as in the event's format file
+func (u *UserEvent) Write(payload []byte) error {
+	data := make([]byte, 4, 4+len(payload))
+	binary.NativeEndian.PutUint32(data, u.writeIndex)
+	_, err := u.file.Write(append(data, payload...))
+	return err
+}
+
+// Close unregisters the event from this process.  The event's definition
+// stays in tracefs until it is deleted, or no process has it registered,
+// depending on the kernel.
+func (u *UserEvent) Close() error {
+	unreg := userUnreg{
+		size:        uint32(unsafe.Sizeof(userUnreg{})),
+		disableAddr: uint64(uintptr(unsafe.Pointer(u.enabled))),
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, u.file.Fd(), diagIOCSUnreg, uintptr(unsafe.Pointer(&unreg)))
+	err := u.file.Close()
+	if errno != 0 {
+		return fmt.Errorf("can't unregister user event %s: %s", u.name, errno.Error())
+	}
+	return err
+}
@@ -0,0 +1,43 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+
+package ftrace
+
+import "fmt"
+
+// UserEvent is an event defined through the user_events ABI, which only
+// Linux has
+type UserEvent struct{}
+
+func RegisterUserEvent(tracefsRoot, definition string) (*UserEvent, error) {
+	return nil, fmt.Errorf("can't register user event %q: not supported", definition)
+}
+
+func (u *UserEvent) Name() string {
+	return ""
+}
+
+func (u *UserEvent) Enabled() bool {
+	return false
+}
+
+func (u *UserEvent) Write(payload []byte) error {
+	return fmt.Errorf("can't write user event: not supported")
+}
+
+func (u *UserEvent) Close() error {
+	return nil
+}
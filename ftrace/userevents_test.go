@@ -0,0 +1,115 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"encoding/binary"
+	"os"
+	"path"
+	"testing"
+)
+
+// userEventFormat is the format of "test_event u32 count;char[8] tag;__rel_loc char[] msg"
+const userEventFormat = `name: test_event
+ID: 1803
+format:
+	field:unsigned short common_type;	offset:0;	size:2;	signed:0;
+	field:unsigned char common_flags;	offset:2;	size:1;	signed:0;
+	field:unsigned char common_preempt_count;	offset:3;	size:1;	signed:0;
+	field:int common_pid;	offset:4;	size:4;	signed:1;
+
+	field:u32 count;	offset:8;	size:4;	signed:0;
+	field:char tag[8];	offset:12;	size:8;	signed:1;
+	field:__rel_loc char msg[];	offset:20;	size:4;	signed:1;
+
+print fmt: "count=%u tag=%s msg=%s", REC->count, REC->tag, __get_rel_str(msg)
+`
+
+func TestUserEventDecode(t *testing.T) {
+	f := newTestFtraceWith(t, map[string]string{
+		"/sys/kernel/debug/tracing/events/user_events/test_event/format": userEventFormat,
+	})
+	etype, err := f.NewEventType("user_events/test_event")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fields := etype.Fields()
+	if msg := fields[len(fields)-1]; !msg.DataLoc || !msg.RelLoc || msg.Type != "char" {
+		t.Errorf("want msg to be a __rel_loc string, got %+v", msg)
+	}
+
+	// The payload written to user_events_data is what follows the common
+	// fields, with the __rel_loc offset from the end of the msg field
+	data := make([]byte, 24)
+	binary.LittleEndian.PutUint16(data[0:], 1803)
+	binary.LittleEndian.PutUint32(data[4:], 1234)
+	binary.LittleEndian.PutUint32(data[8:], 42)
+	copy(data[12:], "tag")
+	binary.LittleEndian.PutUint32(data[20:], 6<<16|0)
+	data = append(data, "hello\x00"...)
+
+	page := &testPage{timestamp: 1000000000}
+	page.addEvent(0, data)
+	events, err := f.decodePage(0, page.bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("want 1 event got %d", len(events))
+	}
+	e := events[0]
+
+	if msg, err := e.Str("msg"); err != nil || msg != "hello" {
+		t.Errorf("want msg hello got %q %v", msg, err)
+	}
+	if want, got := "count=42 tag=tag msg=hello", etype.Format(*e); got != want {
+		t.Errorf("want %q got %q", want, got)
+	}
+
+	// NewEvent lays out __rel_loc fields the same way
+	synthesized, err := etype.NewEvent(map[string]interface{}{"count": 42, "tag": "tag", "msg": "hello"}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg, err := synthesized.Str("msg"); err != nil || msg != "hello" {
+		t.Errorf("want synthesized msg hello got %q %v", msg, err)
+	}
+}
+
+func TestRegisterUserEvent(t *testing.T) {
+	tracefs := FindTracefs()
+	if _, err := os.Stat(path.Join(tracefs, "user_events_data")); err != nil {
+		t.Skip("user_events not available: ", err)
+	}
+
+	u, err := RegisterUserEvent(tracefs, "traceout_test u32 count")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer u.Close()
+
+	if u.Name() != "traceout_test" {
+		t.Errorf("want name traceout_test got %s", u.Name())
+	}
+	if u.Enabled() {
+		t.Error("event enabled without being traced")
+	}
+	payload := make([]byte, 4)
+	binary.LittleEndian.PutUint32(payload, 1)
+	if err := u.Write(payload); err != nil {
+		t.Error(err)
+	}
+}
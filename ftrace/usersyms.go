@@ -0,0 +1,248 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"debug/elf"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// userMapsRefreshInterval limits how often a process's maps are reread when
+// an address can't be resolved
+const userMapsRefreshInterval = time.Second
+
+// UserSymbolizer resolves userspace addresses to the binary they are mapped
+// from, using /proc/<pid>/maps read through a FileProvider.  If binaryRoot is
+// set, the binaries are opened under it to look up ELF symbols.
+type UserSymbolizer struct {
+	// lock guards the caches, for events symbolized by several goroutines
+	lock       sync.Mutex
+	fp         FileProvider
+	binaryRoot string
+	maps       map[int]*userMaps
+	binaries   map[string]*elfBinary
+}
+
+type userMaps struct {
+	mappings []userMapping
+	read     time.Time
+}
+
+type userMapping struct {
+	start, end uint64
+	offset     uint64
+	path       string
+}
+
+type elfBinary struct {
+	// file offsets to virtual addresses of the loadable segments
+	progs   []*elf.Prog
+	symbols elfSymbols
+}
+
+type elfSymbols []elf.Symbol
+
+func (s elfSymbols) Len() int           { return len(s) }
+func (s elfSymbols) Less(i, j int) bool { return s[i].Value < s[j].Value }
+func (s elfSymbols) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// NewUserSymbolizer returns a UserSymbolizer reading maps through fp.  If
+// binaryRoot is empty addresses are only resolved to binary+offset, otherwise
+// mapped paths are opened relative to it, for example "/" when tracing the
+// local machine or the root of an unpacked system image for a remote device.
+func NewUserSymbolizer(fp FileProvider, binaryRoot string) *UserSymbolizer {
+	return &UserSymbolizer{
+		fp:         fp,
+		binaryRoot: binaryRoot,
+		maps:       make(map[int]*userMaps),
+		binaries:   make(map[string]*elfBinary),
+	}
+}
+
+// Symbolize formats an address in the address space of pid as
+// "symbol+0xoff [binary]" if an ELF symbol contains it, as "binary+0xoff"
+// using the offset into the mapped file otherwise, or in hex if it isn't in a
+// mapped file.
+func (s *UserSymbolizer) Symbolize(pid int, addr uint64) string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	m := s.mapping(pid, addr)
+	if m == nil {
+		return fmt.Sprintf("0x%x", addr)
+	}
+	fileOffset := addr - m.start + m.offset
+
+	if b := s.binary(m.path); b != nil {
+		if sym, off, ok := b.lookup(fileOffset); ok {
+			return fmt.Sprintf("%s+0x%x [%s]", sym, off, m.path)
+		}
+	}
+	return fmt.Sprintf("%s+0x%x", m.path, fileOffset)
+}
+
+func (s *UserSymbolizer) mapping(pid int, addr uint64) *userMapping {
+	now := time.Now()
+	maps := s.maps[pid]
+	if maps == nil {
+		maps = s.readMaps(pid, now)
+	}
+	if m := maps.find(addr); m != nil {
+		return m
+	}
+	if now.Sub(maps.read) < userMapsRefreshInterval {
+		return nil
+	}
+	return s.readMaps(pid, now).find(addr)
+}
+
+func (s *UserSymbolizer) readMaps(pid int, now time.Time) *userMaps {
+	maps := &userMaps{read: now}
+	s.maps[pid] = maps
+
+	buf, err := s.fp.ReadProcFile(strconv.Itoa(pid) + "/maps")
+	if err != nil {
+		return maps
+	}
+	maps.mappings = parseMaps(string(buf))
+	return maps
+}
+
+// parseMaps parses the file backed mappings in the contents of
+// /proc/<pid>/maps
+func parseMaps(maps string) []userMapping {
+	var mappings []userMapping
+	for _, line := range strings.Split(maps, "\n") {
+		// start-end perms offset dev inode path
+		v := strings.Fields(line)
+		if len(v) < 6 || !strings.HasPrefix(v[5], "/") {
+			continue
+		}
+		r := strings.SplitN(v[0], "-", 2)
+		if len(r) != 2 {
+			continue
+		}
+		start, err1 := strconv.ParseUint(r[0], 16, 64)
+		end, err2 := strconv.ParseUint(r[1], 16, 64)
+		offset, err3 := strconv.ParseUint(v[2], 16, 64)
+		if err1 != nil || err2 != nil || err3 != nil {
+			continue
+		}
+		mappings = append(mappings, userMapping{
+			start:  start,
+			end:    end,
+			offset: offset,
+			path:   strings.Join(v[5:], " "),
+		})
+	}
+	return mappings
+}
+
+func (maps *userMaps) find(addr uint64) *userMapping {
+	for i := range maps.mappings {
+		m := &maps.mappings[i]
+		if addr >= m.start && addr < m.end {
+			return m
+		}
+	}
+	return nil
+}
+
+// binary returns the ELF symbols of a mapped file, or nil if there is no
+// binaryRoot or the file can't be read
+func (s *UserSymbolizer) binary(path string) *elfBinary {
+	if s.binaryRoot == "" {
+		return nil
+	}
+	if b, ok := s.binaries[path]; ok {
+		return b
+	}
+
+	b := readElfBinary(filepath.Join(s.binaryRoot, path))
+	s.binaries[path] = b
+	return b
+}
+
+func readElfBinary(filename string) *elfBinary {
+	f, err := elf.Open(filename)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	b := &elfBinary{}
+	for _, p := range f.Progs {
+		if p.Type == elf.PT_LOAD {
+			b.progs = append(b.progs, p)
+		}
+	}
+
+	syms, _ := f.Symbols()
+	dynsyms, _ := f.DynamicSymbols()
+	for _, sym := range append(syms, dynsyms...) {
+		if elf.ST_TYPE(sym.Info) == elf.STT_FUNC && sym.Value != 0 {
+			b.symbols = append(b.symbols, sym)
+		}
+	}
+	sort.Stable(b.symbols)
+	return b
+}
+
+// lookup finds the function containing a file offset
+func (b *elfBinary) lookup(fileOffset uint64) (string, uint64, bool) {
+	var vaddr uint64
+	found := false
+	for _, p := range b.progs {
+		if fileOffset >= p.Off && fileOffset < p.Off+p.Filesz {
+			vaddr = fileOffset - p.Off + p.Vaddr
+			found = true
+			break
+		}
+	}
+	if !found {
+		return "", 0, false
+	}
+
+	i := sort.Search(len(b.symbols), func(i int) bool { return b.symbols[i].Value > vaddr })
+	if i == 0 {
+		return "", 0, false
+	}
+	sym := b.symbols[i-1]
+	if sym.Size != 0 && vaddr >= sym.Value+sym.Size {
+		return "", 0, false
+	}
+	return sym.Name, vaddr - sym.Value, true
+}
+
+// SetUserSymbolizer sets the symbolizer used by Event.UserSymbol
+func (f *Ftrace) SetUserSymbolizer(s *UserSymbolizer) {
+	f.userSymbolizer = s
+}
+
+// UserSymbol formats a userspace address in the address space of the process
+// that generated the event, see UserSymbolizer.Symbolize.  Without a
+// UserSymbolizer the address is printed in hex.
+func (e Event) UserSymbol(addr uint64) string {
+	if e.ftrace == nil || e.ftrace.userSymbolizer == nil {
+		return fmt.Sprintf("0x%x", addr)
+	}
+	return e.ftrace.userSymbolizer.Symbolize(e.Pid, addr)
+}
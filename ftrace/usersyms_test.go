@@ -0,0 +1,90 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"debug/elf"
+	"fmt"
+	"testing"
+)
+
+const testMaps = `00400000-00452000 r-xp 00000000 08:02 173521      /usr/bin/dbus-daemon
+00651000-00652000 r--p 00051000 08:02 173521      /usr/bin/dbus-daemon
+00e03000-00e24000 rw-p 00000000 00:00 0           [heap]
+7f5f6c4c2000-7f5f6c676000 r-xp 00021000 08:02 135522      /usr/lib/libc-2.15.so
+7fff6f7e3000-7fff6f804000 rw-p 00000000 00:00 0   [stack]
+`
+
+func TestUserSymbolizerMaps(t *testing.T) {
+	files := map[string]string{"/proc/1234/maps": testMaps}
+	s := NewUserSymbolizer(NewTestFileProvider(files), "")
+
+	tests := []struct {
+		addr uint64
+		want string
+	}{
+		{0x00401234, "/usr/bin/dbus-daemon+0x1234"},
+		{0x7f5f6c4c2010, "/usr/lib/libc-2.15.so+0x21010"},
+		{0x00e03010, "0xe03010"},
+		{0x10, "0x10"},
+	}
+	for _, test := range tests {
+		if got := s.Symbolize(1234, test.addr); got != test.want {
+			t.Errorf("%x: want %q, got %q", test.addr, test.want, got)
+		}
+	}
+}
+
+func TestUserSymbolizerElf(t *testing.T) {
+	const libc = "/lib/x86_64-linux-gnu/libc.so.6"
+	f, err := elf.Open(libc)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer f.Close()
+
+	var malloc elf.Symbol
+	syms, _ := f.DynamicSymbols()
+	for _, sym := range syms {
+		if sym.Name == "malloc" {
+			malloc = sym
+		}
+	}
+	var text *elf.Prog
+	for _, p := range f.Progs {
+		if p.Type == elf.PT_LOAD && p.Flags&elf.PF_X != 0 {
+			text = p
+		}
+	}
+	if malloc.Value == 0 || text == nil {
+		t.Skip("no malloc in " + libc)
+	}
+
+	// Map the text segment of libc at an arbitrary address
+	base := uint64(0x7f0000000000)
+	start := base + text.Vaddr&^0xfff
+	maps := fmt.Sprintf("%x-%x r-xp %08x 08:02 1 %s\n",
+		start, start+text.Memsz+0x1000, text.Off&^0xfff, libc)
+	s := NewUserSymbolizer(NewTestFileProvider(map[string]string{"/proc/1234/maps": maps}), "/")
+
+	// malloc has aliases like __libc_malloc, accept any of them
+	got := s.Symbolize(1234, base+malloc.Value+0x10)
+	for _, sym := range syms {
+		if sym.Value == malloc.Value && got == fmt.Sprintf("%s+0x10 [%s]", sym.Name, libc) {
+			return
+		}
+	}
+	t.Errorf("want malloc+0x10 [%s], got %s", libc, got)
+}
@@ -0,0 +1,64 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// TimeWindow selects the events whose timestamps fall between Start and
+// End inclusive, for inspecting a narrow interval of a large recording.
+// Events outside it are dropped before they are decoded.
+type TimeWindow struct {
+	// Start and End are nanoseconds on the timebase of Event.When, or
+	// since the first event decoded if Relative is set.  A zero End leaves
+	// the window open ended.
+	Start, End uint64
+	Relative   bool
+}
+
+// NewWallClockWindow returns the window between two wall clock times, for
+// traces whose timestamps are wall clock time, for example recorded with
+// the "tai" trace clock and moved to UTC with SetClockOffset
+func NewWallClockWindow(start, end time.Time) TimeWindow {
+	return TimeWindow{
+		Start: uint64(start.UnixNano()),
+		End:   uint64(end.UnixNano()),
+	}
+}
+
+func (w TimeWindow) isZero() bool {
+	return w == TimeWindow{}
+}
+
+// inWindow reports whether an event at when, including the clock offset,
+// is in the capture's time window
+func (f *Ftrace) inWindow(when uint64) bool {
+	w := f.options.Window
+	if w.isZero() {
+		return true
+	}
+	if w.Relative {
+		// The first event decoded on any cpu starts the trace
+		atomic.CompareAndSwapUint64(&f.windowStart, 0, when)
+		if start := atomic.LoadUint64(&f.windowStart); when > start {
+			when -= start
+		} else {
+			when = 0
+		}
+	}
+	return when >= w.Start && (w.End == 0 || when <= w.End)
+}
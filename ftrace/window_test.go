@@ -0,0 +1,67 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftrace
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeWindow(t *testing.T) {
+	f := newTestFtrace(t, testFiles)
+	if _, err := f.NewEventType("sched/sched_wakeup"); err != nil {
+		t.Fatal(err)
+	}
+
+	page := &testPage{timestamp: 1000}
+	for pid := 1; pid <= 4; pid++ {
+		page.addEvent(100, schedWakeup(pid, "bash", 120, 1))
+	}
+	// Timestamps are 1100, 1200, 1300 and 1400
+
+	tests := []struct {
+		window TimeWindow
+		pids   []int
+	}{
+		{TimeWindow{}, []int{1, 2, 3, 4}},
+		{TimeWindow{Start: 1200, End: 1300}, []int{2, 3}},
+		{TimeWindow{Start: 1300}, []int{3, 4}},
+		{TimeWindow{Start: 100, End: 200, Relative: true}, []int{2, 3}},
+		{NewWallClockWindow(time.Unix(0, 1400), time.Unix(0, 2000)), []int{4}},
+	}
+	for _, test := range tests {
+		// As set up by PrepareCaptureWithOptions, without starting readers
+		f.options = CaptureOptions{Window: test.window}
+		f.windowStart = 0
+		events, err := f.decodePage(0, page.bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var pids []int
+		for _, e := range events {
+			pids = append(pids, e.Pid)
+		}
+		if len(pids) != len(test.pids) {
+			t.Errorf("%+v: want pids %v got %v", test.window, test.pids, pids)
+			continue
+		}
+		for i := range pids {
+			if pids[i] != test.pids[i] {
+				t.Errorf("%+v: want pids %v got %v", test.window, test.pids, pids)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/google/traceout/ftrace"
+	"github.com/google/traceout/ftrace/analysis"
+)
+
+// writeIRQSummary prints the time spent in IRQ and softirq handlers by
+// events, which must be sorted by time
+func writeIRQSummary(w io.Writer, events ftrace.Events) {
+	it := analysis.NewIRQTimes()
+	for _, e := range events {
+		it.Add(e)
+	}
+	cpuTime := it.CPUTime()
+	if len(cpuTime) == 0 {
+		fmt.Fprintln(w, "no IRQ times, trace the irq category")
+		return
+	}
+
+	var cpus []int
+	for cpu := range cpuTime {
+		cpus = append(cpus, cpu)
+	}
+	sort.Ints(cpus)
+	fmt.Fprintf(w, "  %-5s %12s\n", "cpu", "irq time")
+	for _, cpu := range cpus {
+		fmt.Fprintf(w, "  %-5d %12v\n", cpu, cpuTime[cpu])
+	}
+
+	for _, section := range []struct {
+		title string
+		times []analysis.IRQTime
+	}{{"irq", it.IRQs()}, {"softirq", it.Softirqs()}} {
+		if len(section.times) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "  %-8s %-16s %8s %12s %10s %10s %10s\n", section.title, "name", "count", "total", "p50", "p99", "max")
+		for _, t := range section.times {
+			fmt.Fprintf(w, "  %-8d %-16s %8d %12v %10v %10v %10v\n",
+				t.IRQ, t.Name, t.Count, t.Total, t.Durations.P50, t.Durations.P99, t.Durations.Max)
+		}
+	}
+}
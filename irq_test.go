@@ -0,0 +1,68 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/google/traceout/ftrace"
+)
+
+const testSoftirqFormat = `name: %s
+ID: %s
+format:
+	field:unsigned short common_type;	offset:0;	size:2;	signed:0;
+	field:unsigned char common_flags;	offset:2;	size:1;	signed:0;
+	field:unsigned char common_preempt_count;	offset:3;	size:1;	signed:0;
+	field:int common_pid;	offset:4;	size:4;	signed:1;
+
+	field:unsigned int vec;	offset:8;	size:4;	signed:0;
+
+print fmt: "vec=%u", REC->vec
+`
+
+func TestWriteIRQSummary(t *testing.T) {
+	entry := strings.Replace(strings.Replace(testSoftirqFormat, "%s", "softirq_entry", 1), "%s", "93", 1)
+	exit := strings.Replace(strings.Replace(testSoftirqFormat, "%s", "softirq_exit", 1), "%s", "92", 1)
+	events := ftrace.Events{
+		testEvent(t, entry, 0, 1000, map[string]interface{}{"vec": 1}),
+		testEvent(t, exit, 0, 3000, map[string]interface{}{"vec": 1}),
+		testEvent(t, entry, 1, 4000, map[string]interface{}{"vec": 3}),
+		testEvent(t, exit, 1, 9000, map[string]interface{}{"vec": 3}),
+	}
+
+	var buf bytes.Buffer
+	writeIRQSummary(&buf, events)
+	want := []string{
+		"  cpu       irq time",
+		"  0              2µs",
+		"  1              5µs",
+		"  softirq  name                count        total        p50        p99        max",
+		"  3        NET_RX                  1          5µs        5µs        5µs        5µs",
+		"  1        TIMER                   1          2µs        2µs        2µs        2µs",
+		"",
+	}
+	if got := buf.String(); got != strings.Join(want, "\n") {
+		t.Errorf("want\n%s\ngot\n%s", strings.Join(want, "\n"), got)
+	}
+
+	buf.Reset()
+	writeIRQSummary(&buf, nil)
+	if !strings.HasPrefix(buf.String(), "no IRQ times") {
+		t.Errorf("unexpected output for no events: %s", buf.String())
+	}
+}
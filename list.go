@@ -0,0 +1,88 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/google/traceout/ftrace"
+)
+
+// listEvents prints the available events matching any of patterns, or all
+// of them if there are no patterns.  With details, each event's fields and
+// print format are printed below it, leaving out the common fields every
+// event has.
+func listEvents(w io.Writer, fp ftrace.FileProvider, f *ftrace.Ftrace, patterns []string, details bool) error {
+	available, err := f.AvailableEvents()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range available {
+		if !matchAny(patterns, name) {
+			continue
+		}
+		fmt.Fprintln(w, name)
+		if !details {
+			continue
+		}
+
+		format, err := fp.ReadFtraceFile(path.Join("events", name, "format"))
+		if err != nil {
+			return err
+		}
+		etype, err := ftrace.ParseEventFormat(format)
+		if err != nil {
+			fmt.Fprintf(w, "\t%s\n", err)
+			continue
+		}
+		for _, field := range etype.Fields() {
+			if strings.HasPrefix(field.Name, "common_") {
+				continue
+			}
+			fmt.Fprintf(w, "\t%-40s offset:%d size:%d signed:%v\n", fieldDecl(field), field.Offset, field.Size, field.Signed)
+		}
+		fmt.Fprintf(w, "\tprint fmt: %s\n", etype.PrintFmt())
+	}
+	return nil
+}
+
+func matchAny(patterns []string, name string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if matched, _ := path.Match(p, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldDecl returns a field's C declaration as in its format file
+func fieldDecl(field ftrace.FieldInfo) string {
+	switch {
+	case field.RelLoc:
+		return fmt.Sprintf("__rel_loc %s[] %s", field.Type, field.Name)
+	case field.DataLoc:
+		return fmt.Sprintf("__data_loc %s[] %s", field.Type, field.Name)
+	case field.Array:
+		return fmt.Sprintf("%s %s[]", field.Type, field.Name)
+	}
+	return fmt.Sprintf("%s %s", field.Type, field.Name)
+}
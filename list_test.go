@@ -0,0 +1,76 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/traceout/ftrace"
+)
+
+const headerPageFormat = `	field: u64 timestamp;	offset:0;	size:8;	signed:0;
+	field: local_t commit;	offset:8;	size:8;	signed:1;
+	field: char data;	offset:16;	size:4080;	signed:1;
+`
+
+const taskRenameFormat = `name: task_rename
+ID: 111
+format:
+	field:unsigned short common_type;	offset:0;	size:2;	signed:0;
+	field:unsigned char common_flags;	offset:2;	size:1;	signed:0;
+	field:unsigned char common_preempt_count;	offset:3;	size:1;	signed:0;
+	field:int common_pid;	offset:4;	size:4;	signed:1;
+
+	field:pid_t pid;	offset:8;	size:4;	signed:1;
+	field:char oldcomm[16];	offset:12;	size:16;	signed:0;
+	field:__data_loc char[] newcomm;	offset:28;	size:4;	signed:0;
+
+print fmt: "pid=%d oldcomm=%s newcomm=%s", REC->pid, REC->oldcomm, __get_str(newcomm)
+`
+
+func TestListEvents(t *testing.T) {
+	fp := ftrace.NewTestFileProvider(map[string]string{
+		"/sys/kernel/debug/tracing/events/header_page":             headerPageFormat,
+		"/sys/kernel/debug/tracing/available_events":               "sched:sched_switch\ntask:task_rename\ntask:task_newtask\n",
+		"/sys/kernel/debug/tracing/events/task/task_rename/format": taskRenameFormat,
+	})
+	f, err := ftrace.New(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := listEvents(&buf, fp, f, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	want := "sched/sched_switch\ntask/task_rename\ntask/task_newtask\n"
+	if buf.String() != want {
+		t.Errorf("want\n%s\ngot\n%s", want, buf.String())
+	}
+
+	buf.Reset()
+	if err := listEvents(&buf, fp, f, []string{"task/*_rename"}, true); err != nil {
+		t.Fatal(err)
+	}
+	want = "task/task_rename\n" +
+		"\tpid_t pid                                offset:8 size:4 signed:true\n" +
+		"\tchar oldcomm[]                           offset:12 size:16 signed:false\n" +
+		"\t__data_loc char[] newcomm                offset:28 size:4 signed:false\n" +
+		"\tprint fmt: \"pid=%d oldcomm=%s newcomm=%s\", REC->pid, REC->oldcomm, __get_str(newcomm)\n"
+	if buf.String() != want {
+		t.Errorf("want\n%s\ngot\n%s", want, buf.String())
+	}
+}
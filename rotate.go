@@ -0,0 +1,137 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/google/traceout/ftrace"
+)
+
+// rotatingFile writes to a file that is moved aside when it grows past
+// maxSize bytes or is older than interval, so long captures use bounded
+// space.  The current output is always name, older output is name.1,
+// name.2 and so on, and only the newest keep old files are kept.  Writes
+// are never split, so files rotate on line boundaries as long as each
+// Write is a whole line.  Each file is compressed separately with codec,
+// and maxSize counts bytes before compression.
+type rotatingFile struct {
+	name     string
+	maxSize  int64
+	interval time.Duration
+	keep     int
+	codec    ftrace.Codec
+
+	f       *os.File
+	w       io.WriteCloser
+	size    int64
+	created time.Time
+	now     func() time.Time
+}
+
+// newRotatingFile creates name, replacing any existing file.  A maxSize or
+// interval of 0 disables rotating on size or time, and a keep of 0 keeps
+// every old file.
+func newRotatingFile(name string, maxSize int64, interval time.Duration, keep int, codec ftrace.Codec) (*rotatingFile, error) {
+	r := &rotatingFile{
+		name:     name,
+		maxSize:  maxSize,
+		interval: interval,
+		keep:     keep,
+		codec:    codec,
+		now:      time.Now,
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.Create(r.name)
+	if err != nil {
+		return err
+	}
+	w, err := r.codec.NewWriter(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f = f
+	r.w = w
+	r.size = 0
+	r.created = r.now()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	full := r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize
+	old := r.interval > 0 && r.now().Sub(r.created) >= r.interval
+	if full || old {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.w.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate moves name to name.1, name.1 to name.2 and so on, drops files
+// beyond keep, and starts a new name
+func (r *rotatingFile) rotate() error {
+	if err := r.Close(); err != nil {
+		return err
+	}
+
+	last := 1
+	for {
+		if _, err := os.Stat(r.oldName(last)); err != nil {
+			break
+		}
+		last++
+	}
+	for i := last; i > 0; i-- {
+		from := r.name
+		if i > 1 {
+			from = r.oldName(i - 1)
+		}
+		if r.keep > 0 && i > r.keep {
+			os.Remove(from)
+			continue
+		}
+		if err := os.Rename(from, r.oldName(i)); err != nil {
+			return err
+		}
+	}
+
+	return r.open()
+}
+
+func (r *rotatingFile) oldName(i int) string {
+	return fmt.Sprintf("%s.%d", r.name, i)
+}
+
+func (r *rotatingFile) Close() error {
+	if err := r.w.Close(); err != nil {
+		r.f.Close()
+		return err
+	}
+	return r.f.Close()
+}
@@ -0,0 +1,143 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/traceout/ftrace"
+)
+
+func readFile(t *testing.T, name string) string {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func noCodec(t *testing.T) ftrace.Codec {
+	c, err := ftrace.GetCodec("none")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestRotatingFileSize(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "trace")
+	r, err := newRotatingFile(name, 10, 0, 2, noCodec(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"one\n", "two\n", "three\n", "four\n", "five\n"} {
+		if _, err := r.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.Close()
+
+	want := map[string]string{
+		name:        "four\nfive\n",
+		name + ".1": "three\n",
+		name + ".2": "one\ntwo\n",
+	}
+	for n, contents := range want {
+		if got := readFile(t, n); got != contents {
+			t.Errorf("%s: want %q got %q", n, contents, got)
+		}
+	}
+}
+
+func TestRotatingFileKeep(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "trace")
+	r, err := newRotatingFile(name, 4, 0, 1, noCodec(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"one\n", "two\n", "six\n"} {
+		if _, err := r.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.Close()
+
+	if got := readFile(t, name+".1"); got != "two\n" {
+		t.Errorf("want %q got %q", "two\n", got)
+	}
+	if _, err := os.Stat(name + ".2"); err == nil {
+		t.Errorf("%s.2 kept with keep 1", name)
+	}
+}
+
+func TestRotatingFileInterval(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "trace")
+	now := time.Unix(1000, 0)
+	r, err := newRotatingFile(name, 0, time.Minute, 0, noCodec(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.now = func() time.Time { return now }
+	r.created = now
+
+	r.Write([]byte("one\n"))
+	now = now.Add(30 * time.Second)
+	r.Write([]byte("two\n"))
+	now = now.Add(30 * time.Second)
+	r.Write([]byte("three\n"))
+	r.Close()
+
+	if got := readFile(t, name+".1"); got != "one\ntwo\n" {
+		t.Errorf("want %q got %q", "one\ntwo\n", got)
+	}
+	if got := readFile(t, name); got != "three\n" {
+		t.Errorf("want %q got %q", "three\n", got)
+	}
+}
+
+func TestRotatingFileCompressed(t *testing.T) {
+	codec, err := ftrace.GetCodec("gzip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(t.TempDir(), "trace")
+	r, err := newRotatingFile(name, 4, 0, 0, codec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Write([]byte("one\n"))
+	r.Write([]byte("two\n"))
+	r.Close()
+
+	for n, contents := range map[string]string{name: "two\n", name + ".1": "one\n"} {
+		z, err := gzip.NewReader(bytes.NewReader([]byte(readFile(t, n))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(z)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != contents {
+			t.Errorf("%s: want %q got %q", n, contents, data)
+		}
+	}
+}
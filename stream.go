@@ -0,0 +1,122 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+// streamBacklog is how many writes are queued for a client before it is
+// considered too slow and disconnected
+const streamBacklog = 4096
+
+// eventStream copies everything written to it to each connected client.
+// Clients only get what is written after they connect, and a client that
+// can't keep up is disconnected rather than slowing down the capture.
+type eventStream struct {
+	ln net.Listener
+
+	sync.Mutex
+	clients map[net.Conn]chan []byte
+}
+
+// listenStream listens on addr, which is "unix:<path>" for a Unix socket
+// or host:port for TCP
+func listenStream(addr string) (*eventStream, error) {
+	network := "tcp"
+	if strings.HasPrefix(addr, "unix:") {
+		network, addr = "unix", strings.TrimPrefix(addr, "unix:")
+	}
+	ln, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &eventStream{
+		ln:      ln,
+		clients: make(map[net.Conn]chan []byte),
+	}
+	go s.accept()
+	return s, nil
+}
+
+func (s *eventStream) accept() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		ch := make(chan []byte, streamBacklog)
+		s.Lock()
+		s.clients[conn] = ch
+		s.Unlock()
+		go s.send(conn, ch)
+	}
+}
+
+// send writes queued data to a client until its queue is closed or the
+// connection fails
+func (s *eventStream) send(conn net.Conn, ch chan []byte) {
+	defer conn.Close()
+	for data := range ch {
+		if _, err := conn.Write(data); err != nil {
+			s.drop(conn)
+			return
+		}
+	}
+}
+
+func (s *eventStream) drop(conn net.Conn) {
+	s.Lock()
+	defer s.Unlock()
+	if ch, ok := s.clients[conn]; ok {
+		delete(s.clients, conn)
+		close(ch)
+	}
+}
+
+func (s *eventStream) Write(p []byte) (int, error) {
+	data := append([]byte(nil), p...)
+
+	s.Lock()
+	defer s.Unlock()
+	for conn, ch := range s.clients {
+		select {
+		case ch <- data:
+		default:
+			fmt.Fprintf(os.Stderr, "dropping slow stream client %s\n", conn.RemoteAddr())
+			delete(s.clients, conn)
+			close(ch)
+		}
+	}
+	return len(p), nil
+}
+
+// Close stops accepting clients and disconnects the current ones once
+// they have been sent everything written so far
+func (s *eventStream) Close() error {
+	err := s.ln.Close()
+	s.Lock()
+	defer s.Unlock()
+	for conn, ch := range s.clients {
+		delete(s.clients, conn)
+		close(ch)
+	}
+	return err
+}
@@ -0,0 +1,73 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testStream(t *testing.T, addr string) {
+	s, err := listenStream(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial(s.ln.Addr().Network(), s.ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Wait for the client to be accepted
+	for i := 0; ; i++ {
+		s.Lock()
+		n := len(s.clients)
+		s.Unlock()
+		if n == 1 {
+			break
+		}
+		if i == 100 {
+			t.Fatal("client not accepted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	s.Write([]byte("first\n"))
+	s.Write([]byte("second\n"))
+	s.Close()
+
+	r := bufio.NewReader(conn)
+	for _, want := range []string{"first\n", "second\n"} {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line != want {
+			t.Errorf("want %q got %q", want, line)
+		}
+	}
+}
+
+func TestStreamTCP(t *testing.T) {
+	testStream(t, "localhost:0")
+}
+
+func TestStreamUnix(t *testing.T) {
+	testStream(t, "unix:"+filepath.Join(t.TempDir(), "btrace.sock"))
+}
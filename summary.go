@@ -0,0 +1,91 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/google/traceout/ftrace"
+)
+
+// captureSummary counts the events of a capture, to tell at the end
+// whether it was healthy
+type captureSummary struct {
+	start  time.Time
+	total  int
+	byType map[string]int
+	byCPU  map[int]int
+}
+
+func newCaptureSummary() *captureSummary {
+	return &captureSummary{
+		start:  time.Now(),
+		byType: make(map[string]int),
+		byCPU:  make(map[int]int),
+	}
+}
+
+func (s *captureSummary) add(events ftrace.Events) {
+	for _, e := range events {
+		s.total++
+		s.byType[e.EventType().Name()]++
+		s.byCPU[e.Cpu]++
+	}
+}
+
+// write prints the counts, and the events lost by the kernel on each of the
+// first cpus cpus, read from the ring buffer stats
+func (s *captureSummary) write(w io.Writer, f *ftrace.Ftrace, cpus int, elapsed time.Duration) {
+	rate := 0.0
+	if elapsed > 0 {
+		rate = float64(s.total) / elapsed.Seconds()
+	}
+	fmt.Fprintf(w, "%d events in %v (%.1f/s)\n", s.total, elapsed.Round(time.Millisecond), rate)
+
+	var names []string
+	for name := range s.byType {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if s.byType[names[i]] != s.byType[names[j]] {
+			return s.byType[names[i]] > s.byType[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-40s %10d\n", name, s.byType[name])
+	}
+
+	var totalLost uint64
+	fmt.Fprintf(w, "  %-5s %10s %10s\n", "cpu", "events", "lost")
+	for cpu := 0; cpu < cpus; cpu++ {
+		stats, err := f.CPUStats(cpu)
+		if err != nil {
+			// Past the last cpu
+			break
+		}
+		if s.byCPU[cpu] == 0 && stats.Lost() == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "  %-5d %10d %10d\n", cpu, s.byCPU[cpu], stats.Lost())
+		totalLost += stats.Lost()
+	}
+	if totalLost > 0 {
+		fmt.Fprintf(w, "warning: the kernel lost %d events, try a bigger buffer or fewer events\n", totalLost)
+	}
+}
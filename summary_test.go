@@ -0,0 +1,60 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/traceout/ftrace"
+)
+
+func TestCaptureSummary(t *testing.T) {
+	f, err := ftrace.New(ftrace.NewTestFileProvider(map[string]string{
+		"/sys/kernel/debug/tracing/events/header_page": "\tfield: u64 timestamp;\toffset:0;\tsize:8;\tsigned:0;\n" +
+			"\tfield: local_t commit;\toffset:8;\tsize:8;\tsigned:1;\n" +
+			"\tfield: char data;\toffset:16;\tsize:4080;\tsigned:1;\n",
+		"/sys/kernel/debug/tracing/per_cpu/cpu0/stats": "entries: 0\noverrun: 0\n",
+		"/sys/kernel/debug/tracing/per_cpu/cpu1/stats": "entries: 0\noverrun: 7\n",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newCaptureSummary()
+	s.add(nil)
+	s.total = 20
+	s.byType = map[string]int{"sched_switch": 15, "sched_wakeup": 5}
+	s.byCPU = map[int]int{0: 20}
+
+	var buf bytes.Buffer
+	s.write(&buf, f, 2, 2*time.Second)
+
+	want := []string{
+		"20 events in 2s (10.0/s)",
+		"  sched_switch                                     15",
+		"  sched_wakeup                                      5",
+		"  cpu       events       lost",
+		"  0             20          0",
+		"  1              0          7",
+		"warning: the kernel lost 7 events, try a bigger buffer or fewer events",
+		"",
+	}
+	if got := buf.String(); got != strings.Join(want, "\n") {
+		t.Errorf("want\n%s\ngot\n%s", strings.Join(want, "\n"), got)
+	}
+}
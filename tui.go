@@ -0,0 +1,271 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/traceout/ftrace"
+)
+
+// tuiHistory is how many event lines the viewer keeps for redrawing
+const tuiHistory = 1000
+
+// tuiRates is how many of the busiest event types are shown
+const tuiRates = 5
+
+// tuiView is an interactive terminal view of a capture, like top for
+// kernel events: the newest events scroll past under a header with the
+// busiest event types.  Keys:
+//
+//	space or p  pause and resume the event view
+//	/           type a filter, events are shown if their line contains it
+//	c           clear the event view
+//	q           quit
+type tuiView struct {
+	sync.Mutex
+	lines   []string
+	paused  bool
+	filter  string
+	editing bool
+	input   string
+
+	// Event counts by type in the current and the last second
+	counts    map[string]int
+	rates     map[string]int
+	rateStart time.Time
+}
+
+func newTUIView(now time.Time) *tuiView {
+	return &tuiView{
+		counts:    make(map[string]int),
+		rates:     make(map[string]int),
+		rateStart: now,
+	}
+}
+
+// tuiEvent is an event as shown by the viewer
+type tuiEvent struct {
+	name string
+	line string
+}
+
+func (v *tuiView) add(events []tuiEvent, now time.Time) {
+	v.Lock()
+	defer v.Unlock()
+
+	v.updateRates(now)
+	for _, e := range events {
+		v.counts[e.name]++
+		if v.paused {
+			continue
+		}
+		if v.filter != "" && !strings.Contains(e.line, v.filter) {
+			continue
+		}
+		v.lines = append(v.lines, e.line)
+	}
+	if len(v.lines) > tuiHistory {
+		v.lines = append([]string(nil), v.lines[len(v.lines)-tuiHistory:]...)
+	}
+}
+
+// updateRates starts a new second of counting if the last one is over
+func (v *tuiView) updateRates(now time.Time) {
+	elapsed := now.Sub(v.rateStart)
+	if elapsed < time.Second {
+		return
+	}
+	v.rates = v.counts
+	if elapsed >= 2*time.Second {
+		// Nothing was counted in the last second
+		v.rates = make(map[string]int)
+	}
+	v.counts = make(map[string]int)
+	v.rateStart = now
+}
+
+// key handles a key press, returning false to quit
+func (v *tuiView) key(b byte) bool {
+	v.Lock()
+	defer v.Unlock()
+
+	if v.editing {
+		switch b {
+		case '\r', '\n':
+			v.filter = v.input
+			v.editing = false
+		case 0x1b:
+			v.editing = false
+		case 0x7f, '\b':
+			if len(v.input) > 0 {
+				v.input = v.input[:len(v.input)-1]
+			}
+		default:
+			if b >= ' ' {
+				v.input += string(b)
+			}
+		}
+		return true
+	}
+
+	switch b {
+	case 'q', 0x03:
+		return false
+	case ' ', 'p':
+		v.paused = !v.paused
+	case '/':
+		v.editing = true
+		v.input = ""
+	case 'c':
+		v.lines = nil
+	}
+	return true
+}
+
+// render draws the view on a terminal of the given size
+func (v *tuiView) render(w io.Writer, rows, cols int, now time.Time) {
+	v.Lock()
+	defer v.Unlock()
+	v.updateRates(now)
+
+	var out []string
+
+	status := "running"
+	if v.paused {
+		status = "paused"
+	}
+	header := fmt.Sprintf("btrace  %s  filter: %q  [space] pause  [/] filter  [c] clear  [q] quit", status, v.filter)
+	if v.editing {
+		header = "filter: " + v.input + "_"
+	}
+	out = append(out, header)
+
+	var names []string
+	for name := range v.rates {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if v.rates[names[i]] != v.rates[names[j]] {
+			return v.rates[names[i]] > v.rates[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	if len(names) > tuiRates {
+		names = names[:tuiRates]
+	}
+	var rates []string
+	for _, name := range names {
+		rates = append(rates, fmt.Sprintf("%s %d/s", name, v.rates[name]))
+	}
+	out = append(out, strings.Join(rates, "  "), "")
+
+	lines := v.lines
+	if n := rows - len(out); n < len(lines) {
+		if n < 0 {
+			n = 0
+		}
+		lines = lines[len(lines)-n:]
+	}
+	out = append(out, lines...)
+
+	// Home the cursor, and clear to the end of each line and the screen
+	bw := bufio.NewWriter(w)
+	bw.WriteString("\x1b[H")
+	for i, line := range out {
+		if len(line) > cols {
+			line = line[:cols]
+		}
+		if i > 0 {
+			bw.WriteString("\r\n")
+		}
+		bw.WriteString(line)
+		bw.WriteString("\x1b[K")
+	}
+	bw.WriteString("\x1b[J")
+	bw.Flush()
+}
+
+// runTUI captures events from f into the view until quit with q, or until
+// the capture ends, redrawing the terminal on stdout a few times a second
+func runTUI(f *ftrace.Ftrace, quit func()) error {
+	fd := int(os.Stdin.Fd())
+	restore, err := makeRaw(fd)
+	if err != nil {
+		return err
+	}
+	defer restore()
+
+	v := newTUIView(time.Now())
+
+	captureDone := make(chan bool)
+	go func() {
+		f.Capture(func(events ftrace.Events) {
+			te := make([]tuiEvent, len(events))
+			for i, e := range events {
+				te[i] = tuiEvent{e.EventType().Name(), e.String()}
+			}
+			v.add(te, time.Now())
+		})
+		close(captureDone)
+	}()
+
+	keys := make(chan byte)
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			if _, err := os.Stdin.Read(buf); err != nil {
+				return
+			}
+			keys <- buf[0]
+		}
+	}()
+
+	draw := func() {
+		rows, cols, err := terminalSize(int(os.Stdout.Fd()))
+		if err != nil {
+			rows, cols = 24, 80
+		}
+		v.render(os.Stdout, rows, cols, time.Now())
+	}
+
+	// Clear the screen and hide the cursor while running
+	fmt.Print("\x1b[2J\x1b[?25l")
+	defer fmt.Print("\x1b[?25h\r\n")
+
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		draw()
+		select {
+		case b := <-keys:
+			if !v.key(b) {
+				quit()
+				<-captureDone
+				return nil
+			}
+		case <-ticker.C:
+		case <-captureDone:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTUIView(t *testing.T) {
+	now := time.Unix(1000, 0)
+	v := newTUIView(now)
+
+	v.add([]tuiEvent{
+		{"sched_switch", "bash sched_switch"},
+		{"sched_wakeup", "make sched_wakeup"},
+		{"sched_switch", "make sched_switch"},
+	}, now)
+
+	// Filter on "make"
+	for _, b := range []byte("/mak\x7fke\r") {
+		v.key(b)
+	}
+	if v.filter != "make" {
+		t.Fatalf("want filter make got %q", v.filter)
+	}
+	v.add([]tuiEvent{
+		{"sched_switch", "bash sched_switch 2"},
+		{"sched_switch", "make sched_switch 2"},
+	}, now)
+
+	// Paused events are counted but not shown
+	v.key(' ')
+	v.add([]tuiEvent{{"sched_switch", "make sched_switch 3"}}, now)
+	v.key(' ')
+
+	want := []string{"bash sched_switch", "make sched_wakeup", "make sched_switch", "make sched_switch 2"}
+	if !reflect.DeepEqual(v.lines, want) {
+		t.Errorf("want lines %q got %q", want, v.lines)
+	}
+
+	var buf bytes.Buffer
+	v.render(&buf, 5, 30, now.Add(time.Second))
+	screen := strings.Split(strings.NewReplacer("\x1b[H", "", "\x1b[K", "", "\x1b[J", "").Replace(buf.String()), "\r\n")
+	wantScreen := []string{
+		`btrace  running  filter: "make`,
+		"sched_switch 5/s  sched_wakeup",
+		"",
+		"make sched_switch",
+		"make sched_switch 2",
+	}
+	if !reflect.DeepEqual(screen, wantScreen) {
+		t.Errorf("want screen\n%q\ngot\n%q", wantScreen, screen)
+	}
+
+	v.key('c')
+	if len(v.lines) != 0 {
+		t.Errorf("lines not cleared")
+	}
+	if v.key('q') {
+		t.Errorf("q didn't quit")
+	}
+}
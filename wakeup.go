@@ -0,0 +1,52 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/google/traceout/ftrace"
+	"github.com/google/traceout/ftrace/analysis"
+)
+
+// writeWakeupLatency prints the wakeup to schedule latencies of events,
+// which must be sorted by time, overall and by cpu, and the worst n of them
+func writeWakeupLatency(w io.Writer, events ftrace.Events, worst int) {
+	l := analysis.NewWakeupLatencies()
+	for _, e := range events {
+		l.Add(e)
+	}
+	if len(l.Latencies) == 0 {
+		fmt.Fprintln(w, "no wakeup latencies, trace sched_wakeup and sched_switch")
+		return
+	}
+
+	fmt.Fprintf(w, "wakeup latency: %v\n", l.All())
+	byCPU := l.ByCPU()
+	var cpus []int
+	for cpu := range byCPU {
+		cpus = append(cpus, cpu)
+	}
+	sort.Ints(cpus)
+	for _, cpu := range cpus {
+		fmt.Fprintf(w, "  cpu %-3d %v\n", cpu, byCPU[cpu])
+	}
+	fmt.Fprintf(w, "longest waits:\n")
+	for _, worst := range l.Worst(worst) {
+		fmt.Fprintf(w, "  %v\n", worst)
+	}
+}
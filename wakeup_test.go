@@ -0,0 +1,98 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/google/traceout/ftrace"
+)
+
+const testSchedSwitchFormat = `name: sched_switch
+ID: 61
+format:
+	field:unsigned short common_type;	offset:0;	size:2;	signed:0;
+	field:unsigned char common_flags;	offset:2;	size:1;	signed:0;
+	field:unsigned char common_preempt_count;	offset:3;	size:1;	signed:0;
+	field:int common_pid;	offset:4;	size:4;	signed:1;
+
+	field:char prev_comm[16];	offset:8;	size:16;	signed:0;
+	field:pid_t prev_pid;	offset:24;	size:4;	signed:1;
+	field:int prev_prio;	offset:28;	size:4;	signed:1;
+	field:long prev_state;	offset:32;	size:8;	signed:1;
+	field:char next_comm[16];	offset:40;	size:16;	signed:0;
+	field:pid_t next_pid;	offset:56;	size:4;	signed:1;
+	field:int next_prio;	offset:60;	size:4;	signed:1;
+
+print fmt: "prev_comm=%s prev_pid=%d next_comm=%s next_pid=%d", REC->prev_comm, REC->prev_pid, REC->next_comm, REC->next_pid
+`
+
+const testSchedWakeupFormat = `name: sched_wakeup
+ID: 63
+format:
+	field:unsigned short common_type;	offset:0;	size:2;	signed:0;
+	field:unsigned char common_flags;	offset:2;	size:1;	signed:0;
+	field:unsigned char common_preempt_count;	offset:3;	size:1;	signed:0;
+	field:int common_pid;	offset:4;	size:4;	signed:1;
+
+	field:char comm[16];	offset:8;	size:16;	signed:0;
+	field:pid_t pid;	offset:24;	size:4;	signed:1;
+	field:int prio;	offset:28;	size:4;	signed:1;
+	field:int target_cpu;	offset:32;	size:4;	signed:1;
+
+print fmt: "comm=%s pid=%d prio=%d target_cpu=%03d", REC->comm, REC->pid, REC->prio, REC->target_cpu
+`
+
+func testEvent(t *testing.T, format string, cpu int, when uint64, fields map[string]interface{}) *ftrace.Event {
+	etype, err := ftrace.ParseEventFormat([]byte(format))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := etype.NewEvent(fields, cpu, when)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestWriteWakeupLatency(t *testing.T) {
+	events := ftrace.Events{
+		testEvent(t, testSchedWakeupFormat, 0, 1000, map[string]interface{}{"pid": 10, "comm": "make"}),
+		testEvent(t, testSchedSwitchFormat, 0, 1500, map[string]interface{}{"next_pid": 10, "next_comm": "make"}),
+		testEvent(t, testSchedWakeupFormat, 1, 2000, map[string]interface{}{"pid": 20, "comm": "cc1"}),
+		testEvent(t, testSchedSwitchFormat, 1, 5000, map[string]interface{}{"next_pid": 20, "next_comm": "cc1"}),
+	}
+	var buf bytes.Buffer
+	writeWakeupLatency(&buf, events, 1)
+	want := []string{
+		"wakeup latency: count=2 min=500ns mean=1.75µs p50=500ns p90=3µs p99=3µs max=3µs",
+		"  cpu 0   count=1 min=500ns mean=500ns p50=500ns p90=500ns p99=500ns max=500ns",
+		"  cpu 1   count=1 min=3µs mean=3µs p50=3µs p90=3µs p99=3µs max=3µs",
+		"longest waits:",
+		"  cc1-20 waited 3µs for cpu 1, woken at 2000 scheduled at 5000",
+		"",
+	}
+	if got := buf.String(); got != strings.Join(want, "\n") {
+		t.Errorf("want\n%s\ngot\n%s", strings.Join(want, "\n"), got)
+	}
+
+	buf.Reset()
+	writeWakeupLatency(&buf, nil, 1)
+	if !strings.HasPrefix(buf.String(), "no wakeup latencies") {
+		t.Errorf("unexpected output for no events: %s", buf.String())
+	}
+}